@@ -0,0 +1,103 @@
+package dtls
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/pion/dtls/v3"
+)
+
+// Client runs the interactive client in certificate mode.
+func Client(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	Options{}.Client(ctx, wg, addr)
+}
+
+// Client performs the DTLS handshake with the UDP address addr and sends it
+// the lines typed on stdin, one encrypted datagram each, until ctx is
+// canceled.
+func (o Options) Client(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	// Create UDP address
+	serverAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		fmt.Println("Error resolving address:", err)
+		return
+	}
+
+	conn, err := dtls.Dial("udp", serverAddr, o.clientConfig())
+	if err != nil {
+		fmt.Println("Error connecting:", err)
+		return
+	}
+	defer conn.Close()
+
+	// The handshake flights are retransmitted until the server answers, so
+	// this also waits for a server that is still starting
+	handshakeCtx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	err = conn.HandshakeContext(handshakeCtx)
+	cancel()
+	if err != nil {
+		fmt.Println("Error during handshake:", err)
+		return
+	}
+	state, _ := conn.ConnectionState()
+	fmt.Printf("Connected to DTLS server with %s, %s\n", o.mode(), dtls.CipherSuiteName(state.CipherSuiteID))
+	if len(state.PeerCertificates) > 0 {
+		fmt.Println("Server certificate SHA-256 fingerprint:", fingerprint(state.PeerCertificates[0]))
+	}
+	fmt.Println("Type your message (exit to quit):")
+
+	// Start goroutine to receive responses
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		buffer := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				fmt.Println("Server connection closed")
+				return
+			}
+			fmt.Printf("Server: %s\n", buffer[:n])
+		}
+	}()
+
+	// Read and send user input
+	lines := readLines(os.Stdin)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case line, ok := <-lines:
+			if !ok || line == "exit" {
+				return
+			}
+			if _, err := conn.Write([]byte(line)); err != nil {
+				fmt.Println("Error sending message:", err)
+				return
+			}
+		}
+	}
+}
+
+// readLines sends the lines of f on the returned channel, closing it at the
+// end of the input. Reading stdin cannot be interrupted, so it happens in a
+// goroutine of its own that the client can stop waiting for.
+func readLines(f *os.File) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
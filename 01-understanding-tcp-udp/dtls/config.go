@@ -0,0 +1,108 @@
+package dtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pion/dtls/v3"
+)
+
+const (
+	// serverHint and clientIdentity name the two sides in PSK mode: the
+	// server hints which key to use, the client says which one it has.
+	serverHint     = "transport-echo"
+	clientIdentity = "transport-client"
+
+	// handshakeTimeout bounds the DTLS handshake, its flights are
+	// retransmitted over UDP until the peer answers.
+	handshakeTimeout = 10 * time.Second
+)
+
+// Options select how the DTLS 1.2 echo authenticates.
+type Options struct {
+	// PSK, when set, is a key both sides share: the handshake proves each
+	// side has it, no certificate involved. Without it the server presents
+	// a self-signed certificate, which the client does not verify but
+	// prints the fingerprint of, to compare with the one the server prints.
+	PSK []byte
+}
+
+func (o Options) mode() string {
+	if o.PSK != nil {
+		return "pre-shared key"
+	}
+	return "certificate"
+}
+
+// serverConfig returns the configuration of the server and, in certificate
+// mode, the fingerprint of its certificate.
+func (o Options) serverConfig() (*dtls.Config, string, error) {
+	config := &dtls.Config{ExtendedMasterSecret: dtls.RequireExtendedMasterSecret}
+	if o.PSK != nil {
+		config.PSK = func(identity []byte) ([]byte, error) {
+			fmt.Printf("Client identifies as %q\n", identity)
+			return o.PSK, nil
+		}
+		config.PSKIdentityHint = []byte(serverHint)
+		config.CipherSuites = []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_GCM_SHA256}
+		return config, "", nil
+	}
+
+	certificate, err := generateCertificate()
+	if err != nil {
+		return nil, "", err
+	}
+	config.Certificates = []tls.Certificate{certificate}
+	return config, fingerprint(certificate.Certificate[0]), nil
+}
+
+func (o Options) clientConfig() *dtls.Config {
+	config := &dtls.Config{ExtendedMasterSecret: dtls.RequireExtendedMasterSecret}
+	if o.PSK != nil {
+		config.PSK = func(hint []byte) ([]byte, error) {
+			fmt.Printf("Server hints %q\n", hint)
+			return o.PSK, nil
+		}
+		config.PSKIdentityHint = []byte(clientIdentity)
+		config.CipherSuites = []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_GCM_SHA256}
+		return config
+	}
+	// The certificate is a new self-signed one on every start of the
+	// server, there is nothing to verify it against
+	config.InsecureSkipVerify = true
+	return config
+}
+
+// generateCertificate returns a self-signed ECDSA certificate.
+func generateCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "transport-echo"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// fingerprint is the SHA-256 of a DER certificate, as openssl prints it.
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return strings.ReplaceAll(fmt.Sprintf("% X", sum), " ", ":")
+}
@@ -0,0 +1,129 @@
+package dtls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/pion/dtls/v3"
+)
+
+// Server runs the echo server in certificate mode.
+func Server(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	Options{}.Server(ctx, wg, addr)
+}
+
+// Server runs the echo server on the UDP address addr until ctx is canceled.
+// Every client gets a DTLS association of its own, over the one socket, in
+// which every message is still a datagram: no stream, no ordering, no
+// retransmission once the handshake is done.
+func (o Options) Server(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	// Create UDP address
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		fmt.Println("Error resolving address:", err)
+		return
+	}
+
+	config, serverFingerprint, err := o.serverConfig()
+	if err != nil {
+		fmt.Println("Error generating certificate:", err)
+		return
+	}
+
+	// Start server
+	listener, err := dtls.Listen("udp", udpAddr, config)
+	if err != nil {
+		fmt.Println("Error listening:", err)
+		return
+	}
+
+	fmt.Println("DTLS Server listening on", listener.Addr(), "with", o.mode())
+	if serverFingerprint != "" {
+		fmt.Println("Certificate SHA-256 fingerprint:", serverFingerprint)
+	}
+
+	var clients sync.WaitGroup
+	var mu sync.Mutex
+	conns := make(map[net.Conn]bool)
+	defer func() {
+		// Like UDP there is nothing to drain, the clients are sent a
+		// close_notify alert
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		clients.Wait()
+		fmt.Println("DTLS Server stopped")
+	}()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Println("Error accepting client:", err)
+			continue
+		}
+
+		mu.Lock()
+		conns[conn] = true
+		mu.Unlock()
+		clients.Add(1)
+
+		// Handle each client in a goroutine
+		go func() {
+			defer clients.Done()
+			handleConnection(ctx, conn.(*dtls.Conn))
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+		}()
+	}
+}
+
+func handleConnection(ctx context.Context, conn *dtls.Conn) {
+	defer conn.Close()
+
+	handshakeCtx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	err := conn.HandshakeContext(handshakeCtx)
+	cancel()
+	if err != nil {
+		fmt.Printf("Handshake with %s failed: %s\n", conn.RemoteAddr(), err)
+		return
+	}
+	state, _ := conn.ConnectionState()
+	fmt.Printf("New client connected: %s (%s)\n", conn.RemoteAddr(), dtls.CipherSuiteName(state.CipherSuiteID))
+
+	buffer := make([]byte, 1024)
+	for {
+		// Read incoming message, one record of one datagram
+		n, err := conn.Read(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+				fmt.Printf("Client %s disconnected\n", conn.RemoteAddr())
+			} else {
+				fmt.Printf("Client %s disconnected: %s\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		fmt.Printf("Received from %s: %s\n", conn.RemoteAddr(), buffer[:n])
+
+		// Echo message back to client
+		if _, err := conn.Write(append([]byte("Echo: "), buffer[:n]...)); err != nil {
+			fmt.Printf("Error sending response to %s: %s\n", conn.RemoteAddr(), err)
+		}
+	}
+}
@@ -1,3 +1,23 @@
 module transport
 
 go 1.23.4
+
+require (
+	github.com/pion/dtls/v3 v3.1.0
+	github.com/quic-go/quic-go v0.54.0
+	netsim v0.0.0
+)
+
+require (
+	github.com/pion/logging v0.2.4 // indirect
+	github.com/pion/transport/v4 v4.0.1 // indirect
+	go.uber.org/mock v0.5.0 // indirect
+	golang.org/x/crypto v0.33.0 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
+)
+
+replace netsim => ../netsim
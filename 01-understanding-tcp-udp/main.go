@@ -1,18 +1,249 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"transport/dtls"
+	"transport/proxy"
+	"transport/quic"
+	"transport/rudp"
+	"transport/stun"
 	"transport/tcp"
-	//"transport/udp"
+	"transport/udp"
+
+	"netsim/lossy"
+	"netsim/throttle"
 )
 
 func main() {
+	proto := flag.String("proto", "tcp", "echo over tcp, udp, rudp (reliable udp), dtls, quic, multicast (udp to a group) stun (public address and NAT type) or proxy (tcp relay to -forward)")
+	role := flag.String("role", "both", "run the echo server, the client or both")
+	bind := flag.String("bind", env("BIND_ADDR", ""), "address the server listens on, all interfaces when empty (env BIND_ADDR)")
+	host := flag.String("host", env("SERVER_HOST", "localhost"), "host the client connects to (env SERVER_HOST)")
+	tcpPort := flag.Int("tcp-port", envInt("TCP_PORT", 8080), "port of the TCP echo server (env TCP_PORT)")
+	udpPort := flag.Int("udp-port", envInt("UDP_PORT", 8081), "port of the UDP echo server (env UDP_PORT)")
+	quicPort := flag.Int("quic-port", envInt("QUIC_PORT", 8082), "UDP port of the QUIC echo server (env QUIC_PORT)")
+	rudpPort := flag.Int("rudp-port", envInt("RUDP_PORT", 8083), "port of the reliable UDP echo server (env RUDP_PORT)")
+	dtlsPort := flag.Int("dtls-port", envInt("DTLS_PORT", 8085), "UDP port of the DTLS echo server (env DTLS_PORT)")
+	proxyPort := flag.Int("proxy-port", envInt("PROXY_PORT", 9000), "port of the TCP proxy (env PROXY_PORT)")
+	forward := flag.String("forward", env("PROXY_TARGET", "localhost:8080"), "proxy: host:port the proxy forwards to, the TCP echo server by default (env PROXY_TARGET)")
+	stunPort := flag.Int("stun-port", envInt("STUN_PORT", 3478), "UDP port of the STUN server (env STUN_PORT)")
+	group := flag.String("group", env("MULTICAST_GROUP", "239.0.0.1"), "multicast: group the servers join and the client sends to (env MULTICAST_GROUP)")
+	multicastPort := flag.Int("multicast-port", envInt("MULTICAST_PORT", 8084), "multicast: port of the group (env MULTICAST_PORT)")
+	broadcast := flag.Bool("broadcast", false, "multicast: the client sends to the broadcast address 255.255.255.255 instead of the group")
+
+	profile := flag.String("tcp-profile", "default", "tcp: socket tuning to start from, default, latency or throughput")
+	nagle := flag.Bool("nagle", false, "tcp: turn Nagle's algorithm on (TCP_NODELAY off)")
+	keepAlive := flag.Duration("keepalive", 0, "tcp: keep-alive probe interval, 0 keeps the profile's, negative disables")
+	readBuffer := flag.Int("rcvbuf", 0, "tcp: SO_RCVBUF in bytes, 0 keeps the profile's")
+	writeBuffer := flag.Int("sndbuf", 0, "tcp: SO_SNDBUF in bytes, 0 keeps the profile's")
+
+	reorderWindow := flag.Int("reorder-window", 0, "udp: echoes held while waiting for a missing one, to show them in send order, 0 for 16, -1 shows them as they arrive")
+	psk := flag.String("psk", env("DTLS_PSK", ""), "dtls: hex pre-shared key both sides authenticate with, a self-signed certificate when empty (env DTLS_PSK)")
+	heartbeat := flag.Duration("heartbeat", 0, "udp: how often a quiet client tells the server it is there, 0 for 10s, negative sends none")
+	idleTimeout := flag.Duration("idle-timeout", 0, "udp: how long the server keeps a client that sent nothing, 0 for 30s; proxy: how long a connection may carry nothing, 0 for 5m")
+	stunServers := flag.String("stun-servers", env("STUN_SERVERS", ""), "stun: more servers to query, host:port separated by commas, to classify the NAT (env STUN_SERVERS)")
+	trace := flag.Bool("trace", false, "rudp: print the congestion window and RTT estimates as they change")
+	send := flag.String("send", "", "tcp: the client sends this file instead of lines, resuming where an earlier attempt stopped")
+	uploadDir := flag.String("upload-dir", "", "tcp: directory the server stores the files sent to it in, files are refused when empty")
+	ping := flag.Duration("ping", 0, "tcp: how often the server and the client ping each other, 0 for never")
+	liveness := flag.Duration("liveness", 0, "tcp: how long a side that pings waits to hear from the peer before it presumes it dead, 0 for three pings")
+	chat := flag.Bool("chat", false, "tcp, udp: the server relays every message to its other clients, a chat room")
+	dropRate := flag.Float64("drop", 0, "tcp, udp, rudp: fraction of outgoing writes to drop on purpose, e.g. 0.3, the client's for tcp and udp, both sides' for rudp")
+	duplicate := flag.Float64("duplicate", 0, "tcp, udp: fraction of the client's writes to send twice")
+	corrupt := flag.Float64("corrupt", 0, "tcp, udp: fraction of the client's writes to flip a bit of")
+	delay := flag.Duration("delay", 0, "tcp, udp: hold every write of the client back that long")
+	jitter := flag.Duration("jitter", 0, "tcp, udp: hold every write of the client back up to that long more, reordering datagrams")
+	poolSize := flag.Int("pool", 0, "tcp: the client sends every line on its own goroutine over a pool of that many connections, 0 for one connection")
+	poolIdle := flag.Duration("pool-idle", 0, "tcp: how long a connection may stay idle in the pool, 0 for 1m")
+	poolLifetime := flag.Duration("pool-lifetime", 0, "tcp: how long a connection of the pool is used at most, 0 for no limit")
+	codec := flag.String("codec", "", "tcp: the client sends every line as a message struct encoded with gob or json, plain frames when empty")
+	mux := flag.Bool("mux", false, "tcp: the client makes every line a request, over one connection without waiting for the answers to the earlier ones")
+	callTimeout := flag.Duration("call-timeout", 0, "tcp: how long a request of -mux waits for its answer, 0 for 5s")
+	rate := flag.Float64("rate", 0, "tcp: bytes per second the client and the server read and write at most, each direction on its own, 0 for no limit")
+	burst := flag.Int("burst", 0, "tcp: bytes read or written at once within -rate, 0 for one second worth")
+	seed := flag.Uint64("seed", 0, "tcp, udp: seed of the faults injected, the same seed drops the same writes, 0 for a random one")
+
+	bench := flag.Bool("bench", false, "open many short-lived TCP connections to demonstrate ephemeral port exhaustion")
+	connections := flag.Int("connections", 10000, "bench: number of connections to open")
+	concurrency := flag.Int("concurrency", 100, "bench: connections open at the same time")
+	linger := flag.Int("linger", -1, "bench: SO_LINGER seconds on close, 0 resets the connection and skips TIME_WAIT")
+	reuse := flag.Int("reuse", 1, "bench: exchanges per connection before closing it")
+	flag.Parse()
+
+	if *bench {
+		tcp.Bench(tcp.BenchConfig{
+			Connections: *connections,
+			Concurrency: *concurrency,
+			Linger:      *linger,
+			Reuse:       *reuse,
+		})
+		return
+	}
+
+	var options tcp.Options
+	switch *profile {
+	case "default":
+	case "latency":
+		options = tcp.LowLatency
+	case "throughput":
+		options = tcp.HighThroughput
+	default:
+		fmt.Println("Unknown -tcp-profile, want default, latency or throughput:", *profile)
+		os.Exit(2)
+	}
+	if *nagle {
+		options.Nagle = true
+	}
+	if *keepAlive != 0 {
+		options.KeepAlive = *keepAlive
+	}
+	if *readBuffer > 0 {
+		options.ReadBuffer = *readBuffer
+	}
+	if *writeBuffer > 0 {
+		options.WriteBuffer = *writeBuffer
+	}
+	faults := lossy.Faults{Drop: *dropRate, Duplicate: *duplicate, Corrupt: *corrupt, Delay: *delay, Jitter: *jitter, Seed: *seed}
+	if err := faults.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	options.Faults = faults
+	options.Chat = *chat
+	options.UploadDir = *uploadDir
+	if *codec != "" {
+		c, err := tcp.ParseCodec(*codec)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		options.Codec = c
+	}
+	options.Heartbeat, options.Liveness = *ping, *liveness
+	options.Bandwidth = throttle.Limit{BytesPerSecond: *rate, Burst: *burst}
+
+	server, client := options.Server, options.Client
+	switch {
+	case *send != "":
+		client = func(ctx context.Context, wg *sync.WaitGroup, addr string) { options.Upload(ctx, wg, addr, *send) }
+	case *mux:
+		client = func(ctx context.Context, wg *sync.WaitGroup, addr string) {
+			options.CallClient(ctx, wg, addr, *callTimeout)
+		}
+	case *poolSize > 0:
+		config := tcp.PoolConfig{Size: *poolSize, MaxIdleTime: *poolIdle, MaxLifetime: *poolLifetime}
+		client = func(ctx context.Context, wg *sync.WaitGroup, addr string) { options.PoolClient(ctx, wg, addr, config) }
+	}
+	port := *tcpPort
+	switch *proto {
+	case "tcp":
+	case "udp":
+		datagrams := udp.Options{ReorderWindow: *reorderWindow, Heartbeat: *heartbeat, IdleTimeout: *idleTimeout, Chat: *chat, Faults: faults}
+		server, client = datagrams.Server, datagrams.Client
+		port = *udpPort
+	case "multicast":
+		// Servers and client meet at the group rather than at an address
+		server, client = udp.MulticastServer, udp.Options{Broadcast: *broadcast}.MulticastClient
+		*bind, *host = *group, *group
+		if *broadcast {
+			*host = "255.255.255.255"
+		}
+		port = *multicastPort
+	case "rudp":
+		if *dropRate < 0 || *dropRate >= 1 {
+			fmt.Println("-drop must be at least 0 and less than 1:", *dropRate)
+			os.Exit(2)
+		}
+		reliable := rudp.Options{DropRate: *dropRate, Trace: *trace}
+		server, client = reliable.Server, reliable.Client
+		port = *rudpPort
+	case "dtls":
+		var secure dtls.Options
+		if *psk != "" {
+			key, err := hex.DecodeString(*psk)
+			if err != nil || len(key) == 0 {
+				fmt.Println("-psk must be a key in hex, e.g. 0123456789abcdef:", *psk)
+				os.Exit(2)
+			}
+			secure.PSK = key
+		}
+		server, client = secure.Server, secure.Client
+		port = *dtlsPort
+	case "stun":
+		var discovery stun.Options
+		if *stunServers != "" {
+			discovery.Servers = strings.Split(*stunServers, ",")
+		}
+		server, client = stun.Server, discovery.Client
+		port = *stunPort
+	case "proxy":
+		// The client is the TCP one, talking to the target through the proxy
+		server = proxy.Options{Target: *forward, IdleTimeout: *idleTimeout}.Server
+		port = *proxyPort
+	case "quic":
+		server, client = quic.Server, quic.Client
+		port = *quicPort
+	default:
+		fmt.Println("Unknown -proto, want tcp, udp, rudp, dtls, quic, multicast, stun or proxy:", *proto)
+		os.Exit(2)
+	}
+	if *role != "both" && *role != "server" && *role != "client" {
+		fmt.Println("Unknown -role, want both, server or client:", *role)
+		os.Exit(2)
+	}
+
+	// SIGINT or SIGTERM cancels ctx, which stops the servers and clients. A
+	// second signal kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(ctx)
+	defer stop()
+
 	var sync sync.WaitGroup
-	sync.Add(2)
-	defer sync.Wait()
-	go tcp.Server(&sync)
-	go tcp.Client(&sync)
-	// go udp.Server(&sync)
-	// go udp.Client(&sync)
+	if *role != "client" {
+		sync.Add(1)
+		go server(ctx, &sync, net.JoinHostPort(*bind, strconv.Itoa(port)))
+	}
+	if *role != "server" {
+		sync.Add(1)
+		// The server has nothing left to serve once the client quit
+		go func() { client(ctx, &sync, net.JoinHostPort(*host, strconv.Itoa(port))); cancel() }()
+	}
+
+	<-ctx.Done()
+	stop()
+	fmt.Println("Shutting down")
+	sync.Wait()
+}
+
+// env returns the environment variable name, or fallback when it is unset.
+// Flags still override it.
+func env(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
 
+// envInt is env for integers.
+func envInt(name string, fallback int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("%s=%q is not a number\n", name, value)
+		os.Exit(2)
+	}
+	return n
 }
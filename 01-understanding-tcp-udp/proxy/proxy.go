@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultIdleTimeout is how long a relayed connection may carry nothing
+	// in either direction when Options.IdleTimeout is zero.
+	defaultIdleTimeout = 5 * time.Minute
+
+	// dialTimeout bounds the connection to the target.
+	dialTimeout = 5 * time.Second
+
+	// bufferSize is how much a relay reads at once.
+	bufferSize = 32 << 10
+)
+
+// Options configures the proxy.
+type Options struct {
+	// Target is the host:port every connection accepted is forwarded to.
+	Target string
+
+	// IdleTimeout closes a connection that carried nothing in either
+	// direction for that long. Zero is 5 minutes.
+	IdleTimeout time.Duration
+}
+
+func (o Options) idleTimeout() time.Duration {
+	if o.IdleTimeout <= 0 {
+		return defaultIdleTimeout
+	}
+	return o.IdleTimeout
+}
+
+/**
+ * * Server accepts TCP connections on addr until ctx is canceled and relays each to o.Target: a
+ * * connection that it dials for it, and two goroutines copying the bytes one way each. The proxy
+ * * sees bytes, not messages, so it works for any protocol over TCP.
+ *
+ * * When one side closes its half, the proxy closes the same half towards the other side, with
+ * * CloseWrite, and keeps relaying the other direction until it ends as well. Either side resetting,
+ * * or the idle timeout, closes both connections.
+ */
+func (o Options) Server(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	if o.Target == "" {
+		fmt.Println("Error starting proxy: no target to forward to")
+		return
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Println("Error starting proxy:", err)
+		return
+	}
+
+	fmt.Printf("TCP Proxy listening on %s, forwarding to %s\n", listener.Addr(), o.Target)
+
+	// Closing the listener is what gets Accept to return on shutdown
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var relays sync.WaitGroup
+	var totals stats
+	defer func() {
+		relays.Wait()
+		fmt.Printf("TCP Proxy stopped after %d connections, %d bytes to %s and %d bytes back\n",
+			totals.connections.Load(), totals.up.Load(), o.Target, totals.down.Load())
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Println("Error accepting connection:", err)
+			continue
+		}
+
+		relays.Add(1)
+		go func() {
+			defer relays.Done()
+			r := &relay{client: conn, timeout: o.idleTimeout()}
+			r.run(ctx, o.Target)
+			totals.connections.Add(1)
+			totals.up.Add(r.up.Load())
+			totals.down.Add(r.down.Load())
+		}()
+	}
+}
+
+// stats counts what went through the proxy.
+type stats struct {
+	connections, up, down atomic.Int64
+}
+
+// relay is a connection from a client and the one to the target it is
+// relayed to. up counts the bytes from the client to the target, down the
+// bytes back.
+type relay struct {
+	client, target net.Conn
+	timeout        time.Duration
+
+	up, down atomic.Int64
+	lastSeen atomic.Int64 // unix nanoseconds of the last bytes either way
+
+	mu     sync.Mutex
+	reason error // why the connections were closed, nil when both sides did
+}
+
+func (r *relay) run(ctx context.Context, target string) {
+	defer r.client.Close()
+	from := r.client.RemoteAddr()
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		fmt.Printf("Error forwarding %s: %s\n", from, err)
+		return
+	}
+	r.target = conn
+	defer r.target.Close()
+	fmt.Printf("Relaying %s to %s (via %s)\n", from, target, r.target.LocalAddr())
+
+	// Shutting down closes both connections, which ends both directions
+	stop := context.AfterFunc(ctx, func() { r.abort(errors.New("proxy stopped")) })
+	defer stop()
+
+	started := time.Now()
+	r.touch()
+	var directions sync.WaitGroup
+	directions.Add(2)
+	go func() { defer directions.Done(); r.pipe(r.target, r.client, &r.up) }()
+	go func() { defer directions.Done(); r.pipe(r.client, r.target, &r.down) }()
+	directions.Wait()
+
+	reason := "closed"
+	r.mu.Lock()
+	if r.reason != nil {
+		reason = r.reason.Error()
+	}
+	r.mu.Unlock()
+	fmt.Printf("Closed %s after %s (%s): %d bytes sent, %d bytes received\n",
+		from, time.Since(started).Round(time.Millisecond), reason, r.up.Load(), r.down.Load())
+}
+
+// pipe copies from src to dst, counting the bytes in count. When src ends,
+// dst is told no more is coming. Any other error ends both directions.
+func (r *relay) pipe(dst, src net.Conn, count *atomic.Int64) {
+	buffer := make([]byte, bufferSize)
+	for {
+		// The deadline only means this direction was idle, the connection
+		// is idle when the other one was too
+		src.SetReadDeadline(time.Now().Add(r.timeout))
+		n, err := src.Read(buffer)
+		if n > 0 {
+			r.touch()
+			dst.SetWriteDeadline(time.Now().Add(r.timeout))
+			if _, err := dst.Write(buffer[:n]); err != nil {
+				r.abort(err)
+				return
+			}
+			count.Add(int64(n))
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, os.ErrDeadlineExceeded) && r.idle() < r.timeout:
+		case errors.Is(err, io.EOF):
+			if tcp, ok := dst.(*net.TCPConn); ok {
+				tcp.CloseWrite()
+			}
+			return
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			r.abort(fmt.Errorf("idle for %s", r.timeout))
+			return
+		default:
+			r.abort(err)
+			return
+		}
+	}
+}
+
+// abort closes both connections, recording why unless an earlier call did.
+func (r *relay) abort(reason error) {
+	r.mu.Lock()
+	if r.reason == nil {
+		r.reason = reason
+	}
+	r.mu.Unlock()
+	r.client.Close()
+	r.target.Close()
+}
+
+// touch records bytes going through now.
+func (r *relay) touch() {
+	r.lastSeen.Store(time.Now().UnixNano())
+}
+
+// idle is how long nothing went through either way.
+func (r *relay) idle() time.Duration {
+	return time.Since(time.Unix(0, r.lastSeen.Load()))
+}
@@ -0,0 +1,101 @@
+package quic
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// handshakeTimeout bounds the QUIC handshake. The client retransmits its
+// first packet until the server answers, so it also covers a server that
+// is still starting.
+const handshakeTimeout = 5 * time.Second
+
+// Client connects to the UDP address addr, opens a stream and sends it the
+// lines typed on stdin until ctx is canceled. When it is done it finishes the
+// stream, waits for the last echoes and closes the connection itself.
+func Client(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	// Connect to server
+	dialCtx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	conn, err := quic.DialAddr(dialCtx, addr, clientTLSConfig(), nil)
+	cancel()
+	if err != nil {
+		fmt.Println("Error connecting:", err)
+		return
+	}
+	defer conn.CloseWithError(0, "client done")
+
+	// Open a stream, the connection could carry many side by side
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		fmt.Println("Error opening stream:", err)
+		return
+	}
+
+	fmt.Println("Connected to QUIC server. Type your message (exit to quit):")
+
+	// Start a goroutine to read server responses
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		reader := bufio.NewReader(stream)
+		for {
+			message, err := reader.ReadString('\n')
+			if err != nil {
+				fmt.Println("Server closed the stream")
+				return
+			}
+			fmt.Print("Server: ", message)
+		}
+	}()
+
+	// finish closes the sending side of the stream and waits for the server
+	// to echo what is left and close its side.
+	finish := func() {
+		stream.Close()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+		}
+	}
+
+	// Read user input and send to server
+	lines := readLines(os.Stdin)
+	for {
+		select {
+		case <-ctx.Done():
+			finish()
+			return
+		case <-closed:
+			return
+		case message, ok := <-lines:
+			if !ok || message == "exit" {
+				finish()
+				return
+			}
+			fmt.Fprintf(stream, "%s\n", message)
+		}
+	}
+}
+
+// readLines sends the lines of f on the returned channel, closing it at the
+// end of the input. Reading stdin cannot be interrupted, so it happens in a
+// goroutine of its own that the client can stop waiting for.
+func readLines(f *os.File) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
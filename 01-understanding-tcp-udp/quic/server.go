@@ -0,0 +1,149 @@
+package quic
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// drainTimeout is how long clients are given to finish after the server is
+// asked to stop, before their connections are closed.
+const drainTimeout = 5 * time.Second
+
+// Server runs the echo server on the UDP address addr until ctx is canceled.
+// Every stream a client opens is echoed on its own, line by line.
+func Server(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	tlsConf, err := serverTLSConfig()
+	if err != nil {
+		fmt.Println("Error generating certificate:", err)
+		return
+	}
+
+	// Start server
+	listener, err := quic.ListenAddr(addr, tlsConf, nil)
+	if err != nil {
+		fmt.Println("Error starting server:", err)
+		return
+	}
+	defer listener.Close()
+
+	fmt.Println("QUIC Server listening on", listener.Addr())
+
+	var clients sync.WaitGroup
+	defer func() {
+		clients.Wait()
+		fmt.Println("QUIC Server stopped")
+	}()
+
+	for {
+		// Accept connections, Accept returns once ctx is canceled
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Println("Error accepting connection:", err)
+			continue
+		}
+
+		// Handle each client in a goroutine
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			handleConnection(ctx, conn)
+		}()
+	}
+}
+
+// handleConnection echoes the streams of conn until the client closes the
+// connection, or ctx is canceled. Then the streams stop being read, which lets
+// their handlers finish the line they are on, and the server closes the
+// connection after them, at the latest after drainTimeout.
+func handleConnection(ctx context.Context, conn *quic.Conn) {
+	fmt.Printf("New client connected: %s\n", conn.RemoteAddr())
+
+	var streams sync.WaitGroup
+	var mu sync.Mutex
+	open := make(map[*quic.Stream]bool)
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			break
+		}
+		mu.Lock()
+		open[stream] = true
+		mu.Unlock()
+		streams.Add(1)
+		go func() {
+			defer streams.Done()
+			handleStream(conn, stream)
+			mu.Lock()
+			delete(open, stream)
+			mu.Unlock()
+		}()
+	}
+
+	if ctx.Err() == nil {
+		// The client closed the connection, which ended its streams too.
+		streams.Wait()
+		fmt.Printf("Client %s disconnected: %s\n", conn.RemoteAddr(), context.Cause(conn.Context()))
+		return
+	}
+
+	mu.Lock()
+	for stream := range open {
+		stream.SetReadDeadline(time.Now())
+	}
+	mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		streams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+	}
+	conn.CloseWithError(0, "server shutting down")
+	<-done
+}
+
+// handleStream echoes the lines of stream back on it until the client
+// finishes its side, then finishes the server's side.
+func handleStream(conn *quic.Conn, stream *quic.Stream) {
+	defer stream.Close()
+	fmt.Printf("Client %s opened stream %d\n", conn.RemoteAddr(), stream.StreamID())
+
+	reader := bufio.NewReader(stream)
+	for {
+		// Read incoming message
+		message, err := reader.ReadString('\n')
+		if err != nil {
+			var appErr *quic.ApplicationError
+			switch {
+			case errors.Is(err, io.EOF) || errors.As(err, &appErr):
+				fmt.Printf("Client %s closed stream %d\n", conn.RemoteAddr(), stream.StreamID())
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				// handleConnection stopped reading, the server is shutting down
+				fmt.Printf("Closing stream %d of %s\n", stream.StreamID(), conn.RemoteAddr())
+			default:
+				fmt.Printf("Stream %d of %s ended: %s\n", stream.StreamID(), conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		fmt.Printf("Received from %s [stream %d]: %s", conn.RemoteAddr(), stream.StreamID(), message)
+
+		// Echo message back to client
+		stream.Write([]byte("Echo: " + message))
+	}
+}
@@ -0,0 +1,45 @@
+package quic
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+)
+
+// nextProto is the ALPN protocol of the echo. QUIC always runs TLS 1.3 and
+// both sides have to agree on an application protocol during the handshake.
+const nextProto = "transport-echo"
+
+// serverTLSConfig returns a TLS configuration with a self-signed certificate
+// generated on the spot. QUIC cannot run without TLS, unlike TCP and UDP.
+func serverTLSConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "transport-echo"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{nextProto},
+	}, nil
+}
+
+// clientTLSConfig trusts any certificate: the server generates a new one on
+// every start, there is nothing to check it against.
+func clientTLSConfig() *tls.Config {
+	return &tls.Config{InsecureSkipVerify: true, NextProtos: []string{nextProto}}
+}
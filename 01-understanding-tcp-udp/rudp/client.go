@@ -0,0 +1,130 @@
+package rudp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// flushTimeout is how long the client waits on exit for its messages to be
+// acknowledged and their echoes to arrive.
+const flushTimeout = 5 * time.Second
+
+// Client runs the interactive client with the default Options.
+func Client(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	Options{}.Client(ctx, wg, addr)
+}
+
+// Client sends the lines typed on stdin to the UDP address addr until ctx is
+// canceled, every one of them retransmitted until the server acknowledges it.
+func (o Options) Client(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	// Create UDP address
+	serverAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		fmt.Println("Error resolving address:", err)
+		return
+	}
+
+	// Create UDP connection
+	conn, err := net.DialUDP("udp", nil, serverAddr)
+	if err != nil {
+		fmt.Println("Error connecting:", err)
+		return
+	}
+	defer conn.Close()
+
+	fmt.Println("Connected to reliable UDP server. Type your message (exit to quit):")
+
+	var echoes sync.WaitGroup
+	server := newEndpoint(
+		"server",
+		func(datagram []byte) error {
+			_, err := conn.Write(datagram)
+			return err
+		},
+		func(seq uint64, message string) {
+			fmt.Printf("Server [seq %d]: %s\n", seq, message)
+			echoes.Done()
+		},
+		o,
+	)
+	runCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go server.run(runCtx)
+
+	defer func() {
+		// Wait for the ACKs and the echoes of what was sent, then report
+		done := make(chan struct{})
+		go func() {
+			echoes.Wait()
+			server.flush(flushTimeout)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(flushTimeout):
+			fmt.Println("Some messages or echoes did not make it")
+		}
+		fmt.Println(server.report())
+	}()
+
+	// Start goroutine to receive responses
+	go func() {
+		buffer := make([]byte, 2048)
+		for {
+			n, err := conn.Read(buffer)
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				// The ICMP error of a datagram sent before the server listened
+				continue
+			}
+			if err != nil {
+				if runCtx.Err() == nil {
+					fmt.Println("Error reading from server:", err)
+				}
+				return
+			}
+			server.handle(buffer[:n])
+		}
+	}()
+
+	// Read and send user input
+	lines := readLines(os.Stdin)
+	for {
+		var message string
+		select {
+		case <-ctx.Done():
+			return
+		case line, ok := <-lines:
+			if !ok || line == "exit" {
+				return
+			}
+			message = line
+		}
+
+		echoes.Add(1)
+		server.send(message)
+	}
+}
+
+// readLines sends the lines of f on the returned channel, closing it at the
+// end of the input. Reading stdin cannot be interrupted, so it happens in a
+// goroutine of its own that the client can stop waiting for.
+func readLines(f *os.File) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
@@ -0,0 +1,365 @@
+package rudp
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// initialRTO is how long a message waits for its ACK before it is sent
+	// again, until the round-trip time was measured. Every retransmission
+	// of a message doubles its timeout, up to maxRTO.
+	initialRTO = 200 * time.Millisecond
+	minRTO     = 50 * time.Millisecond
+	maxRTO     = 2 * time.Second
+
+	// maxAttempts is how many times a message is sent before the sender
+	// gives up on it.
+	maxAttempts = 8
+
+	// tick is how often the retransmission timers are checked, the clock
+	// granularity of the RTO.
+	tick = 20 * time.Millisecond
+
+	// initialWindow is how many messages are in flight at first, the
+	// congestion window growing and shrinking from there up to maxWindow.
+	initialWindow = 2
+	maxWindow     = 64
+
+	// receiveWindow is how many messages arriving ahead of a missing one a
+	// receiver holds. It advertises the room left in every ACK, and drops
+	// what does not fit.
+	receiveWindow = 32
+)
+
+// pending is a message sent and not acknowledged yet.
+type pending struct {
+	packet   []byte
+	sentAt   time.Time
+	rto      time.Duration
+	attempts int
+}
+
+/**
+ * * endpoint is one side of the reliable exchange with a peer: it numbers and retransmits what it
+ * * sends, and acknowledges, de-duplicates and orders what it receives.
+ * *
+ * * What it sends waits in a queue until the send window has room: the smaller of the congestion
+ * * window, cwnd, and of the room the peer advertised, rwnd. cwnd follows AIMD like TCP without slow
+ * * start: it grows by one message per window of messages acknowledged, and halves when a message
+ * * times out, at most once per window sent. The timeout, the RTO, follows the smoothed round-trip
+ * * time and its variation, SRTT and RTTVAR, measured on the messages acknowledged without having
+ * * been sent again (Karn's algorithm), as RFC 6298 has it.
+ */
+type endpoint struct {
+	// peer names the other side in the output. write sends a datagram to
+	// it, deliver is called with every message received, once and in order.
+	peer     string
+	write    func([]byte) error
+	deliver  func(seq uint64, message string)
+	dropRate float64
+	trace    bool
+
+	mu       sync.Mutex
+	next     uint64
+	queue    []string
+	unacked  map[uint64]*pending
+	cwnd     float64
+	rwnd     int
+	recovery uint64 // cwnd is not halved again for messages up to it
+	srtt     time.Duration
+	rttvar   time.Duration
+	rto      time.Duration
+	expected uint64
+	buffered map[uint64]string
+	lastSeen time.Time
+	stats    stats
+}
+
+// stats counts what happened on an endpoint.
+type stats struct {
+	sent, retransmitted, givenUp, dropped uint64
+	delivered, duplicates, early, refused uint64
+	samples, reductions                   uint64
+	peakWindow, peakQueue                 int
+}
+
+func newEndpoint(peer string, write func([]byte) error, deliver func(uint64, string), o Options) *endpoint {
+	return &endpoint{
+		peer:     peer,
+		write:    write,
+		deliver:  deliver,
+		dropRate: o.DropRate,
+		trace:    o.Trace,
+		unacked:  make(map[uint64]*pending),
+		cwnd:     initialWindow,
+		rwnd:     receiveWindow,
+		rto:      initialRTO,
+		expected: 1,
+		buffered: make(map[uint64]string),
+		lastSeen: time.Now(),
+		stats:    stats{peakWindow: initialWindow},
+	}
+}
+
+// send queues message, it is numbered and sent once the window has room and
+// its retransmissions are up to run.
+func (e *endpoint) send(message string) {
+	e.mu.Lock()
+	e.queue = append(e.queue, message)
+	e.stats.peakQueue = max(e.stats.peakQueue, len(e.queue))
+	packets := e.fill()
+	e.mu.Unlock()
+	for _, packet := range packets {
+		e.transmit(packet)
+	}
+}
+
+// window is how many messages may be in flight. It stays at least one even
+// when the peer advertised no room, so that its ACKs keep coming and tell
+// when there is some again.
+func (e *endpoint) window() int {
+	return max(1, min(int(e.cwnd), e.rwnd))
+}
+
+// fill numbers the queued messages the window has room for and returns their
+// packets to transmit. e.mu must be held.
+func (e *endpoint) fill() [][]byte {
+	var packets [][]byte
+	for len(e.queue) > 0 && len(e.unacked) < e.window() {
+		e.next++
+		packet := encodeData(e.next, e.queue[0])
+		e.queue = e.queue[1:]
+		e.unacked[e.next] = &pending{packet: packet, sentAt: time.Now(), rto: e.rto, attempts: 1}
+		e.stats.sent++
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+// transmit writes packet, unless it is one of the datagrams dropped on
+// purpose to show the retransmissions at work. A failed write is one more
+// lost datagram: the peer may not listen yet, the ICMP error of an earlier
+// datagram comes back as connection refused.
+func (e *endpoint) transmit(packet []byte) {
+	if e.dropRate > 0 && rand.Float64() < e.dropRate {
+		e.mu.Lock()
+		e.stats.dropped++
+		e.mu.Unlock()
+		return
+	}
+	if err := e.write(packet); err != nil {
+		fmt.Printf("Error sending to %s: %s\n", e.peer, err)
+	}
+}
+
+// handle processes a datagram from the peer.
+func (e *endpoint) handle(datagram []byte) {
+	kind, seq, message, ok := decodePacket(datagram)
+	if !ok {
+		fmt.Printf("Ignoring invalid datagram: %q\n", datagram)
+		return
+	}
+
+	e.mu.Lock()
+	e.lastSeen = time.Now()
+	if kind == ackPacket {
+		if window, err := strconv.Atoi(message); err == nil {
+			e.rwnd = window
+		}
+		e.acknowledge(seq, e.lastSeen)
+		packets := e.fill()
+		e.mu.Unlock()
+		for _, packet := range packets {
+			e.transmit(packet)
+		}
+		return
+	}
+
+	var ready []string
+	_, seen := e.buffered[seq]
+	switch {
+	case seq < e.expected || seen:
+		e.stats.duplicates++
+		fmt.Printf("Duplicate of seq %d from %s suppressed\n", seq, e.peer)
+	case seq > e.expected && len(e.buffered) >= receiveWindow:
+		// No room to hold it, the sender will send it again.
+		e.stats.refused++
+	case seq > e.expected:
+		// An earlier message is missing, hold this one until it arrives.
+		e.buffered[seq] = message
+		e.stats.early++
+	default:
+		ready = append(ready, message)
+		for {
+			next, ok := e.buffered[seq+uint64(len(ready))]
+			if !ok {
+				break
+			}
+			delete(e.buffered, seq+uint64(len(ready)))
+			ready = append(ready, next)
+		}
+		e.expected += uint64(len(ready))
+		e.stats.delivered += uint64(len(ready))
+	}
+	ack, window := e.expected-1, receiveWindow-len(e.buffered)
+	e.mu.Unlock()
+
+	e.transmit(encodeAck(ack, window))
+	for i, message := range ready {
+		e.deliver(seq+uint64(i), message)
+	}
+}
+
+// acknowledge forgets the messages up to seq, the cumulative ACK received at
+// now, measures the round-trip time of seq and opens the congestion window. e.mu must be held.
+func (e *endpoint) acknowledge(seq uint64, now time.Time) {
+	var acked int
+	var sentAt time.Time
+	retransmitted := false
+	for s, p := range e.unacked {
+		if s > seq {
+			continue
+		}
+		if s == seq {
+			sentAt = p.sentAt
+		}
+		retransmitted = retransmitted || p.attempts > 1
+		delete(e.unacked, s)
+		acked++
+	}
+	if acked == 0 {
+		return
+	}
+
+	// The ACK answers seq, unless a message it covers was sent again:
+	// there is no telling which copy it answers, and it may have filled a
+	// gap that held the ACK of seq back
+	if !retransmitted && !sentAt.IsZero() {
+		e.sample(now.Sub(sentAt))
+	}
+
+	// Additive increase, one message per window acknowledged
+	before := int(e.cwnd)
+	e.cwnd = min(e.cwnd+float64(acked)/e.cwnd, maxWindow)
+	e.stats.peakWindow = max(e.stats.peakWindow, int(e.cwnd))
+	if int(e.cwnd) != before {
+		e.tracef("Window to %s grows to %d", e.peer)
+	}
+}
+
+// sample updates SRTT, RTTVAR and the RTO with the round-trip time rtt, as
+// RFC 6298 has it. e.mu must be held.
+func (e *endpoint) sample(rtt time.Duration) {
+	if e.stats.samples == 0 {
+		e.srtt, e.rttvar = rtt, rtt/2
+	} else {
+		e.rttvar = (3*e.rttvar + (e.srtt - rtt).Abs()) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+	}
+	e.stats.samples++
+	e.rto = min(max(e.srtt+max(tick, 4*e.rttvar), minRTO), maxRTO)
+}
+
+// run retransmits the messages whose ACK is overdue until ctx is canceled.
+func (e *endpoint) run(ctx context.Context) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.retransmit(now)
+		}
+	}
+}
+
+func (e *endpoint) retransmit(now time.Time) {
+	var packets [][]byte
+	e.mu.Lock()
+	for seq, p := range e.unacked {
+		if now.Sub(p.sentAt) < p.rto {
+			continue
+		}
+		if p.attempts == maxAttempts {
+			delete(e.unacked, seq)
+			e.stats.givenUp++
+			fmt.Printf("Giving up on seq %d to %s after %d attempts\n", seq, e.peer, p.attempts)
+			continue
+		}
+
+		// Multiplicative decrease, once for all the messages of the window
+		// the loss happened in
+		if seq > e.recovery {
+			e.cwnd = max(e.cwnd/2, 1)
+			e.recovery = e.next
+			e.stats.reductions++
+			e.tracef("Window to %s halves to %d after seq %d timed out", e.peer, seq)
+		}
+		p.attempts++
+		p.sentAt = now
+		p.rto = min(2*p.rto, maxRTO)
+		e.stats.retransmitted++
+		fmt.Printf("Retransmitting seq %d to %s (attempt %d)\n", seq, e.peer, p.attempts)
+		packets = append(packets, p.packet)
+	}
+	// Giving up made room
+	packets = append(packets, e.fill()...)
+	e.mu.Unlock()
+
+	for _, packet := range packets {
+		e.transmit(packet)
+	}
+}
+
+// tracef prints a change of the congestion window with format, the window
+// and the RTT estimates, when tracing. e.mu must be held.
+func (e *endpoint) tracef(format, peer string, args ...any) {
+	if !e.trace {
+		return
+	}
+	args = append([]any{peer, int(e.cwnd)}, args...)
+	fmt.Printf(format+" (rwnd %d, %d in flight, %d queued, srtt %s, rttvar %s, rto %s)\n",
+		append(args, e.rwnd, len(e.unacked), len(e.queue), e.srtt.Round(time.Microsecond), e.rttvar.Round(time.Microsecond), e.rto.Round(time.Millisecond))...)
+}
+
+// flush waits until every message sent was acknowledged or given up on, at
+// most timeout.
+func (e *endpoint) flush(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		e.mu.Lock()
+		idle := len(e.unacked) == 0 && len(e.queue) == 0
+		e.mu.Unlock()
+		if idle {
+			return
+		}
+		time.Sleep(tick)
+	}
+}
+
+// idleSince reports when the peer was last heard from.
+func (e *endpoint) idleSince() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lastSeen
+}
+
+// report summarizes what the endpoint sent and received, and where its
+// window and RTT estimates ended up.
+func (e *endpoint) report() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.stats
+	return fmt.Sprintf(
+		"Sent %d (%d retransmissions, %d dropped on purpose, %d given up), received %d (%d duplicates suppressed, %d held for reordering, %d refused for lack of room)\n"+
+			"Window %.1f (peak %d, halved %d times, %d queued at most), srtt %s, rttvar %s, rto %s from %d samples",
+		s.sent, s.retransmitted, s.dropped, s.givenUp, s.delivered, s.duplicates, s.early, s.refused,
+		e.cwnd, s.peakWindow, s.reductions, s.peakQueue, e.srtt.Round(time.Microsecond), e.rttvar.Round(time.Microsecond), e.rto.Round(time.Millisecond), s.samples,
+	)
+}
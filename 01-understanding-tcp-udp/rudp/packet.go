@@ -0,0 +1,43 @@
+package rudp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Two kinds of datagrams travel in both directions, written as text so they
+// can be read in tcpdump:
+//
+//	data|<seq>|<message>   a message, numbered by its sender from 1
+//	ack|<seq>|<window>     every message up to seq arrived, a cumulative ACK,
+//	                       and window more fit in the receiver's buffer
+//
+// A data datagram is sent again until an ACK covers it, and its receiver
+// acknowledges every data datagram, duplicates included, since the ACK of
+// the first copy may be the one that got lost.
+
+const (
+	dataPacket = "data"
+	ackPacket  = "ack"
+)
+
+func encodeData(seq uint64, message string) []byte {
+	return []byte(fmt.Sprintf("%s|%d|%s", dataPacket, seq, message))
+}
+
+func encodeAck(seq uint64, window int) []byte {
+	return []byte(fmt.Sprintf("%s|%d|%d", ackPacket, seq, window))
+}
+
+// decodePacket splits a datagram into its kind, sequence number and, for
+// data, message, or for an ACK, window. ok is false for anything else.
+func decodePacket(datagram []byte) (kind string, seq uint64, message string, ok bool) {
+	kind, rest, _ := strings.Cut(string(datagram), "|")
+	prefix, message, _ := strings.Cut(rest, "|")
+	seq, err := strconv.ParseUint(prefix, 10, 64)
+	if err != nil || (kind != dataPacket && kind != ackPacket) {
+		return "", 0, "", false
+	}
+	return kind, seq, message, true
+}
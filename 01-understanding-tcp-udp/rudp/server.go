@@ -0,0 +1,118 @@
+package rudp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// peerTimeout is how long the server remembers a client it does not hear
+// from, with its sequence numbers and unacknowledged echoes.
+const peerTimeout = time.Minute
+
+// Options tune the reliable UDP echo.
+type Options struct {
+	// DropRate is the fraction of outgoing datagrams, data and ACKs,
+	// dropped on purpose to watch the retransmissions recover them. Zero
+	// drops none.
+	DropRate float64
+
+	// Trace prints the congestion window every time it changes, with the
+	// advertised window and the RTT estimates, to watch it adapt to loss.
+	Trace bool
+}
+
+// Server runs the echo server with the default Options.
+func Server(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	Options{}.Server(ctx, wg, addr)
+}
+
+// Server runs the echo server on the UDP address addr until ctx is canceled,
+// keeping one endpoint per client address.
+func (o Options) Server(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	// Create UDP address
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		fmt.Println("Error resolving address:", err)
+		return
+	}
+
+	// Create UDP connection
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		fmt.Println("Error listening:", err)
+		return
+	}
+	defer conn.Close()
+
+	fmt.Println("Reliable UDP Server listening on", conn.LocalAddr())
+
+	// Echoes waiting for their ACK are dropped on shutdown, like UDP there
+	// are no connections to drain
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	peers := make(map[string]*endpoint)
+	cancels := make(map[string]context.CancelFunc)
+	forgetIdle := time.NewTicker(peerTimeout / 2)
+	defer forgetIdle.Stop()
+
+	buffer := make([]byte, 2048)
+	for {
+		// Read incoming datagram
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				for _, cancel := range cancels {
+					cancel()
+				}
+				fmt.Println("Reliable UDP Server stopped")
+				return
+			}
+			fmt.Println("Error reading from UDP:", err)
+			continue
+		}
+
+		select {
+		case <-forgetIdle.C:
+			for key, peer := range peers {
+				if time.Since(peer.idleSince()) > peerTimeout {
+					cancels[key]()
+					delete(peers, key)
+					delete(cancels, key)
+				}
+			}
+		default:
+		}
+
+		key := remoteAddr.String()
+		peer, ok := peers[key]
+		if !ok {
+			fmt.Println("New client:", remoteAddr)
+			peer = newEndpoint(
+				key,
+				func(datagram []byte) error {
+					_, err := conn.WriteToUDP(datagram, remoteAddr)
+					return err
+				},
+				nil,
+				o,
+			)
+			// Echo every message back, reliably too
+			peer.deliver = func(seq uint64, message string) {
+				fmt.Printf("Received from %s [seq %d]: %s\n", remoteAddr, seq, message)
+				peer.send("Echo: " + message)
+			}
+			peerCtx, cancel := context.WithCancel(ctx)
+			go peer.run(peerCtx)
+			peers[key], cancels[key] = peer, cancel
+		}
+		peer.handle(buffer[:n])
+	}
+}
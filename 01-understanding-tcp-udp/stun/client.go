@@ -0,0 +1,140 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// initialRTO is how long the first binding request is waited on before
+	// it is sent again, doubling with every attempt, as RFC 5389 has it.
+	initialRTO = 500 * time.Millisecond
+
+	// maxAttempts is how many times a request is sent: 7.5 seconds of
+	// waiting in all, rather than the 39.5 of the RFC.
+	maxAttempts = 4
+)
+
+// Options tune the STUN client.
+type Options struct {
+	// Servers are more STUN servers to query, "host:port", after the one
+	// the client is given. Telling the kinds of NAT apart takes two servers
+	// at different addresses.
+	Servers []string
+}
+
+// Client runs the STUN client with no server but addr.
+func Client(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	Options{}.Client(ctx, wg, addr)
+}
+
+// Client sends binding requests to the STUN server at addr and to Servers,
+// all from one UDP socket, prints the public address each of them sees and
+// what it tells of the NAT in the way.
+func (o Options) Client(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	// One unconnected socket for every server: the NAT is classified by
+	// how it maps that one socket to each of them
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		fmt.Println("Error listening:", err)
+		return
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	var bindings []binding
+	for _, server := range append([]string{addr}, o.Servers...) {
+		serverAddr, err := net.ResolveUDPAddr("udp4", server)
+		if err != nil {
+			fmt.Println("Error resolving address:", err)
+			continue
+		}
+		mapped, rtt, err := bind(conn, serverAddr)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Printf("Binding request to %s failed: %s\n", serverAddr, err)
+			continue
+		}
+		fmt.Printf("STUN server %s sees us as %s (%s)\n", serverAddr, mapped, rtt.Round(time.Microsecond))
+		bindings = append(bindings, binding{server: serverAddr, mapped: mapped, local: localAddr(conn, serverAddr)})
+	}
+	if len(bindings) == 0 {
+		fmt.Println("No STUN server answered, UDP may be blocked")
+		return
+	}
+	fmt.Println("NAT type:", classify(bindings))
+}
+
+// bind sends a binding request to server over conn, again as long as no
+// response arrives, and returns the mapped address of the response and the
+// round-trip time of the request answered.
+func bind(conn *net.UDPConn, server *net.UDPAddr) (*net.UDPAddr, time.Duration, error) {
+	request := &message{
+		kind:       bindingRequest,
+		id:         newTransactionID(),
+		attributes: map[uint16][]byte{attrSoftware: []byte(software)},
+	}
+	datagram := request.encode()
+	buffer := make([]byte, 1500)
+	rto := initialRTO
+	for range maxAttempts {
+		// Every attempt is the same transaction, a response to any of them
+		// will do: the round-trip time is from the last one sent
+		sent := time.Now()
+		if _, err := conn.WriteToUDP(datagram, server); err != nil {
+			return nil, 0, err
+		}
+		conn.SetReadDeadline(sent.Add(rto))
+		for {
+			n, from, err := conn.ReadFromUDP(buffer)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			response, err := decode(buffer[:n])
+			if err != nil || response.id != request.id || !from.IP.Equal(server.IP) {
+				// A late response to an earlier request, or stray traffic
+				continue
+			}
+			if response.kind == bindingError {
+				return nil, 0, fmt.Errorf("server answered %s", response.errorCode())
+			}
+			if response.kind != bindingResponse {
+				continue
+			}
+			mapped, err := response.mappedAddress()
+			return mapped, time.Since(sent), err
+		}
+		rto *= 2
+	}
+	return nil, 0, fmt.Errorf("no response after %d attempts", maxAttempts)
+}
+
+// localAddr returns the address of conn as the packets to server leave it.
+// conn listens on every interface, the kernel picks the IP from its routes,
+// which a connected socket to server reveals.
+func localAddr(conn *net.UDPConn, server *net.UDPAddr) *net.UDPAddr {
+	local := *conn.LocalAddr().(*net.UDPAddr)
+	probe, err := net.DialUDP("udp4", nil, server)
+	if err != nil {
+		return &local
+	}
+	defer probe.Close()
+	local.IP = probe.LocalAddr().(*net.UDPAddr).IP
+	return &local
+}
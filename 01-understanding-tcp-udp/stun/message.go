@@ -0,0 +1,173 @@
+package stun
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// A STUN message (RFC 5389) is a 20 byte header followed by attributes:
+//
+//	type (2) | length of the attributes (2) | magic cookie (4) | transaction ID (12)
+//	attribute type (2) | value length (2) | value, padded to 4 bytes | ...
+//
+// A binding request asks the server for the address it sees the request
+// coming from, the mapped address, which behind a NAT is the public one the
+// NAT gave the socket. The server answers with a binding response carrying
+// it XORed with the magic cookie, so that NATs rewriting addresses they find
+// in payloads leave it alone.
+
+const (
+	headerSize  = 20
+	magicCookie = 0x2112A442
+
+	bindingRequest  = 0x0001
+	bindingResponse = 0x0101
+	bindingError    = 0x0111
+
+	attrMappedAddress    = 0x0001
+	attrErrorCode        = 0x0009
+	attrXORMappedAddress = 0x0020
+	attrSoftware         = 0x8022
+
+	familyIPv4 = 0x01
+	familyIPv6 = 0x02
+
+	// software is the SOFTWARE attribute of the messages sent.
+	software = "transport-stun"
+)
+
+type transactionID [12]byte
+
+// message is a decoded STUN message, attributes by type.
+type message struct {
+	kind       uint16
+	id         transactionID
+	attributes map[uint16][]byte
+}
+
+func newTransactionID() transactionID {
+	var id transactionID
+	rand.Read(id[:])
+	return id
+}
+
+// encode returns the datagram of m.
+func (m *message) encode() []byte {
+	b := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(b[0:], m.kind)
+	binary.BigEndian.PutUint32(b[4:], magicCookie)
+	copy(b[8:], m.id[:])
+	for kind, value := range m.attributes {
+		b = binary.BigEndian.AppendUint16(b, kind)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+		b = append(b, value...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)-headerSize))
+	return b
+}
+
+// decode parses a datagram, failing for anything that is not a STUN message.
+func decode(b []byte) (*message, error) {
+	if len(b) < headerSize {
+		return nil, errors.New("shorter than a STUN header")
+	}
+	// The two top bits of every STUN message are zero, which tells it apart
+	// from the other protocols sharing a port with it
+	if b[0]&0xC0 != 0 || binary.BigEndian.Uint32(b[4:]) != magicCookie {
+		return nil, errors.New("not a STUN message")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if length%4 != 0 || headerSize+length > len(b) {
+		return nil, fmt.Errorf("bad message length %d", length)
+	}
+	m := &message{kind: binary.BigEndian.Uint16(b[0:]), attributes: make(map[uint16][]byte)}
+	copy(m.id[:], b[8:headerSize])
+	for rest := b[headerSize : headerSize+length]; len(rest) > 0; {
+		if len(rest) < 4 {
+			return nil, errors.New("truncated attribute")
+		}
+		kind, size := binary.BigEndian.Uint16(rest[0:]), int(binary.BigEndian.Uint16(rest[2:]))
+		padded := (size + 3) &^ 3
+		if 4+padded > len(rest) {
+			return nil, fmt.Errorf("attribute %#04x overflows the message", kind)
+		}
+		// The first of repeated attributes is the one that counts
+		if _, ok := m.attributes[kind]; !ok {
+			m.attributes[kind] = rest[4 : 4+size]
+		}
+		rest = rest[4+padded:]
+	}
+	return m, nil
+}
+
+// xorAddress is the value of an XOR-MAPPED-ADDRESS attribute for addr, or of
+// a MAPPED-ADDRESS one when xor is false.
+func xorAddress(addr *net.UDPAddr, id transactionID, xor bool) []byte {
+	ip, family := addr.IP.To4(), byte(familyIPv4)
+	if ip == nil {
+		ip, family = addr.IP.To16(), familyIPv6
+	}
+	value := []byte{0, family, 0, 0}
+	binary.BigEndian.PutUint16(value[2:], uint16(addr.Port))
+	value = append(value, ip...)
+	if xor {
+		xorMask(value, id)
+	}
+	return value
+}
+
+// parseAddress is the reverse of xorAddress.
+func parseAddress(value []byte, id transactionID, xor bool) (*net.UDPAddr, error) {
+	if len(value) < 4 {
+		return nil, errors.New("truncated address")
+	}
+	size := map[byte]int{familyIPv4: net.IPv4len, familyIPv6: net.IPv6len}[value[1]]
+	if size == 0 || len(value) != 4+size {
+		return nil, fmt.Errorf("bad address family %d or length %d", value[1], len(value))
+	}
+	value = append([]byte(nil), value...)
+	if xor {
+		xorMask(value, id)
+	}
+	return &net.UDPAddr{IP: net.IP(value[4:]), Port: int(binary.BigEndian.Uint16(value[2:]))}, nil
+}
+
+// xorMask XORs the port of an address value with the top half of the magic
+// cookie, and the IP with the cookie followed by the transaction ID.
+func xorMask(value []byte, id transactionID) {
+	mask := binary.BigEndian.AppendUint32(nil, magicCookie)
+	mask = append(mask, id[:]...)
+	value[2] ^= mask[0]
+	value[3] ^= mask[1]
+	for i := range value[4:] {
+		value[4+i] ^= mask[i]
+	}
+}
+
+// mappedAddress returns the address of a binding response, from the
+// XOR-MAPPED-ADDRESS attribute or, for servers of the older RFC 3489, the
+// MAPPED-ADDRESS one.
+func (m *message) mappedAddress() (*net.UDPAddr, error) {
+	if value, ok := m.attributes[attrXORMappedAddress]; ok {
+		return parseAddress(value, m.id, true)
+	}
+	if value, ok := m.attributes[attrMappedAddress]; ok {
+		return parseAddress(value, m.id, false)
+	}
+	return nil, errors.New("no mapped address in the response")
+}
+
+// errorCode describes the ERROR-CODE attribute of an error response.
+func (m *message) errorCode() string {
+	value := m.attributes[attrErrorCode]
+	if len(value) < 4 {
+		return "unknown error"
+	}
+	return fmt.Sprintf("error %d %s", int(value[2]&0x7)*100+int(value[3]), value[4:])
+}
@@ -0,0 +1,58 @@
+package stun
+
+import (
+	"fmt"
+	"net"
+)
+
+/**
+ * * A NAT gives a socket a public address, its mapping, as the socket first sends out. What sets
+ * * NATs apart is whether the mapping is the same whoever the socket sends to (endpoint-independent,
+ * * the cone NATs) or a new one per destination (symmetric NATs), which STUN servers at different
+ * * addresses reveal by answering with different mapped addresses. Hole punching needs the former:
+ * * a peer is told the address a STUN server saw, which a symmetric NAT does not reuse for the peer.
+ * * Which packets the NAT then lets in, its filtering, takes a server that can answer from another
+ * * address (RFC 5780), which the public ones do not do, so it is not classified.
+ */
+
+// binding is what one STUN server answered.
+type binding struct {
+	server *net.UDPAddr
+	mapped *net.UDPAddr
+	local  *net.UDPAddr
+}
+
+// classify tells what bindings, for one socket, say of the NAT in the way.
+func classify(bindings []binding) string {
+	first := bindings[0]
+	for _, b := range bindings {
+		if b.mapped.IP.Equal(b.local.IP) && b.mapped.Port == b.local.Port {
+			return "no NAT, the servers see the address of the socket itself"
+		}
+	}
+
+	port := "ports are not preserved"
+	if first.mapped.Port == first.local.Port {
+		port = "ports are preserved"
+	}
+
+	// Only servers at different IPs can tell an address-dependent mapping
+	// apart from an endpoint-independent one
+	var other *binding
+	for i := range bindings[1:] {
+		if !bindings[1+i].server.IP.Equal(first.server.IP) {
+			other = &bindings[1+i]
+			break
+		}
+	}
+	if other == nil {
+		return fmt.Sprintf("behind a NAT, %s, mapping unknown: it takes a second STUN server at another address", port)
+	}
+
+	for _, b := range bindings[1:] {
+		if !b.mapped.IP.Equal(first.mapped.IP) || b.mapped.Port != first.mapped.Port {
+			return fmt.Sprintf("symmetric NAT, a mapping per destination (%s then %s), hole punching is unlikely to work", first.mapped, b.mapped)
+		}
+	}
+	return fmt.Sprintf("cone NAT, endpoint-independent mapping, %s, hole punching should work", port)
+}
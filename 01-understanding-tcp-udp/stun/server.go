@@ -0,0 +1,75 @@
+package stun
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Server answers the binding requests arriving at addr until ctx is canceled,
+// with the address each came from. It is enough for trying the client out
+// without a public server, where it sees the client's own address, and is
+// what a STUN server does for a client behind a NAT too.
+func Server(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	// Create UDP address
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		fmt.Println("Error resolving address:", err)
+		return
+	}
+
+	// Create UDP connection
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		fmt.Println("Error listening:", err)
+		return
+	}
+	defer conn.Close()
+
+	fmt.Println("STUN Server listening on", conn.LocalAddr())
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buffer := make([]byte, 1500)
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				fmt.Println("STUN Server stopped")
+				return
+			}
+			fmt.Println("Error reading from UDP:", err)
+			continue
+		}
+
+		request, err := decode(buffer[:n])
+		if err != nil || request.kind != bindingRequest {
+			// Not for a STUN server, and not worth an answer
+			continue
+		}
+		fmt.Printf("Binding request from %s (%s)\n", remoteAddr, request.attributes[attrSoftware])
+
+		// An IPv4 client on a dual-stack socket reads as ::ffff:a.b.c.d
+		mapped := &net.UDPAddr{IP: remoteAddr.IP, Port: remoteAddr.Port}
+		if ip := mapped.IP.To4(); ip != nil {
+			mapped.IP = ip
+		}
+		response := &message{
+			kind: bindingResponse,
+			id:   request.id,
+			attributes: map[uint16][]byte{
+				attrXORMappedAddress: xorAddress(mapped, request.id, true),
+				attrSoftware:         []byte(software),
+			},
+		}
+		if _, err := conn.WriteToUDP(response.encode(), remoteAddr); err != nil {
+			fmt.Printf("Error sending response to %s: %s\n", remoteAddr, err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package tcp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BenchConfig configures Bench.
+type BenchConfig struct {
+	// Connections is the total number of connections to open, Concurrency
+	// how many of them are open at the same time.
+	Connections int
+	Concurrency int
+
+	// Linger is passed to SetLinger on every client connection before it is
+	// closed. A negative value keeps the default graceful close, which leaves
+	// the client side of every connection in TIME_WAIT for a while. Zero makes
+	// Close send a RST instead, so no TIME_WAIT entry is left behind.
+	Linger int
+
+	// Reuse is the number of request/response exchanges made over every
+	// connection before it is closed. Reusing connections is the real fix for
+	// port exhaustion, fewer connections means fewer ports in TIME_WAIT.
+	Reuse int
+}
+
+/**
+ * * Bench opens short-lived TCP connections to a local echo server as fast as it can, to show
+ * * ephemeral port exhaustion.
+ *
+ * * Every connection the client closes first stays in TIME_WAIT for up to a couple of minutes
+ * * and keeps its ephemeral port (32768-60999 by default on Linux) reserved. Once they are all
+ * * taken, Dial fails with "cannot assign requested address". Bench reports the accept rate,
+ * * how many sockets ended up in TIME_WAIT and the errors seen.
+ */
+func Bench(config BenchConfig) {
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	if config.Reuse <= 0 {
+		config.Reuse = 1
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println("Error starting server:", err)
+		return
+	}
+	defer listener.Close()
+
+	var accepted atomic.Int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go echo(conn)
+		}
+	}()
+
+	fmt.Printf("Opening %d connections, %d at a time, %d exchanges each, linger %d\n",
+		config.Connections, config.Concurrency, config.Reuse, config.Linger)
+	timeWaitBefore := countTimeWait()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   = make(map[string]int)
+		next   atomic.Int64
+		failed atomic.Int64
+	)
+	start := time.Now()
+	for i := 0; i < config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next.Add(1) <= int64(config.Connections) {
+				if err := benchConnection(listener.Addr().String(), config); err != nil {
+					failed.Add(1)
+					mu.Lock()
+					errs[errorKind(err)]++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	fmt.Printf("Accepted %d connections in %s (%.0f/s), %d failed\n",
+		accepted.Load(), elapsed.Round(time.Millisecond), float64(accepted.Load())/elapsed.Seconds(), failed.Load())
+	if timeWaitAfter := countTimeWait(); timeWaitAfter >= 0 {
+		fmt.Printf("Sockets in TIME_WAIT: %d before, %d after\n", timeWaitBefore, timeWaitAfter)
+	} else {
+		fmt.Println("Sockets in TIME_WAIT: unknown, /proc/net/tcp is not available")
+	}
+
+	kinds := make([]string, 0, len(errs))
+	for kind := range errs {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Printf("  %6d x %s\n", errs[kind], kind)
+	}
+}
+
+// benchConnection dials addr, makes config.Reuse exchanges and closes the
+// connection from the client side, so the client keeps the TIME_WAIT entry.
+func benchConnection(addr string, config BenchConfig) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if config.Linger >= 0 {
+		conn.(*net.TCPConn).SetLinger(config.Linger)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for i := 0; i < config.Reuse; i++ {
+		if _, err := fmt.Fprintf(conn, "ping %d\n", i); err != nil {
+			return err
+		}
+		if _, err := reader.ReadString('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// echo answers every line read from conn, like handleConnection but silent.
+func echo(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		message, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("Echo: " + message))
+	}
+}
+
+// errorKind strips the addresses from err so that errors can be grouped.
+func errorKind(err error) string {
+	message := err.Error()
+	if i := strings.LastIndex(message, ": "); i >= 0 {
+		return message[i+2:]
+	}
+	return message
+}
+
+// countTimeWait returns the number of TCP sockets in TIME_WAIT, or -1 when
+// the system does not expose them in /proc/net (Linux only).
+func countTimeWait() int {
+	count, found := 0, false
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		found = true
+		for _, line := range strings.Split(string(data), "\n")[1:] {
+			// The fourth column is the state, 06 is TIME_WAIT.
+			if fields := strings.Fields(line); len(fields) > 3 && fields[3] == "06" {
+				count++
+			}
+		}
+	}
+	if !found {
+		return -1
+	}
+	return count
+}
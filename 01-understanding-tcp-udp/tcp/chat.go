@@ -0,0 +1,151 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In chat mode the server does not echo: every text frame a client sends is
+// broadcast to the other clients, "[alice] hi all", binary frames as they
+// are. Joins, leaves and nickname changes are announced by lines starting
+// with "*". Two commands are understood, "/nick <name>" and "/quit".
+
+const (
+	// maxNickname bounds the length of a nickname.
+	maxNickname = 32
+
+	// broadcastTimeout is how long a broadcast waits on a client that does
+	// not read, before that client misses the message.
+	broadcastTimeout = time.Second
+)
+
+// chatClient is a client of the chat room.
+type chatClient struct {
+	conn     net.Conn
+	nickname string
+}
+
+// handle is what the client is called in the room, its nickname or else its
+// address.
+func (c *chatClient) handle() string {
+	if c.nickname == "" {
+		return c.conn.RemoteAddr().String()
+	}
+	return c.nickname
+}
+
+// registry holds the clients connected to the chat room.
+type registry struct {
+	mu      sync.Mutex
+	clients map[net.Conn]*chatClient
+}
+
+func newRegistry() *registry {
+	return &registry{clients: make(map[net.Conn]*chatClient)}
+}
+
+// join adds the client of conn and announces it.
+func (r *registry) join(conn net.Conn) {
+	r.mu.Lock()
+	client := &chatClient{conn: conn}
+	r.clients[conn] = client
+	r.mu.Unlock()
+	r.announce(conn, fmt.Sprintf("* %s joined", client.handle()))
+}
+
+// leave removes the client of conn and announces it.
+func (r *registry) leave(conn net.Conn) {
+	r.mu.Lock()
+	client, ok := r.clients[conn]
+	delete(r.clients, conn)
+	r.mu.Unlock()
+	if ok {
+		r.announce(conn, fmt.Sprintf("* %s left", client.handle()))
+	}
+}
+
+// rename sets the nickname of the client of conn, unique in the room, and
+// announces it.
+func (r *registry) rename(conn net.Conn, nickname string) error {
+	if nickname == "" || len(nickname) > maxNickname || strings.ContainsAny(nickname, " \t") {
+		return fmt.Errorf("nickname must be 1 to %d characters, without spaces", maxNickname)
+	}
+	r.mu.Lock()
+	for _, other := range r.clients {
+		if other.conn != conn && other.nickname == nickname {
+			r.mu.Unlock()
+			return fmt.Errorf("nickname %s is taken", nickname)
+		}
+	}
+	client := r.clients[conn]
+	before := client.handle()
+	client.nickname = nickname
+	r.mu.Unlock()
+	r.announce(conn, fmt.Sprintf("* %s is now %s", before, nickname))
+	return nil
+}
+
+// name returns the handle of the client of conn.
+func (r *registry) name(conn net.Conn) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.clients[conn].handle()
+}
+
+// announce sends line to every client but the one of from.
+func (r *registry) announce(from net.Conn, line string) {
+	r.broadcast(from, Frame{Type: FrameText, Payload: []byte(line)})
+}
+
+// broadcast sends frame to every client but the one of from. A client that
+// does not read within broadcastTimeout misses it rather than holding up
+// the others.
+func (r *registry) broadcast(from net.Conn, frame Frame) {
+	r.mu.Lock()
+	conns := make([]net.Conn, 0, len(r.clients))
+	for conn := range r.clients {
+		if conn != from {
+			conns = append(conns, conn)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.SetWriteDeadline(time.Now().Add(broadcastTimeout))
+		if err := WriteFrame(conn, frame); err != nil && !errors.Is(err, net.ErrClosed) {
+			fmt.Printf("Error sending to %s: %s\n", conn.RemoteAddr(), err)
+		}
+		conn.SetWriteDeadline(time.Time{})
+	}
+}
+
+// chat handles a frame of the client of conn in the room. It returns false
+// once the client quit.
+func (r *registry) chat(conn net.Conn, frame Frame) bool {
+	if frame.Type != FrameText {
+		r.broadcast(conn, frame)
+		return true
+	}
+	message := string(frame.Payload)
+	reply := func(line string) {
+		WriteFrame(conn, Frame{Type: FrameText, Payload: []byte(line)})
+	}
+	switch {
+	case message == "/quit":
+		reply("* Bye")
+		return false
+	case strings.HasPrefix(message, "/nick "):
+		if err := r.rename(conn, strings.TrimPrefix(message, "/nick ")); err != nil {
+			reply("* Error: " + err.Error())
+		} else {
+			reply("* Nickname set to " + strings.TrimPrefix(message, "/nick "))
+		}
+	default:
+		r.announce(conn, fmt.Sprintf("[%s] %s", r.name(conn), message))
+	}
+	return true
+}
@@ -2,15 +2,35 @@ package tcp
 
 import (
 	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"strings"
 	"sync"
+	"time"
+
+	"netsim/lossy"
 )
 
-func Client(wg *sync.WaitGroup) {
-	// Connect to server
-	conn, err := net.Dial("tcp", "localhost:8080")
+// Client runs the interactive client with the default Options.
+func Client(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	Options{}.Client(ctx, wg, addr)
+}
+
+// Client connects to addr and sends it the lines typed on stdin until ctx is
+// canceled, tuning the connection with o. Every line is a text frame, except
+// "/hex <bytes>", which sends the bytes as a binary frame. With o.Codec every
+// line is the body of a Message instead. At the end of the input the client
+// closes its writing side and reads the answers until the server closes its
+// own.
+func (o Options) Client(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	conn, err := o.dial(ctx, addr)
 	if err != nil {
 		fmt.Println("Error connecting:", err)
 		return
@@ -19,26 +39,309 @@ func Client(wg *sync.WaitGroup) {
 
 	fmt.Println("Connected to server. Type your message (exit to quit):")
 
+	beat := startHeartbeat(conn, o.Heartbeat, o.Liveness)
+	defer beat.stop()
+
 	// Start a goroutine to read server responses
+	decoder := NewDecoder(nil)
+	closed := make(chan struct{})
 	go func() {
-		reader := bufio.NewReader(conn)
+		defer close(closed)
+		reader := bufio.NewReader(beat.reader(conn))
 		for {
-			message, err := reader.ReadString('\n')
+			frame, err := ReadFrame(reader)
 			if err != nil {
-				fmt.Println("Server connection closed")
-				os.Exit(0)
+				switch {
+				case beat.timedOut():
+					fmt.Printf("Server presumed dead, nothing received for %s (%s)\n", beat.liveness, beat)
+				case errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed):
+					fmt.Println("Server connection closed")
+				default:
+					fmt.Println("Server connection closed:", err)
+				}
+				return
+			}
+			if ping, err := answerPing(conn, frame, beat); ping {
+				if err != nil {
+					fmt.Println("Error answering ping:", err)
+					return
+				}
+				continue
 			}
-			fmt.Print("Server: ", message)
+			if frame.Type == FrameClose {
+				fmt.Println("Server closed the connection:", string(frame.Payload))
+				return
+			}
+			if frame.Type == FrameGob || frame.Type == FrameJSON {
+				m, err := decoder.decodeFrame(frame)
+				if err != nil {
+					fmt.Println("Server sent a bad message:", err)
+					continue
+				}
+				fmt.Printf("Server: %s (%s, %d bytes)\n", m, codecOf(frame), len(frame.Payload))
+				continue
+			}
+			fmt.Println("Server:", frame)
 		}
 	}()
 
 	// Read user input and send to server
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		message := scanner.Text()
-		if message == "exit" {
+	var encoder *Encoder
+	if o.Codec != 0 {
+		encoder = NewEncoder(conn, o.Codec)
+	}
+	lines := readLines(os.Stdin)
+	for id := uint64(1); ; id++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case message, ok := <-lines:
+			if !ok {
+				finish(conn, beat, closed)
+				return
+			}
+			if message == "exit" {
+				return
+			}
+			if encoder != nil {
+				if _, err := encoder.Encode(Message{ID: id, Kind: "text", Body: message}); err != nil {
+					fmt.Println("Error sending message:", err)
+					return
+				}
+				continue
+			}
+			frame := Frame{Type: FrameText, Payload: []byte(message)}
+			if data, found := strings.CutPrefix(message, "/hex "); found {
+				payload, err := hex.DecodeString(strings.ReplaceAll(data, " ", ""))
+				if err != nil {
+					fmt.Println("Not hex:", err)
+					continue
+				}
+				frame = Frame{Type: FrameBinary, Payload: payload}
+			}
+			if err := WriteFrame(conn, frame); err != nil {
+				fmt.Println("Error sending message:", err)
+				return
+			}
+		}
+	}
+}
+
+// finish half-closes conn at the end of the input and waits for closed, the
+// server closing its side once it answered everything, for up to
+// lingerTimeout.
+func finish(conn net.Conn, beat *heartbeat, closed <-chan struct{}) {
+	beat.pause()
+	if err := closeWrite(conn); err != nil {
+		fmt.Println("Error closing the writing side:", err)
+		return
+	}
+	fmt.Println("End of input, waiting for the last answers")
+	select {
+	case <-closed:
+	case <-time.After(lingerTimeout):
+		fmt.Println("The server did not close its side after", lingerTimeout)
+	}
+}
+
+// PoolClient is Client over a Pool configured by config, to the echo server
+// at addr: every line goes out on a goroutine of its own, over a connection
+// checked out of the pool for the exchange and checked back in once the echo
+// arrived. Lines typed one by one reuse the same connection, lines piped in
+// open more, up to the size of the pool. It prints the counters of the pool
+// at the end.
+func (o Options) PoolClient(ctx context.Context, wg *sync.WaitGroup, addr string, config PoolConfig) {
+	defer wg.Done()
+
+	config.Addr, config.Options = addr, o
+	pool := NewPool(config)
+	defer func() {
+		pool.Close()
+		fmt.Println("Pool:", pool.Stats())
+	}()
+
+	fmt.Println("Connected to server through a pool. Type your message (exit to quit):")
+
+	var exchanges sync.WaitGroup
+	defer exchanges.Wait()
+	lines := readLines(os.Stdin)
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case message, ok := <-lines:
+			if !ok || message == "exit" {
+				return
+			}
+			exchanges.Add(1)
+			go func() {
+				defer exchanges.Done()
+				exchange(ctx, pool, message)
+			}()
 		}
-		fmt.Fprintf(conn, "%s\n", message)
 	}
 }
+
+// CallClient is Client making a call to the handler of the server for every
+// line, over one connection through a MuxClient: the calls go out as the
+// lines are typed, without waiting for the answers of the ones before, and
+// the answers are printed as they arrive. A call gets timeout to be answered,
+// 5 seconds when zero.
+func (o Options) CallClient(ctx context.Context, wg *sync.WaitGroup, addr string, timeout time.Duration) {
+	defer wg.Done()
+	if timeout <= 0 {
+		timeout = defaultCallTimeout
+	}
+
+	conn, err := o.dial(ctx, addr)
+	if err != nil {
+		fmt.Println("Error connecting:", err)
+		return
+	}
+	client := NewMuxClient(conn)
+	var calls sync.WaitGroup
+	defer func() {
+		calls.Wait()
+		client.Close()
+		if late := client.Late(); late > 0 {
+			fmt.Printf("%d answers arrived after their call timed out\n", late)
+		}
+	}()
+
+	fmt.Println("Connected to server. Type your requests, \"2s slow\" is answered in 2s (exit to quit):")
+
+	lines := readLines(os.Stdin)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-lines:
+			if !ok || message == "exit" {
+				return
+			}
+			calls.Add(1)
+			go func() {
+				defer calls.Done()
+				callCtx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+				start := time.Now()
+				answer, err := client.Call(callCtx, []byte(message))
+				took := time.Since(start).Round(time.Millisecond)
+				if err != nil {
+					fmt.Printf("No answer to %q after %s: %s\n", message, took, err)
+					return
+				}
+				fmt.Printf("Answer in %s: %s\n", took, answer)
+			}()
+		}
+	}
+}
+
+// defaultCallTimeout is the timeout of a call of CallClient.
+const defaultCallTimeout = 5 * time.Second
+
+// exchangeTimeout bounds an exchange of PoolClient.
+const exchangeTimeout = 5 * time.Second
+
+// exchange sends message over a connection of pool and prints the echo.
+func exchange(ctx context.Context, pool *Pool, message string) {
+	conn, err := pool.Checkout(ctx)
+	if err != nil {
+		fmt.Println("Error checking out a connection:", err)
+		return
+	}
+	conn.SetDeadline(time.Now().Add(exchangeTimeout))
+	var frame Frame
+	err = WriteFrame(conn, Frame{Type: FrameText, Payload: []byte(message)})
+	if err == nil {
+		// The pings of the server may come before the answer
+		frame, err = nextFrame(conn)
+	}
+	if err != nil {
+		// Whatever is left of the exchange would be read by the next one
+		fmt.Println("Error exchanging message:", err)
+		conn.Close()
+		return
+	}
+	fmt.Printf("Server (via %s, use %d): %s\n", conn.LocalAddr(), conn.Uses(), frame)
+	pool.Checkin(conn)
+}
+
+// uploadAttempts is how many connections Upload tries the file over.
+const uploadAttempts = 5
+
+// Upload sends the file at path to the server at addr, connecting again and
+// resuming the transfer when the connection drops, until ctx is canceled.
+func (o Options) Upload(ctx context.Context, wg *sync.WaitGroup, addr, path string) {
+	defer wg.Done()
+
+	for attempt := 1; attempt <= uploadAttempts; attempt++ {
+		conn, err := o.dial(ctx, addr)
+		if err != nil {
+			fmt.Println("Error connecting:", err)
+			return
+		}
+		// Closing the connection is what interrupts the transfer
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		err = SendFile(conn, path, printProgress("Sending", path))
+		stop()
+		conn.Close()
+		switch {
+		case err == nil:
+			fmt.Println("Sent", path)
+			return
+		case ctx.Err() != nil:
+			fmt.Println("Upload interrupted, sending the file again resumes it")
+			return
+		case errors.Is(err, ErrReceiver) || errors.Is(err, os.ErrNotExist):
+			fmt.Println("Error sending file:", err)
+			return
+		}
+		fmt.Printf("Error sending file (attempt %d of %d): %s\n", attempt, uploadAttempts, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// dial connects to addr, tuned with o and with its faults. The server may be
+// started by main at the same time, so it is given a moment to listen.
+func (o Options) dial(ctx context.Context, addr string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		if conn, err = net.Dial("tcp", addr); err == nil || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := o.Apply(conn.(*net.TCPConn)); err != nil {
+		fmt.Println("Error tuning connection:", err)
+	}
+	if o.Faults.Enabled() {
+		conn = lossy.Conn(conn, o.Faults)
+	}
+	return o.throttle(conn), nil
+}
+
+// readLines sends the lines of f on the returned channel, closing it at the
+// end of the input. Reading stdin cannot be interrupted, so it happens in a
+// goroutine of its own that the callers can stop waiting for.
+func readLines(f *os.File) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
@@ -0,0 +1,249 @@
+package tcp
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A file travels in frames of its own types, between a sender and a
+// receiver:
+//
+//	sender -> receiver  FrameFileInfo    name, size and SHA-256 of the file, in JSON
+//	receiver -> sender  FrameFileResume  offset, 8 bytes big-endian
+//	sender -> receiver  FrameFileData    the file from offset on, 64KB a frame
+//	receiver -> sender  FrameFileResult  empty when the SHA-256 matches, the error otherwise
+//
+// The receiver writes what arrives to a partial file named after the name
+// and hash of the file, and keeps it when the connection drops. When the same
+// file is sent again, on a new connection, it answers with the size of the
+// partial file: the sender skips what arrived already and the transfer
+// resumes where it stopped. The hash is checked over the whole file at the
+// end, which catches corruption on the way as well as a partial file that is
+// not from this file after all.
+
+// Types of the frames of a file transfer.
+const (
+	FrameFileInfo byte = iota + 3
+	FrameFileResume
+	FrameFileData
+	FrameFileResult
+)
+
+// fileChunkSize is the payload of a FrameFileData.
+const fileChunkSize = 64 << 10
+
+// FileInfo describes the file a transfer is about.
+type FileInfo struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Progress is called as a transfer goes, with how much of the file arrived
+// and its size: once with where the transfer starts, which is not zero when
+// it resumes, and then after every frame of data.
+type Progress func(done, total int64)
+
+// ErrReceiver is wrapped by the errors of SendFile the receiver reported,
+// which sending again does not fix, unlike a connection that dropped.
+var ErrReceiver = errors.New("tcp: receiver failed")
+
+// SendFile sends the file at path over conn, resuming where the copy of the
+// receiver stopped, and waits for the receiver to confirm that its copy is
+// whole. progress may be nil.
+func SendFile(conn io.ReadWriter, path string, progress Progress) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The hash goes first, so the whole file is read twice
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	info := FileInfo{Name: filepath.Base(path), Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	payload, _ := json.Marshal(info)
+	if err := WriteFrame(conn, Frame{Type: FrameFileInfo, Payload: payload}); err != nil {
+		return err
+	}
+
+	frame, err := nextFrame(conn)
+	if err != nil {
+		return err
+	}
+	if frame.Type == FrameFileResult {
+		return fmt.Errorf("%w: %s", ErrReceiver, frame.Payload)
+	}
+	if frame.Type != FrameFileResume || len(frame.Payload) != 8 {
+		return fmt.Errorf("unexpected frame of type %d", frame.Type)
+	}
+	offset := int64(binary.BigEndian.Uint64(frame.Payload))
+	if offset < 0 || offset > size {
+		return fmt.Errorf("receiver resumes at %d, past the end of the file", offset)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(offset, size)
+	}
+
+	chunk := make([]byte, fileChunkSize)
+	for sent := offset; sent < size; {
+		n, err := file.Read(chunk)
+		if err != nil {
+			return err
+		}
+		if err := WriteFrame(conn, Frame{Type: FrameFileData, Payload: chunk[:n]}); err != nil {
+			return err
+		}
+		sent += int64(n)
+		if progress != nil {
+			progress(sent, size)
+		}
+	}
+
+	frame, err = nextFrame(conn)
+	if err != nil {
+		return err
+	}
+	if frame.Type != FrameFileResult {
+		return fmt.Errorf("unexpected frame of type %d", frame.Type)
+	}
+	if len(frame.Payload) > 0 {
+		return fmt.Errorf("%w: %s", ErrReceiver, frame.Payload)
+	}
+	return nil
+}
+
+// ReceiveFile receives a file sent by SendFile over conn into the directory
+// dir and returns its path. progress may be nil.
+func ReceiveFile(conn io.ReadWriter, dir string, progress Progress) (string, error) {
+	frame, err := ReadFrame(conn)
+	if err != nil {
+		return "", err
+	}
+	if frame.Type != FrameFileInfo {
+		return "", fmt.Errorf("unexpected frame of type %d", frame.Type)
+	}
+	return receiveFile(conn, conn, frame, dir, progress)
+}
+
+// receiveFile is ReceiveFile once the FrameFileInfo was read, reading the
+// rest from r and answering on w. Whatever goes wrong is also reported to
+// the sender, as long as the connection still works.
+func receiveFile(r io.Reader, w io.Writer, first Frame, dir string, progress Progress) (string, error) {
+	path, err := receiveFileData(r, w, first, dir, progress)
+	result := Frame{Type: FrameFileResult}
+	if err != nil {
+		result.Payload = []byte(err.Error())
+	}
+	if writeErr := WriteFrame(w, result); err == nil {
+		err = writeErr
+	}
+	return path, err
+}
+
+func receiveFileData(r io.Reader, w io.Writer, first Frame, dir string, progress Progress) (string, error) {
+	var info FileInfo
+	if err := json.Unmarshal(first.Payload, &info); err != nil {
+		return "", fmt.Errorf("bad file info: %w", err)
+	}
+	// The name is the sender's, it must not escape dir
+	name := filepath.Base(info.Name)
+	if name != info.Name || name == "." || name == ".." || info.Size < 0 || len(info.SHA256) != 2*sha256.Size {
+		return "", fmt.Errorf("bad file info %+v", info)
+	}
+	path := filepath.Join(dir, name)
+	partial := filepath.Join(dir, fmt.Sprintf(".%s.%s.part", name, info.SHA256[:16]))
+
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+	if offset > info.Size {
+		// Not from this file after all, start over
+		if err := file.Truncate(0); err != nil {
+			return "", err
+		}
+		offset, _ = file.Seek(0, io.SeekStart)
+	}
+	resume := Frame{Type: FrameFileResume, Payload: binary.BigEndian.AppendUint64(nil, uint64(offset))}
+	if err := WriteFrame(w, resume); err != nil {
+		return "", err
+	}
+	if progress != nil {
+		progress(offset, info.Size)
+	}
+
+	for received := offset; received < info.Size; {
+		frame, err := ReadFrame(r)
+		if err != nil {
+			return "", fmt.Errorf("%d of %d bytes received, the rest can be sent again: %w", received, info.Size, err)
+		}
+		if frame.Type != FrameFileData {
+			return "", fmt.Errorf("unexpected frame of type %d", frame.Type)
+		}
+		if received+int64(len(frame.Payload)) > info.Size {
+			return "", errors.New("more data than the size of the file")
+		}
+		// Written as it arrives, so that a dropped connection keeps it
+		if _, err := file.Write(frame.Payload); err != nil {
+			return "", err
+		}
+		received += int64(len(frame.Payload))
+		if progress != nil {
+			progress(received, info.Size)
+		}
+	}
+
+	hash := sha256.New()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != info.SHA256 {
+		os.Remove(partial)
+		return "", fmt.Errorf("SHA-256 %s does not match %s, the partial file is discarded", sum, info.SHA256)
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(partial, path)
+}
+
+// printProgress returns a Progress that prints what verb did with name, at
+// every tenth of the file and where a resumed transfer starts.
+func printProgress(verb, name string) Progress {
+	var last int64 = -1
+	return func(done, total int64) {
+		if last < 0 && done > 0 {
+			fmt.Printf("%s %s: resuming at %d of %d bytes\n", verb, name, done, total)
+		}
+		tenth := int64(10)
+		if total > 0 {
+			tenth = done * 10 / total
+		}
+		if tenth != last {
+			fmt.Printf("%s %s: %d of %d bytes (%d%%)\n", verb, name, done, total, tenth*10)
+		}
+		last = tenth
+	}
+}
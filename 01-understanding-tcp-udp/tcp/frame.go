@@ -0,0 +1,90 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TCP carries a stream of bytes, not messages: one Write may arrive in
+// several reads and several Writes in one. A newline can tell messages apart
+// only as long as they contain none, which binary data does. So every
+// message travels in a frame, its length first:
+//
+//	length (4 bytes, big-endian) | type (1 byte) | payload (length bytes)
+//
+// The reader knows how many bytes the payload has before it reads them,
+// whatever they are.
+
+// Types of frames.
+const (
+	// FrameText carries UTF-8 text, a line typed in the client.
+	FrameText byte = iota + 1
+	// FrameBinary carries bytes of any value.
+	FrameBinary
+)
+
+// MaxFrameSize bounds the payload of a frame, which the reader allocates
+// before reading it: a corrupted length must not make it allocate 4GB.
+const MaxFrameSize = 16 << 20
+
+// frameHeaderSize is the length and the type.
+const frameHeaderSize = 5
+
+// ErrFrameTooLarge is returned by ReadFrame for a frame longer than
+// MaxFrameSize, and by WriteFrame for a payload longer than it.
+var ErrFrameTooLarge = errors.New("tcp: frame too large")
+
+// Frame is a message of the framed protocol.
+type Frame struct {
+	Type    byte
+	Payload []byte
+}
+
+// String describes the frame for the output, text as it is and binary data
+// by its size and first bytes.
+func (f Frame) String() string {
+	if f.Type == FrameText {
+		return string(f.Payload)
+	}
+	const maxPreview = 16
+	if len(f.Payload) <= maxPreview {
+		return fmt.Sprintf("%d bytes [% x]", len(f.Payload), f.Payload)
+	}
+	return fmt.Sprintf("%d bytes [% x ...]", len(f.Payload), f.Payload[:maxPreview])
+}
+
+// WriteFrame writes f to w with a single Write, so that frames written from
+// several goroutines do not interleave.
+func WriteFrame(w io.Writer, f Frame) error {
+	if len(f.Payload) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	buffer := make([]byte, frameHeaderSize, frameHeaderSize+len(f.Payload))
+	binary.BigEndian.PutUint32(buffer, uint32(len(f.Payload)))
+	buffer[4] = f.Type
+	_, err := w.Write(append(buffer, f.Payload...))
+	return err
+}
+
+// ReadFrame reads the next frame from r. It returns io.EOF when r ends
+// between frames and io.ErrUnexpectedEOF when it ends inside one.
+func ReadFrame(r io.Reader) (Frame, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Frame{}, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > MaxFrameSize {
+		return Frame{}, ErrFrameTooLarge
+	}
+	f := Frame{Type: header[4], Payload: make([]byte, length)}
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+	return f, nil
+}
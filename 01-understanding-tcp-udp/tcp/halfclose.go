@@ -0,0 +1,49 @@
+package tcp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// A TCP connection is two streams, one each way, and either side can end
+// its own with a FIN while it keeps reading the other: CloseWrite is a
+// half-close. The peer reads EOF once it read everything sent before, and
+// can still answer it. Close ends both streams at once, and when bytes came
+// in that were not read, the kernel answers with a RST rather than a FIN:
+// the peer drops what it had received and not read yet, the last answers
+// included. A side that closes first and wants everything it sent to be
+// read closes its writing side, reads until the peer closes its own, and
+// only then closes the connection, a lingering close.
+
+// lingerTimeout is how long a side that closed its writing side waits for
+// the peer to close its own.
+const lingerTimeout = 5 * time.Second
+
+// closeWrite sends a FIN on conn, through the wrappers of the connection
+// that do not have a CloseWrite of their own.
+func closeWrite(conn net.Conn) error {
+	for {
+		if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+			return closer.CloseWrite()
+		}
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return errors.New("tcp: the connection cannot be half-closed")
+		}
+		conn = wrapper.NetConn()
+	}
+}
+
+// lingerClose closes the writing side of conn, drops what the peer still
+// sends, read from r, until the peer closes its side or lingerTimeout, and
+// closes conn. It returns how many bytes were dropped.
+func lingerClose(conn net.Conn, r io.Reader) (int64, error) {
+	defer conn.Close()
+	if err := closeWrite(conn); err != nil {
+		return 0, err
+	}
+	conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	return io.Copy(io.Discard, r)
+}
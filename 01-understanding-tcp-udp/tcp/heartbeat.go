@@ -0,0 +1,190 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Keep-alive probes (Options.KeepAlive) are sent by the kernel and answered
+// by the kernel of the peer: they notice a host that vanished, not a process
+// that hangs with its socket open, and the application never hears of them
+// unless the connection fails, after minutes by default. Heartbeats are
+// frames of the protocol instead, answered by the peer's code:
+//
+//	FramePing  8 bytes, the time it was sent
+//	FramePong  the payload of the ping it answers
+//	FrameClose the reason the connection is closed, as text
+//
+// Either side answers a ping with a pong. The side that sends pings also
+// expects to hear from the peer: when nothing, pongs included, arrived for
+// the liveness timeout, the peer is presumed dead and the connection is
+// closed, with a FrameClose telling why in case the peer is only slow.
+
+// Types of the frames of heartbeats.
+const (
+	FramePing byte = iota + 12
+	FramePong
+	FrameClose
+)
+
+// heartbeat pings a peer every interval and closes the connection when
+// nothing arrived from it for liveness. A nil heartbeat does nothing.
+type heartbeat struct {
+	conn     net.Conn
+	liveness time.Duration
+	timer    *time.Timer
+	done     chan struct{}
+	paused   atomic.Bool
+	dead     atomic.Bool
+	rtt      atomic.Int64
+}
+
+// startHeartbeat starts pinging conn every interval, nil when interval is
+// not positive. liveness is three intervals when zero.
+func startHeartbeat(conn net.Conn, interval, liveness time.Duration) *heartbeat {
+	if interval <= 0 {
+		return nil
+	}
+	if liveness <= 0 {
+		liveness = 3 * interval
+	}
+	h := &heartbeat{conn: conn, liveness: liveness, done: make(chan struct{})}
+	h.timer = time.AfterFunc(liveness, func() {
+		h.dead.Store(true)
+		closeWithReason(conn, fmt.Sprintf("nothing received for %s, presumed dead", liveness))
+	})
+	go h.ping(interval)
+	return h
+}
+
+func (h *heartbeat) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case now := <-ticker.C:
+			if h.paused.Load() {
+				continue
+			}
+			payload := binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano()))
+			if err := WriteFrame(h.conn, Frame{Type: FramePing, Payload: payload}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// reader returns r, which reads from the connection, pushing the liveness
+// timeout back whenever bytes arrive: a frame too large to arrive within it
+// still counts.
+func (h *heartbeat) reader(r io.Reader) io.Reader {
+	if h == nil {
+		return r
+	}
+	return readerFunc(func(p []byte) (int, error) {
+		n, err := r.Read(p)
+		if n > 0 {
+			h.timer.Reset(h.liveness)
+		}
+		return n, err
+	})
+}
+
+// readerFunc is a function reading like an io.Reader.
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+// pause stops sending pings while an exchange whose frames the peer reads
+// one by one is going on, such as a file transfer, and resume starts again.
+func (h *heartbeat) pause() {
+	if h != nil {
+		h.paused.Store(true)
+	}
+}
+
+func (h *heartbeat) resume() {
+	if h != nil {
+		h.paused.Store(false)
+	}
+}
+
+// pong measures the round-trip time of the ping f answers.
+func (h *heartbeat) pong(f Frame) {
+	if h == nil || len(f.Payload) != 8 {
+		return
+	}
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(f.Payload)))
+	h.rtt.Store(int64(time.Since(sent)))
+}
+
+// timedOut reports whether the connection was closed for lack of frames.
+func (h *heartbeat) timedOut() bool {
+	return h != nil && h.dead.Load()
+}
+
+// stop stops pinging and the liveness timeout.
+func (h *heartbeat) stop() {
+	if h == nil {
+		return
+	}
+	h.timer.Stop()
+	close(h.done)
+}
+
+// String describes the last round-trip time measured.
+func (h *heartbeat) String() string {
+	if h.rtt.Load() == 0 {
+		return "no ping answered"
+	}
+	return fmt.Sprintf("last ping answered in %s", time.Duration(h.rtt.Load()).Round(time.Microsecond))
+}
+
+// answerPing answers f when it is a ping, and reports whether it was a ping
+// or a pong, which need nothing more.
+func answerPing(conn io.Writer, f Frame, h *heartbeat) (bool, error) {
+	switch f.Type {
+	case FramePing:
+		return true, WriteFrame(conn, Frame{Type: FramePong, Payload: f.Payload})
+	case FramePong:
+		h.pong(f)
+		return true, nil
+	}
+	return false, nil
+}
+
+// nextFrame reads the next frame from conn that is not a ping or a pong,
+// answering the pings, for the exchanges that expect a given frame.
+func nextFrame(conn io.ReadWriter) (Frame, error) {
+	for {
+		f, err := ReadFrame(conn)
+		if err != nil {
+			return f, err
+		}
+		if ping, err := answerPing(conn, f, nil); !ping || err != nil {
+			return f, err
+		}
+	}
+}
+
+// closeWithReason tells the peer why the connection ends and closes it.
+func closeWithReason(conn net.Conn, reason string) {
+	sendClose(conn, reason)
+	conn.Close()
+}
+
+// sendClose tells the peer why the connection ends. The write is bounded,
+// the peer may not be reading.
+func sendClose(conn net.Conn, reason string) {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	WriteFrame(conn, Frame{Type: FrameClose, Payload: []byte(reason)})
+	conn.SetWriteDeadline(time.Time{})
+}
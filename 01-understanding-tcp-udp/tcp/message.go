@@ -0,0 +1,162 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// A Message is a struct rather than a string: it is serialized into the
+// payload of a frame, with encoding/gob or encoding/json, and the type of the
+// frame tells the reader which.
+//
+// gob describes a type the first time a value of it is sent and refers to it
+// after that, so the first message of an Encoder costs some fifty bytes more
+// than the others and every message depends on the ones before it: the
+// frames of a gob stream must all be decoded, in order, by one Decoder. A
+// JSON message stands on its own, and spells out the field names every time.
+
+// Types of the frames of messages.
+const (
+	FrameGob byte = iota + 10
+	FrameJSON
+)
+
+// Message is a typed message: ID numbers it, Kind says what Body is.
+type Message struct {
+	ID   uint64 `json:"id"`
+	Kind string `json:"kind"`
+	Body string `json:"body"`
+}
+
+// String describes the message for the output.
+func (m Message) String() string {
+	return fmt.Sprintf("#%d %s %q", m.ID, m.Kind, m.Body)
+}
+
+// Codec is the encoding of the messages of an Encoder.
+type Codec int
+
+const (
+	// Gob encodes messages with encoding/gob.
+	Gob Codec = iota + 1
+	// JSON encodes messages with encoding/json.
+	JSON
+)
+
+// String names the codec.
+func (c Codec) String() string {
+	switch c {
+	case Gob:
+		return "gob"
+	case JSON:
+		return "json"
+	}
+	return fmt.Sprintf("Codec(%d)", int(c))
+}
+
+// ParseCodec returns the codec named name, "gob" or "json".
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "gob":
+		return Gob, nil
+	case "json":
+		return JSON, nil
+	}
+	return 0, fmt.Errorf("tcp: unknown codec %q, want gob or json", name)
+}
+
+// Encoder writes messages to a framed connection, a frame each.
+type Encoder struct {
+	w     io.Writer
+	codec Codec
+
+	// The gob stream is written to buffer, and what every message added
+	// to it becomes the payload of its frame
+	buffer bytes.Buffer
+	gob    *gob.Encoder
+}
+
+// NewEncoder returns an Encoder writing to w with codec.
+func NewEncoder(w io.Writer, codec Codec) *Encoder {
+	e := &Encoder{w: w, codec: codec}
+	e.gob = gob.NewEncoder(&e.buffer)
+	return e
+}
+
+// Encode writes m in a frame and returns the size of its payload.
+func (e *Encoder) Encode(m Message) (int, error) {
+	var f Frame
+	switch e.codec {
+	case Gob:
+		e.buffer.Reset()
+		if err := e.gob.Encode(m); err != nil {
+			return 0, err
+		}
+		f = Frame{Type: FrameGob, Payload: e.buffer.Bytes()}
+	case JSON:
+		payload, err := json.Marshal(m)
+		if err != nil {
+			return 0, err
+		}
+		f = Frame{Type: FrameJSON, Payload: payload}
+	default:
+		return 0, fmt.Errorf("tcp: unknown codec %d", e.codec)
+	}
+	return len(f.Payload), WriteFrame(e.w, f)
+}
+
+// Decoder reads messages from a framed connection, whatever codec each was
+// encoded with.
+type Decoder struct {
+	r io.Reader
+
+	// The gob stream is fed the payloads of the frames as they are read
+	buffer bytes.Buffer
+	gob    *gob.Decoder
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	d := &Decoder{r: r}
+	d.gob = gob.NewDecoder(&d.buffer)
+	return d
+}
+
+// Decode reads the next frame, which has to hold a message, and returns it.
+func (d *Decoder) Decode() (Message, error) {
+	f, err := ReadFrame(d.r)
+	if err != nil {
+		return Message{}, err
+	}
+	return d.decodeFrame(f)
+}
+
+// decodeFrame returns the message f holds.
+func (d *Decoder) decodeFrame(f Frame) (Message, error) {
+	var m Message
+	switch f.Type {
+	case FrameGob:
+		d.buffer.Write(f.Payload)
+		if err := d.gob.Decode(&m); err != nil {
+			return Message{}, fmt.Errorf("tcp: bad gob message: %w", err)
+		}
+	case FrameJSON:
+		if err := json.Unmarshal(f.Payload, &m); err != nil {
+			return Message{}, fmt.Errorf("tcp: bad JSON message: %w", err)
+		}
+	default:
+		return Message{}, fmt.Errorf("tcp: frame of type %d holds no message", f.Type)
+	}
+	return m, nil
+}
+
+// codecOf returns the codec of a frame of a message.
+func codecOf(f Frame) Codec {
+	if f.Type == FrameGob {
+		return Gob
+	}
+	return JSON
+}
@@ -0,0 +1,257 @@
+package tcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An echo answers in the order it was asked: a slow answer holds up every
+// one after it, and a client waits for each before it sends the next. A
+// request that carries an ID can be answered in any order instead, the
+// response carrying the same ID:
+//
+//	FrameRequest   ID (8 bytes, big-endian) | body
+//	FrameResponse  ID | body
+//	FrameError     ID | error message
+//
+// The client sends as many requests as it likes without waiting, the server
+// runs a handler for each on a goroutine of its own and writes the answers
+// as they are ready: the requests are pipelined and their answers
+// multiplexed over one connection.
+
+// Types of the frames of requests and their responses.
+const (
+	FrameRequest byte = iota + 7
+	FrameResponse
+	FrameError
+)
+
+// requestIDSize is the length of the ID in front of the payload.
+const requestIDSize = 8
+
+var (
+	// ErrMuxClosed is returned by Call once the connection of the client is
+	// closed, and to the calls waiting when it is.
+	ErrMuxClosed = errors.New("tcp: multiplexed connection closed")
+
+	// ErrHandler is wrapped by the errors of Call the handler returned.
+	ErrHandler = errors.New("tcp: handler failed")
+)
+
+// Handler answers the body of a request. ctx is canceled when the
+// connection ends.
+type Handler func(ctx context.Context, body []byte) ([]byte, error)
+
+// EchoHandler answers a request with its body. A body starting with a
+// duration, "2s slow one", is answered after that long, so the answers to
+// the requests sent after it overtake it.
+func EchoHandler(ctx context.Context, body []byte) ([]byte, error) {
+	first, _, _ := strings.Cut(string(body), " ")
+	if delay, err := time.ParseDuration(first); err == nil && delay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return body, nil
+}
+
+// requestFrame returns a frame of type kind for the request id.
+func requestFrame(kind byte, id uint64, body []byte) Frame {
+	payload := make([]byte, requestIDSize, requestIDSize+len(body))
+	binary.BigEndian.PutUint64(payload, id)
+	return Frame{Type: kind, Payload: append(payload, body...)}
+}
+
+// splitRequest returns the ID and the body of a frame of a request.
+func splitRequest(f Frame) (uint64, []byte, bool) {
+	if len(f.Payload) < requestIDSize {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint64(f.Payload), f.Payload[requestIDSize:], true
+}
+
+// dispatcher runs the handler of the requests of a connection of the server.
+type dispatcher struct {
+	conn    net.Conn
+	handler Handler
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+func newDispatcher(conn net.Conn, handler Handler) *dispatcher {
+	if handler == nil {
+		handler = EchoHandler
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &dispatcher{conn: conn, handler: handler, ctx: ctx, cancel: cancel}
+}
+
+// dispatch runs the handler for the request f on a goroutine and writes its
+// answer, a frame written at once that the other answers do not interleave
+// with.
+func (d *dispatcher) dispatch(f Frame) error {
+	id, body, ok := splitRequest(f)
+	if !ok {
+		return errors.New("request without an ID")
+	}
+	fmt.Printf("Request %d from %s: %s\n", id, d.conn.RemoteAddr(), body)
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		answer, err := d.handler(d.ctx, body)
+		response := requestFrame(FrameResponse, id, answer)
+		if err != nil {
+			response = requestFrame(FrameError, id, []byte(err.Error()))
+		}
+		if err := WriteFrame(d.conn, response); err != nil {
+			fmt.Printf("Error answering request %d of %s: %s\n", id, d.conn.RemoteAddr(), err)
+		}
+	}()
+	return nil
+}
+
+// finish waits for the requests running to be answered.
+func (d *dispatcher) finish() {
+	d.running.Wait()
+}
+
+// stop cancels the requests still running and waits for them.
+func (d *dispatcher) stop() {
+	d.cancel()
+	d.running.Wait()
+}
+
+/**
+ * * MuxClient makes calls to the handler of a server over one connection, as many at once as its
+ * * callers like. Every call gets the next ID and waits for the response with its ID, which the
+ * * goroutine reading the connection hands it, in whatever order they arrive.
+ *
+ * * A call that times out stops waiting, its response is dropped when it arrives. The connection
+ * * stays usable, unlike one where a late answer would be taken for the answer to the next call.
+ */
+type MuxClient struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]chan Frame
+	err     error // why the connection ended, nil while it is open
+	late    int
+}
+
+// NewMuxClient returns a client of the requests over conn, which it reads
+// until it is closed.
+func NewMuxClient(conn net.Conn) *MuxClient {
+	c := &MuxClient{conn: conn, pending: make(map[uint64]chan Frame)}
+	go c.read()
+	return c
+}
+
+// Call sends body as a request and returns the body of its response, or the
+// error of the handler. It gives up when ctx is done, the timeout of the
+// call.
+func (c *MuxClient) Call(ctx context.Context, body []byte) ([]byte, error) {
+	// Buffered, so that read never waits for a call that gave up
+	response := make(chan Frame, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.next++
+	id := c.next
+	c.pending[id] = response
+	c.mu.Unlock()
+
+	forget := func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}
+	if err := WriteFrame(c.conn, requestFrame(FrameRequest, id, body)); err != nil {
+		forget()
+		return nil, err
+	}
+
+	select {
+	case f, ok := <-response:
+		if !ok {
+			return nil, c.err
+		}
+		_, answer, _ := splitRequest(f)
+		if f.Type == FrameError {
+			return nil, fmt.Errorf("%w: %s", ErrHandler, answer)
+		}
+		return answer, nil
+	case <-ctx.Done():
+		forget()
+		return nil, ctx.Err()
+	}
+}
+
+// Late is how many responses arrived after their call gave up.
+func (c *MuxClient) Late() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.late
+}
+
+// Close closes the connection, failing the calls still waiting.
+func (c *MuxClient) Close() error {
+	return c.conn.Close()
+}
+
+// read hands every response to the call waiting for it until the connection
+// ends, and then fails the calls left.
+func (c *MuxClient) read() {
+	var err error
+	for {
+		var f Frame
+		if f, err = ReadFrame(c.conn); err != nil {
+			break
+		}
+		if ping, pingErr := answerPing(c.conn, f, nil); ping {
+			if pingErr != nil {
+				err = pingErr
+				break
+			}
+			continue
+		}
+		if f.Type == FrameClose {
+			err = fmt.Errorf("closed by the server: %s", f.Payload)
+			break
+		}
+		id, _, ok := splitRequest(f)
+		if !ok || (f.Type != FrameResponse && f.Type != FrameError) {
+			fmt.Println("Ignoring a frame that answers no request:", f)
+			continue
+		}
+		c.mu.Lock()
+		response, waiting := c.pending[id]
+		delete(c.pending, id)
+		if !waiting {
+			c.late++
+		}
+		c.mu.Unlock()
+		if waiting {
+			response <- f
+		}
+	}
+
+	c.mu.Lock()
+	c.err = fmt.Errorf("%w: %w", ErrMuxClosed, err)
+	for id, response := range c.pending {
+		delete(c.pending, id)
+		close(response)
+	}
+	c.mu.Unlock()
+}
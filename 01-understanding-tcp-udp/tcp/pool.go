@@ -0,0 +1,313 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Defaults of the zero PoolConfig.
+const (
+	defaultPoolSize    = 4
+	defaultMaxIdleTime = time.Minute
+	defaultHealthCheck = 10 * time.Second
+)
+
+// ErrPoolClosed is returned by Checkout once the pool is closed.
+var ErrPoolClosed = errors.New("tcp: pool closed")
+
+// PoolConfig configures a Pool.
+type PoolConfig struct {
+	// Addr is the server the connections are opened to, Options tunes them.
+	Addr    string
+	Options Options
+
+	// Size is how many connections may be open at once, checked out or
+	// idle, 4 when zero. MaxIdle is how many idle ones are kept for reuse,
+	// Size when zero.
+	Size    int
+	MaxIdle int
+
+	// MaxIdleTime closes a connection that was idle that long, 1 minute when
+	// zero. MaxLifetime closes one that was opened that long ago once it is
+	// checked in or found idle, zero never does.
+	MaxIdleTime time.Duration
+	MaxLifetime time.Duration
+
+	// HealthCheck is how often the idle connections are checked, 10 seconds
+	// when zero.
+	HealthCheck time.Duration
+}
+
+/**
+ * * Pool keeps connections to a server open for reuse, so that every exchange does not pay for a
+ * * handshake and leave a socket in TIME_WAIT, see Bench. Checkout hands out an idle connection,
+ * * or opens one when they are all busy, up to Size, and waits for one to be checked in beyond.
+ * * Checkin gives it back for the next Checkout.
+ *
+ * * A connection idle in the pool can die without anyone noticing: the server closed it after a
+ * * timeout of its own, restarted, or the NAT on the way forgot it. Checkout and a periodic check
+ * * every HealthCheck probe the idle connections with a read that returns right away: a live
+ * * connection has nothing to read and times out, a closed one returns EOF or an error and is
+ * * dropped. The probe reads nothing of a protocol where the server only ever answers, like the
+ * * echo, anything it does read means the connection is out of step and is dropped too.
+ */
+type Pool struct {
+	config PoolConfig
+	slots  chan struct{}    // one token per connection open
+	idle   chan *PooledConn // the connections checked in
+	done   chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	stats  PoolStats
+}
+
+// PoolStats counts what happened to the connections of a Pool.
+type PoolStats struct {
+	Opened, Reused, Open, Idle           int
+	ClosedIdle, ClosedExpired, Unhealthy int
+}
+
+// String summarizes the counters for the output.
+func (s PoolStats) String() string {
+	return fmt.Sprintf("%d opened, %d reused, %d open (%d idle), closed %d idle too long, %d past their lifetime, %d unhealthy",
+		s.Opened, s.Reused, s.Open, s.Idle, s.ClosedIdle, s.ClosedExpired, s.Unhealthy)
+}
+
+// PooledConn is a connection checked out of a Pool. Close closes it for good
+// and frees its place in the pool, which a connection that failed should get
+// rather than a Checkin.
+type PooledConn struct {
+	net.Conn
+	pool     *Pool
+	opened   time.Time
+	lastUsed time.Time
+	uses     int
+	once     sync.Once
+}
+
+// Uses is how many times the connection was checked out, this time included.
+func (c *PooledConn) Uses() int {
+	return c.uses
+}
+
+// Close closes the connection and frees its place in the pool.
+func (c *PooledConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.pool.release() })
+	return err
+}
+
+// NewPool returns a Pool of connections to config.Addr, opened as they are
+// needed. Close it to close them.
+func NewPool(config PoolConfig) *Pool {
+	if config.Size <= 0 {
+		config.Size = defaultPoolSize
+	}
+	if config.MaxIdle <= 0 || config.MaxIdle > config.Size {
+		config.MaxIdle = config.Size
+	}
+	if config.MaxIdleTime <= 0 {
+		config.MaxIdleTime = defaultMaxIdleTime
+	}
+	if config.HealthCheck <= 0 {
+		config.HealthCheck = defaultHealthCheck
+	}
+	p := &Pool{
+		config: config,
+		slots:  make(chan struct{}, config.Size),
+		idle:   make(chan *PooledConn, config.MaxIdle),
+		done:   make(chan struct{}),
+	}
+	go p.check()
+	return p
+}
+
+// Checkout returns an idle connection that passed its health check, or a new
+// one, waiting for ctx while all Size connections are checked out.
+func (p *Pool) Checkout(ctx context.Context) (*PooledConn, error) {
+	for {
+		// An idle connection first, a new one only when there is none
+		select {
+		case <-p.done:
+			return nil, ErrPoolClosed
+		case c := <-p.idle:
+			if p.healthy(c, time.Now()) {
+				return p.reuse(c), nil
+			}
+			continue
+		default:
+		}
+
+		select {
+		case c := <-p.idle:
+			if p.healthy(c, time.Now()) {
+				return p.reuse(c), nil
+			}
+		case p.slots <- struct{}{}:
+			return p.open(ctx)
+		case <-p.done:
+			return nil, ErrPoolClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Checkin returns c to the pool for the next Checkout, or closes it when the
+// pool has enough idle connections, is closed or c is past its lifetime.
+func (p *Pool) Checkin(c *PooledConn) {
+	now := time.Now()
+	c.lastUsed = now
+	c.SetDeadline(time.Time{})
+
+	p.mu.Lock()
+	expired := p.config.MaxLifetime > 0 && now.Sub(c.opened) >= p.config.MaxLifetime
+	if expired {
+		p.stats.ClosedExpired++
+	}
+	p.mu.Unlock()
+	if expired {
+		c.Close()
+		return
+	}
+	p.keep(c)
+}
+
+// keep makes c idle, unless the pool is closed or has enough idle
+// connections already.
+func (p *Pool) keep(c *PooledConn) {
+	// Under p.mu, so that Close does not miss it
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		select {
+		case p.idle <- c:
+			return
+		default:
+		}
+	}
+	c.Close()
+}
+
+// Stats returns the counters of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	s.Open, s.Idle = len(p.slots), len(p.idle)
+	return s
+}
+
+// Close closes the idle connections, and the checked out ones as they are
+// checked in.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	for {
+		select {
+		case c := <-p.idle:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// reuse counts c checked out once more.
+func (p *Pool) reuse(c *PooledConn) *PooledConn {
+	c.uses++
+	p.mu.Lock()
+	p.stats.Reused++
+	p.mu.Unlock()
+	return c
+}
+
+// open dials a new connection, its slot already taken.
+func (p *Pool) open(ctx context.Context) (*PooledConn, error) {
+	conn, err := p.config.Options.dial(ctx, p.config.Addr)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	now := time.Now()
+	p.mu.Lock()
+	p.stats.Opened++
+	p.mu.Unlock()
+	return &PooledConn{Conn: conn, pool: p, opened: now, lastUsed: now, uses: 1}, nil
+}
+
+// release frees the slot of a connection closed.
+func (p *Pool) release() {
+	<-p.slots
+}
+
+// healthy reports whether the idle connection c may still be used at now,
+// closing it when not.
+func (p *Pool) healthy(c *PooledConn, now time.Time) bool {
+	p.mu.Lock()
+	ok := true
+	switch {
+	case now.Sub(c.lastUsed) >= p.config.MaxIdleTime:
+		p.stats.ClosedIdle++
+		ok = false
+	case p.config.MaxLifetime > 0 && now.Sub(c.opened) >= p.config.MaxLifetime:
+		p.stats.ClosedExpired++
+		ok = false
+	}
+	p.mu.Unlock()
+	if ok && !probe(c.Conn) {
+		p.mu.Lock()
+		p.stats.Unhealthy++
+		p.mu.Unlock()
+		ok = false
+	}
+	if !ok {
+		c.Close()
+	}
+	return ok
+}
+
+// probe reports whether conn is still open, with a read that only waits a
+// millisecond: a live connection idle in the pool has nothing to read.
+func probe(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	conn.SetReadDeadline(time.Time{})
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// check runs the health check of the idle connections every
+// config.HealthCheck until the pool is closed.
+func (p *Pool) check() {
+	ticker := time.NewTicker(p.config.HealthCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			// The ones there now, Checkout may take some meanwhile
+			for range len(p.idle) {
+				select {
+				case c := <-p.idle:
+					if p.healthy(c, now) {
+						p.keep(c)
+					}
+				default:
+				}
+			}
+		}
+	}
+}
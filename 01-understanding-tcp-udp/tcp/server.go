@@ -2,51 +2,244 @@ package tcp
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
 	"sync"
+	"time"
 )
 
-func Server(wg *sync.WaitGroup) {
+// drainTimeout is how long clients are given to finish after the server is
+// asked to stop, before their connections are closed.
+const drainTimeout = 5 * time.Second
+
+// Server runs the echo server with the default Options.
+func Server(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	Options{}.Server(ctx, wg, addr)
+}
+
+// Server runs the echo server on addr until ctx is canceled, tuning every
+// connection it accepts with o. With o.Chat it runs a chat room instead.
+func (o Options) Server(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
 	// Start server
-	listener, err := net.Listen("tcp", ":8080")
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		fmt.Println("Error starting server:", err)
 		return
 	}
-	defer listener.Close()
 
-	fmt.Println("TCP Server listening on :8080")
+	fmt.Println("TCP Server listening on", listener.Addr())
+
+	// Closing the listener is what gets Accept to return on shutdown
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var room *registry
+	if o.Chat {
+		room = newRegistry()
+	}
+
+	var clients sync.WaitGroup
+	var mu sync.Mutex
+	conns := make(map[net.Conn]bool)
+	defer func() {
+		drain(&clients, &mu, conns)
+		fmt.Println("TCP Server stopped")
+	}()
 
 	for {
 		// Accept connections
 		conn, err := listener.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			fmt.Println("Error accepting connection:", err)
 			continue
 		}
 
+		if err := o.Apply(conn.(*net.TCPConn)); err != nil {
+			fmt.Println("Error tuning connection:", err)
+		}
+		conn = o.throttle(conn)
+
+		mu.Lock()
+		conns[conn] = true
+		mu.Unlock()
+		clients.Add(1)
+
 		// Handle each client in a goroutine
-		go handleConnection(conn)
+		go func() {
+			defer clients.Done()
+			handleConnection(conn, o, room)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+		}()
+	}
+}
+
+// drain stops reading from every open connection, which lets the handlers
+// finish the message they are busy with and return, and waits for them. The
+// connections left after drainTimeout are closed.
+func drain(clients *sync.WaitGroup, mu *sync.Mutex, conns map[net.Conn]bool) {
+	mu.Lock()
+	for conn := range conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		clients.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		<-done
 	}
 }
 
-func handleConnection(conn net.Conn) {
+// handleConnection echoes the frames of conn, or has room handle them when
+// it is not nil. Files sent to it are received into o.UploadDir, or refused
+// without one, and requests are answered by o.Handler.
+func handleConnection(conn net.Conn, o Options, room *registry) {
 	defer conn.Close()
 	fmt.Printf("New client connected: %s\n", conn.RemoteAddr())
+	if room != nil {
+		room.join(conn)
+		defer room.leave(conn)
+	}
+	requests := newDispatcher(conn, o.Handler)
+	defer requests.stop()
+	decoder := NewDecoder(nil)
+	encoders := map[Codec]*Encoder{Gob: NewEncoder(conn, Gob), JSON: NewEncoder(conn, JSON)}
+	beat := startHeartbeat(conn, o.Heartbeat, o.Liveness)
+	defer beat.stop()
 
-	reader := bufio.NewReader(conn)
+	reader := bufio.NewReader(beat.reader(conn))
 	for {
 		// Read incoming message
-		message, err := reader.ReadString('\n')
+		frame, err := ReadFrame(reader)
 		if err != nil {
-			fmt.Printf("Client %s disconnected\n", conn.RemoteAddr())
+			switch {
+			case beat.timedOut():
+				fmt.Printf("Client %s presumed dead, nothing received for %s (%s)\n", conn.RemoteAddr(), beat.liveness, beat)
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				// drain stopped the reads, the server is shutting down. What
+				// the client sends meanwhile is read until it closes, closing
+				// with it unread would reset the answers on their way
+				requests.finish()
+				beat.pause()
+				sendClose(conn, "server shutting down")
+				dropped, _ := lingerClose(conn, reader)
+				fmt.Printf("Client %s disconnected, %d bytes it sent after the shutdown dropped\n", conn.RemoteAddr(), dropped)
+			case errors.Is(err, io.EOF):
+				// The client closed its writing side but may still read, the
+				// requests running get answered
+				requests.finish()
+				fmt.Printf("Client %s disconnected\n", conn.RemoteAddr())
+			default:
+				fmt.Printf("Client %s disconnected: %s\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		if ping, err := answerPing(conn, frame, beat); ping {
+			if err != nil {
+				fmt.Printf("Error answering ping of %s: %s\n", conn.RemoteAddr(), err)
+				return
+			}
+			continue
+		}
+		switch frame.Type {
+		case FrameClose:
+			fmt.Printf("Client %s closed the connection: %s\n", conn.RemoteAddr(), frame.Payload)
+			return
+		case FrameFileInfo:
+			// The client reads nothing until the end of the transfer, the pings would pile up
+			beat.pause()
+			ok := receiveUpload(conn, reader, frame, o.UploadDir)
+			beat.resume()
+			if !ok {
+				return
+			}
+			continue
+		case FrameGob, FrameJSON:
+			if err := echoMessage(conn, frame, decoder, encoders[codecOf(frame)]); err != nil {
+				fmt.Printf("Disconnecting %s: %s\n", conn.RemoteAddr(), err)
+				return
+			}
+			continue
+		case FrameRequest:
+			if err := requests.dispatch(frame); err != nil {
+				fmt.Printf("Disconnecting %s: %s\n", conn.RemoteAddr(), err)
+				return
+			}
+			continue
+		}
+
+		fmt.Printf("Received from %s: %s\n", conn.RemoteAddr(), frame)
+		if room != nil {
+			if !room.chat(conn, frame) {
+				fmt.Printf("Client %s quit\n", conn.RemoteAddr())
+				return
+			}
+			continue
+		}
+
+		// Echo message back to client, binary data as it is
+		if frame.Type == FrameText {
+			frame.Payload = append([]byte("Echo: "), frame.Payload...)
+		}
+		if err := WriteFrame(conn, frame); err != nil {
+			fmt.Printf("Error sending response to %s: %s\n", conn.RemoteAddr(), err)
 			return
 		}
+	}
+}
 
-		fmt.Printf("Received from %s: %s", conn.RemoteAddr(), message)
+// echoMessage decodes the message in frame and sends it back, with the codec
+// it came in.
+func echoMessage(conn net.Conn, frame Frame, decoder *Decoder, encoder *Encoder) error {
+	m, err := decoder.decodeFrame(frame)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Message from %s: %s (%s, %d bytes)\n", conn.RemoteAddr(), m, codecOf(frame), len(frame.Payload))
+	_, err = encoder.Encode(Message{ID: m.ID, Kind: "echo", Body: m.Body})
+	return err
+}
 
-		// Echo message back to client
-		conn.Write([]byte("Echo: " + message))
+// receiveUpload receives the file frame announces from conn into uploads,
+// reading the rest of it from reader, and reports whether the connection is
+// still usable.
+func receiveUpload(conn net.Conn, reader io.Reader, frame Frame, uploads string) bool {
+	if uploads == "" {
+		fmt.Printf("Refusing a file from %s, uploads are off\n", conn.RemoteAddr())
+		refusal := Frame{Type: FrameFileResult, Payload: []byte("uploads are off, the server has no upload directory")}
+		return WriteFrame(conn, refusal) == nil
+	}
+	label := fmt.Sprintf("from %s", conn.RemoteAddr())
+	path, err := receiveFile(reader, conn, frame, uploads, printProgress("Receiving", label))
+	if err != nil {
+		fmt.Printf("Error receiving a file %s: %s\n", label, err)
+		// The frames of the rest of the file may still be on their way
+		return false
 	}
+	fmt.Printf("Received %s %s\n", path, label)
+	return true
 }
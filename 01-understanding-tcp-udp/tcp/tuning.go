@@ -0,0 +1,110 @@
+package tcp
+
+import (
+	"net"
+	"time"
+
+	"netsim/lossy"
+	"netsim/throttle"
+)
+
+/**
+ * * Options tunes the socket of every connection the server accepts or the client dials. The
+ * * zero value keeps Go's defaults: TCP_NODELAY set, so every Write goes out right away, keep-alive
+ * * probes after 15 seconds of silence and the buffer sizes the kernel picks.
+ *
+ * * LowLatency and HighThroughput are starting points for the two ends of the trade-off.
+ */
+type Options struct {
+	// Nagle turns Nagle's algorithm back on (TCP_NODELAY off): small writes
+	// wait for the previous ones to be acknowledged and leave together,
+	// fewer packets at the cost of up to a round trip of delay.
+	Nagle bool
+
+	// KeepAlive is the interval of keep-alive probes, which notice a peer
+	// that vanished without closing. Zero keeps the default, negative turns
+	// them off.
+	KeepAlive time.Duration
+
+	// ReadBuffer and WriteBuffer set SO_RCVBUF and SO_SNDBUF in bytes, zero
+	// leaves them to the kernel, which doubles the value asked for and caps
+	// it at net.core.rmem_max and wmem_max.
+	ReadBuffer  int
+	WriteBuffer int
+
+	// Faults are injected into what the client writes, see lossy.Conn. TCP
+	// never loses, repeats or reorders bytes, so these are what the
+	// application would see if it did.
+	Faults lossy.Faults
+
+	// Bandwidth limits how fast the client and every connection of the
+	// server read and write, each direction on its own, see throttle.Conn.
+	Bandwidth throttle.Limit
+
+	// Heartbeat is how often the client and every connection of the server
+	// send a ping, zero sends none. Liveness is how long they wait to hear
+	// from the peer, pongs included, before they presume it dead and close
+	// the connection, three heartbeats when zero. See heartbeat.
+	Heartbeat time.Duration
+	Liveness  time.Duration
+
+	// Chat makes Server a chat room rather than an echo: what a client
+	// sends is broadcast to the other clients, see registry.
+	Chat bool
+
+	// UploadDir is where Server stores the files clients send it with
+	// SendFile. Empty refuses them.
+	UploadDir string
+
+	// Handler answers the requests of MuxClient on the server, EchoHandler
+	// when nil.
+	Handler Handler
+
+	// Codec makes Client send every line as a Message encoded with it, zero
+	// sends plain frames.
+	Codec Codec
+}
+
+var (
+	// LowLatency sends every line right away and probes idle peers often.
+	LowLatency = Options{KeepAlive: 10 * time.Second}
+
+	// HighThroughput coalesces small writes and uses 4MB buffers, so more
+	// data is in flight on long fat links.
+	HighThroughput = Options{Nagle: true, KeepAlive: time.Minute, ReadBuffer: 4 << 20, WriteBuffer: 4 << 20}
+)
+
+// Apply sets the options on conn.
+func (o Options) Apply(conn *net.TCPConn) error {
+	if o.Nagle {
+		if err := conn.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	switch {
+	case o.KeepAlive > 0:
+		if err := conn.SetKeepAliveConfig(net.KeepAliveConfig{Enable: true, Idle: o.KeepAlive, Interval: o.KeepAlive}); err != nil {
+			return err
+		}
+	case o.KeepAlive < 0:
+		if err := conn.SetKeepAlive(false); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := conn.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := conn.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// throttle limits conn to o.Bandwidth, a limiter for each direction.
+func (o Options) throttle(conn net.Conn) net.Conn {
+	return throttle.Conn(conn, throttle.NewLimiter(o.Bandwidth), throttle.NewLimiter(o.Bandwidth))
+}
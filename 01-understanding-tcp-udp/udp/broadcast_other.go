@@ -0,0 +1,13 @@
+//go:build !unix
+
+package udp
+
+import (
+	"errors"
+	"net"
+)
+
+// enableBroadcast is only implemented on unix systems.
+func enableBroadcast(conn *net.UDPConn) error {
+	return errors.New("broadcast is only supported on unix systems")
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package udp
+
+import (
+	"net"
+	"syscall"
+)
+
+// enableBroadcast sets SO_BROADCAST on conn, which sending to a broadcast
+// address requires.
+func enableBroadcast(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
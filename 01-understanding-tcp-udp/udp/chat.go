@@ -0,0 +1,43 @@
+package udp
+
+import (
+	"fmt"
+	"net"
+)
+
+// In a chat room every message a client sends is relayed to the other clients
+// of the session table, as a datagram without a sequence number. The sender
+// still gets its echo, which its sequence numbers are matched against. Joins,
+// leaves and nickname changes are announced the same way, lines starting with
+// "*". Relayed messages are not made reliable: a client that misses one
+// never knows.
+
+// chatRoom relays messages between the clients of sessions, when enabled.
+type chatRoom struct {
+	conn     *net.UDPConn
+	sessions *sessionTable
+	enabled  bool
+}
+
+// joined prints and announces a new client.
+func (r *chatRoom) joined(client *session) {
+	fmt.Println("New client", client)
+	r.announce(client.addr, fmt.Sprintf("* %s joined", client.handle()))
+}
+
+// announce sends line to every client but the one at from, to all of them
+// when from is nil. A line longer than a datagram is shortened, relayed
+// messages are not fragmented.
+func (r *chatRoom) announce(from *net.UDPAddr, line string) {
+	if !r.enabled {
+		return
+	}
+	if len(line) > maxDatagram {
+		line = preview(line)
+	}
+	for _, addr := range r.sessions.others(from) {
+		if _, err := r.conn.WriteToUDP([]byte(line), addr); err != nil {
+			fmt.Printf("Error relaying to %s: %s\n", addr, err)
+		}
+	}
+}
@@ -2,15 +2,31 @@ package udp
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"sync"
+	"time"
+
+	"netsim/lossy"
 )
 
-func Client(wg *sync.WaitGroup) {
+// straggleTime is how long the client waits for late echoes before reporting.
+const straggleTime = 500 * time.Millisecond
+
+// Client runs the interactive client with the default Options.
+func Client(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	Options{}.Client(ctx, wg, addr)
+}
+
+// Client sends the lines typed on stdin to addr until ctx is canceled, and
+// shows the echoes in the order the lines were sent, see ReorderWindow.
+func (o Options) Client(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
 	// Create UDP address
-	serverAddr, err := net.ResolveUDPAddr("udp", "localhost:8081")
+	serverAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		fmt.Println("Error resolving address:", err)
 		return
@@ -23,34 +39,150 @@ func Client(wg *sync.WaitGroup) {
 		return
 	}
 	defer conn.Close()
+	if err := conn.SetReadBuffer(receiveBuffer); err != nil {
+		fmt.Println("Error setting receive buffer:", err)
+	}
+	// Datagrams are sent through out, faults injected when asked for
+	var out net.Conn = conn
+	if o.Faults.Enabled() {
+		out = lossy.Conn(conn, o.Faults)
+		defer out.Close()
+	}
 
 	fmt.Println("Connected to UDP server. Type your message (exit to quit):")
 
+	stats := newSequenceStats()
+	window := o.ReorderWindow
+	if window == 0 {
+		window = defaultReorderWindow
+	}
+	var order *reorderBuffer
+	if window > 0 {
+		order = newReorderBuffer(window)
+		go expireGaps(ctx, order)
+	}
+	defer func() {
+		time.Sleep(straggleTime)
+		// Tell the server rather than let it wait for the idle timeout
+		out.Write([]byte(leaveMessage))
+		if order != nil {
+			printEvents(order.flush())
+		}
+		fmt.Println(stats.report())
+	}()
+
 	// Start goroutine to receive responses
+	fragments := newReassembler()
+	go expireFragments(ctx, fragments)
 	go func() {
-		buffer := make([]byte, 1024)
+		buffer := make([]byte, maxDatagram)
 		for {
 			n, _, err := conn.ReadFromUDP(buffer)
 			if err != nil {
 				fmt.Println("Error reading from server:", err)
 				return
 			}
-			fmt.Printf("Server: %s\n", string(buffer[:n]))
+			seq, index, count, message, ok := decodeFragment(buffer[:n])
+			if !ok {
+				// Relayed by a chat room, from another client
+				fmt.Println(preview(message))
+				continue
+			}
+			message, complete := fragments.add("server", seq, index, count, message)
+			if !complete {
+				continue
+			}
+			line := fmt.Sprintf("Server [seq %d, %s]: %s", seq, stats.observe(seq), preview(message))
+			if order == nil {
+				fmt.Println(line)
+				continue
+			}
+			printEvents(order.add(seq, line, time.Now()))
 		}
 	}()
 
+	// A heartbeat is due when nothing was sent for a whole interval
+	interval := o.Heartbeat
+	if interval == 0 {
+		interval = defaultHeartbeat
+	}
+	var heartbeats <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		heartbeats = ticker.C
+	}
+	sent := false
+
 	// Read and send user input
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		message := scanner.Text()
-		if message == "exit" {
+	lines := readLines(os.Stdin)
+	for {
+		var message string
+		select {
+		case <-ctx.Done():
 			return
+		case <-heartbeats:
+			if !sent {
+				if _, err := out.Write([]byte(heartbeatMessage)); err != nil {
+					fmt.Println("Error sending heartbeat:", err)
+				}
+			}
+			sent = false
+			continue
+		case line, ok := <-lines:
+			if !ok || line == "exit" {
+				return
+			}
+			message = line
+		}
+		sent = true
+
+		for _, datagram := range encodeFragments(stats.next(), message) {
+			if _, err := out.Write(datagram); err != nil {
+				fmt.Println("Error sending message:", err)
+				return
+			}
+		}
+	}
+}
+
+// readLines sends the lines of f on the returned channel, closing it at the
+// end of the input. Reading stdin cannot be interrupted, so it happens in a
+// goroutine of its own that the client can stop waiting for.
+func readLines(f *os.File) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(f)
+		// Lines as long as the largest message that can be fragmented
+		scanner.Buffer(nil, maxFragments*fragmentPayload)
+		for scanner.Scan() {
+			lines <- scanner.Text()
 		}
+		if err := scanner.Err(); err != nil {
+			fmt.Println("Error reading input:", err)
+		}
+	}()
+	return lines
+}
 
-		_, err := conn.Write([]byte(message))
-		if err != nil {
-			fmt.Println("Error sending message:", err)
+// expireGaps reports the gaps of order that were waited on long enough,
+// until ctx is canceled.
+func expireGaps(ctx context.Context, order *reorderBuffer) {
+	ticker := time.NewTicker(reorderTimeout / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case now := <-ticker.C:
+			printEvents(order.expire(now))
 		}
 	}
 }
+
+func printEvents(events []reorderEvent) {
+	for _, event := range events {
+		fmt.Println(event)
+	}
+}
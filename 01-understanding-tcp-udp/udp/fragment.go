@@ -0,0 +1,163 @@
+package udp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Messages longer than fits in one datagram are split into fragments, each
+// carrying the sequence number of its message, its index and the number of
+// fragments: "<seq>:<index>/<count>|<chunk>". The receiver puts them back
+// together in a reassembly buffer, whatever order they arrive in, and drops
+// the messages that are still incomplete after fragmentTimeout. Messages that
+// fit keep the plain "<seq>|<message>" form.
+
+const (
+	// maxDatagram is the largest datagram sent and the size of the read
+	// buffers, well below the 1500 bytes of an Ethernet MTU.
+	maxDatagram = 1024
+
+	// fragmentPayload is how much of a message one fragment carries, what is
+	// left of maxDatagram by the longest header.
+	fragmentPayload = maxDatagram - 64
+
+	// maxFragments bounds the size of a message, and so what a sender can
+	// make the receiver buffer, to about 1MB.
+	maxFragments = 1024
+
+	// fragmentTimeout is how long the fragments of a message are kept while
+	// waiting for the missing ones.
+	fragmentTimeout = 5 * time.Second
+
+	// receiveBuffer is the SO_RCVBUF asked for. The fragments of a long
+	// message arrive in a burst that overflows the kernel's default one,
+	// which drops the datagrams that do not fit.
+	receiveBuffer = 4 << 20
+)
+
+// encodeFragments returns the datagrams carrying message.
+func encodeFragments(seq uint64, message string) [][]byte {
+	if len(message) <= fragmentPayload {
+		return [][]byte{encodeSequenced(seq, message)}
+	}
+	count := (len(message) + fragmentPayload - 1) / fragmentPayload
+	datagrams := make([][]byte, 0, count)
+	for i := range count {
+		chunk := message[i*fragmentPayload : min((i+1)*fragmentPayload, len(message))]
+		datagrams = append(datagrams, []byte(fmt.Sprintf("%d:%d/%d|%s", seq, i, count, chunk)))
+	}
+	return datagrams
+}
+
+// decodeFragment is decodeSequenced for fragments too. For a whole message
+// index is 0 and count 1.
+func decodeFragment(datagram []byte) (seq uint64, index, count int, chunk string, ok bool) {
+	prefix, chunk, found := strings.Cut(string(datagram), "|")
+	position, fraction, fragmented := strings.Cut(prefix, ":")
+	if !found || !fragmented {
+		seq, message, ok := decodeSequenced(datagram)
+		return seq, 0, 1, message, ok
+	}
+	seq, err := strconv.ParseUint(position, 10, 64)
+	if err != nil {
+		return 0, 0, 0, string(datagram), false
+	}
+	i, n, _ := strings.Cut(fraction, "/")
+	index, errIndex := strconv.Atoi(i)
+	count, errCount := strconv.Atoi(n)
+	if errIndex != nil || errCount != nil || count < 1 || count > maxFragments || index < 0 || index >= count {
+		return 0, 0, 0, string(datagram), false
+	}
+	return seq, index, count, chunk, true
+}
+
+// partialMessage holds the fragments of a message received so far.
+type partialMessage struct {
+	fragments []string
+	arrived   []bool
+	received  int
+	started   time.Time
+}
+
+// reassembler puts fragmented messages back together, per sender.
+type reassembler struct {
+	mu      sync.Mutex
+	partial map[string]*partialMessage
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{partial: make(map[string]*partialMessage)}
+}
+
+// add records a fragment of the message seq of sender and returns the
+// message once all its fragments arrived. Duplicates are ignored.
+func (r *reassembler) add(sender string, seq uint64, index, count int, chunk string) (message string, complete bool) {
+	if count == 1 {
+		return chunk, true
+	}
+	key := sender + "#" + strconv.FormatUint(seq, 10)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.partial[key]
+	if !ok {
+		p = &partialMessage{fragments: make([]string, count), arrived: make([]bool, count), started: time.Now()}
+		r.partial[key] = p
+	}
+	if len(p.fragments) != count || p.arrived[index] {
+		return "", false
+	}
+	p.fragments[index], p.arrived[index] = chunk, true
+	p.received++
+	if p.received < count {
+		return "", false
+	}
+	delete(r.partial, key)
+	return strings.Join(p.fragments, ""), true
+}
+
+// expire drops the messages whose fragments did not all arrive within
+// fragmentTimeout and describes them.
+func (r *reassembler) expire(now time.Time) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var dropped []string
+	for key, p := range r.partial {
+		if now.Sub(p.started) < fragmentTimeout {
+			continue
+		}
+		delete(r.partial, key)
+		sender, seq, _ := strings.Cut(key, "#")
+		dropped = append(dropped, fmt.Sprintf("seq %s from %s, %d of %d fragments", seq, sender, p.received, len(p.fragments)))
+	}
+	return dropped
+}
+
+// expireFragments drops the incomplete messages of r until ctx is canceled.
+func expireFragments(ctx context.Context, r *reassembler) {
+	ticker := time.NewTicker(fragmentTimeout / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, dropped := range r.expire(now) {
+				fmt.Println("Dropped incomplete message:", dropped)
+			}
+		}
+	}
+}
+
+// preview shortens long messages to what is worth printing.
+func preview(message string) string {
+	const maxPreview = 80
+	if len(message) <= maxPreview {
+		return message
+	}
+	return fmt.Sprintf("%s... (%d bytes)", message[:maxPreview], len(message))
+}
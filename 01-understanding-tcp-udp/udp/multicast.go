@@ -0,0 +1,150 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// One datagram sent to a multicast group (224.0.0.0/4, 239.0.0.0/8 being the
+// range for local use) reaches every socket that joined the group, on this
+// host and, within the TTL, on the LAN. A broadcast to 255.255.255.255 or to
+// the broadcast address of a subnet reaches every socket bound to its port on
+// the subnet, joined or not. Either way the sender does not know how many, if
+// any, receivers there are: it finds out from the echoes.
+
+// MulticastServer joins the multicast group of addr, e.g. 239.0.0.1:8084,
+// and echoes every datagram arriving for the group, or broadcast to its
+// port, until ctx is canceled. The echoes go back to the sender alone. Any
+// number of them can run side by side, on one host or across the LAN.
+func MulticastServer(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	// Create UDP address
+	groupAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		fmt.Println("Error resolving address:", err)
+		return
+	}
+	if !groupAddr.IP.IsMulticast() {
+		fmt.Println("Not a multicast group:", groupAddr.IP)
+		return
+	}
+
+	// Join the group on the default interface. The socket is bound to the
+	// port on every address, with SO_REUSEADDR so that servers share it.
+	conn, err := net.ListenMulticastUDP("udp4", nil, groupAddr)
+	if err != nil {
+		fmt.Println("Error joining group:", err)
+		return
+	}
+	defer conn.Close()
+
+	fmt.Println("Multicast Server joined", groupAddr)
+
+	// The name tells the echoes of the servers apart
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%s/%d", host, os.Getpid())
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buffer := make([]byte, maxDatagram)
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				fmt.Println("Multicast Server stopped")
+				return
+			}
+			fmt.Println("Error reading from UDP:", err)
+			continue
+		}
+
+		message := string(buffer[:n])
+		fmt.Printf("Received from %s: %s\n", remoteAddr, message)
+
+		// Answer the sender only, not the whole group
+		response := fmt.Sprintf("Echo from %s: %s", name, message)
+		if _, err := conn.WriteToUDP([]byte(response), remoteAddr); err != nil {
+			fmt.Printf("Error sending response to %s: %s\n", remoteAddr, err)
+		}
+	}
+}
+
+// MulticastClient runs the multicast client with the default Options.
+func MulticastClient(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	Options{}.MulticastClient(ctx, wg, addr)
+}
+
+// MulticastClient sends the lines typed on stdin to addr, a multicast group
+// or with o.Broadcast a broadcast address, until ctx is canceled, and prints
+// the echoes of every server that received them.
+func (o Options) MulticastClient(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
+	// Create UDP address
+	destination, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		fmt.Println("Error resolving address:", err)
+		return
+	}
+
+	// Not connected to the destination: the echoes come from the address of
+	// every server, not from the group
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		fmt.Println("Error listening:", err)
+		return
+	}
+	defer conn.Close()
+	if o.Broadcast {
+		// Linux refuses to send to a broadcast address without SO_BROADCAST
+		if err := enableBroadcast(conn); err != nil {
+			fmt.Println("Error enabling broadcast:", err)
+			return
+		}
+	}
+
+	fmt.Printf("Sending to %s. Type your message (exit to quit):\n", destination)
+
+	// Start goroutine to receive responses, from any number of servers
+	go func() {
+		buffer := make([]byte, maxDatagram)
+		for {
+			n, serverAddr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					fmt.Println("Error reading from servers:", err)
+				}
+				return
+			}
+			fmt.Printf("%s: %s\n", serverAddr, buffer[:n])
+		}
+	}()
+
+	// Read and send user input
+	lines := readLines(os.Stdin)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line, ok := <-lines:
+			if !ok || line == "exit" {
+				// Give the echoes of the last line time to arrive
+				time.Sleep(straggleTime)
+				return
+			}
+			if _, err := conn.WriteToUDP([]byte(line), destination); err != nil {
+				fmt.Println("Error sending message:", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package udp
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"netsim/lossy"
+)
+
+const (
+	// defaultReorderWindow is how many later echoes the client holds while
+	// waiting for a missing one, when Options.ReorderWindow is zero.
+	defaultReorderWindow = 16
+
+	// reorderTimeout is how long the client waits for a missing echo before
+	// it reports it lost, however few later ones it holds.
+	reorderTimeout = straggleTime
+)
+
+// Options tune the UDP clients and server.
+type Options struct {
+	// ReorderWindow is how many echoes arriving ahead of a missing one are
+	// held so that they are shown in the order they were sent, zero means
+	// defaultReorderWindow. Negative shows them as they arrive.
+	ReorderWindow int
+
+	// Broadcast lets MulticastClient send to a broadcast address instead of
+	// a group: 255.255.255.255, or the one of a subnet like 192.168.1.255.
+	Broadcast bool
+
+	// Heartbeat is how often the client sends a heartbeat while it has
+	// nothing else to send, zero means defaultHeartbeat. Negative sends
+	// none, and the server evicts the client once it is quiet for long.
+	Heartbeat time.Duration
+
+	// IdleTimeout is how long the server keeps the session of a client that
+	// sent nothing, heartbeats included, zero means defaultIdleTimeout.
+	IdleTimeout time.Duration
+
+	// Faults are injected into the datagrams the client sends, see
+	// lossy.Conn, to watch the sequence numbers and the reorder buffer
+	// expose them.
+	Faults lossy.Faults
+
+	// Chat makes Server a chat room: every message is relayed to the other
+	// clients it has a session with, and joins and leaves are announced.
+	Chat bool
+}
+
+// eventKind tells what a reorderEvent reports.
+type eventKind int
+
+const (
+	delivered eventKind = iota // A line to show, in send order.
+	gap                        // Sequence numbers given up on.
+	late                       // A line arriving after its gap was reported, or twice.
+)
+
+// reorderEvent is something the reorderBuffer has to report.
+type reorderEvent struct {
+	kind    eventKind
+	seq     uint64
+	through uint64 // The last sequence number of a gap.
+	line    string
+}
+
+/**
+ * * reorderBuffer puts the lines numbered by one sender back in the order they were sent. A line
+ * * arriving ahead of a missing one is held until the missing one arrives, or until window lines
+ * * are held or the oldest gap was waited on for reorderTimeout: then the gap is reported lost
+ * * and the lines after it are released.
+ */
+type reorderBuffer struct {
+	mu      sync.Mutex
+	window  int
+	next    uint64
+	held    map[uint64]string
+	waiting time.Time // Since when the gap at next is waited on.
+}
+
+func newReorderBuffer(window int) *reorderBuffer {
+	return &reorderBuffer{window: window, next: 1, held: make(map[uint64]string)}
+}
+
+// add records the line of seq and returns what can be reported now.
+func (b *reorderBuffer) add(seq uint64, line string, now time.Time) []reorderEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.held[seq]; ok || seq < b.next {
+		return []reorderEvent{{kind: late, seq: seq, line: line}}
+	}
+	b.held[seq] = line
+	return b.release(now, false)
+}
+
+// expire reports the gaps waited on for reorderTimeout.
+func (b *reorderBuffer) expire(now time.Time) []reorderEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.release(now, false)
+}
+
+// flush reports every gap and releases every line held, once the sender is
+// done.
+func (b *reorderBuffer) flush() []reorderEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.release(time.Now(), true)
+}
+
+// release returns the lines that are next in order and the gaps given up
+// on, all of them when force is set. The caller must hold b.mu.
+func (b *reorderBuffer) release(now time.Time, force bool) []reorderEvent {
+	var events []reorderEvent
+	for len(b.held) > 0 {
+		if line, ok := b.held[b.next]; ok {
+			events = append(events, reorderEvent{kind: delivered, seq: b.next, line: line})
+			delete(b.held, b.next)
+			b.next++
+			b.waiting = time.Time{}
+			continue
+		}
+		if b.waiting.IsZero() {
+			b.waiting = now
+		}
+		if !force && len(b.held) < b.window && now.Sub(b.waiting) < reorderTimeout {
+			break
+		}
+		// Give up on the gap, up to the first line held.
+		first := slices.Min(slices.Collect(maps.Keys(b.held)))
+		events = append(events, reorderEvent{kind: gap, seq: b.next, through: first - 1})
+		b.next = first
+		b.waiting = time.Time{}
+	}
+	return events
+}
+
+// String formats e for the client's output.
+func (e reorderEvent) String() string {
+	switch {
+	case e.kind == delivered:
+		return e.line
+	case e.kind == late:
+		return fmt.Sprintf("Late or duplicate [seq %d], already reported: %s", e.seq, e.line)
+	case e.seq == e.through:
+		return fmt.Sprintf("Gap: seq %d lost", e.seq)
+	default:
+		return fmt.Sprintf("Gap: seq %d to %d lost", e.seq, e.through)
+	}
+}
@@ -0,0 +1,89 @@
+package udp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Every datagram carries its sequence number in front of the message,
+// "<seq>|<message>", and the server echoes it back the same way. Sequence
+// numbers make UDP's behavior visible: datagrams may be lost, duplicated or
+// arrive out of order, none of which can happen on a TCP connection.
+
+func encodeSequenced(seq uint64, message string) []byte {
+	return []byte(fmt.Sprintf("%d|%s", seq, message))
+}
+
+// decodeSequenced splits a datagram into its sequence number and message. ok
+// is false for datagrams without a sequence number.
+func decodeSequenced(datagram []byte) (seq uint64, message string, ok bool) {
+	prefix, message, found := strings.Cut(string(datagram), "|")
+	if !found {
+		return 0, string(datagram), false
+	}
+	seq, err := strconv.ParseUint(prefix, 10, 64)
+	if err != nil {
+		return 0, string(datagram), false
+	}
+	return seq, message, true
+}
+
+// sequenceStats tracks the echoes of the datagrams a client sent.
+type sequenceStats struct {
+	mu         sync.Mutex
+	sent       uint64
+	received   map[uint64]bool
+	duplicates uint64
+	reordered  uint64
+	highest    uint64
+}
+
+func newSequenceStats() *sequenceStats {
+	return &sequenceStats{received: make(map[uint64]bool)}
+}
+
+// next returns the sequence number of the next datagram to send.
+func (s *sequenceStats) next() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent++
+	return s.sent
+}
+
+// observe records the echo of seq and describes how it arrived.
+func (s *sequenceStats) observe(seq uint64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.received[seq]:
+		s.duplicates++
+		return "duplicate"
+	case seq < s.highest:
+		s.received[seq] = true
+		s.reordered++
+		return "out of order"
+	default:
+		s.received[seq] = true
+		s.highest = seq
+		return "in order"
+	}
+}
+
+// report summarizes the run as percentages of the datagrams sent.
+func (s *sequenceStats) report() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sent == 0 {
+		return "No datagrams sent"
+	}
+	lost := s.sent - uint64(len(s.received))
+	percent := func(n uint64) float64 { return 100 * float64(n) / float64(s.sent) }
+	return fmt.Sprintf(
+		"Sent %d, received %d: %.1f%% lost, %.1f%% duplicated, %.1f%% reordered",
+		s.sent, len(s.received), percent(lost), percent(s.duplicates), percent(s.reordered),
+	)
+}
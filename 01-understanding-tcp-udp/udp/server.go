@@ -1,46 +1,137 @@
 package udp
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
+	"time"
 )
 
-func Server(wg *sync.WaitGroup) {
+// Server runs the echo server with the default Options.
+func Server(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	Options{}.Server(ctx, wg, addr)
+}
+
+// Server echoes the datagrams arriving at addr until ctx is canceled, and
+// keeps a session per client, see IdleTimeout. A client sending
+// "/nick <name>" is known by that name from then on. With Chat the messages
+// are also relayed to the other clients.
+func (o Options) Server(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	defer wg.Done()
+
 	// Create UDP address
-	addr, err := net.ResolveUDPAddr("udp", ":8081")
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		fmt.Println("Error resolving address:", err)
 		return
 	}
 
 	// Create UDP connection
-	conn, err := net.ListenUDP("udp", addr)
+	conn, err := net.ListenUDP("udp", udpAddr)
 	if err != nil {
 		fmt.Println("Error listening:", err)
 		return
 	}
 	defer conn.Close()
+	if err := conn.SetReadBuffer(receiveBuffer); err != nil {
+		fmt.Println("Error setting receive buffer:", err)
+	}
+
+	fmt.Println("UDP Server listening on", conn.LocalAddr())
 
-	fmt.Println("UDP Server listening on :8081")
+	// UDP has no connections to drain, closing the socket is enough to stop
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
 
-	buffer := make([]byte, 1024)
+	fragments := newReassembler()
+	go expireFragments(ctx, fragments)
+
+	timeout := o.IdleTimeout
+	if timeout <= 0 {
+		timeout = defaultIdleTimeout
+	}
+	sessions := newSessionTable()
+	room := &chatRoom{conn: conn, sessions: sessions, enabled: o.Chat}
+	go evictIdle(ctx, sessions, timeout, func(s *session) {
+		fmt.Println("Evicted idle client", s.summary(time.Now()))
+		room.announce(nil, fmt.Sprintf("* %s left, idle", s.handle()))
+	})
+	defer func() {
+		for _, summary := range sessions.summaries(time.Now()) {
+			fmt.Println("Session", summary)
+		}
+	}()
+
+	buffer := make([]byte, maxDatagram)
 	for {
 		// Read incoming message
 		n, remoteAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
+			if ctx.Err() != nil {
+				fmt.Println("UDP Server stopped")
+				return
+			}
 			fmt.Println("Error reading from UDP:", err)
 			continue
 		}
 
-		message := string(buffer[:n])
-		fmt.Printf("Received from %s: %s\n", remoteAddr, message)
+		switch string(buffer[:n]) {
+		case heartbeatMessage:
+			if client, created := sessions.touch(remoteAddr, time.Now(), true); created {
+				room.joined(&client)
+			}
+			continue
+		case leaveMessage:
+			if s := sessions.remove(remoteAddr); s != nil {
+				fmt.Println("Client left", s.summary(time.Now()))
+				room.announce(nil, fmt.Sprintf("* %s left", s.handle()))
+			}
+			continue
+		}
 
-		// Send response back to client
-		response := "Echo: " + message
-		_, err = conn.WriteToUDP([]byte(response), remoteAddr)
-		if err != nil {
-			fmt.Printf("Error sending response to %s: %s\n", remoteAddr, err)
+		seq, index, count, message, ok := decodeFragment(buffer[:n])
+		if ok {
+			// Wait for the other fragments of the message
+			var complete bool
+			if message, complete = fragments.add(remoteAddr.String(), seq, index, count, message); !complete {
+				continue
+			}
+		}
+		client, created := sessions.touch(remoteAddr, time.Now(), false)
+		if created {
+			room.joined(&client)
+		}
+		fmt.Printf("Received from %s [seq %d]: %s\n", &client, seq, preview(message))
+
+		reply := "Echo: " + message
+		if nickname, found := strings.CutPrefix(message, "/nick "); found {
+			if err := sessions.rename(remoteAddr, nickname); err != nil {
+				reply = "Error: " + err.Error()
+			} else {
+				reply = "Nickname set to " + nickname
+				fmt.Printf("Client %s is now %s\n", &client, nickname)
+				room.announce(remoteAddr, fmt.Sprintf("* %s is now %s", client.handle(), nickname))
+			}
+		} else {
+			room.announce(remoteAddr, fmt.Sprintf("[%s] %s", client.handle(), message))
+		}
+
+		// Send response back to client, keeping the sequence number so the
+		// client can match echoes to what it sent, fragmented when the
+		// message was
+		response := [][]byte{[]byte(reply)}
+		if ok {
+			response = encodeFragments(seq, reply)
+		}
+		for _, datagram := range response {
+			if _, err := conn.WriteToUDP(datagram, remoteAddr); err != nil {
+				fmt.Printf("Error sending response to %s: %s\n", remoteAddr, err)
+				break
+			}
 		}
 	}
 }
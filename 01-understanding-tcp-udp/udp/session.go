@@ -0,0 +1,194 @@
+package udp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UDP has no connections, so the server makes up sessions: the clients it
+// heard from lately, by remote address. A client is expected to send a
+// heartbeat, a bare "heartbeat" datagram that is not echoed, whenever it had
+// nothing to send for a while, and a bare "leave" one when it quits. One
+// that sent nothing at all, heartbeats included, for the idle timeout is
+// evicted: its session is forgotten, and a datagram from it later starts a
+// new one.
+
+const (
+	// heartbeatMessage is the datagram of a heartbeat. It carries no sequence
+	// number, which no message of the client lacks.
+	heartbeatMessage = "heartbeat"
+
+	// leaveMessage is the datagram a client sends when it quits, so that the
+	// server need not wait for the idle timeout to forget it.
+	leaveMessage = "leave"
+
+	// defaultHeartbeat is how often the client sends a heartbeat when
+	// Options.Heartbeat is zero.
+	defaultHeartbeat = 10 * time.Second
+
+	// defaultIdleTimeout is how long the server keeps a silent client when
+	// Options.IdleTimeout is zero, three heartbeats missed in a row.
+	defaultIdleTimeout = 3 * defaultHeartbeat
+
+	// maxNickname bounds the length of a nickname.
+	maxNickname = 32
+)
+
+// session is what the server knows of a client.
+type session struct {
+	addr       *net.UDPAddr
+	nickname   string
+	messages   int
+	heartbeats int
+	joined     time.Time
+	lastSeen   time.Time
+}
+
+// String names the client by its nickname when it set one.
+func (s *session) String() string {
+	if s.nickname == "" {
+		return s.addr.String()
+	}
+	return fmt.Sprintf("%s (%s)", s.nickname, s.addr)
+}
+
+// handle is what the client is called in a chat room, its nickname or else
+// its address.
+func (s *session) handle() string {
+	if s.nickname == "" {
+		return s.addr.String()
+	}
+	return s.nickname
+}
+
+// summary describes what the client did during its session.
+func (s *session) summary(now time.Time) string {
+	return fmt.Sprintf("%s: %d messages and %d heartbeats in %s, last seen %s ago",
+		s, s.messages, s.heartbeats, now.Sub(s.joined).Round(time.Second), now.Sub(s.lastSeen).Round(time.Second))
+}
+
+// sessionTable holds the sessions of the clients, by remote address.
+type sessionTable struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{sessions: make(map[string]*session)}
+}
+
+// touch records a datagram from addr at now, a heartbeat or a message, and
+// returns a copy of the session and whether it was started by it.
+func (t *sessionTable) touch(addr *net.UDPAddr, now time.Time, heartbeat bool) (client session, created bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[addr.String()]
+	if !ok {
+		s = &session{addr: addr, joined: now}
+		t.sessions[addr.String()] = s
+	}
+	s.lastSeen = now
+	if heartbeat {
+		s.heartbeats++
+	} else {
+		s.messages++
+	}
+	return *s, !ok
+}
+
+// rename sets the nickname of the client at addr. Nicknames are unique, and
+// the session has to exist.
+func (t *sessionTable) rename(addr *net.UDPAddr, nickname string) error {
+	if nickname == "" || len(nickname) > maxNickname || strings.ContainsAny(nickname, " \t|") {
+		return fmt.Errorf("nickname must be 1 to %d characters, without spaces or |", maxNickname)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[addr.String()]
+	if !ok {
+		return fmt.Errorf("no session for %s", addr)
+	}
+	for _, other := range t.sessions {
+		if other != s && other.nickname == nickname {
+			return fmt.Errorf("nickname %s is taken", nickname)
+		}
+	}
+	s.nickname = nickname
+	return nil
+}
+
+// evict forgets the sessions that were silent for timeout at now and returns
+// them.
+func (t *sessionTable) evict(now time.Time, timeout time.Duration) []*session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var evicted []*session
+	for key, s := range t.sessions {
+		if now.Sub(s.lastSeen) < timeout {
+			continue
+		}
+		delete(t.sessions, key)
+		evicted = append(evicted, s)
+	}
+	return evicted
+}
+
+// remove forgets the session of addr and returns it, nil when there was none.
+func (t *sessionTable) remove(addr *net.UDPAddr) *session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.sessions[addr.String()]
+	delete(t.sessions, addr.String())
+	return s
+}
+
+// others returns the addresses of the clients other than the one at addr.
+func (t *sessionTable) others(addr *net.UDPAddr) []*net.UDPAddr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	others := make([]*net.UDPAddr, 0, len(t.sessions))
+	for key, s := range t.sessions {
+		if addr == nil || key != addr.String() {
+			others = append(others, s.addr)
+		}
+	}
+	return others
+}
+
+// summaries describes every session, oldest first.
+func (t *sessionTable) summaries(now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessions := make([]*session, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		sessions = append(sessions, s)
+	}
+	slices.SortFunc(sessions, func(a, b *session) int { return a.joined.Compare(b.joined) })
+	summaries := make([]string, len(sessions))
+	for i, s := range sessions {
+		summaries[i] = s.summary(now)
+	}
+	return summaries
+}
+
+// evictIdle evicts the sessions of t silent for timeout until ctx is
+// canceled, calling evicted with each.
+func evictIdle(ctx context.Context, t *sessionTable, timeout time.Duration, evicted func(*session)) {
+	ticker := time.NewTicker(timeout / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, s := range t.evict(now, timeout) {
+				evicted(s)
+			}
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Package admin is the HTTP API operators run a websocket.Server with, on an
+// address of its own that is not exposed to clients.
+//
+//	POST /admin/drain?deadline=30s&retry_after=5s
+//	GET  /admin/drain
+//
+// POST starts draining the server for a rolling deploy, see Server.Drain: new
+// handshakes are refused with 503 and Retry-After, open connections are given
+// until the deadline to end before they are asked to go away. It answers 202
+// right away, 409 when the server drains already. GET reports the progress,
+// a deploy script polls it until no connection is left and stops the
+// process:
+//
+//	{"draining":true,"connections":12}
+//
+// The other routes look into the live connections and act on them:
+//
+//	GET    /admin/conns               the connections, with their rooms and traffic
+//	GET    /admin/conns/{id}          one connection, with its frame histograms
+//	DELETE /admin/conns/{id}?reason=  kick it, closing with 1008 and reason
+//	GET    /admin/rooms               the rooms and their member counts
+//	POST   /admin/notice              send the body as a text message to every connection
+//
+// Whoever reaches the API can disconnect every client: serve it on a
+// loopback address, or behind RequireToken.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"websocket"
+)
+
+const (
+	// defaultDeadline is how long the connections of a drain are given to
+	// end when the request names no deadline.
+	defaultDeadline = 30 * time.Second
+
+	// defaultKickReason is the close reason of a kick naming none.
+	defaultKickReason = "kicked by an operator"
+
+	// maxNotice bounds the body of /admin/notice, a notice is sent in a
+	// single frame.
+	maxNotice = 64 << 10
+)
+
+// DrainStatus is the answer of /admin/drain.
+type DrainStatus struct {
+	Draining    bool `json:"draining"`
+	Connections int  `json:"connections"`
+
+	// Done is set once the server drains and every connection ended, or
+	// went away after the deadline, whoever started the drain.
+	Done bool `json:"done"`
+}
+
+// ConnInfo describes a connection in /admin/conns.
+type ConnInfo struct {
+	ID         string   `json:"id"`
+	RemoteAddr string   `json:"remote_addr"`
+	Principal  string   `json:"principal,omitempty"`
+	Session    string   `json:"session"`
+	Rooms      []string `json:"rooms,omitempty"`
+
+	ConnectedAt time.Time `json:"connected_at"`
+	Uptime      float64   `json:"uptime_seconds"`
+	Latency     float64   `json:"latency_seconds,omitempty"` // Zero until a ping was answered.
+
+	BytesRead    uint64 `json:"bytes_read"`
+	BytesWritten uint64 `json:"bytes_written"`
+
+	// Stats is only set by /admin/conns/{id}.
+	Stats *websocket.ConnStats `json:"stats,omitempty"`
+}
+
+// RoomInfo describes a room in /admin/rooms.
+type RoomInfo struct {
+	Room    string `json:"room"`
+	Members int    `json:"members"`
+}
+
+// Handler serves the admin API of server. rooms may be nil when the server
+// has none, connections are then listed without rooms and /admin/rooms
+// answers 404.
+func Handler(server *websocket.Server, rooms *websocket.Rooms) http.Handler {
+	a := &api{server: server, rooms: rooms}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/drain", a.drain)
+	mux.HandleFunc("GET /admin/drain", a.drainStatus)
+	mux.HandleFunc("GET /admin/conns", a.conns)
+	mux.HandleFunc("GET /admin/conns/{id}", a.conn)
+	mux.HandleFunc("DELETE /admin/conns/{id}", a.kick)
+	mux.HandleFunc("GET /admin/rooms", a.roomCounts)
+	mux.HandleFunc("POST /admin/notice", a.notice)
+	return mux
+}
+
+// RequireToken wraps the admin API, answering 401 to the requests without an
+// Authorization: Bearer header carrying token.
+func RequireToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "admin token required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type api struct {
+	server *websocket.Server
+	rooms  *websocket.Rooms
+
+	// once starts the single drain of the server.
+	once sync.Once
+}
+
+func (a *api) drain(w http.ResponseWriter, r *http.Request) {
+	deadline, ok := duration(w, r, "deadline", defaultDeadline)
+	if !ok {
+		return
+	}
+	retryAfter, ok := duration(w, r, "retry_after", 0)
+	if !ok {
+		return
+	}
+	if a.server.Draining() {
+		http.Error(w, "server draining already", http.StatusConflict)
+		return
+	}
+
+	a.once.Do(func() {
+		slog.Info("Drain requested", "deadline", deadline, "remote_addr", r.RemoteAddr)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), deadline)
+			defer cancel()
+			err := a.server.Drain(ctx, retryAfter)
+			slog.Info("Drain complete", "err", err)
+		}()
+	})
+	// The drain goroutine may not have started yet
+	status := a.status()
+	status.Draining = true
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+func (a *api) drainStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.status())
+}
+
+// status returns the progress of the drain, over once no connection is
+// left: Drain closes the last ones after the deadline.
+func (a *api) status() DrainStatus {
+	status := DrainStatus{Draining: a.server.Draining(), Connections: len(a.server.Conns())}
+	status.Done = status.Draining && status.Connections == 0
+	return status
+}
+
+func (a *api) conns(w http.ResponseWriter, r *http.Request) {
+	conns := a.server.Conns()
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, conn := range conns {
+		infos = append(infos, a.info(conn))
+	}
+	slices.SortFunc(infos, func(a, b ConnInfo) int { return a.ConnectedAt.Compare(b.ConnectedAt) })
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (a *api) conn(w http.ResponseWriter, r *http.Request) {
+	conn := a.find(w, r)
+	if conn == nil {
+		return
+	}
+	info := a.info(conn)
+	stats := conn.Stats()
+	info.Stats = &stats
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (a *api) kick(w http.ResponseWriter, r *http.Request) {
+	conn := a.find(w, r)
+	if conn == nil {
+		return
+	}
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = defaultKickReason
+	}
+	if len(reason) > 123 || !utf8.ValidString(reason) {
+		http.Error(w, "reason must be at most 123 bytes of UTF-8", http.StatusBadRequest)
+		return
+	}
+	conn.Logger().Info("Kicked by an operator", "reason", reason, "admin_addr", r.RemoteAddr)
+	// Close waits for the client's answer, the operator does not
+	go conn.Close(1008, reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) roomCounts(w http.ResponseWriter, r *http.Request) {
+	if a.rooms == nil {
+		http.NotFound(w, r)
+		return
+	}
+	counts := a.rooms.MemberCounts()
+	infos := make([]RoomInfo, 0, len(counts))
+	for room, members := range counts {
+		infos = append(infos, RoomInfo{Room: room, Members: members})
+	}
+	slices.SortFunc(infos, func(a, b RoomInfo) int { return strings.Compare(a.Room, b.Room) })
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// notice sends the body verbatim, a JSON server notice for instance, to
+// every connection through its send queue.
+func (a *api) notice(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxNotice))
+	if err != nil {
+		http.Error(w, "notice too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(body) == 0 || !utf8.Valid(body) {
+		http.Error(w, "notice must be UTF-8 text", http.StatusBadRequest)
+		return
+	}
+
+	var sent, failed int
+	for _, conn := range a.server.Conns() {
+		if conn.Enqueue(0x1, body) != nil {
+			failed++
+			continue
+		}
+		sent++
+	}
+	slog.Info("Notice sent", "size", len(body), "sent", sent, "failed", failed, "remote_addr", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]int{"sent": sent, "failed": failed})
+}
+
+// info describes conn.
+func (a *api) info(conn *websocket.Conn) ConnInfo {
+	stats := conn.Stats()
+	info := ConnInfo{
+		ID:           conn.ID(),
+		RemoteAddr:   conn.RemoteAddr().String(),
+		Session:      conn.Session().ID(),
+		ConnectedAt:  conn.ConnectedAt(),
+		Uptime:       time.Since(conn.ConnectedAt()).Seconds(),
+		Latency:      conn.Latency().Seconds(),
+		BytesRead:    stats.BytesRead,
+		BytesWritten: stats.BytesWritten,
+	}
+	if principal, ok := conn.Principal(); ok {
+		info.Principal = principal.ID
+	}
+	if a.rooms != nil {
+		info.Rooms = a.rooms.RoomsOf(conn)
+	}
+	return info
+}
+
+// find returns the connection named by the id path value of r, answering
+// 404 when there is none.
+func (a *api) find(w http.ResponseWriter, r *http.Request) *websocket.Conn {
+	id := r.PathValue("id")
+	for _, conn := range a.server.Conns() {
+		if conn.ID() == id {
+			return conn
+		}
+	}
+	http.Error(w, "no connection "+id, http.StatusNotFound)
+	return nil
+}
+
+// writeJSON answers with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// duration parses the query parameter name of r, fallback when it is
+// absent, and answers 400 when it is not a duration.
+func duration(w http.ResponseWriter, r *http.Request, name string, fallback time.Duration) (time.Duration, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, true
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		http.Error(w, name+" must be a duration such as 30s", http.StatusBadRequest)
+		return 0, false
+	}
+	return d, true
+}
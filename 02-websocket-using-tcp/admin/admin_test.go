@@ -0,0 +1,35 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"websocket"
+)
+
+func TestDrainStartedElsewhere(t *testing.T) {
+	server := websocket.NewServer("")
+	if err := server.Drain(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	handler := Handler(server, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/drain", nil))
+	var status DrainStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if want := (DrainStatus{Draining: true, Done: true}); status != want {
+		t.Errorf("GET /admin/drain: %+v, want %+v", status, want)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/drain", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("POST /admin/drain: %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
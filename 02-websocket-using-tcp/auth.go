@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Principal is the identity a client authenticated as during the handshake.
+type Principal struct {
+	// ID identifies the client, e.g. a user ID or an API key name.
+	ID string
+
+	// Attributes carries whatever else the authenticator knows about the
+	// client, such as roles or a tenant, for handlers to authorize against.
+	Attributes map[string]string
+
+	// Guest is set for clients a Server with AllowGuests admitted without
+	// credentials. Their ID is "guest-" followed by the connection ID.
+	Guest bool
+}
+
+// Authenticator identifies the client from its handshake request. Returning
+// an error rejects the handshake with 401 Unauthorized.
+type Authenticator func(request *http.Request) (Principal, error)
+
+// ErrNoCredentials is returned by the built-in authenticators when the
+// request carries no credentials at all.
+var ErrNoCredentials = errors.New("no credentials")
+
+// BearerToken authenticates clients sending "Authorization: Bearer <token>",
+// validate maps the token to a principal. Browsers cannot set headers on
+// WebSocket requests, so the token is also accepted as the access_token query
+// parameter.
+func BearerToken(validate func(token string) (Principal, error)) Authenticator {
+	return func(request *http.Request) (Principal, error) {
+		token, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			token = request.URL.Query().Get("access_token")
+		}
+		if token == "" {
+			return Principal{}, ErrNoCredentials
+		}
+		return validate(token)
+	}
+}
+
+// Cookie authenticates clients by the value of the named cookie, typically a
+// session ID, validate maps the value to a principal.
+func Cookie(name string, validate func(value string) (Principal, error)) Authenticator {
+	return func(request *http.Request) (Principal, error) {
+		cookie, err := request.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return Principal{}, ErrNoCredentials
+		}
+		return validate(cookie.Value)
+	}
+}
+
+// Principal returns the identity the client authenticated as. ok is false
+// when the server has no Authenticate hook.
+func (c *Conn) Principal() (principal Principal, ok bool) {
+	c.identityMu.RLock()
+	defer c.identityMu.RUnlock()
+	if c.principal == nil {
+		return Principal{}, false
+	}
+	return *c.principal, true
+}
@@ -0,0 +1,75 @@
+// Package broker connects the hubs of several server instances, so that a
+// message broadcast on one instance reaches the clients of all of them.
+//
+// A Hub publishes every broadcast to a topic and delivers what its
+// subscriptions receive to its local clients. With the in-memory broker,
+// the default, publishing is delivering and a single instance behaves as if
+// there were no broker at all. Implementations backed by a message system,
+// such as broker/redis, make the same topics span processes.
+package broker
+
+import "sync"
+
+// Broker is a topic based publish/subscribe transport.
+type Broker interface {
+	// Publish sends payload to every subscriber of topic, on every instance.
+	Publish(topic string, payload []byte) error
+
+	// Subscribe calls handler for every payload published to topic until
+	// unsubscribe is called. The subscription must be active when Subscribe
+	// returns, so that a message published right after is not missed.
+	// Handlers of one topic are called in publishing order, from a single
+	// goroutine or the publisher's, and must not block for long.
+	Subscribe(topic string, handler func(payload []byte)) (unsubscribe func(), err error)
+}
+
+// Memory is a Broker within one process. It delivers synchronously from
+// the publishing goroutine. Its zero value is ready to use.
+type Memory struct {
+	mu       sync.RWMutex
+	handlers map[string]map[int]func([]byte)
+	next     int
+}
+
+// NewMemory returns an in-memory broker.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Publish(topic string, payload []byte) error {
+	m.mu.RLock()
+	handlers := make([]func([]byte), 0, len(m.handlers[topic]))
+	for _, handler := range m.handlers[topic] {
+		handlers = append(handlers, handler)
+	}
+	m.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(payload)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(topic string, handler func([]byte)) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.handlers == nil {
+		m.handlers = make(map[string]map[int]func([]byte))
+	}
+	if m.handlers[topic] == nil {
+		m.handlers[topic] = make(map[int]func([]byte))
+	}
+	id := m.next
+	m.next++
+	m.handlers[topic][id] = handler
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.handlers[topic], id)
+		if len(m.handlers[topic]) == 0 {
+			delete(m.handlers, topic)
+		}
+	}, nil
+}
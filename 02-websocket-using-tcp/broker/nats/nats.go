@@ -0,0 +1,248 @@
+// Package nats is a broker.Broker on top of NATS core publish/subscribe, so
+// that deployments already running NATS scale the server horizontally.
+//
+// Every topic maps to its own subject, Prefix followed by the topic: the
+// members of room "lobby" are reached on "socket101.room.lobby". Like the
+// redis package it speaks the few protocol operations it needs (CONNECT,
+// PUB, SUB, UNSUB, PING and PONG) itself and does not depend on a NATS
+// client library. Servers requiring credentials or TLS are not supported.
+package nats
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Broker publishes and subscribes through one NATS server connection.
+type Broker struct {
+	Prefix string
+
+	writeMu sync.Mutex
+	conn    net.Conn
+
+	mu       sync.Mutex
+	handlers map[int]func([]byte) // By subscription id.
+	next     int
+	pongs    []chan struct{} // PINGs waiting for their PONG, in order.
+	closed   chan struct{}
+}
+
+// Dial connects to the NATS server at addr (host:port).
+func Dial(addr string) (*Broker, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := readLine(r)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("nats: expected INFO, got %q", line)
+	}
+
+	options, _ := json.Marshal(map[string]any{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "socket-101",
+		"lang":     "go",
+		"protocol": 1,
+	})
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", options); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	b := &Broker{
+		Prefix:   "socket101.",
+		conn:     conn,
+		handlers: make(map[int]func([]byte)),
+		closed:   make(chan struct{}),
+	}
+	go b.readLoop(r)
+	// The PONG confirms the server accepted CONNECT.
+	if err := b.flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Close closes the connection to NATS.
+func (b *Broker) Close() error {
+	return b.conn.Close()
+}
+
+// Subject returns the subject topic is published on.
+func (b *Broker) Subject(topic string) string {
+	return b.Prefix + escape(topic)
+}
+
+func (b *Broker) Publish(topic string, payload []byte) error {
+	header := fmt.Sprintf("PUB %s %d\r\n", b.Subject(topic), len(payload))
+	return b.write(append(append([]byte(header), payload...), '\r', '\n'))
+}
+
+func (b *Broker) Subscribe(topic string, handler func([]byte)) (func(), error) {
+	return b.QueueSubscribe(topic, "", handler)
+}
+
+// QueueSubscribe is Subscribe within the queue group group: every message
+// is handed to one subscriber of the group only, chosen by the server,
+// instead of all of them. Instances subscribing to a topic in the same group
+// share its load. Hub and room broadcasts must reach every instance and use
+// Subscribe; queue groups suit topics consumed once per deployment. An empty
+// group is Subscribe.
+func (b *Broker) QueueSubscribe(topic, group string, handler func([]byte)) (func(), error) {
+	b.mu.Lock()
+	b.next++
+	sid := b.next
+	b.handlers[sid] = handler
+	b.mu.Unlock()
+
+	sub := "SUB " + b.Subject(topic)
+	if group != "" {
+		sub += " " + group
+	}
+	unsubscribe := func() {
+		b.mu.Lock()
+		delete(b.handlers, sid)
+		b.mu.Unlock()
+		b.write(fmt.Appendf(nil, "UNSUB %d\r\n", sid))
+	}
+
+	if err := b.write(fmt.Appendf(nil, "%s %d\r\n", sub, sid)); err != nil {
+		unsubscribe()
+		return nil, err
+	}
+	// The server handles operations in order, once it answered a PING the
+	// subscription is active.
+	if err := b.flush(); err != nil {
+		unsubscribe()
+		return nil, err
+	}
+	return unsubscribe, nil
+}
+
+// flush sends a PING and waits for its PONG.
+func (b *Broker) flush() error {
+	pong := make(chan struct{})
+	b.mu.Lock()
+	b.pongs = append(b.pongs, pong)
+	b.mu.Unlock()
+
+	if err := b.write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+	select {
+	case <-pong:
+		return nil
+	case <-b.closed:
+		return errors.New("nats: connection closed")
+	case <-time.After(5 * time.Second):
+		return errors.New("nats: no PONG from server")
+	}
+}
+
+func (b *Broker) write(data []byte) error {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	_, err := b.conn.Write(data)
+	return err
+}
+
+// readLoop dispatches the operations sent by the server.
+func (b *Broker) readLoop(r *bufio.Reader) {
+	defer close(b.closed)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("NATS connection lost", "err", err)
+			}
+			return
+		}
+		op, args, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(op) {
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(args)
+			if len(fields) < 3 {
+				slog.Error("Malformed NATS message", "line", line)
+				return
+			}
+			sid, _ := strconv.Atoi(fields[1])
+			n, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || n < 0 {
+				slog.Error("Malformed NATS message", "line", line)
+				return
+			}
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			b.mu.Lock()
+			handler := b.handlers[sid]
+			b.mu.Unlock()
+			if handler != nil {
+				handler(payload[:n])
+			}
+		case "PING":
+			b.write([]byte("PONG\r\n"))
+		case "PONG":
+			b.mu.Lock()
+			if len(b.pongs) > 0 {
+				close(b.pongs[0])
+				b.pongs = b.pongs[1:]
+			}
+			b.mu.Unlock()
+		case "-ERR":
+			slog.Error("NATS server error", "err", args)
+		}
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// escape makes topic a valid subject. Whitespace and the wildcards * and >
+// cannot appear in one and are percent-encoded. Dots separate tokens and are
+// kept, except where they would leave a token empty.
+func escape(topic string) string {
+	var sb strings.Builder
+	for i, c := range []byte(topic) {
+		switch {
+		case c == '%' || c == '*' || c == '>' || c <= ' ' || c == 0x7f:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		case c == '.' && (i == 0 || i == len(topic)-1 || topic[i-1] == '.'):
+			sb.WriteString("%2E")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	if sb.Len() == 0 {
+		return "%"
+	}
+	return sb.String()
+}
@@ -0,0 +1,237 @@
+// Package redis is a broker.Broker on top of Redis Pub/Sub, so that several
+// instances of the server broadcast to each other's clients.
+//
+// It speaks the few RESP commands it needs (PUBLISH, SUBSCRIBE and
+// UNSUBSCRIBE) itself, the server does not depend on a Redis client library.
+// Redis Pub/Sub is fire and forget: messages published while an instance is
+// disconnected are not delivered to it later.
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Broker publishes and subscribes through one Redis server. Topics are
+// mapped to channels named Prefix followed by the topic.
+type Broker struct {
+	Prefix string
+
+	pubMu  sync.Mutex
+	pub    net.Conn
+	pubBuf *bufio.Reader
+
+	subMu    sync.Mutex
+	sub      net.Conn
+	handlers map[string]map[int]func([]byte) // By channel.
+	next     int
+	pending  map[string]chan struct{} // SUBSCRIBE commands waiting for their confirmation.
+	closed   chan struct{}
+}
+
+// Dial connects to the Redis server at addr (host:port). It opens two
+// connections, a subscribed connection cannot publish.
+func Dial(addr string) (*Broker, error) {
+	pub, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		pub.Close()
+		return nil, err
+	}
+
+	b := &Broker{
+		Prefix:   "socket101:",
+		pub:      pub,
+		pubBuf:   bufio.NewReader(pub),
+		sub:      sub,
+		handlers: make(map[string]map[int]func([]byte)),
+		pending:  make(map[string]chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	go b.readLoop(bufio.NewReader(sub))
+	return b, nil
+}
+
+// Close closes both connections to Redis.
+func (b *Broker) Close() error {
+	b.sub.Close()
+	return b.pub.Close()
+}
+
+func (b *Broker) Publish(topic string, payload []byte) error {
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	if err := writeCommand(b.pub, []byte("PUBLISH"), []byte(b.Prefix+topic), payload); err != nil {
+		return err
+	}
+	reply, err := readReply(b.pubBuf)
+	if err != nil {
+		return err
+	}
+	if err, ok := reply.(error); ok {
+		return err
+	}
+	return nil
+}
+
+func (b *Broker) Subscribe(topic string, handler func([]byte)) (func(), error) {
+	channel := b.Prefix + topic
+
+	b.subMu.Lock()
+	id := b.next
+	b.next++
+	first := b.handlers[channel] == nil
+	if first {
+		b.handlers[channel] = make(map[int]func([]byte))
+	}
+	b.handlers[channel][id] = handler
+
+	// Later subscribers of a channel still being subscribed wait for the
+	// same confirmation.
+	confirmed := b.pending[channel]
+	if first {
+		confirmed = make(chan struct{})
+		b.pending[channel] = confirmed
+		if err := writeCommand(b.sub, []byte("SUBSCRIBE"), []byte(channel)); err != nil {
+			delete(b.handlers, channel)
+			delete(b.pending, channel)
+			b.subMu.Unlock()
+			return nil, err
+		}
+	}
+	b.subMu.Unlock()
+
+	unsubscribe := func() {
+		b.subMu.Lock()
+		defer b.subMu.Unlock()
+		delete(b.handlers[channel], id)
+		if len(b.handlers[channel]) == 0 {
+			delete(b.handlers, channel)
+			writeCommand(b.sub, []byte("UNSUBSCRIBE"), []byte(channel))
+		}
+	}
+
+	if confirmed != nil {
+		select {
+		case <-confirmed:
+		case <-b.closed:
+			unsubscribe()
+			return nil, errors.New("redis: connection closed")
+		case <-time.After(5 * time.Second):
+			unsubscribe()
+			return nil, fmt.Errorf("redis: no confirmation for SUBSCRIBE %s", channel)
+		}
+	}
+	return unsubscribe, nil
+}
+
+// readLoop dispatches the pushes of the subscribed connection.
+func (b *Broker) readLoop(r *bufio.Reader) {
+	defer close(b.closed)
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("Redis subscription connection lost", "err", err)
+			}
+			return
+		}
+		push, ok := reply.([]any)
+		if !ok || len(push) < 3 {
+			continue
+		}
+		kind, _ := push[0].([]byte)
+		channel, _ := push[1].([]byte)
+
+		switch string(kind) {
+		case "subscribe":
+			b.subMu.Lock()
+			if confirmed, ok := b.pending[string(channel)]; ok {
+				close(confirmed)
+				delete(b.pending, string(channel))
+			}
+			b.subMu.Unlock()
+		case "message":
+			payload, _ := push[2].([]byte)
+			b.subMu.Lock()
+			handlers := make([]func([]byte), 0, len(b.handlers[string(channel)]))
+			for _, handler := range b.handlers[string(channel)] {
+				handlers = append(handlers, handler)
+			}
+			b.subMu.Unlock()
+			for _, handler := range handlers {
+				handler(payload)
+			}
+		}
+	}
+}
+
+// writeCommand sends args as a RESP array of bulk strings.
+func writeCommand(w io.Writer, args ...[]byte) error {
+	buf := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, arg := range args {
+		buf = fmt.Appendf(buf, "$%d\r\n", len(arg))
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// readReply reads one RESP value: a string or bulk string as []byte, an
+// integer as int64, an error as error, an array as []any and a nil bulk
+// string or array as nil.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return []byte(body), nil
+	case '-':
+		return errors.New("redis: " + body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]any, n)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+	}
+}
@@ -0,0 +1,29 @@
+// Package chat is the chat protocol generated by cmd/wsgen from schema.json.
+// Edit the schema and run go generate instead of editing messages_gen.go.
+package chat
+
+//go:generate go run websocket/cmd/wsgen -schema schema.json -out messages_gen.go
+
+import "websocket"
+
+// Handler is a websocket.Handler speaking the generated protocol: every message
+// is acknowledged, typing notifications are only logged.
+func Handler(conn *websocket.Conn) {
+	handlers := &Handlers{
+		OnMessage: func(msg Message) error {
+			conn.Logger().Info("Received message", "content", msg.Content)
+			return SendMessage(conn, Message{Role: "agent", Content: "Message Recieved"})
+		},
+		OnTyping: func(msg Typing) error {
+			conn.Logger().Info("Typing", "role", msg.Role)
+			return nil
+		},
+	}
+
+	for {
+		if err := Dispatch(conn, handlers); err != nil {
+			conn.Logger().Warn("Error handling message", "err", err)
+			return
+		}
+	}
+}
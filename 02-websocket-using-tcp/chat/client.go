@@ -0,0 +1,135 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"websocket"
+	"websocket/id"
+)
+
+// ErrClientClosed is returned by SendWithAck once the connection of the
+// Client ended, wrapping why, and to the calls waiting when it does.
+var ErrClientClosed = errors.New("chat: connection closed")
+
+/**
+ * * Client speaks the plain JSON chat protocol over a websocket.Client. It reads the connection on a
+ * * goroutine of its own: every Msg goes to the onMessage of NewClient, every Receipt to the
+ * * SendWithAck waiting for it, whatever order they arrive in.
+ */
+type Client struct {
+	conn      *websocket.Client
+	onMessage func(Msg)
+	done      chan struct{}
+
+	mu      sync.Mutex
+	waiting map[string]chan Receipt
+	err     error // why the connection ended, nil while it is open
+}
+
+// NewClient returns a Client over conn calling onMessage, on the goroutine
+// reading conn, with every Msg received until the connection ends.
+func NewClient(conn *websocket.Client, onMessage func(Msg)) *Client {
+	c := &Client{conn: conn, onMessage: onMessage, done: make(chan struct{}), waiting: make(map[string]chan Receipt)}
+	go c.read()
+	return c
+}
+
+// Send sends msg without asking for a receipt.
+func (c *Client) Send(msg Msg) error {
+	msg.Ack = false
+	return c.conn.WriteJSON(msg)
+}
+
+// SendWithAck sends msg asking for a receipt and waits for it until ctx is
+// done. msg gets an ID when it has none.
+func (c *Client) SendWithAck(ctx context.Context, msg Msg) (Receipt, error) {
+	if msg.ID == "" {
+		msg.ID = id.Default.New()
+	}
+	msg.Ack = true
+	// Buffered, so that read never waits for a call that gave up
+	receipt := make(chan Receipt, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return Receipt{}, c.err
+	}
+	c.waiting[msg.ID] = receipt
+	c.mu.Unlock()
+	forget := func() {
+		c.mu.Lock()
+		delete(c.waiting, msg.ID)
+		c.mu.Unlock()
+	}
+
+	if err := c.conn.WriteJSON(msg); err != nil {
+		forget()
+		return Receipt{}, err
+	}
+	select {
+	case r := <-receipt:
+		return r, nil
+	case <-c.done:
+		return Receipt{}, c.Err()
+	case <-ctx.Done():
+		forget()
+		return Receipt{}, ctx.Err()
+	}
+}
+
+// Done is closed once the connection ended, Err tells why.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection ended, nil while it is open.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// read dispatches what the server sends until the connection ends.
+func (c *Client) read() {
+	var err error
+	for {
+		var data []byte
+		if _, data, err = c.conn.ReadFullMessage(); err != nil {
+			break
+		}
+		var envelope struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(data, &envelope) != nil {
+			continue
+		}
+		if envelope.Type == "ack" {
+			var r Receipt
+			if json.Unmarshal(data, &r) != nil {
+				continue
+			}
+			c.mu.Lock()
+			receipt, ok := c.waiting[r.ID]
+			delete(c.waiting, r.ID)
+			c.mu.Unlock()
+			if ok {
+				receipt <- r
+			}
+			continue
+		}
+		var msg Msg
+		if json.Unmarshal(data, &msg) == nil && c.onMessage != nil {
+			c.onMessage(msg)
+		}
+	}
+
+	c.mu.Lock()
+	c.err = fmt.Errorf("%w: %w", ErrClientClosed, err)
+	c.waiting = nil
+	c.mu.Unlock()
+	close(c.done)
+}
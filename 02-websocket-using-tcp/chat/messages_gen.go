@@ -0,0 +1,91 @@
+// Code generated by wsgen. DO NOT EDIT.
+
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Sender is implemented by *websocket.Conn and *websocket.Client.
+type Sender interface {
+	WriteJSON(v any) error
+}
+
+// Receiver is implemented by *websocket.Conn and *websocket.Client.
+type Receiver interface {
+	ReadJSON(v any) error
+}
+
+// envelope is the wire format of every message.
+type envelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Message is the "message" message.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// SendMessage sends msg as a "message" message.
+func SendMessage(conn Sender, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteJSON(envelope{Type: "message", Data: data})
+}
+
+// Typing is the "typing" message.
+type Typing struct {
+	Role string `json:"role"`
+}
+
+// SendTyping sends msg as a "typing" message.
+func SendTyping(conn Sender, msg Typing) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteJSON(envelope{Type: "typing", Data: data})
+}
+
+// Handlers holds one callback per message type. Messages whose callback is
+// nil are ignored.
+type Handlers struct {
+	OnMessage func(Message) error
+	OnTyping  func(Typing) error
+}
+
+// Dispatch reads one message from conn and passes it to the matching handler.
+func Dispatch(conn Receiver, h *Handlers) error {
+	var env envelope
+	if err := conn.ReadJSON(&env); err != nil {
+		return err
+	}
+
+	switch env.Type {
+	case "message":
+		var msg Message
+		if err := json.Unmarshal(env.Data, &msg); err != nil {
+			return fmt.Errorf("decoding message message: %w", err)
+		}
+		if h.OnMessage == nil {
+			return nil
+		}
+		return h.OnMessage(msg)
+	case "typing":
+		var msg Typing
+		if err := json.Unmarshal(env.Data, &msg); err != nil {
+			return fmt.Errorf("decoding typing message: %w", err)
+		}
+		if h.OnTyping == nil {
+			return nil
+		}
+		return h.OnTyping(msg)
+	default:
+		return fmt.Errorf("unknown message type %q", env.Type)
+	}
+}
@@ -0,0 +1,185 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"websocket"
+)
+
+// Msg is the message of the plain JSON chat protocol spoken by the web
+// client in ./client, older than the generated one.
+type Msg struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+
+	// TraceID is an optional envelope field identifying the message in the
+	// logs of every delivery it causes. The server assigns one to messages
+	// sent without, the reply and the relayed copies carry it.
+	TraceID string `json:"trace_id,omitempty"`
+
+	// ID identifies the message in its Receipt, which the sender asks for
+	// with Ack. Both are optional, see RelayHandler.
+	ID  string `json:"id,omitempty"`
+	Ack bool   `json:"ack,omitempty"`
+}
+
+// Receipt acknowledges a Msg sent with Ack: out of the connections the
+// message was relayed to, Delivered got it written, Failed had it dropped
+// and TimedOut still had it queued after ackTimeout. Type is always "ack",
+// which tells it apart from a Msg.
+type Receipt struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Delivered int    `json:"delivered"`
+	Failed    int    `json:"failed"`
+	TimedOut  int    `json:"timed_out"`
+}
+
+// ackTimeout is how long RelayHandler waits for a message asking for a
+// receipt to be written to every recipient.
+const ackTimeout = 5 * time.Second
+
+// AckHandler acknowledges every Msg it receives. It is the handler
+// cmd/ws-server runs by default and the one the web client talks to.
+func AckHandler(conn *websocket.Conn) {
+	serveAcks(conn, nil)
+}
+
+// PooledAckHandler is AckHandler decoding and answering messages on pool,
+// while the connection's goroutine keeps reading, see websocket.WorkerPool.
+func PooledAckHandler(pool *websocket.WorkerPool) websocket.Handler {
+	return func(conn *websocket.Conn) {
+		serveAcks(conn, pool)
+	}
+}
+
+func serveAcks(conn *websocket.Conn, pool *websocket.WorkerPool) {
+	err := conn.ReadLoop(pool, func(opcode byte, data []byte) error {
+		msg, ok := decodeMsg(conn, data)
+		if !ok {
+			return nil
+		}
+		traceID := traceMsg(conn, &msg)
+		conn.Logger().Info("Received message", "trace_id", traceID, "content", msg.Content)
+
+		response := Msg{Role: "agent", Content: "Message Recieved", TraceID: msg.TraceID}
+		if err := conn.WriteJSON(response); err != nil {
+			conn.Logger().Error("Error sending message", "trace_id", traceID, "err", err)
+		}
+		return nil
+	})
+	logDisconnect(conn, err)
+}
+
+// RelayHandler returns a handler relaying every Msg it receives to all
+// connections in hub, including the sender. A Msg with Ack set is answered
+// with a Receipt once it was written to them, see Hub.Deliver: it only
+// reaches the connections of this instance.
+func RelayHandler(hub *websocket.Hub) websocket.Handler {
+	return func(conn *websocket.Conn) {
+		hub.Register(conn)
+		defer hub.Unregister(conn)
+
+		for {
+			msg, err := readMsg(conn)
+			if err != nil {
+				logDisconnect(conn, err)
+				return
+			}
+
+			traceID := traceMsg(conn, &msg)
+			conn.Logger().Info("Received message", "trace_id", traceID, "content", msg.Content)
+
+			// The recipients do not acknowledge it themselves
+			ack := msg.Ack
+			msg.Ack = false
+			if ack && msg.ID == "" {
+				msg.ID = conn.NewID()
+			}
+			payload, err := json.Marshal(msg)
+			if err != nil {
+				conn.Logger().Error("Error encoding message", "trace_id", traceID, "err", err)
+				continue
+			}
+			if ack {
+				// Waiting for the writes off the goroutine reading conn
+				go deliver(hub, conn, traceID, msg.ID, payload)
+				continue
+			}
+			hub.Broadcast(traceID, 0x1, payload)
+		}
+	}
+}
+
+// deliver relays payload, the message id, to the connections of hub and
+// sends conn the Receipt.
+func deliver(hub *websocket.Hub, conn *websocket.Conn, traceID, id string, payload []byte) {
+	ctx, cancel := context.WithTimeout(conn.Context(), ackTimeout)
+	defer cancel()
+	d := hub.Deliver(ctx, traceID, 0x1, payload, nil)
+	conn.Logger().Info("Message delivered", "trace_id", traceID, "id", id, "delivered", d.Delivered, "failed", d.Failed, "timed_out", d.Pending)
+
+	receipt, err := json.Marshal(Receipt{Type: "ack", ID: id, Delivered: d.Delivered, Failed: d.Failed, TimedOut: d.Pending})
+	if err != nil {
+		return
+	}
+	// Queued behind the copy of the message the sender gets itself
+	if err := conn.Enqueue(0x1, receipt); err != nil {
+		conn.Logger().Debug("Dropped receipt", "trace_id", traceID, "err", err)
+	}
+}
+
+// readMsg reads the next Msg from conn, logging and skipping messages that
+// are not valid JSON.
+func readMsg(conn *websocket.Conn) (Msg, error) {
+	for {
+		var msg Msg
+		err := conn.ReadJSON(&msg)
+		if err == nil {
+			return msg, nil
+		}
+		if !isJSONError(err) {
+			return Msg{}, err
+		}
+		conn.Logger().Warn("Error parsing JSON", "err", err)
+	}
+}
+
+// decodeMsg decodes a message read by ReadLoop, logging it when it is not a
+// valid Msg.
+func decodeMsg(conn *websocket.Conn, data []byte) (Msg, bool) {
+	var msg Msg
+	if err := json.Unmarshal(data, &msg); err != nil {
+		conn.Logger().Warn("Error parsing JSON", "err", err)
+		return Msg{}, false
+	}
+	return msg, true
+}
+
+func isJSONError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
+
+// traceMsg returns the trace ID of an inbound message: the one the client
+// put in the envelope, or a fresh one from the connection's generator, set
+// in the envelope, when it sent none.
+func traceMsg(conn *websocket.Conn, msg *Msg) string {
+	if msg.TraceID == "" {
+		msg.TraceID = conn.NewID()
+	}
+	return msg.TraceID
+}
+
+func logDisconnect(conn *websocket.Conn, err error) {
+	if errors.Is(err, io.EOF) {
+		conn.Logger().Info("Client disconnected")
+	} else {
+		conn.Logger().Warn("Error reading WebSocket message", "err", err)
+	}
+}
@@ -0,0 +1,40 @@
+package chat_test
+
+import (
+	"testing"
+
+	"websocket"
+	"websocket/chat"
+	"websocket/wstest"
+)
+
+// traced sends a message without trace ID through handler and returns the
+// first message the client gets back.
+func traced(t *testing.T, handler websocket.Handler) chat.Msg {
+	t.Helper()
+	client, err := wstest.Pipe(handler)
+	if err != nil {
+		t.Fatal("handshake:", err)
+	}
+	defer client.Close()
+	if err := client.WriteJSON(chat.Msg{Role: "user", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	var reply chat.Msg
+	if err := client.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestAckHandlerAssignsTraceID(t *testing.T) {
+	if reply := traced(t, chat.AckHandler); reply.TraceID == "" {
+		t.Errorf("reply %+v without trace ID", reply)
+	}
+}
+
+func TestRelayHandlerAssignsTraceID(t *testing.T) {
+	if relayed := traced(t, chat.RelayHandler(websocket.NewHub())); relayed.TraceID == "" || relayed.Content != "hi" {
+		t.Errorf("relayed %+v, want hi with a trace ID", relayed)
+	}
+}
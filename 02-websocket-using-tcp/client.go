@@ -0,0 +1,714 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"netsim/throttle"
+)
+
+// defaultReassemblyTimeout bounds how long a fragmented message may take to
+// arrive completely before the connection is failed.
+const defaultReassemblyTimeout = 10 * time.Second
+
+// Client is the client side of a WebSocket connection.
+type Client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mode   Mode
+
+	// writeMu is held while a frame is written, messageMu while the frames
+	// of a data message are, see Conn.
+	writeMu   sync.Mutex
+	messageMu sync.Mutex
+
+	closeSent atomic.Bool
+	wire      wireTrace
+
+	// reads counts the goroutines blocked reading a frame and peer records
+	// the server's close frame, for CloseWith.
+	reads atomic.Int32
+	peer  peerClose
+
+	subprotocol  string
+	sessionToken string
+	log          *slog.Logger
+
+	// ReassemblyTimeout is how long ReadFullMessage waits for the remaining
+	// fragments of a message once the first one arrived. Incomplete messages
+	// are dropped and the connection is closed with 1002. Zero means
+	// defaultReassemblyTimeout.
+	ReassemblyTimeout time.Duration
+
+	// Chaos injects faults into outgoing frames, see Chaos.
+	Chaos Chaos
+
+	// MaxMessageSize is the largest message ReadFullMessage, ReadJSON and
+	// Receive accept. Zero means defaultMaxMessageSize.
+	MaxMessageSize int64
+
+	// MaxFrameSize is the largest frame payload accepted from the peer,
+	// checked before the payload is allocated. Longer frames fail the
+	// connection with 1009. Zero means defaultMaxFrameSize.
+	MaxFrameSize uint64
+
+	// FragmentSize is the largest payload written in a single frame, longer
+	// messages are split into continuation frames. Zero means
+	// defaultFragmentSize, 65535 bytes.
+	FragmentSize int
+
+	// Codec encodes the values passed to Send and Receive, JSONCodec when nil.
+	Codec Codec
+
+	// Hooks observe the frames and errors of the connection, see Hooks.
+	Hooks Hooks
+
+	// OnPong is called with the payload of every pong received while
+	// reading, for example the answer to Ping.
+	OnPong func(payload []byte)
+
+	outbound []Middleware
+	inbound  []Middleware
+}
+
+// Chaos describes faults a Client injects into the frames it writes, so that
+// the peer's handling of slow or broken senders can be exercised.
+type Chaos struct {
+	// ContinuationDelay is slept before every continuation frame is written.
+	ContinuationDelay time.Duration
+}
+
+// Dialer opens client connections.
+type Dialer struct {
+	// Mode selects how strictly the server is held to RFC 6455, see Mode.
+	Mode Mode
+
+	// TLSConfig configures the TLS connection of wss:// URLs. When nil the
+	// default configuration is used, with the server name taken from the URL.
+	TLSConfig *tls.Config
+
+	// HandshakeTimeout bounds the dial and the opening handshake, no limit
+	// when zero besides the context of DialContext.
+	HandshakeTimeout time.Duration
+
+	// Subprotocols are offered to the server in order of preference, see
+	// Client.Subprotocol for the one it selected.
+	Subprotocols []string
+
+	// SessionToken is sent to resume the session of a previous connection,
+	// see Client.SessionToken.
+	SessionToken string
+
+	// MaxMessageSize, MaxFrameSize, FragmentSize and ReassemblyTimeout are
+	// copied to the Client, see there.
+	MaxMessageSize    int64
+	MaxFrameSize      uint64
+	FragmentSize      int
+	ReassemblyTimeout time.Duration
+
+	// Logger receives the client's wire trace, slog.Default() when nil.
+	Logger *slog.Logger
+
+	// TCP tunes the socket of the connection, see TCPOptions.
+	TCP TCPOptions
+
+	// Bandwidth limits how fast the connection is read from and written
+	// to, each direction on its own, see throttle.Conn. Unlimited by
+	// default. It applies to the connections of Dial, not to Handshake.
+	Bandwidth throttle.Limit
+
+	// UnixSocket, when set, is the path of a unix domain socket dialed
+	// instead of the host of the URL, which still names the Host header and
+	// the TLS server, like curl --unix-socket.
+	UnixSocket string
+
+	// Proxy, when set, returns the proxy to connect to the server of a URL
+	// through, nil for a direct connection, see ProxyFromEnvironment and
+	// ProxyURL. It does not apply to UnixSocket.
+	Proxy func(*url.URL) (*url.URL, error)
+
+	// HTTP2 opens the WebSocket over HTTP/2 with the extended CONNECT of RFC
+	// 8441, see Server.HTTP2. wss:// URLs offer h2 with ALPN and fall back to
+	// HTTP/1.1 when the server does not pick it, ws:// URLs speak HTTP/2 with
+	// prior knowledge, to servers known to accept it.
+	HTTP2 bool
+}
+
+// Dial connects to rawURL with a Dialer configured by opts, see Dialer.Dial.
+// Without options the Dialer is in Strict mode with the default limits and
+// goes through the proxy of the environment, see ProxyFromEnvironment.
+func Dial(rawURL string, opts ...ClientOption) (*Client, error) {
+	return DialContext(context.Background(), rawURL, opts...)
+}
+
+// DialContext is Dial giving up when ctx is done.
+func DialContext(ctx context.Context, rawURL string, opts ...ClientOption) (*Client, error) {
+	dialer := &Dialer{Proxy: ProxyFromEnvironment}
+	for _, opt := range opts {
+		opt(dialer)
+	}
+	return dialer.DialContext(ctx, rawURL)
+}
+
+// Dial opens a TCP connection to the server at rawURL (ws://host:port/path,
+// or wss:// over TLS), or to d.UnixSocket, possibly through d.Proxy, and
+// performs the WebSocket opening handshake on it.
+func (d *Dialer) Dial(rawURL string) (*Client, error) {
+	return d.DialContext(context.Background(), rawURL)
+}
+
+// DialContext is Dial bounded by ctx: canceling it, or its deadline passing,
+// aborts the connection, the TLS handshake or the opening handshake still in
+// progress. ctx is not used once the client is returned.
+func (d *Dialer) DialContext(ctx context.Context, rawURL string) (*Client, error) {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	conn, err := d.dial(ctx, u, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn = throttle.Conn(conn, throttle.NewLimiter(d.Bandwidth), throttle.NewLimiter(d.Bandwidth))
+	handshake := d.handshake
+	if d.HTTP2 && u.Scheme == "ws" {
+		handshake = d.handshakeHTTP2
+	}
+	if u.Scheme == "wss" {
+		config := d.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName = u.Hostname()
+		}
+		if d.HTTP2 && len(config.NextProtos) == 0 {
+			config = config.Clone()
+			config.NextProtos = []string{"h2", "http/1.1"}
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+		if d.HTTP2 && tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+			handshake = d.handshakeHTTP2
+		}
+	}
+	if err := d.TCP.apply(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	release := bindContext(ctx, conn.SetDeadline)
+	client, err := handshake(conn, u)
+	release()
+	if err != nil {
+		conn.Close()
+		return nil, contextError(ctx, err)
+	}
+	return client, nil
+}
+
+// dial opens the connection to addr, the server of u: over d.UnixSocket,
+// through the proxy d.Proxy picks or directly.
+func (d *Dialer) dial(ctx context.Context, u *url.URL, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	if d.UnixSocket != "" {
+		return dialer.DialContext(ctx, "unix", d.UnixSocket)
+	}
+	if d.Proxy != nil {
+		proxy, err := d.Proxy(u)
+		if err != nil {
+			return nil, err
+		}
+		if proxy != nil {
+			return dialProxy(ctx, proxy, addr)
+		}
+	}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// Handshake performs the WebSocket opening handshake for rawURL over an
+// already established connection, such as one end of a net.Pipe.
+func (d *Dialer) Handshake(conn net.Conn, rawURL string) (*Client, error) {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return d.handshake(conn, u)
+}
+
+/**
+ * * handshake sends the opening handshake and validates the server's answer.
+ *
+ * * The client sends a random 16 byte base64 encoded Sec-WebSocket-Key, the server must
+ * * answer with 101 Switching Protocols and a Sec-WebSocket-Accept header derived from that key.
+ */
+func (d *Dialer) handshake(conn net.Conn, u *url.URL) (*Client, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	request := fmt.Sprintf(
+		"GET %s HTTP/1.1\r\n"+
+			"Host: %s\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Sec-WebSocket-Key: %s\r\n"+
+			"Sec-WebSocket-Version: 13\r\n",
+		u.RequestURI(), u.Host, key,
+	)
+	if len(d.Subprotocols) > 0 {
+		request += "Sec-WebSocket-Protocol: " + strings.Join(d.Subprotocols, ", ") + "\r\n"
+	}
+	if d.SessionToken != "" {
+		request += SessionHeader + ": " + d.SessionToken + "\r\n"
+	}
+	request += "\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil, err
+	}
+
+	// Frames are read through the same buffered reader, it may already hold
+	// the first bytes sent after the response.
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: unexpected status %s", ErrBadHandshake, response.Status)
+	}
+	if response.Header.Get("Sec-WebSocket-Accept") != generateWebSocketAcceptKey(key) {
+		return nil, fmt.Errorf("%w: invalid Sec-WebSocket-Accept header", ErrBadHandshake)
+	}
+	if err := checkHandshakeResponse(response, d.Mode); err != nil {
+		return nil, err
+	}
+	subprotocol := response.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !slices.Contains(d.Subprotocols, subprotocol) {
+		return nil, fmt.Errorf("%w: server selected subprotocol %q, which was not offered", ErrBadHandshake, subprotocol)
+	}
+
+	return d.newClient(conn, reader, response), nil
+}
+
+// newClient returns the Client of a connection whose handshake completed
+// with response, reading its frames through reader.
+func (d *Dialer) newClient(conn net.Conn, reader *bufio.Reader, response *http.Response) *Client {
+	return &Client{
+		conn:              conn,
+		reader:            reader,
+		mode:              d.Mode,
+		subprotocol:       response.Header.Get("Sec-WebSocket-Protocol"),
+		sessionToken:      response.Header.Get(SessionHeader),
+		log:               d.Logger,
+		MaxMessageSize:    d.MaxMessageSize,
+		MaxFrameSize:      d.MaxFrameSize,
+		FragmentSize:      d.FragmentSize,
+		ReassemblyTimeout: d.ReassemblyTimeout,
+	}
+}
+
+// Subprotocol returns the subprotocol the server selected among
+// Dialer.Subprotocols, or "" when it selected none.
+func (c *Client) Subprotocol() string {
+	return c.subprotocol
+}
+
+// SessionToken returns the token of the session the server gave the
+// connection, "" when its sessions are not sticky. Dialing again with it in
+// Dialer.SessionToken resumes the session, see Server.SessionKey.
+func (c *Client) SessionToken() string {
+	return c.sessionToken
+}
+
+func (c *Client) logger() *slog.Logger {
+	if c.log != nil {
+		return c.log
+	}
+	return slog.Default()
+}
+
+func parseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	return u, nil
+}
+
+// SendTextMessage sends message as a text frame, fragmenting it into
+// continuation frames when it is longer than FragmentSize.
+func (c *Client) SendTextMessage(message string) error {
+	return c.WriteMessage(0x1, []byte(message)) // Text frame
+}
+
+// NextWriter returns a writer for the next message. Data written to it is
+// sent as masked frames of the given opcode (0x1 text, 0x2 binary) of at most
+// FragmentSize bytes, and the message is finished by closing the writer.
+// Only one message is written at a time: NextWriter and WriteMessage wait
+// until the previous writer has been closed, which makes them safe to use
+// from several goroutines. Pings, pongs and close frames go out between
+// fragments. Other opcodes are refused.
+func (c *Client) NextWriter(opcode byte) (io.WriteCloser, error) {
+	if err := checkDataOpcode(opcode); err != nil {
+		return nil, err
+	}
+	c.messageMu.Lock()
+	return newMessageWriter(opcode, c.FragmentSize, c.writeDataFrame, c.messageMu.Unlock), nil
+}
+
+// WriteMessage sends data as a single message of the given opcode, after
+// passing it through the outbound middleware.
+func (c *Client) WriteMessage(opcode byte, data []byte) error {
+	return chain(c.outbound, c.writeMessage)(opcode, data)
+}
+
+// WriteMessageContext is WriteMessage giving up when ctx is done, see
+// bindContext.
+func (c *Client) WriteMessageContext(ctx context.Context, opcode byte, data []byte) error {
+	defer bindContext(ctx, c.conn.SetWriteDeadline)()
+	return contextError(ctx, c.WriteMessage(opcode, data))
+}
+
+func (c *Client) writeMessage(opcode byte, data []byte) error {
+	c.messageMu.Lock()
+	defer c.messageMu.Unlock()
+	return writeFragmented(c.writeDataFrame, opcode, data, c.FragmentSize)
+}
+
+// UseOutbound appends middleware run on every message sent with
+// WriteMessage, SendTextMessage, WriteJSON or Send. Messages streamed
+// through NextWriter bypass it.
+func (c *Client) UseOutbound(middleware ...Middleware) {
+	c.outbound = append(c.outbound, middleware...)
+}
+
+// UseInbound appends middleware run on every message returned by
+// ReadFullMessage, ReadJSON or Receive. Messages streamed through NextReader
+// bypass it.
+func (c *Client) UseInbound(middleware ...Middleware) {
+	c.inbound = append(c.inbound, middleware...)
+}
+
+// writeControl writes a control frame, between two frames of the message
+// being written if there is one.
+func (c *Client) writeControl(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrame(true, opcode, payload)
+}
+
+// writeDataFrame writes a frame of a data message, with messageMu held.
+func (c *Client) writeDataFrame(fin bool, opcode byte, payload []byte) error {
+	if opcode == 0x0 && c.Chaos.ContinuationDelay > 0 {
+		time.Sleep(c.Chaos.ContinuationDelay)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent.Load() {
+		return ErrCloseSent
+	}
+	return c.writeFrame(fin, opcode, payload)
+}
+
+// writeFrame writes a single frame, masked as frames from a client must be.
+func (c *Client) writeFrame(fin bool, opcode byte, payload []byte) error {
+	if err := c.wire.writeFrame(c.conn, fin, opcode, payload, true, c.logger()); err != nil {
+		c.Hooks.failed(err)
+		return err
+	}
+	c.Hooks.frameWritten(fin, opcode, payload, true)
+	return nil
+}
+
+/**
+ * * NextReader returns the opcode of the next message and a reader for its payload.
+ *
+ * * Continuation frames are pulled from the connection as the reader is drained. Pings received
+ * * in between are answered with a pong, a close frame from the server is reported as a CloseError.
+ * * The reader must be drained before NextReader is called again.
+ */
+func (c *Client) NextReader() (byte, io.Reader, error) {
+	r, err := c.nextMessage()
+	if err != nil {
+		return 0, nil, err
+	}
+	return r.opcode, r, nil
+}
+
+// ReadFullMessage reads frames until a complete message has arrived and
+// returns its opcode together with the reassembled payload, as left by the
+// inbound middleware. A message whose fragments do not all arrive within
+// ReassemblyTimeout fails the connection.
+func (c *Client) ReadFullMessage() (byte, []byte, error) {
+	return c.ReadFullMessageContext(context.Background())
+}
+
+// ReadFullMessageContext is ReadFullMessage giving up when ctx is done, see
+// bindContext. The deadline of ctx takes over ReassemblyTimeout when earlier.
+func (c *Client) ReadFullMessageContext(ctx context.Context) (byte, []byte, error) {
+	defer bindContext(ctx, c.conn.SetReadDeadline)()
+	for {
+		opcode, payload, err := c.readMessage(ctx)
+		err = contextError(ctx, err)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		delivered := false
+		err = chain(c.inbound, func(op byte, data []byte) error {
+			opcode, payload, delivered = op, data, true
+			return nil
+		})(opcode, payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		if delivered {
+			return opcode, payload, nil
+		}
+	}
+}
+
+// readMessage reads the next complete message off the connection, within
+// the deadline of ctx if it has one.
+func (c *Client) readMessage(ctx context.Context) (byte, []byte, error) {
+	r, err := c.nextMessage()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	timeout := c.ReassemblyTimeout
+	if timeout == 0 {
+		timeout = defaultReassemblyTimeout
+	}
+
+	// The clock for the whole message starts at its first fragment.
+	if !r.fin {
+		ctxDeadline, _ := ctx.Deadline()
+		if deadline := time.Now().Add(timeout); ctxDeadline.IsZero() || deadline.Before(ctxDeadline) {
+			c.conn.SetReadDeadline(deadline)
+			defer c.conn.SetReadDeadline(ctxDeadline)
+			// Canceled while the deadline was moved: interrupt the read anyway.
+			if ctx.Err() != nil {
+				c.conn.SetReadDeadline(time.Unix(1, 0))
+			}
+		}
+	}
+
+	payload, err := readLimited(r, c.MaxMessageSize, c.fail)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) && contextError(ctx, err) == err {
+			c.writeClose(closeProtocolError)
+			c.conn.Close()
+			return 0, nil, &ErrProtocolError{Code: closeProtocolError, Reason: fmt.Sprintf("fragmented message not completed within %s", timeout)}
+		}
+		return 0, nil, err
+	}
+	return r.opcode, payload, nil
+}
+
+// ReadJSON reads the next message and decodes it as JSON into v.
+func (c *Client) ReadJSON(v any) error {
+	_, data, err := c.ReadFullMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteJSON sends v encoded as JSON in a text message.
+func (c *Client) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(0x1, data)
+}
+
+// SendContext is Send giving up when ctx is done, see bindContext.
+func (c *Client) SendContext(ctx context.Context, v any) error {
+	defer bindContext(ctx, c.conn.SetWriteDeadline)()
+	return contextError(ctx, c.Send(v))
+}
+
+// Send encodes v with the connection's codec and sends it as one message.
+func (c *Client) Send(v any) error {
+	codec := c.codec()
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(messageOpcode(codec), data)
+}
+
+// Receive reads the next message and decodes it into v with the connection's codec.
+func (c *Client) Receive(v any) error {
+	_, data, err := c.ReadFullMessage()
+	if err != nil {
+		return err
+	}
+	return c.codec().Unmarshal(data, v)
+}
+
+// ReceiveContext is Receive giving up when ctx is done, see bindContext.
+func (c *Client) ReceiveContext(ctx context.Context, v any) error {
+	_, data, err := c.ReadFullMessageContext(ctx)
+	if err != nil {
+		return err
+	}
+	return c.codec().Unmarshal(data, v)
+}
+
+func (c *Client) codec() Codec {
+	if c.Codec == nil {
+		return JSONCodec{}
+	}
+	return c.Codec
+}
+
+// nextMessage waits for the first frame of the next message.
+func (c *Client) nextMessage() (*messageReader, error) {
+	frame, err := c.nextDataFrame()
+	if err != nil {
+		return nil, err
+	}
+	return newMessageReader(frame, c.nextDataFrame, c.fail, c.mode)
+}
+
+// nextDataFrame reads frames until a data frame arrives, answering pings on
+// the way. A close frame from the server is answered and reported as a
+// CloseError.
+func (c *Client) nextDataFrame() (*Frame, error) {
+	for {
+		c.reads.Add(1)
+		frame, err := c.wire.readFrame(c.reader, frameLimit(c.MaxFrameSize), c.logger())
+		c.reads.Add(-1)
+		if err != nil {
+			return nil, c.fail(err)
+		}
+		c.Hooks.frameRead(frame)
+		if err := checkFrame(frame, false, c.mode); err != nil {
+			return nil, c.fail(err)
+		}
+
+		switch frame.OpcodeName() {
+		case "close":
+			if err := checkClose(frame.Payload); err != nil {
+				if c.mode == Strict {
+					return nil, c.fail(err)
+				}
+				frame.Payload = nil
+			}
+			// No answer when the close frame answers ours.
+			if !c.closeSent.Swap(true) {
+				c.writeControl(0x8, closeReply(frame.Payload))
+			}
+			err := closeError(frame.Payload)
+			c.peer.received(err)
+			return nil, err
+		case "ping":
+			if err := c.writeControl(0xA, frame.Payload); err != nil {
+				return nil, err
+			}
+		case "pong":
+			if c.OnPong != nil {
+				c.OnPong(frame.Payload)
+			}
+		default:
+			return frame, nil
+		}
+	}
+}
+
+// fail sends the close frame matching a protocol error and returns err.
+func (c *Client) fail(err error) error {
+	c.Hooks.failed(err)
+	var protoErr *ErrProtocolError
+	if errors.As(err, &protoErr) {
+		c.writeClose(protoErr.Code)
+	}
+	return err
+}
+
+// writeClose writes a close frame carrying the given status code, unless one
+// was sent already.
+func (c *Client) writeClose(code uint16) error {
+	if c.closeSent.Swap(true) {
+		return nil
+	}
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	return c.writeControl(0x8, payload)
+}
+
+// Ping sends a ping frame carrying payload, at most 125 bytes. The server's
+// pong is passed to OnPong by the goroutine reading messages.
+func (c *Client) Ping(payload []byte) error {
+	if len(payload) > 125 {
+		return errors.New("ping payload longer than 125 bytes")
+	}
+	return c.writeControl(0x9, payload)
+}
+
+// WriteClose starts the closing handshake with code and reason without
+// closing the connection: reads return a CloseError once the server answered,
+// then Close releases the connection.
+func (c *Client) WriteClose(code uint16, reason string) error {
+	payload, err := closePayload(code, reason)
+	if err != nil {
+		return err
+	}
+	if c.closeSent.Swap(true) {
+		return ErrCloseSent
+	}
+	return c.writeControl(0x8, payload)
+}
+
+// Close sends a close frame and closes the underlying TCP connection without
+// waiting for the server's answer, see CloseWith.
+func (c *Client) Close() error {
+	c.writeClose(closeNormal)
+	return c.conn.Close()
+}
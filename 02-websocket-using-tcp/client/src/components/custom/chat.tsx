@@ -80,7 +80,9 @@ export function CardsChat() {
 
   React.useEffect(() => {
     const connectWebSocket = () => {
-      ws.current = new WebSocket("ws://localhost:4443");
+      ws.current = new WebSocket(
+        process.env.NEXT_PUBLIC_WS_URL ?? "ws://localhost:4443"
+      );
 
       ws.current.onopen = () => {
         setSocketStatus("connected");
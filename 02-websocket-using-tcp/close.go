@@ -0,0 +1,159 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// closeTimeout is how long Close waits for the peer to answer its close frame.
+const closeTimeout = 5 * time.Second
+
+// ErrCloseTimeout is returned by Close when the peer did not answer the close
+// frame within closeTimeout. The connection is closed all the same.
+var ErrCloseTimeout = errors.New("websocket: peer did not answer the close frame")
+
+// closePayload returns the payload of a close frame carrying code and reason,
+// which RFC 6455 limits to 123 bytes of UTF-8 so the frame fits in 125.
+func closePayload(code uint16, reason string) ([]byte, error) {
+	if !validCloseCode(code) {
+		return nil, fmt.Errorf("close code %d cannot be sent", code)
+	}
+	if len(reason) > 123 {
+		return nil, errors.New("close reason longer than 123 bytes")
+	}
+	if !utf8.ValidString(reason) {
+		return nil, errors.New("close reason is not valid UTF-8")
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	payload[0], payload[1] = byte(code>>8), byte(code)
+	return append(payload, reason...), nil
+}
+
+// peerClose records the close frame the peer sent, so that Close can wait
+// for it while another goroutine is the one reading.
+type peerClose struct {
+	mu   sync.Mutex
+	done chan struct{}
+	err  *CloseError
+}
+
+func (p *peerClose) wait() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.channel()
+}
+
+func (p *peerClose) received(err *CloseError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+		close(p.channel())
+	}
+}
+
+// channel returns done, creating it first, with mu held.
+func (p *peerClose) channel() chan struct{} {
+	if p.done == nil {
+		p.done = make(chan struct{})
+	}
+	return p.done
+}
+
+/**
+ * * awaitClose waits for the peer to answer a close frame, within closeTimeout. When a goroutine is
+ * * blocked reading the connection, reads counting them, that reader sees the answer and returns
+ * * the CloseError. Otherwise awaitClose reads itself through next, discarding the messages that
+ * * were on their way, until next returns the CloseError.
+ *
+ * * Either way the read deadline is moved to closeTimeout from now, so a reader waiting for a peer
+ * * that never answers gives up as well.
+ */
+func awaitClose(conn net.Conn, reads *atomic.Int32, peer *peerClose, next func() (*Frame, error)) error {
+	deadline := time.Now().Add(closeTimeout)
+	conn.SetReadDeadline(deadline)
+	if reads.Load() > 0 {
+		timer := time.NewTimer(closeTimeout)
+		defer timer.Stop()
+		select {
+		case <-peer.wait():
+			return nil
+		case <-timer.C:
+			return ErrCloseTimeout
+		}
+	}
+
+	for {
+		select {
+		case <-peer.wait():
+			return nil
+		default:
+		}
+		_, err := next()
+		var closeErr *CloseError
+		switch {
+		case err == nil:
+		case errors.As(err, &closeErr):
+			return nil
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			return ErrCloseTimeout
+		default:
+			return err
+		}
+	}
+}
+
+/**
+ * * Close closes the connection with code and a reason of at most 123 bytes of UTF-8: it sends the
+ * * close frame, waits up to closeTimeout for the client's answer and closes the TCP connection.
+ * * It returns nil once the client answered, ErrCloseTimeout when it did not. Reads of other
+ * * goroutines return the client's CloseError, and messages arriving in the meantime are dropped.
+ *
+ * * When the client closed the connection first, Close only closes the TCP connection. In reactor
+ * * mode Close returns once the frame is sent, the poller reads the answer and releases the
+ * * connection, after idleCloseGrace at the latest.
+ */
+func (c *Conn) Close(code uint16, reason string) error {
+	payload, err := closePayload(code, reason)
+	if err != nil {
+		return err
+	}
+	c.closing()
+	if !c.closeSent.Swap(true) {
+		if err := c.writeControl(0x8, payload); err != nil {
+			c.conn.Close()
+			return err
+		}
+	}
+	if c.polled.Load() {
+		return nil
+	}
+	err = awaitClose(c.conn, &c.reads, &c.peer, c.nextDataFrame)
+	c.conn.Close()
+	return err
+}
+
+// CloseWith is Conn.Close for the client: it sends a close frame with code
+// and reason, waits up to closeTimeout for the server's answer and closes the
+// connection. Close is CloseWith(1000, "") without the wait, for defer.
+func (c *Client) CloseWith(code uint16, reason string) error {
+	payload, err := closePayload(code, reason)
+	if err != nil {
+		return err
+	}
+	if !c.closeSent.Swap(true) {
+		if err := c.writeControl(0x8, payload); err != nil {
+			c.conn.Close()
+			return err
+		}
+	}
+	err = awaitClose(c.conn, &c.reads, &c.peer, c.nextDataFrame)
+	c.conn.Close()
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// suiteTimeout bounds a run of the whole fuzzing client, performance cases
+// included.
+const suiteTimeout = 30 * time.Minute
+
+// caseResult is the verdict of one case in reports/server/index.json.
+type caseResult struct {
+	Behavior      string `json:"behavior"`
+	BehaviorClose string `json:"behaviorClose"`
+	ReportFile    string `json:"reportfile"`
+}
+
+// passed reports whether verdict is a pass. INFORMATIONAL is the verdict of
+// the cases that only record what the server did, they cannot fail.
+func passed(verdict string) bool {
+	return verdict == "OK" || verdict == "INFORMATIONAL"
+}
+
+// TestFuzzingClient runs the fuzzing client of the suite, in docker, against
+// the echo server and fails on every case not passed. The report stays in
+// ./reports/server for a look at the failures.
+func TestFuzzingClient(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the Autobahn TestSuite takes minutes")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not installed")
+	}
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skip("docker is not running")
+	}
+
+	// fuzzingclient.json names this address
+	listener, err := net.Listen("tcp", "127.0.0.1:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serve(listener)
+
+	config, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, err := filepath.Abs("../../reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(reports, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), suiteTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "docker", "run", "--rm", "--net=host",
+		"-v", config+":/config", "-v", reports+":/reports",
+		"crossbario/autobahn-testsuite", "wstest", "-m", "fuzzingclient", "-s", "/config/fuzzingclient.json")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("running the fuzzing client: %v\n%s", err, output)
+	}
+
+	data, err := os.ReadFile(filepath.Join(reports, "server", "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index map[string]map[string]caseResult
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	results, ok := index[agent]
+	if !ok || len(results) == 0 {
+		t.Fatalf("no results for agent %s in the report", agent)
+	}
+	cases := make([]string, 0, len(results))
+	for id := range results {
+		cases = append(cases, id)
+	}
+	sort.Strings(cases)
+	for _, id := range cases {
+		r := results[id]
+		if !passed(r.Behavior) || !passed(r.BehaviorClose) {
+			t.Errorf("case %s: behavior %s, close %s, see reports/server/%s", id, r.Behavior, r.BehaviorClose, r.ReportFile)
+		}
+	}
+	t.Logf("%d cases run", len(cases))
+}
@@ -0,0 +1,140 @@
+// Command autobahn runs this package against the Autobahn TestSuite
+// (https://github.com/crossbario/autobahn-testsuite).
+//
+// Server mode runs an echo server for the suite's fuzzing client:
+//
+//	go run ./cmd/autobahn -mode server
+//	docker run -it --rm --net=host -v "$PWD/cmd/autobahn:/config" -v "$PWD/reports:/reports" \
+//		crossbario/autobahn-testsuite wstest -m fuzzingclient -s /config/fuzzingclient.json
+//
+// Client mode runs every case of the suite's fuzzing server through an echo client:
+//
+//	docker run -it --rm --net=host -v "$PWD/cmd/autobahn:/config" -v "$PWD/reports:/reports" \
+//		crossbario/autobahn-testsuite wstest -m fuzzingserver -s /config/fuzzingserver.json
+//	go run ./cmd/autobahn -mode client
+//
+// Reports end up in ./reports. go test ./cmd/autobahn runs the fuzzing
+// client against the server and fails on any case whose verdict is not OK,
+// it is skipped without docker or with -short.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"strconv"
+
+	"websocket"
+)
+
+// maxMessageSize covers the largest messages of the 9.x performance cases.
+const maxMessageSize = 64 << 20
+
+const agent = "socket-101"
+
+var (
+	mode    = flag.String("mode", "server", "server or client")
+	addr    = flag.String("addr", ":9001", "listen address in server mode")
+	server  = flag.String("server", "ws://localhost:9001", "fuzzing server URL in client mode")
+	verbose = flag.Bool("v", false, "log every connection and frame")
+)
+
+func main() {
+	flag.Parse()
+	// The cases fail connections on purpose, their logs would drown the
+	// progress
+	if *verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	} else {
+		slog.SetLogLoggerLevel(slog.LevelError + 1)
+	}
+
+	switch *mode {
+	case "server":
+		runServer()
+	case "client":
+		runClient()
+	default:
+		log.Fatalln("Unknown mode:", *mode)
+	}
+}
+
+func runServer() {
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalln("Error starting server:", err)
+	}
+	fmt.Println("Autobahn echo server listening on", *addr)
+	if err := serve(listener); err != nil {
+		log.Fatalln("Error serving:", err)
+	}
+}
+
+// serve runs the echo server the fuzzing client tests on listener.
+func serve(listener net.Listener) error {
+	return websocket.Serve(listener, func(conn *websocket.Conn) {
+		conn.MaxMessageSize = maxMessageSize
+		conn.MaxFrameSize = maxMessageSize
+		websocket.EchoHandler(conn)
+	})
+}
+
+func runClient() {
+	count, err := caseCount()
+	if err != nil {
+		log.Fatalln("Error getting case count:", err)
+	}
+
+	for i := 1; i <= count; i++ {
+		fmt.Printf("Running case %d of %d\n", i, count)
+		runCase(i)
+	}
+
+	client, err := websocket.Dial(*server + "/updateReports?agent=" + agent)
+	if err != nil {
+		log.Fatalln("Error updating reports:", err)
+	}
+	client.Close()
+}
+
+func caseCount() (int, error) {
+	client, err := websocket.Dial(*server + "/getCaseCount")
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	_, payload, err := client.ReadFullMessage()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(payload))
+}
+
+// runCase echoes every message of one test case until the server closes.
+func runCase(n int) {
+	client, err := websocket.Dial(fmt.Sprintf("%s/runCase?case=%d&agent=%s", *server, n, agent))
+	if err != nil {
+		log.Printf("Error running case %d: %v", n, err)
+		return
+	}
+	defer client.Close()
+	client.MaxFrameSize = maxMessageSize
+
+	for {
+		opcode, r, err := client.NextReader()
+		if err != nil {
+			return
+		}
+		message, err := io.ReadAll(io.LimitReader(r, maxMessageSize))
+		if err != nil {
+			return
+		}
+		if err := client.WriteMessage(opcode, message); err != nil {
+			return
+		}
+	}
+}
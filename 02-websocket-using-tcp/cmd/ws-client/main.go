@@ -0,0 +1,122 @@
+// Command ws-client sends one message to a WebSocket server and logs what
+// comes back until the server closes the connection. With -upload or
+// -download it transfers a file instead, see package files.
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+
+	"websocket"
+	"websocket/files"
+)
+
+// defaultMessage is sent when no -message file is given.
+//
+//go:embed message.txt
+var defaultMessage []byte
+
+func main() {
+	url := flag.String("url", env("WS_URL", "ws://localhost:4443"), "server to connect to (env WS_URL)")
+	message := flag.String("message", env("WS_MESSAGE_FILE", ""), "file sent as the message, message.txt built into the binary when empty (env WS_MESSAGE_FILE)")
+	unix := flag.String("unix", env("WS_UNIX_SOCKET", ""), "unix domain socket to connect to instead of the host of -url (env WS_UNIX_SOCKET)")
+	upload := flag.String("upload", "", "file uploaded to a server started with -upload-dir, instead of sending a message")
+	download := flag.String("download", "", "name of the file downloaded from a server started with -upload-dir into -dir, instead of sending a message")
+	dir := flag.String("dir", ".", "directory -download stores the file in")
+	flag.Parse()
+
+	if *upload != "" || *download != "" {
+		os.Exit(transfer(*url, *unix, *upload, *download, *dir))
+	}
+
+	client, err := websocket.Dial(*url, websocket.DialUnix(*unix))
+	if err != nil {
+		slog.Error("Error connecting to WebSocket server", "err", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	slog.Info("Connected to WebSocket server")
+
+	var r io.Reader = bytes.NewReader(defaultMessage)
+	if *message != "" {
+		// Stream the file so that large messages are never held in memory as a whole.
+		file, err := os.Open(*message)
+		if err != nil {
+			slog.Error("Error reading message file", "err", err)
+			return
+		}
+		defer file.Close()
+		r = file
+	}
+
+	w, err := client.NextWriter(0x1)
+	if err != nil {
+		slog.Error("Error sending message", "err", err)
+		return
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		slog.Error("Error sending message", "err", err)
+		return
+	}
+	if err := w.Close(); err != nil {
+		slog.Error("Error sending message", "err", err)
+		return
+	}
+
+	for {
+		_, payload, err := client.ReadFullMessage()
+		if err != nil {
+			slog.Error("Error reading message", "err", err)
+			return
+		}
+		slog.Info("Received message", "payload", string(payload))
+	}
+}
+
+// transfer uploads the file at upload or downloads the file called download
+// into dir, and returns the exit status.
+func transfer(url, unix, upload, download, dir string) int {
+	client, err := websocket.Dial(url, websocket.DialUnix(unix), websocket.DialSubprotocols(files.Subprotocol))
+	if err != nil {
+		slog.Error("Error connecting to WebSocket server", "err", err)
+		return 1
+	}
+	defer client.Close()
+	if client.Subprotocol() != files.Subprotocol {
+		slog.Error("The server does not transfer files, start it with -upload-dir")
+		return 1
+	}
+
+	progress := func(done, total int64) {
+		slog.Info("Progress", "bytes", done, "size", total)
+	}
+	if upload != "" {
+		if err := files.Upload(client, upload, progress); err != nil {
+			slog.Error("Error uploading file", "err", err)
+			return 1
+		}
+		slog.Info("File uploaded", "path", upload)
+		return 0
+	}
+	path, err := files.Download(client, download, dir, progress)
+	if err != nil {
+		slog.Error("Error downloading file", "err", err)
+		return 1
+	}
+	slog.Info("File downloaded", "path", path)
+	return 0
+}
+
+// env returns the environment variable name, or fallback when it is unset.
+// Flags still override it.
+func env(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,369 @@
+// Command ws-server runs the chat server the web client in ./client talks
+// to, see the README for its flags and configuration.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"websocket"
+	"websocket/admin"
+	"websocket/broker"
+	"websocket/broker/nats"
+	"websocket/broker/redis"
+	"websocket/chat"
+	"websocket/config"
+	"websocket/files"
+	"websocket/metrics"
+	"websocket/mqtt"
+	"websocket/sse"
+)
+
+// roomsSubprotocol is the subprotocol of the clients joining rooms rather
+// than the chat.
+const roomsSubprotocol = "rooms"
+
+func main() {
+	os.Exit(run())
+}
+
+// run starts the server and returns the exit status once it stopped: 0 after
+// a graceful shutdown, 1 when it failed or connections had to be dropped.
+func run() int {
+	configPath := flag.String("config", "", "load the server configuration from this JSON, YAML (.yaml, .yml) or TOML (.toml) file")
+	validate := flag.Bool("validate", false, "validate the configuration, print a JSON report and exit (status 1 when invalid)")
+	metricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics on this address at /metrics, e.g. :9090 (disabled when empty)")
+	adminAddr := flag.String("admin-addr", "", "serve the admin API on this address at /admin/, e.g. localhost:9091, to list and kick connections or drain the server before a deploy (disabled when empty)")
+	adminToken := flag.String("admin-token", env("WS_ADMIN_TOKEN", ""), "bearer token required by the admin API, mandatory when -admin-addr is not a loopback address (env WS_ADMIN_TOKEN)")
+	debug := flag.Bool("debug", false, "log every frame, ping and pong")
+	wireTrace := flag.Bool("wire-trace", false, "log the header bytes and a hex dump of every frame of connections opened with ?trace=wire")
+	redisAddr := flag.String("redis-addr", "", "broadcast chat messages through Redis Pub/Sub at this address, e.g. localhost:6379, to reach the clients of every instance")
+	natsAddr := flag.String("nats-addr", "", "broadcast chat messages through NATS at this address, e.g. localhost:4222, like -redis-addr")
+	sseAddr := flag.String("sse-addr", "", "stream the relayed chat messages as Server-Sent Events on this address at /events, e.g. :8080, for clients that cannot upgrade (disabled when empty)")
+	mqttUpstream := flag.String("mqtt-upstream", "", "bridge MQTT over WebSocket to the broker at this address, e.g. localhost:1883, instead of serving the chat")
+	uploadDir := flag.String("upload-dir", "", "store the files uploaded by clients negotiating the files subprotocol in this directory, and serve them to downloads (disabled when empty)")
+	maxUpload := flag.Int64("max-upload", 0, "largest file accepted by -upload-dir in bytes, 100MB when zero")
+	bind := flag.String("bind", env("WS_BIND", ""), "host to listen on, overrides the configured addr (env WS_BIND)")
+	port := flag.Int("port", envInt("WS_PORT", 0), "port to listen on, overrides the configured addr (env WS_PORT)")
+	flag.Parse()
+
+	if *debug {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	cfg := config.Default()
+	if *configPath != "" {
+		var err error
+		if cfg, err = config.Load(*configPath); err != nil {
+			if *validate {
+				printReport(config.Report{Checks: []config.Check{{Name: "config", Error: err.Error()}}})
+			}
+			log.Fatalln("Error loading configuration:", err)
+		}
+	}
+	if *metricsAddr != "" {
+		cfg.MetricsAddr = *metricsAddr
+	}
+	if *bind != "" || *port != 0 {
+		host, portStr, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			log.Fatalln("Error in configured addr:", err)
+		}
+		if *bind != "" {
+			host = *bind
+		}
+		if *port != 0 {
+			portStr = strconv.Itoa(*port)
+		}
+		cfg.Addr = net.JoinHostPort(host, portStr)
+	}
+
+	if *validate {
+		report := cfg.Validate()
+		printReport(report)
+		if !report.Valid {
+			return 1
+		}
+		return 0
+	}
+
+	// Report every invalid setting at once rather than failing on the first.
+	if report := cfg.Validate(); !report.Valid {
+		for _, check := range report.Checks {
+			if !check.OK {
+				slog.Error("Invalid configuration", "setting", check.Name, "err", check.Error)
+			}
+		}
+		return 1
+	}
+
+	// The first SIGINT or SIGTERM cancels ctx and starts a graceful shutdown,
+	// a second one kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	var metricsServer *http.Server
+	if cfg.MetricsAddr != "" {
+		metricsServer = metrics.NewServer(cfg.MetricsAddr)
+		go func() {
+			log.Printf("Metrics available on %s/metrics\n", cfg.MetricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Println("Error serving metrics:", err)
+			}
+		}()
+	}
+
+	var b broker.Broker
+	switch {
+	case *redisAddr != "":
+		r, err := redis.Dial(*redisAddr)
+		if err != nil {
+			log.Fatalln("Error connecting to Redis:", err)
+		}
+		defer r.Close()
+		b = r
+	case *natsAddr != "":
+		n, err := nats.Dial(*natsAddr)
+		if err != nil {
+			log.Fatalln("Error connecting to NATS:", err)
+		}
+		defer n.Close()
+		b = n
+	}
+
+	handler := websocket.Handler(chat.AckHandler)
+	if cfg.Workers > 0 {
+		pool := websocket.NewWorkerPool(cfg.Workers, cfg.WorkerQueue)
+		defer pool.Close()
+		handler = chat.PooledAckHandler(pool)
+	}
+	var hub *websocket.Hub
+	switch {
+	case b != nil:
+		var err error
+		if hub, err = websocket.NewHubWithBroker(b); err != nil {
+			log.Fatalln("Error subscribing to the broker:", err)
+		}
+	case *sseAddr != "":
+		// SSE clients follow the hub, the chat has to be relayed through one.
+		hub = websocket.NewHub()
+	}
+	if hub != nil {
+		if cfg.IdleTimeout > 0 {
+			defer hub.ReapIdle(time.Duration(cfg.IdleTimeout))()
+		}
+		handler = chat.RelayHandler(hub)
+	}
+
+	var sseServer *http.Server
+	var events *sse.Server
+	if *sseAddr != "" {
+		var err error
+		if events, err = sse.NewServer(hub, nil); err != nil {
+			log.Fatalln("Error subscribing to the hub:", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/events", events)
+		sseServer = &http.Server{Addr: *sseAddr, Handler: mux}
+		go func() {
+			log.Printf("Server-Sent Events available on %s/events\n", *sseAddr)
+			if err := sseServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Println("Error serving Server-Sent Events:", err)
+			}
+		}()
+	}
+
+	listeners, err := listen(cfg)
+	if err != nil {
+		log.Fatalln("Error starting WebSocket server:", err)
+	}
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		log.Fatalln("Error loading TLS certificate:", err)
+	}
+	for i, listener := range listeners {
+		if cfg.ProxyProtocol {
+			listener = websocket.ProxyListener(listener)
+		}
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		listeners[i] = listener
+	}
+	slog.Info("WebSocket Server running", "addr", cfg.Addr, "tls", tlsConfig != nil, "listeners", len(listeners))
+
+	if *mqttUpstream != "" {
+		handler = (&mqtt.Bridge{Upstream: *mqttUpstream}).Handle
+	}
+
+	// Connections negotiating the rooms subprotocol speak the protocol of
+	// Rooms.Handler, on the hub of the chat when it has one.
+	roomsHub := hub
+	if roomsHub == nil {
+		roomsHub = websocket.NewHub()
+	}
+	rooms := websocket.NewRooms(roomsHub)
+	chatHandler := handler
+	handler = func(conn *websocket.Conn) {
+		if conn.Subprotocol() == roomsSubprotocol {
+			rooms.Handler(conn)
+			return
+		}
+		chatHandler(conn)
+	}
+
+	if *uploadDir != "" {
+		if err := os.MkdirAll(*uploadDir, 0o755); err != nil {
+			log.Fatalln("Error creating the upload directory:", err)
+		}
+		// Connections negotiating the files subprotocol transfer files, the
+		// others get the handler chosen above.
+		receiver := &files.Receiver{Dir: *uploadDir, MaxSize: *maxUpload}
+		next := handler
+		handler = func(conn *websocket.Conn) {
+			if conn.Subprotocol() == files.Subprotocol {
+				receiver.Handle(conn)
+				return
+			}
+			next(conn)
+		}
+	}
+
+	server := cfg.Server(handler)
+	if *mqttUpstream != "" {
+		server.Subprotocols = mqtt.Subprotocols
+	}
+	if *uploadDir != "" {
+		server.Subprotocols = append(server.Subprotocols, files.Subprotocol)
+	}
+	server.Subprotocols = append(server.Subprotocols, roomsSubprotocol)
+	if *wireTrace {
+		server.TraceWire = func(r *http.Request) bool {
+			return r.URL.Query().Get("trace") == "wire"
+		}
+	}
+	var adminServer *http.Server
+	if *adminAddr != "" {
+		adminHandler := admin.Handler(server, rooms)
+		switch {
+		case *adminToken != "":
+			adminHandler = admin.RequireToken(*adminToken, adminHandler)
+		case !loopback(*adminAddr):
+			log.Fatalf("The admin API on %s would be open to anyone reaching it, set -admin-token or bind it to a loopback address", *adminAddr)
+		}
+		adminServer = &http.Server{Addr: *adminAddr, Handler: adminHandler}
+		go func() {
+			log.Printf("Admin API available on %s/admin/\n", *adminAddr)
+			if err := adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Println("Error serving the admin API:", err)
+			}
+		}()
+	}
+
+	served := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() { served <- server.Serve(listener) }()
+	}
+
+	select {
+	case err := <-served:
+		slog.Error("WebSocket server stopped", "err", err)
+		return 1
+	case <-ctx.Done():
+	}
+
+	timeout := time.Duration(cfg.ShutdownTimeout)
+	if timeout <= 0 {
+		timeout = config.DefaultShutdownTimeout
+	}
+	slog.Info("Shutting down, draining connections", "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	status := 0
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Connections did not close in time, dropped them", "err", err)
+		status = 1
+	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(shutdownCtx)
+	}
+	if adminServer != nil {
+		adminServer.Shutdown(shutdownCtx)
+	}
+	if sseServer != nil {
+		// Streams never end by themselves, close them before waiting.
+		events.Close()
+		sseServer.Shutdown(shutdownCtx)
+	}
+	slog.Info("Server stopped")
+	return status
+}
+
+// env returns the environment variable name, or fallback when it is unset.
+// Flags still override it.
+func env(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// envInt is env for integers.
+func envInt(name string, fallback int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s=%q is not a number", name, value)
+	}
+	return n
+}
+
+// loopback reports whether addr listens on a loopback interface only.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func printReport(report config.Report) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+}
+
+// listen opens the listener of cfg.Addr, one per CPU with reuse_port set.
+func listen(cfg *config.Config) ([]net.Listener, error) {
+	if cfg.ReusePort {
+		return websocket.ListenReusePort("tcp", cfg.Addr, 0)
+	}
+	listener, err := websocket.Listen(cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{listener}, nil
+}
@@ -0,0 +1,328 @@
+/**
+ * * Command wsbench load tests a WebSocket server that answers every message with one message,
+ * * like cmd/ws-server or the cmd/autobahn echo server:
+ *
+ *	go run ./cmd/wsbench -url ws://localhost:4443 -chat -c 100 -rate 20 -size 256 -duration 30s
+ *
+ * * It opens -c connections at once, sends -rate messages per second of -size bytes on each of
+ * * them for -duration and then reports how long the connections took to open, the round trip
+ * * time of the messages (from sending one to receiving the next reply on the same connection),
+ * * the errors and the throughput. With -rate 0 every connection sends its next message as soon
+ * * as the previous one was answered.
+ *
+ * * Servers that broadcast messages (chat.RelayHandler) answer with more than one message and
+ * * make the round trip times meaningless.
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket"
+	"websocket/chat"
+)
+
+// drainTimeout is how long a connection waits for the replies still
+// outstanding once sending stopped. Missing replies are then counted as lost.
+const drainTimeout = 2 * time.Second
+
+func main() {
+	url := flag.String("url", "ws://localhost:4443", "server to load")
+	conns := flag.Int("c", 10, "concurrent connections")
+	rate := flag.Float64("rate", 10, "messages per second on each connection, 0 to send each message once the previous one was answered")
+	size := flag.Int("size", 64, "payload size in bytes")
+	duration := flag.Duration("duration", 10*time.Second, "how long to send")
+	binary := flag.Bool("binary", false, "send binary instead of text messages")
+	chatMsg := flag.Bool("chat", false, "wrap the payload in a chat message, as cmd/ws-server expects")
+	flag.Parse()
+
+	if *conns <= 0 || *size < 0 || *rate < 0 {
+		fmt.Fprintln(os.Stderr, "-c must be positive, -size and -rate not negative")
+		os.Exit(2)
+	}
+
+	opcode := byte(0x1)
+	if *binary {
+		opcode = 0x2
+	}
+	payload := bytes.Repeat([]byte("x"), *size)
+	if *chatMsg {
+		payload, _ = json.Marshal(chat.Msg{Role: "user", Content: string(payload)})
+	}
+
+	b := &bench{url: *url, opcode: opcode, payload: payload, rate: *rate, errors: make(map[string]int)}
+	fmt.Printf("Loading %s with %d connections for %s\n", *url, *conns, *duration)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range *conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.run(ctx)
+		}()
+	}
+	wg.Wait()
+	b.report(time.Since(start))
+
+	if b.failed() {
+		os.Exit(1)
+	}
+}
+
+// bench collects the measurements of every connection.
+type bench struct {
+	url     string
+	opcode  byte
+	payload []byte
+	rate    float64
+
+	mu        sync.Mutex
+	connects  []time.Duration
+	rtts      []time.Duration
+	sent      int
+	received  int
+	lost      int
+	bytesSent int64
+	bytesRecv int64
+	errors    map[string]int
+}
+
+// run opens one connection and sends messages on it until ctx is done.
+func (b *bench) run(ctx context.Context) {
+	start := time.Now()
+	client, err := websocket.Dial(b.url)
+	if err != nil {
+		b.fail("connect", err)
+		return
+	}
+	b.mu.Lock()
+	b.connects = append(b.connects, time.Since(start))
+	b.mu.Unlock()
+
+	// The server answers in order, the first timestamp is the one of the
+	// message the next reply is for.
+	var mu sync.Mutex
+	var pending []time.Time
+	replied := make(chan struct{}, 1)
+	finished := make(chan struct{})
+	stopping := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		for {
+			_, data, err := client.ReadFullMessage()
+			if err != nil {
+				select {
+				case <-stopping:
+				default:
+					b.fail("read", err)
+				}
+				return
+			}
+
+			mu.Lock()
+			var rtt time.Duration
+			if len(pending) > 0 {
+				rtt = time.Since(pending[0])
+				pending = pending[1:]
+			}
+			mu.Unlock()
+
+			b.mu.Lock()
+			b.received++
+			b.bytesRecv += int64(len(data))
+			if rtt > 0 {
+				b.rtts = append(b.rtts, rtt)
+			}
+			b.mu.Unlock()
+			select {
+			case replied <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	var tick <-chan time.Time
+	if b.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / b.rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+			case <-finished:
+			case <-tick:
+			}
+		}
+		if ctx.Err() != nil || isClosed(finished) {
+			break
+		}
+
+		mu.Lock()
+		pending = append(pending, time.Now())
+		mu.Unlock()
+		if err := client.WriteMessage(b.opcode, b.payload); err != nil {
+			b.fail("write", err)
+			break
+		}
+		b.mu.Lock()
+		b.sent++
+		b.bytesSent += int64(len(b.payload))
+		b.mu.Unlock()
+
+		if tick == nil {
+			select {
+			case <-ctx.Done():
+			case <-finished:
+			case <-replied:
+			}
+		}
+	}
+
+	// Give the replies still on their way a chance to arrive.
+	deadline := time.After(drainTimeout)
+drain:
+	for {
+		mu.Lock()
+		outstanding := len(pending)
+		mu.Unlock()
+		if outstanding == 0 {
+			break
+		}
+		select {
+		case <-replied:
+		case <-finished:
+			break drain
+		case <-deadline:
+			break drain
+		}
+	}
+
+	close(stopping)
+	client.Close()
+	<-finished
+
+	b.mu.Lock()
+	b.lost += len(pending)
+	b.mu.Unlock()
+}
+
+func (b *bench) fail(stage string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errors[stage+": "+err.Error()]++
+}
+
+func (b *bench) failed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.errors) > 0 || b.lost > 0
+}
+
+func (b *bench) report(elapsed time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	seconds := elapsed.Seconds()
+	fmt.Printf("\nConnections: %d opened, %d failed\n", len(b.connects), b.countErrors("connect: "))
+	printLatencies("Connect", b.connects)
+	printLatencies("RTT", b.rtts)
+	fmt.Printf("\nMessages:    %d sent, %d received, %d lost\n", b.sent, b.received, b.lost)
+	fmt.Printf("Throughput:  %.1f msg/s sent, %.1f msg/s received, %s/s sent, %s/s received\n",
+		float64(b.sent)/seconds, float64(b.received)/seconds,
+		formatBytes(float64(b.bytesSent)/seconds), formatBytes(float64(b.bytesRecv)/seconds))
+
+	if len(b.errors) == 0 {
+		fmt.Println("Errors:      none")
+		return
+	}
+	fmt.Println("Errors:")
+	messages := make([]string, 0, len(b.errors))
+	for message := range b.errors {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool { return b.errors[messages[i]] > b.errors[messages[j]] })
+	for _, message := range messages {
+		fmt.Printf("  %6d  %s\n", b.errors[message], message)
+	}
+}
+
+func (b *bench) countErrors(prefix string) int {
+	n := 0
+	for message, count := range b.errors {
+		if strings.HasPrefix(message, prefix) {
+			n += count
+		}
+	}
+	return n
+}
+
+// printLatencies prints the percentiles of durations.
+func printLatencies(name string, durations []time.Duration) {
+	if len(durations) == 0 {
+		fmt.Printf("%-12s no samples\n", name+":")
+		return
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	fmt.Printf("%-12s min %s  p50 %s  p90 %s  p99 %s  max %s  mean %s\n", name+":",
+		round(sorted[0]), round(percentile(sorted, 0.5)), round(percentile(sorted, 0.9)),
+		round(percentile(sorted, 0.99)), round(sorted[len(sorted)-1]), round(total/time.Duration(len(sorted))))
+}
+
+// percentile returns the q quantile of sorted using the nearest rank method.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d > time.Second:
+		return d.Round(time.Millisecond)
+	case d > time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(100 * time.Nanosecond)
+	}
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%s", n, units[unit])
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,297 @@
+/**
+ * * Command wscat is an interactive WebSocket client, in the spirit of the wscat npm package:
+ *
+ *	go run ./cmd/wscat ws://localhost:4443
+ *
+ * * Every line typed is sent as a text message and incoming messages are printed with the time
+ * * they arrived, their opcode, how many frames they came in and how long after the last message
+ * * sent. -frames prints every frame too. Lines starting with a slash are commands:
+ *
+ *	/ping [payload]        send a ping, the pong is printed with the round trip time
+ *	/binary <file>         send the content of file as a binary message
+ *	/fragment <size>       send the next messages in frames of at most size bytes, 0 for 65535
+ *	/close [code [reason]] start the closing handshake, 1000 by default
+ *
+ * * A line starting with two slashes is sent as text without its first slash. End of input
+ * * (Ctrl-D) closes the connection with 1000.
+ */
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket"
+)
+
+// maxDump is how many bytes of a binary message are printed.
+const maxDump = 64
+
+func main() {
+	insecure := flag.Bool("insecure", false, "do not verify the certificate of wss:// servers")
+	lenient := flag.Bool("lenient", false, "accept servers that bend RFC 6455, see websocket.Lenient")
+	wire := flag.Bool("wire", false, "log the header bytes and a hex dump of every frame sent and received")
+	proxy := flag.String("proxy", "", "connect through this http://, https://, socks5:// or socks5h:// proxy, HTTP_PROXY or HTTPS_PROXY when empty")
+	unix := flag.String("unix", "", "connect to this unix domain socket instead of the host of the url")
+	frames := flag.Bool("frames", false, "print every frame received, continuations and control frames included")
+	http2 := flag.Bool("http2", false, "connect over HTTP/2 with an extended CONNECT (RFC 8441), negotiated for wss://, with prior knowledge for ws://")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: wscat [flags] <ws:// or wss:// url>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dialer := &websocket.Dialer{Proxy: websocket.ProxyFromEnvironment}
+	if *proxy != "" {
+		proxyURL, err := url.Parse(*proxy)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -proxy:", err)
+			os.Exit(2)
+		}
+		dialer.Proxy = websocket.ProxyURL(proxyURL)
+	}
+	if *insecure {
+		dialer.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if *lenient {
+		dialer.Mode = websocket.Lenient
+	}
+	dialer.UnixSocket = *unix
+	dialer.HTTP2 = *http2
+	client, err := dialer.Dial(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error connecting:", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+	client.SetWireTrace(*wire)
+	timing := &timing{}
+	client.Hooks.OnFrameRead = func(frame *websocket.Frame) {
+		timing.frameRead(frame)
+		if *frames {
+			printf("<< %s frame, fin %t, %d bytes", frame.OpcodeName(), frame.Fin, frame.PayloadLen)
+		}
+	}
+	client.OnPong = func(payload []byte) {
+		printf("< pong %q, round trip %s", payload, timing.sincePing())
+	}
+	printf("connected to %s", flag.Arg(0))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		read(client, timing)
+	}()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case line, ok := <-lines:
+			if !ok {
+				client.WriteClose(1000, "")
+				waitClosed(done)
+				return
+			}
+			closing, err := handle(client, line, timing)
+			if err != nil {
+				printf("error: %v", err)
+			}
+			if closing {
+				waitClosed(done)
+				return
+			}
+		}
+	}
+}
+
+// handle sends line or runs the command it holds. It reports whether the
+// closing handshake was started.
+func handle(client *websocket.Client, line string, timing *timing) (bool, error) {
+	if !strings.HasPrefix(line, "/") || strings.HasPrefix(line, "//") {
+		timing.sent()
+		return false, client.SendTextMessage(strings.TrimPrefix(line, "/"))
+	}
+
+	command, args, _ := strings.Cut(line, " ")
+	args = strings.TrimSpace(args)
+	switch command {
+	case "/ping":
+		timing.pinged()
+		return false, client.Ping([]byte(args))
+	case "/binary":
+		if args == "" {
+			return false, errors.New("usage: /binary <file>")
+		}
+		data, err := os.ReadFile(args)
+		if err != nil {
+			return false, err
+		}
+		timing.sent()
+		if err := client.WriteMessage(0x2, data); err != nil {
+			return false, err
+		}
+		printf("sent %d bytes from %s", len(data), args)
+		return false, nil
+	case "/fragment":
+		size, err := strconv.Atoi(args)
+		if err != nil || size < 0 {
+			return false, errors.New("usage: /fragment <size>, 0 for the default")
+		}
+		client.FragmentSize = size
+		if size == 0 {
+			printf("messages go out in frames of at most 65535 bytes")
+		} else {
+			printf("messages go out in frames of at most %d bytes", size)
+		}
+		return false, nil
+	case "/close":
+		code := 1000
+		codeArg, reason, _ := strings.Cut(args, " ")
+		if codeArg != "" {
+			var err error
+			if code, err = strconv.Atoi(codeArg); err != nil || code < 0 || code > 0xFFFF {
+				return false, fmt.Errorf("invalid close code %q", codeArg)
+			}
+		}
+		if err := client.WriteClose(uint16(code), reason); err != nil {
+			return false, err
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown command %s, use /ping, /binary, /fragment or /close", command)
+	}
+}
+
+// read prints incoming messages until the connection ends.
+func read(client *websocket.Client, timing *timing) {
+	for {
+		opcode, payload, err := client.ReadFullMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			printf("< close, code %d %s", closeErr.Code, closeErr.Reason)
+			return
+		}
+		if errors.Is(err, io.EOF) {
+			printf("disconnected")
+			return
+		}
+		if err != nil {
+			printf("disconnected: %v", err)
+			return
+		}
+		how := timing.message()
+		if opcode == 0x2 {
+			printf("< binary, %d bytes%s: %s", len(payload), how, dump(payload))
+			continue
+		}
+		printf("< text%s: %s", how, payload)
+	}
+}
+
+// timing keeps the times the messages received are printed with: when the
+// last message and ping were sent, and the frames of the message being read.
+type timing struct {
+	mu       sync.Mutex
+	lastSent time.Time
+	lastPing time.Time
+	frames   int
+	first    time.Time
+}
+
+func (t *timing) sent() {
+	t.mu.Lock()
+	t.lastSent = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *timing) pinged() {
+	t.mu.Lock()
+	t.lastPing = time.Now()
+	t.mu.Unlock()
+}
+
+// frameRead counts the data frames of the message being read.
+func (t *timing) frameRead(frame *websocket.Frame) {
+	if frame.Opcode > 0x2 {
+		return
+	}
+	t.mu.Lock()
+	if t.frames == 0 {
+		t.first = time.Now()
+	}
+	t.frames++
+	t.mu.Unlock()
+}
+
+// message describes the frames of the message just read and when it came,
+// and starts over for the next one.
+func (t *timing) message() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var how string
+	if t.frames > 1 {
+		how = fmt.Sprintf(", %d frames over %s", t.frames, round(time.Since(t.first)))
+	}
+	if !t.lastSent.IsZero() {
+		how += fmt.Sprintf(", %s after the last send", round(time.Since(t.lastSent)))
+	}
+	t.frames = 0
+	return how
+}
+
+// sincePing is how long ago the last ping was sent.
+func (t *timing) sincePing() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return round(time.Since(t.lastPing))
+}
+
+// round rounds d for the output.
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+// waitClosed waits for the server to answer the closing handshake.
+func waitClosed(done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		printf("no close frame from the server, closing anyway")
+	}
+}
+
+func dump(payload []byte) string {
+	if len(payload) > maxDump {
+		return hex.EncodeToString(payload[:maxDump]) + "..."
+	}
+	return hex.EncodeToString(payload)
+}
+
+func printf(format string, args ...any) {
+	fmt.Printf("%s "+format+"\n", append([]any{time.Now().Format("15:04:05.000")}, args...)...)
+}
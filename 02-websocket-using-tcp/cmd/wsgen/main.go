@@ -0,0 +1,178 @@
+// Command wsgen generates typed message code from a schema, for use with
+// go:generate:
+//
+//	//go:generate go run websocket/cmd/wsgen -schema schema.json -out messages_gen.go
+//
+// The schema lists the message types of a protocol:
+//
+//	{
+//	  "package": "chat",
+//	  "messages": [
+//	    {"name": "Say", "type": "say", "fields": [
+//	      {"name": "Text", "type": "string", "json": "text"}
+//	    ]}
+//	  ]
+//	}
+//
+// Every message travels as a JSON envelope {"type": "say", "data": {...}}.
+// For each message wsgen emits its struct, a SendSay(conn, msg) function and
+// an OnSay field on Handlers. Dispatch reads one envelope and calls the
+// matching handler, replacing hand-written switch statements over the type.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"text/template"
+)
+
+type schema struct {
+	Package  string    `json:"package"`
+	Messages []message `json:"messages"`
+}
+
+type message struct {
+	Name   string  `json:"name"`
+	Type   string  `json:"type"`
+	Fields []field `json:"fields"`
+}
+
+type field struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	JSON string `json:"json"`
+}
+
+var (
+	schemaPath = flag.String("schema", "schema.json", "schema file")
+	outPath    = flag.String("out", "messages_gen.go", "generated file")
+)
+
+func main() {
+	flag.Parse()
+	if err := generate(*schemaPath, *outPath); err != nil {
+		fmt.Fprintln(os.Stderr, "wsgen:", err)
+		os.Exit(1)
+	}
+}
+
+func generate(schemaPath, outPath string) error {
+	data, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return err
+	}
+
+	var s schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("parsing %s: %w", schemaPath, err)
+	}
+	if err := validate(&s); err != nil {
+		return fmt.Errorf("%s: %w", schemaPath, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, s); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting generated code: %w", err)
+	}
+	return os.WriteFile(outPath, src, 0o644)
+}
+
+func validate(s *schema) error {
+	if s.Package == "" {
+		return fmt.Errorf("missing package")
+	}
+	names := make(map[string]bool)
+	types := make(map[string]bool)
+	for _, m := range s.Messages {
+		if m.Name == "" || m.Type == "" {
+			return fmt.Errorf("message needs both a name and a type")
+		}
+		if names[m.Name] || types[m.Type] {
+			return fmt.Errorf("duplicate message %s (%s)", m.Name, m.Type)
+		}
+		names[m.Name], types[m.Type] = true, true
+	}
+	return nil
+}
+
+var tmpl = template.Must(template.New("messages").Parse(`// Code generated by wsgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Sender is implemented by *websocket.Conn and *websocket.Client.
+type Sender interface {
+	WriteJSON(v any) error
+}
+
+// Receiver is implemented by *websocket.Conn and *websocket.Client.
+type Receiver interface {
+	ReadJSON(v any) error
+}
+
+// envelope is the wire format of every message.
+type envelope struct {
+	Type string          ` + "`json:\"type\"`" + `
+	Data json.RawMessage ` + "`json:\"data\"`" + `
+}
+{{range .Messages}}
+// {{.Name}} is the "{{.Type}}" message.
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}} ` + "`json:\"{{.JSON}}\"`" + `
+{{- end}}
+}
+
+// Send{{.Name}} sends msg as a "{{.Type}}" message.
+func Send{{.Name}}(conn Sender, msg {{.Name}}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteJSON(envelope{Type: "{{.Type}}", Data: data})
+}
+{{end}}
+// Handlers holds one callback per message type. Messages whose callback is
+// nil are ignored.
+type Handlers struct {
+{{- range .Messages}}
+	On{{.Name}} func({{.Name}}) error
+{{- end}}
+}
+
+// Dispatch reads one message from conn and passes it to the matching handler.
+func Dispatch(conn Receiver, h *Handlers) error {
+	var env envelope
+	if err := conn.ReadJSON(&env); err != nil {
+		return err
+	}
+
+	switch env.Type {
+{{- range .Messages}}
+	case "{{.Type}}":
+		var msg {{.Name}}
+		if err := json.Unmarshal(env.Data, &msg); err != nil {
+			return fmt.Errorf("decoding {{.Type}} message: %w", err)
+		}
+		if h.On{{.Name}} == nil {
+			return nil
+		}
+		return h.On{{.Name}}(msg)
+{{- end}}
+	default:
+		return fmt.Errorf("unknown message type %q", env.Type)
+	}
+}
+`))
@@ -0,0 +1,55 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Codec turns values into message payloads and back. Send and Receive use
+// the codec of the connection, JSONCodec when none is set.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	ContentType() string
+}
+
+// JSONCodec encodes values as JSON. It is the only codec whose messages are
+// sent as text frames, every other codec produces binary frames.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (JSONCodec) ContentType() string                { return "application/json" }
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{"application/json": JSONCodec{}}
+)
+
+// RegisterCodec makes codec available to CodecFor under its content type,
+// replacing any codec previously registered for it.
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.ContentType()] = codec
+}
+
+// CodecFor returns the codec registered for contentType.
+func CodecFor(contentType string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for %q", contentType)
+	}
+	return codec, nil
+}
+
+// messageOpcode returns the opcode messages produced by codec are sent with.
+func messageOpcode(codec Codec) byte {
+	if _, ok := codec.(JSONCodec); ok {
+		return 0x1 // Text frame
+	}
+	return 0x2 // Binary frame
+}
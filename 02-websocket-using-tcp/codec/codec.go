@@ -0,0 +1,49 @@
+// Package codec provides binary websocket.Codec implementations for Protocol
+// Buffers, MessagePack and CBOR. Register the ones an application speaks:
+//
+//	websocket.RegisterCodec(codec.MessagePack{})
+//	conn.Codec, _ = websocket.CodecFor("application/msgpack")
+package codec
+
+import (
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Protobuf encodes values implementing proto.Message.
+type Protobuf struct{}
+
+func (Protobuf) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec: %T does not implement proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (Protobuf) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("codec: %T does not implement proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (Protobuf) ContentType() string { return "application/protobuf" }
+
+// MessagePack encodes values with MessagePack.
+type MessagePack struct{}
+
+func (MessagePack) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (MessagePack) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+func (MessagePack) ContentType() string                { return "application/msgpack" }
+
+// CBOR encodes values with CBOR (RFC 8949).
+type CBOR struct{}
+
+func (CBOR) Marshal(v any) ([]byte, error)      { return cbor.Marshal(v) }
+func (CBOR) Unmarshal(data []byte, v any) error { return cbor.Unmarshal(data, v) }
+func (CBOR) ContentType() string                { return "application/cbor" }
@@ -0,0 +1,468 @@
+// Package config loads the server configuration from a JSON, YAML or TOML
+// file and validates it, so that a rollout can check a configuration before
+// any server is restarted with it.
+//
+//	{
+//		"addr": ":4443",
+//		"metrics_addr": ":9090",
+//		"mode": "strict",
+//		"max_connections": 10000,
+//		"max_message_size": 1048576,
+//		"handshake_timeout": "10s",
+//		"shutdown_timeout": "30s",
+//		"tls": {"cert_file": "cert.pem", "key_file": "key.pem"},
+//		"allowed_origins": ["https://chat.example.com"],
+//		"rate_limit": {"messages_per_second": 50, "on_exceed": "close"}
+//	}
+//
+// The same in YAML:
+//
+//	addr: ":4443"
+//	max_message_size: 1048576
+//	handshake_timeout: 10s
+//	tls:
+//	  cert_file: cert.pem
+//	  key_file: key.pem
+//	allowed_origins: [https://chat.example.com]
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"websocket"
+)
+
+// Config is the configuration of a WebSocket server.
+type Config struct {
+	// Addr is a TCP address or unix:/path for a unix domain socket, see
+	// websocket.Listen.
+	Addr        string `json:"addr"`
+	MetricsAddr string `json:"metrics_addr,omitempty"`
+
+	// ReusePort accepts on one SO_REUSEPORT listener per CPU, Linux only, see
+	// websocket.ListenReusePort.
+	ReusePort bool `json:"reuse_port,omitempty"`
+
+	// ProxyProtocol expects a PROXY protocol v1 or v2 header ahead of every
+	// connection, see websocket.ProxyListener.
+	ProxyProtocol bool `json:"proxy_protocol,omitempty"`
+
+	// Mode is "strict" or "lenient", see websocket.Mode.
+	Mode string `json:"mode,omitempty"`
+
+	MaxConnections   int      `json:"max_connections,omitempty"`
+	QueueConnections bool     `json:"queue_connections,omitempty"`
+	HandshakeTimeout Duration `json:"handshake_timeout,omitempty"`
+	MaxHeaderBytes   int      `json:"max_header_bytes,omitempty"`
+
+	// MaxMessageSize and MaxFrameSize bound what clients send, see
+	// websocket.Conn. Zero keeps the package defaults.
+	MaxMessageSize int64 `json:"max_message_size,omitempty"`
+	MaxFrameSize   int64 `json:"max_frame_size,omitempty"`
+
+	// FragmentSize splits the messages the server sends into frames of at
+	// most that many bytes. Zero keeps 65535.
+	FragmentSize int `json:"fragment_size,omitempty"`
+
+	// PingInterval, when set, pings every client at that interval to measure
+	// its round trip time, see websocket.Conn.Latency.
+	PingInterval Duration `json:"ping_interval,omitempty"`
+
+	// IdleTimeout, when set, closes the connections of the chat hub that
+	// have not sent or received a message for that long, see
+	// websocket.Hub.ReapIdle.
+	IdleTimeout Duration `json:"idle_timeout,omitempty"`
+
+	// SendQueueSize bounds the messages queued for a client that reads slower
+	// than broadcasts arrive, SendQueuePolicy is "disconnect" (the default),
+	// "drop_oldest" or "drop_newest", see websocket.Server.SendQueueSize.
+	SendQueueSize   int    `json:"send_queue_size,omitempty"`
+	SendQueuePolicy string `json:"send_queue_policy,omitempty"`
+
+	// Workers, when set, answers chat messages on a pool of that many
+	// goroutines queuing WorkerQueue messages each, so a slow answer does not
+	// delay reading, see websocket.WorkerPool.
+	Workers     int `json:"workers,omitempty"`
+	WorkerQueue int `json:"worker_queue,omitempty"`
+
+	// ShutdownTimeout is how long connections are given to close on SIGINT
+	// or SIGTERM before they are dropped, DefaultShutdownTimeout when zero.
+	ShutdownTimeout Duration `json:"shutdown_timeout,omitempty"`
+
+	RateLimit       RateLimit `json:"rate_limit,omitempty"`
+	GlobalRateLimit RateLimit `json:"global_rate_limit,omitempty"`
+
+	// TCP tunes the socket of every connection, see websocket.TCPOptions.
+	TCP TCP `json:"tcp,omitempty"`
+
+	// TLS, when set, serves wss:// with the given certificate.
+	TLS *TLS `json:"tls,omitempty"`
+
+	// HTTP2 accepts WebSockets over HTTP/2 too, negotiated with ALPN over
+	// TLS, see websocket.Server.HTTP2.
+	HTTP2 bool `json:"http2,omitempty"`
+
+	// AllowedOrigins lists the origins (scheme://host[:port]) browsers may
+	// connect from, "*" allows any. Empty allows any too.
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+
+	// Extensions lists the WebSocket extensions to negotiate. None are
+	// implemented yet, so any entry fails validation.
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+// TLS holds the paths of a PEM encoded certificate chain and its key.
+type TLS struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// TCP is the JSON form of websocket.TCPOptions. Profile starts from
+// websocket.LowLatency ("latency") or websocket.HighThroughput
+// ("throughput"), the other fields override it when set.
+type TCP struct {
+	Profile     string   `json:"profile,omitempty"`
+	Nagle       bool     `json:"nagle,omitempty"`
+	KeepAlive   Duration `json:"keep_alive,omitempty"`
+	ReadBuffer  int      `json:"read_buffer,omitempty"`
+	WriteBuffer int      `json:"write_buffer,omitempty"`
+}
+
+// supportedExtensions are the extensions Extensions may list.
+var supportedExtensions []string
+
+// RateLimit is the JSON form of websocket.RateLimit. OnExceed is "throttle" or "close".
+type RateLimit struct {
+	MessagesPerSecond float64 `json:"messages_per_second,omitempty"`
+	MessageBurst      int     `json:"message_burst,omitempty"`
+	BytesPerSecond    float64 `json:"bytes_per_second,omitempty"`
+	ByteBurst         int     `json:"byte_burst,omitempty"`
+	OnExceed          string  `json:"on_exceed,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "10s" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultShutdownTimeout is the ShutdownTimeout used when none is set.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Default returns the configuration the server runs with when no file is given.
+func Default() *Config {
+	return &Config{Addr: ":4443", Mode: "strict"}
+}
+
+// Load reads the configuration in path on top of Default. The format
+// follows the extension: .yaml or .yml, .toml, and JSON otherwise. Unknown
+// fields are an error, a misspelled option must not be silently ignored.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = toJSON(filepath.Ext(path), data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	config := Default()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// toJSON converts a YAML or TOML document to JSON, so that every format is
+// decoded by the same JSON tags and the same Duration parsing.
+func toJSON(ext string, data []byte) ([]byte, error) {
+	var doc map[string]any
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	default:
+		return data, nil
+	}
+	if doc == nil {
+		doc = map[string]any{}
+	}
+	return json.Marshal(doc)
+}
+
+// Server returns a websocket.Server running handler with this configuration. The
+// configuration must be valid, see Validate.
+func (c *Config) Server(handler websocket.Handler) *websocket.Server {
+	mode := websocket.Strict
+	if c.Mode == "lenient" {
+		mode = websocket.Lenient
+	}
+	return &websocket.Server{
+		Handler:          handler,
+		Mode:             mode,
+		ReusePort:        c.ReusePort,
+		ProxyProtocol:    c.ProxyProtocol,
+		HTTP2:            c.HTTP2,
+		TCP:              c.TCP.options(),
+		MaxConnections:   c.MaxConnections,
+		QueueConnections: c.QueueConnections,
+		HandshakeTimeout: time.Duration(c.HandshakeTimeout),
+		MaxHeaderBytes:   c.MaxHeaderBytes,
+		PingInterval:     time.Duration(c.PingInterval),
+		SendQueueSize:    c.SendQueueSize,
+		SendQueuePolicy:  queuePolicies[c.SendQueuePolicy],
+		MaxMessageSize:   c.MaxMessageSize,
+		MaxFrameSize:     uint64(c.MaxFrameSize),
+		FragmentSize:     c.FragmentSize,
+		RateLimit:        c.RateLimit.limit(),
+		GlobalRateLimit:  c.GlobalRateLimit.limit(),
+		AllowedOrigins:   c.AllowedOrigins,
+	}
+}
+
+// TLSConfig returns the TLS configuration to serve with, or nil without TLS.
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	if c.TLS == nil {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.HTTP2 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return config, nil
+}
+
+func (t TCP) options() websocket.TCPOptions {
+	var options websocket.TCPOptions
+	switch t.Profile {
+	case "latency":
+		options = websocket.LowLatency
+	case "throughput":
+		options = websocket.HighThroughput
+	}
+	if t.Nagle {
+		options.Nagle = true
+	}
+	if t.KeepAlive != 0 {
+		options.KeepAlive = time.Duration(t.KeepAlive)
+	}
+	if t.ReadBuffer > 0 {
+		options.ReadBuffer = t.ReadBuffer
+	}
+	if t.WriteBuffer > 0 {
+		options.WriteBuffer = t.WriteBuffer
+	}
+	return options
+}
+
+func (t TCP) validate() error {
+	if t.Profile != "" && t.Profile != "latency" && t.Profile != "throughput" {
+		return fmt.Errorf("profile must be \"latency\" or \"throughput\", got %q", t.Profile)
+	}
+	if t.ReadBuffer < 0 || t.WriteBuffer < 0 {
+		return fmt.Errorf("buffer sizes must not be negative")
+	}
+	return nil
+}
+
+func (r RateLimit) limit() websocket.RateLimit {
+	action := websocket.Throttle
+	if r.OnExceed == "close" {
+		action = websocket.ClosePolicyViolation
+	}
+	return websocket.RateLimit{
+		MessagesPerSecond: r.MessagesPerSecond,
+		MessageBurst:      r.MessageBurst,
+		BytesPerSecond:    r.BytesPerSecond,
+		ByteBurst:         r.ByteBurst,
+		OnExceed:          action,
+	}
+}
+
+// Report is the machine readable result of Validate.
+type Report struct {
+	Valid  bool    `json:"valid"`
+	Checks []Check `json:"checks"`
+}
+
+// Check is the outcome of one validation step.
+type Check struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+/**
+ * * Validate checks every setting of the configuration and reports each check separately.
+ *
+ * * Besides the values themselves it binds the listen and metrics addresses and releases them
+ * * right away, which catches ports taken by another process or needing privileges the server
+ * * does not have. The check therefore fails for addresses a running server already holds.
+ */
+func (c *Config) Validate() Report {
+	report := Report{Valid: true}
+	check := func(name string, err error) {
+		result := Check{Name: name, OK: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+			report.Valid = false
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	check("mode", c.validateMode())
+	check("max_connections", nonNegative(c.MaxConnections))
+	check("handshake_timeout", nonNegative(c.HandshakeTimeout))
+	check("max_header_bytes", nonNegative(c.MaxHeaderBytes))
+	check("max_message_size", nonNegative(c.MaxMessageSize))
+	check("max_frame_size", nonNegative(c.MaxFrameSize))
+	check("fragment_size", nonNegative(c.FragmentSize))
+	check("ping_interval", nonNegative(c.PingInterval))
+	check("idle_timeout", nonNegative(c.IdleTimeout))
+	check("send_queue_size", nonNegative(c.SendQueueSize))
+	check("send_queue_policy", c.validateSendQueuePolicy())
+	check("workers", nonNegative(c.Workers))
+	check("worker_queue", nonNegative(c.WorkerQueue))
+	check("shutdown_timeout", nonNegative(c.ShutdownTimeout))
+	check("rate_limit", c.RateLimit.validate())
+	check("global_rate_limit", c.GlobalRateLimit.validate())
+	check("tcp", c.TCP.validate())
+	if c.TLS != nil {
+		_, err := c.TLSConfig()
+		check("tls", err)
+	}
+	check("allowed_origins", c.validateOrigins())
+	check("extensions", c.validateExtensions())
+	check("addr", bind(c.Addr))
+	if c.ReusePort {
+		if _, ok := websocket.UnixPath(c.Addr); ok {
+			check("reuse_port", fmt.Errorf("needs a TCP addr, not a unix socket"))
+		} else {
+			check("reuse_port", bindReusePort(c.Addr))
+		}
+	}
+	if c.MetricsAddr != "" {
+		check("metrics_addr", bind(c.MetricsAddr))
+	}
+	return report
+}
+
+func (c *Config) validateMode() error {
+	if c.Mode != "strict" && c.Mode != "lenient" {
+		return fmt.Errorf("mode must be \"strict\" or \"lenient\", got %q", c.Mode)
+	}
+	return nil
+}
+
+// queuePolicies maps the values of send_queue_policy to websocket.QueuePolicy.
+var queuePolicies = map[string]websocket.QueuePolicy{
+	"":            websocket.DisconnectSlow,
+	"disconnect":  websocket.DisconnectSlow,
+	"drop_oldest": websocket.DropOldest,
+	"drop_newest": websocket.DropNewest,
+}
+
+func (c *Config) validateSendQueuePolicy() error {
+	if _, ok := queuePolicies[c.SendQueuePolicy]; !ok {
+		return fmt.Errorf("send_queue_policy must be \"disconnect\", \"drop_oldest\" or \"drop_newest\", got %q", c.SendQueuePolicy)
+	}
+	return nil
+}
+
+func (c *Config) validateOrigins() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("origin must look like scheme://host[:port] or be \"*\", got %q", origin)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateExtensions() error {
+	for _, extension := range c.Extensions {
+		if !slices.Contains(supportedExtensions, extension) {
+			return fmt.Errorf("extension %q is not supported", extension)
+		}
+	}
+	return nil
+}
+
+func (r RateLimit) validate() error {
+	if r.MessagesPerSecond < 0 || r.BytesPerSecond < 0 || r.MessageBurst < 0 || r.ByteBurst < 0 {
+		return fmt.Errorf("rates and bursts must not be negative")
+	}
+	if r.OnExceed != "" && r.OnExceed != "throttle" && r.OnExceed != "close" {
+		return fmt.Errorf("on_exceed must be \"throttle\" or \"close\", got %q", r.OnExceed)
+	}
+	return nil
+}
+
+func nonNegative[T int | int64 | Duration](v T) error {
+	if v < 0 {
+		return fmt.Errorf("must not be negative, got %v", v)
+	}
+	return nil
+}
+
+// bind listens on addr and closes the listener again.
+func bind(addr string) error {
+	if addr == "" {
+		return fmt.Errorf("address is required")
+	}
+	listener, err := websocket.Listen(addr)
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+// bindReusePort is bind with SO_REUSEPORT, which fails where it is not
+// supported.
+func bindReusePort(addr string) error {
+	listeners, err := websocket.ListenReusePort("tcp", addr, 1)
+	if err != nil {
+		return err
+	}
+	return listeners[0].Close()
+}
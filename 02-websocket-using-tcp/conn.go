@@ -0,0 +1,442 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket/id"
+)
+
+// Conn is the server side of a WebSocket connection.
+type Conn struct {
+	id     string
+	ids    id.Generator
+	conn   net.Conn
+	reader *bufio.Reader // Left over from the handshake, may hold the first frames.
+	mode   Mode
+
+	// writeMu is held while a frame is written, messageMu while the frames
+	// of a data message are. Control frames only take writeMu, so that they
+	// go out between the fragments of a long message instead of after it.
+	writeMu   sync.Mutex
+	messageMu sync.Mutex
+
+	// closeSent is set once a close frame went out, a connection sends at most one.
+	closeSent atomic.Bool
+
+	// reads counts the goroutines blocked reading a frame and peer records
+	// the client's close frame, for Close. polled is set while the reactor
+	// watches the connection.
+	reads  atomic.Int32
+	peer   peerClose
+	polled atomic.Bool
+
+	// MaxMessageSize is the largest message ReadJSON and Receive accept.
+	// Zero means defaultMaxMessageSize.
+	MaxMessageSize int64
+
+	// MaxFrameSize is the largest frame payload accepted from the peer,
+	// checked before the payload is allocated. Longer frames fail the
+	// connection with 1009. Zero means defaultMaxFrameSize.
+	MaxFrameSize uint64
+
+	// FragmentSize is the largest payload written in a single frame, longer
+	// messages are split into continuation frames. Zero means
+	// defaultFragmentSize, 65535 bytes.
+	FragmentSize int
+
+	// ReassemblyTimeout is how long a reader of NextReader waits for the
+	// remaining fragments of a message once the first one arrived. Slower
+	// messages fail the connection with 1002. Zero means
+	// defaultReassemblyTimeout.
+	ReassemblyTimeout time.Duration
+
+	// Codec encodes the values passed to Send and Receive, JSONCodec when nil.
+	Codec Codec
+
+	// Hooks observe the frames and errors of the connection, see Hooks.
+	Hooks Hooks
+
+	meta metadata
+	log  *slog.Logger
+
+	// session is created once, by Session.
+	session     *Session
+	sessionOnce sync.Once
+
+	// ctx is the connection's Context, cancel cancels it once it is closing.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// limiter and global enforce Server.RateLimit and Server.GlobalRateLimit.
+	limiter atomic.Pointer[limiter]
+	global  *limiter
+
+	stats connStats
+	wire  wireTrace
+
+	subprotocol string
+
+	// lastPing is the send time of the ping awaiting its pong, latency the
+	// round trip time of the latest pong, both in nanoseconds.
+	lastPing atomic.Int64
+	latency  atomic.Int64
+
+	// lastActive is when the latest data frame was read or written, in Unix
+	// nanoseconds, see LastActivity.
+	lastActive atomic.Int64
+
+	// connected is when the handshake completed, see ConnectedAt.
+	connected time.Time
+
+	// queue holds the messages of Enqueue, see Server.SendQueueSize.
+	queue sendQueue
+
+	// principal is replaced when a guest authenticates in-band, identityMu
+	// guards it. authenticate and rateLimit are the server's, for that upgrade.
+	identityMu   sync.RWMutex
+	principal    *Principal
+	authenticate Authenticator
+	rateLimit    RateLimit
+}
+
+// ID returns the identifier the server assigned to the connection, the
+// conn_id of its log records and lifecycle events.
+func (c *Conn) ID() string {
+	return c.id
+}
+
+// NewID returns a fresh identifier from the server's generator, for message
+// or session IDs that must sort and be unique like the connection IDs.
+func (c *Conn) NewID() string {
+	if c.ids == nil {
+		return id.Default.New()
+	}
+	return c.ids.New()
+}
+
+// Subprotocol returns the subprotocol selected during the handshake, see
+// Server.Subprotocols, or "" when none was.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the address of the client.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ConnectedAt returns when the handshake of the connection completed. A
+// resumed session is older, see Session.CreatedAt.
+func (c *Conn) ConnectedAt() time.Time {
+	return c.connected
+}
+
+// ReadFrame reads the next frame sent by the client.
+func (c *Conn) ReadFrame() (*Frame, error) {
+	c.reads.Add(1)
+	frame, err := c.wire.readFrame(c.reader, frameLimit(c.MaxFrameSize), c.Logger())
+	c.reads.Add(-1)
+	if err != nil {
+		return nil, c.fail(err)
+	}
+	c.Hooks.frameRead(frame)
+	c.markActive(frame.Opcode)
+
+	framesRead.Inc(frame.OpcodeName(), c.tenantLabel())
+	if !c.limiter.Load().admit(len(frame.Payload)) || !c.global.admit(len(frame.Payload)) {
+		c.Logger().Warn("Rate limit exceeded")
+		return nil, c.fail(&ErrProtocolError{Code: closePolicyViolation, Reason: "rate limit exceeded"})
+	}
+	c.Logger().Debug("Received frame", "opcode", frame.OpcodeName(), "fin", frame.Fin, "size", len(frame.Payload))
+
+	if err := checkFrame(frame, true, c.mode); err != nil {
+		return nil, c.fail(err)
+	}
+	c.stats.observe(frame)
+	return frame, nil
+}
+
+// ReadJSON reads the next message and decodes it as JSON into v.
+func (c *Conn) ReadJSON(v any) error {
+	_, r, err := c.NextReader()
+	if err != nil {
+		return err
+	}
+	return readJSON(r, c.MaxMessageSize, c.fail, v)
+}
+
+// ReadJSONContext is ReadJSON giving up when ctx is done, see bindContext.
+func (c *Conn) ReadJSONContext(ctx context.Context, v any) error {
+	defer bindContext(ctx, c.conn.SetReadDeadline)()
+	return contextError(ctx, c.ReadJSON(v))
+}
+
+// WriteJSON sends v encoded as JSON in a text message.
+func (c *Conn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(0x1, data)
+}
+
+// NextReader returns the opcode of the next message and a reader for its
+// payload. Continuation frames are pulled from the connection as the reader
+// is drained, pings received in between are answered with a pong. A close
+// frame from the client is reported as a CloseError. The reader must be drained
+// before NextReader is called again.
+func (c *Conn) NextReader() (byte, io.Reader, error) {
+	frame, err := c.nextDataFrame()
+	if err != nil {
+		return 0, nil, err
+	}
+	next := c.nextDataFrame
+	if !frame.Fin {
+		next = c.reassembly(time.Now())
+	}
+	r, err := newMessageReader(frame, next, c.fail, c.mode)
+	if err != nil {
+		return 0, nil, err
+	}
+	return r.opcode, r, nil
+}
+
+// reassembly returns the nextFrame of a message whose first fragment arrived
+// at start, failing the connection with 1002 unless the last one arrives
+// within ReassemblyTimeout. The clock interrupts the read waiting for a
+// fragment the way a canceled context does, see bindContext.
+func (c *Conn) reassembly(start time.Time) func() (*Frame, error) {
+	timeout := c.reassemblyTimeout()
+	var timer *time.Timer
+	return func() (*Frame, error) {
+		if timer == nil {
+			timer = time.AfterFunc(time.Until(start.Add(timeout)), func() {
+				c.conn.SetReadDeadline(time.Unix(1, 0))
+			})
+		}
+		frame, err := c.nextDataFrame()
+		if err == nil && !frame.Fin {
+			return frame, nil
+		}
+		if !timer.Stop() {
+			return nil, c.fail(errReassemblyTimeout(timeout))
+		}
+		return frame, err
+	}
+}
+
+// reassemblyTimeout returns ReassemblyTimeout, defaultReassemblyTimeout when
+// zero.
+func (c *Conn) reassemblyTimeout() time.Duration {
+	if c.ReassemblyTimeout == 0 {
+		return defaultReassemblyTimeout
+	}
+	return c.ReassemblyTimeout
+}
+
+// errReassemblyTimeout is the error a connection fails with when a fragmented
+// message was not completed within timeout.
+func errReassemblyTimeout(timeout time.Duration) error {
+	return &ErrProtocolError{Code: closeProtocolError, Reason: fmt.Sprintf("fragmented message not completed within %s", timeout)}
+}
+
+// Send encodes v with the connection's codec and sends it as one message.
+func (c *Conn) Send(v any) error {
+	codec := c.codec()
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(messageOpcode(codec), data)
+}
+
+// SendContext is Send giving up when ctx is done, see bindContext.
+func (c *Conn) SendContext(ctx context.Context, v any) error {
+	defer bindContext(ctx, c.conn.SetWriteDeadline)()
+	return contextError(ctx, c.Send(v))
+}
+
+// Receive reads the next message and decodes it into v with the connection's codec.
+func (c *Conn) Receive(v any) error {
+	_, r, err := c.NextReader()
+	if err != nil {
+		return err
+	}
+	data, err := readLimited(r, c.MaxMessageSize, c.fail)
+	if err != nil {
+		return err
+	}
+	return c.codec().Unmarshal(data, v)
+}
+
+// ReceiveContext is Receive giving up when ctx is done, see bindContext.
+func (c *Conn) ReceiveContext(ctx context.Context, v any) error {
+	defer bindContext(ctx, c.conn.SetReadDeadline)()
+	return contextError(ctx, c.Receive(v))
+}
+
+func (c *Conn) codec() Codec {
+	if c.Codec == nil {
+		return JSONCodec{}
+	}
+	return c.Codec
+}
+
+// nextDataFrame reads frames until a data frame arrives, answering pings on the way.
+func (c *Conn) nextDataFrame() (*Frame, error) {
+	for {
+		frame, err := c.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		if frame.Opcode < 0x8 {
+			return frame, nil
+		}
+		if err := c.control(frame); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// control handles a control frame: pings are answered, pongs measure the
+// latency and a close frame is echoed and reported as a CloseError.
+func (c *Conn) control(frame *Frame) error {
+	switch frame.OpcodeName() {
+	case "close":
+		closeCodes.Inc(closeCodeLabel(frame.Payload))
+		if err := checkClose(frame.Payload); err != nil {
+			if c.mode == Strict {
+				return c.fail(err)
+			}
+			frame.Payload = nil
+		}
+		c.Logger().Info("Closing connection", "code", closeCodeLabel(frame.Payload))
+		// Unless this answers our own close frame, echo it.
+		if !c.closeSent.Swap(true) {
+			c.writeControl(0x8, closeReply(frame.Payload))
+		}
+		c.closing()
+		err := closeError(frame.Payload)
+		c.peer.received(err)
+		return err
+	case "ping":
+		c.Logger().Debug("Received ping")
+		return c.writeControl(0xA, frame.Payload)
+	case "pong":
+		c.Logger().Debug("Received pong")
+		c.pongReceived(frame.Payload)
+	}
+	return nil
+}
+
+// fail sends the close frame matching a protocol error and returns err.
+func (c *Conn) fail(err error) error {
+	c.Hooks.failed(err)
+	c.closing()
+	var protoErr *ErrProtocolError
+	if errors.As(err, &protoErr) {
+		c.writeClose(protoErr.Code)
+	}
+	return err
+}
+
+// closing cancels the connection's Context.
+func (c *Conn) closing() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// writeClose writes a close frame carrying the given status code, unless one
+// was sent already.
+func (c *Conn) writeClose(code uint16) error {
+	if c.closeSent.Swap(true) {
+		return nil
+	}
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	return c.writeControl(0x8, payload)
+}
+
+// goAway starts the closing handshake with 1001 (going away) when the server
+// shuts down or drains. The client's answering close frame ends the
+// handler's reads.
+func (c *Conn) goAway() {
+	c.Logger().Info("Server going away, closing connection")
+	c.writeClose(closeGoingAway)
+}
+
+// NextWriter returns a writer for the next message. Data written to it is
+// sent as frames of the given opcode (0x1 text, 0x2 binary) followed by
+// continuation frames, and the message is finished by closing the writer.
+// Only one message is written at a time: NextWriter and WriteMessage wait
+// until the previous writer has been closed, which makes them safe to use
+// from several goroutines. Pongs and close frames go out between fragments.
+// Other opcodes are refused.
+func (c *Conn) NextWriter(opcode byte) (io.WriteCloser, error) {
+	if err := checkDataOpcode(opcode); err != nil {
+		return nil, err
+	}
+	c.messageMu.Lock()
+	return newMessageWriter(opcode, c.FragmentSize, c.writeDataFrame, c.messageMu.Unlock), nil
+}
+
+// WriteMessage sends data as a single message of the given opcode, in frames
+// of at most FragmentSize bytes.
+func (c *Conn) WriteMessage(opcode byte, data []byte) error {
+	// Fragments are sliced from data rather than copied to the writer's
+	// buffer, which would cost a broadcast to many connections an allocation
+	// each.
+	c.messageMu.Lock()
+	defer c.messageMu.Unlock()
+	return writeFragmented(c.writeDataFrame, opcode, data, c.FragmentSize)
+}
+
+// WriteMessageContext is WriteMessage giving up when ctx is done, see
+// bindContext.
+func (c *Conn) WriteMessageContext(ctx context.Context, opcode byte, data []byte) error {
+	defer bindContext(ctx, c.conn.SetWriteDeadline)()
+	return contextError(ctx, c.WriteMessage(opcode, data))
+}
+
+// writeControl writes a control frame, between two frames of the message
+// being written if there is one.
+func (c *Conn) writeControl(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrame(true, opcode, payload)
+}
+
+// writeDataFrame writes a frame of a data message, with messageMu held.
+func (c *Conn) writeDataFrame(fin bool, opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent.Load() {
+		return ErrCloseSent
+	}
+	return c.writeFrame(fin, opcode, payload)
+}
+
+// writeFrame writes a single unmasked frame, server frames are never masked.
+func (c *Conn) writeFrame(fin bool, opcode byte, payload []byte) error {
+	if err := c.wire.writeFrame(c.conn, fin, opcode, payload, false, c.Logger()); err != nil {
+		c.Hooks.failed(err)
+		return err
+	}
+	c.Hooks.frameWritten(fin, opcode, payload, false)
+	c.stats.observeWrite(payload)
+	c.markActive(opcode)
+	framesWritten.Inc((&Frame{Opcode: opcode}).OpcodeName(), c.tenantLabel())
+	return nil
+}
@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+type contextKey int
+
+const (
+	connKey contextKey = iota
+	traceIDKey
+)
+
+// Context returns the context of the connection. It carries the Conn, and so
+// its principal, to code that only receives the context, and whatever the
+// server's ConnContext added. It is canceled once the connection is closing:
+// the client sent a close frame, the connection failed or the handler
+// returned.
+func (c *Conn) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// ConnFromContext returns the connection whose Context ctx is or derives from.
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	conn, ok := ctx.Value(connKey).(*Conn)
+	return conn, ok
+}
+
+// PrincipalFromContext returns the principal of the connection of ctx, see
+// Conn.Principal.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	conn, ok := ConnFromContext(ctx)
+	if !ok {
+		return Principal{}, false
+	}
+	return conn.Principal()
+}
+
+// WithTraceID returns a copy of ctx carrying the trace ID of the message
+// being handled, so that the code it causes to run can log it.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey, traceID)
+}
+
+// TraceIDFromContext returns the trace ID stored by WithTraceID.
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	traceID, ok := ctx.Value(traceIDKey).(string)
+	return traceID, ok
+}
+
+/**
+ * * bindContext applies ctx to the reads or writes of a connection for the duration of one call,
+ * * setDeadline being its SetReadDeadline or SetWriteDeadline: the deadline of ctx becomes the I/O
+ * * deadline and canceling ctx moves the deadline to the past, which interrupts the blocked call.
+ * * The returned function clears the deadline again.
+ *
+ * * An interrupted call may leave half a frame read or written, the connection cannot be used any
+ * * longer and should be closed.
+ */
+func bindContext(ctx context.Context, setDeadline func(time.Time) error) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		setDeadline(deadline)
+	}
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(interrupted)
+		setDeadline(time.Unix(1, 0))
+	})
+	return func() {
+		if !stop() {
+			<-interrupted
+		}
+		setDeadline(time.Time{})
+	}
+}
+
+// contextError returns the error of ctx when it is the reason err happened,
+// err otherwise.
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	// The I/O deadline may fire a moment before the context's own timer.
+	if deadline, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
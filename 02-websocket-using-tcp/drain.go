@@ -0,0 +1,114 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRetryAfter is the Retry-After of the handshakes refused while the
+// server drains, when Drain is given none.
+const defaultRetryAfter = 5 * time.Second
+
+// ErrDraining is the error of the handshakes refused while the server
+// drains, see Drain.
+var ErrDraining = errors.New("websocket: server draining")
+
+/**
+ * * Drain prepares the server for a rolling deploy. From now on handshakes are refused with 503
+ * * Service Unavailable and a Retry-After of retryAfter, defaultRetryAfter when zero, while the
+ * * listeners stay open: load balancers take the instance out and clients retry on another one.
+ * * The open connections go on until they end, or until ctx is done: the remaining ones are then
+ * * sent a 1001 (going away) close frame, for their clients to reconnect elsewhere, and are given
+ * * idleCloseGrace to answer it before their TCP connections are closed. Drain then returns
+ * * ctx.Err().
+ *
+ * * A drained server still has to be shut down, which is immediate once no connection is left.
+ * * Drain returns ErrServerClosed when Shutdown was called already.
+ */
+func (s *Server) Drain(ctx context.Context, retryAfter time.Duration) error {
+	s.init()
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	if s.drained == nil {
+		s.drained = make(chan struct{})
+		if len(s.conns) == 0 {
+			close(s.drained)
+		}
+	}
+	s.retryAfter = retryAfter
+	drained := s.drained
+	s.mu.Unlock()
+	s.logger().Info("Draining, refusing new handshakes", "retry_after", retryAfter)
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+	conns := s.Conns()
+	s.logger().Info("Drain deadline passed, asking the remaining connections to go away", "connections", len(conns))
+	for _, c := range conns {
+		c.goAway()
+	}
+	grace := time.NewTimer(idleCloseGrace)
+	defer grace.Stop()
+	select {
+	case <-drained:
+	case <-grace.C:
+		// Clients that never answer the close frame would hold the drain
+		// forever, as would handlers that stopped reading
+		conns = s.Conns()
+		s.logger().Info("Closing the connections that did not answer", "connections", len(conns))
+		for _, c := range conns {
+			c.conn.Close()
+		}
+		<-drained
+	}
+	return ctx.Err()
+}
+
+// Draining reports whether Drain was called.
+func (s *Server) Draining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.drained != nil
+}
+
+// Conns returns the connections open on the server, upgraded and not
+// closed yet.
+func (s *Server) Conns() []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// rejectHeader returns the headers of the response refusing a handshake
+// with status because of err.
+func (s *Server) rejectHeader(err error, status int) http.Header {
+	header := make(http.Header)
+	if status == http.StatusUpgradeRequired {
+		header.Set("Sec-WebSocket-Version", "13")
+	}
+	if errors.Is(err, ErrDraining) {
+		s.mu.Lock()
+		seconds := math.Ceil(s.retryAfter.Seconds())
+		s.mu.Unlock()
+		header.Set("Retry-After", strconv.Itoa(int(max(seconds, 1))))
+	}
+	return header
+}
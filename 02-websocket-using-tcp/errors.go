@@ -0,0 +1,77 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors returned by the package, to be checked with errors.Is.
+var (
+	// ErrBadHandshake wraps the reasons an opening handshake was refused,
+	// on either side: missing or invalid headers, an unexpected status.
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+
+	// ErrMessageTooBig is returned, wrapped in an ErrProtocolError with code
+	// 1009, for messages longer than MaxMessageSize and frames longer than
+	// MaxFrameSize. The connection was failed with 1009 and is done.
+	ErrMessageTooBig = errors.New("websocket: message too big")
+
+	// ErrUnexpectedContinuation is wrapped in the ErrProtocolError returned
+	// when a continuation frame arrives with no message to continue, or a new
+	// message starts before the previous one was finished.
+	ErrUnexpectedContinuation = errors.New("websocket: unexpected continuation frame")
+
+	// ErrCloseSent is returned by writes once a close frame went out, after
+	// which RFC 6455 allows no more data frames.
+	ErrCloseSent = errors.New("websocket: close frame already sent")
+)
+
+// ErrProtocolError is a violation of RFC 6455 by the peer. The connection
+// that detects it is failed with a close frame carrying Code.
+type ErrProtocolError struct {
+	Code   uint16
+	Reason string
+
+	err error // Sentinel matched by errors.Is, if any.
+}
+
+func (e *ErrProtocolError) Error() string {
+	return fmt.Sprintf("websocket protocol error %d: %s", e.Code, e.Reason)
+}
+
+func (e *ErrProtocolError) Unwrap() error {
+	return e.err
+}
+
+/**
+ * * CloseError is returned by reads once the peer sent a close frame, with the status code and
+ * * reason it carried. A close frame without a status code is reported with 1005 (no status).
+ *
+ * * errors.Is(err, io.EOF) holds for a CloseError: the peer closing the connection is the end of
+ * * the stream, whatever its code, and code written against the io.EOF of earlier versions keeps
+ * * working.
+ */
+type CloseError struct {
+	Code   uint16
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed by peer with %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed by peer with %d: %s", e.Code, e.Reason)
+}
+
+func (e *CloseError) Is(target error) bool {
+	return target == io.EOF
+}
+
+// closeError returns the CloseError of a validated close frame payload.
+func closeError(payload []byte) *CloseError {
+	if len(payload) < 2 {
+		return &CloseError{Code: closeNoStatus}
+	}
+	return &CloseError{Code: uint16(payload[0])<<8 | uint16(payload[1]), Reason: string(payload[2:])}
+}
@@ -0,0 +1,102 @@
+// Package events is an in-process publish/subscribe bus for connection
+// lifecycle events. Servers publish what happens to their connections and
+// observability code (metrics, logging, admin endpoints) subscribes to it,
+// so that none of it has to live in the connection handling code.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Kind is the lifecycle stage an Event reports.
+type Kind int
+
+const (
+	// Accepted is published when a TCP connection is accepted, before the
+	// opening handshake.
+	Accepted Kind = iota
+	// Upgraded is published once the 101 response has been sent.
+	Upgraded
+	// Closed is published when the handler of an upgraded connection returns.
+	Closed
+	// Errored is published when the opening handshake fails or is rejected.
+	// The connection is closed without a Closed event.
+	Errored
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Accepted:
+		return "accepted"
+	case Upgraded:
+		return "upgraded"
+	case Closed:
+		return "closed"
+	case Errored:
+		return "errored"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes one lifecycle change of a connection.
+type Event struct {
+	Kind       Kind
+	Time       time.Time
+	ConnID     string
+	RemoteAddr string
+
+	// Err is the reason of an Errored event and Status the HTTP status the
+	// handshake was rejected with, zero when no response could be sent.
+	Err    error
+	Status int
+}
+
+// Bus delivers published events to every subscriber. Its zero value is ready
+// to use.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[int]func(Event)
+	next        int
+}
+
+// Default is the bus servers publish to unless configured otherwise.
+var Default = &Bus{}
+
+// Subscribe registers fn to receive every event published from now on and
+// returns a function removing it again.
+//
+// Events are delivered synchronously on the publishing goroutine, in the
+// order they were published for a given connection. fn must therefore be
+// fast and must not block, hand the event to a goroutine for slow work.
+func (b *Bus) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers == nil {
+		b.subscribers = make(map[int]func(Event))
+	}
+	id := b.next
+	b.next++
+	b.subscribers[id] = fn
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// Publish delivers event to every subscriber, setting its Time when unset.
+func (b *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.subscribers {
+		fn(event)
+	}
+}
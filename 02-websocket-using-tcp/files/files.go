@@ -0,0 +1,358 @@
+/**
+ * * Package files transfers files over a WebSocket, in both directions. Connections negotiate the
+ * * "files" subprotocol, and every transfer is a JSON control message followed by the content of
+ * * the file in one binary message, fragmented into frames of FragmentSize, so that neither side
+ * * holds a file in memory:
+ *
+ *	client: {"type":"upload","name":"a.iso","size":4096,"sha256":"9f86..."}
+ *	server: {"type":"ready"}
+ *	client: binary message, the 4096 bytes
+ *	server: {"type":"progress","received":2048,"size":4096}   every tenth of the file
+ *	server: {"type":"done","name":"a.iso","size":4096,"sha256":"9f86..."}
+ *
+ *	client: {"type":"download","name":"a.iso"}
+ *	server: {"type":"file","name":"a.iso","size":4096,"sha256":"9f86..."}
+ *	server: binary message, the 4096 bytes
+ *
+ * * A server refusing a request, or a file whose checksum does not match, answers
+ * * {"type":"error","error":"..."} instead. An upload is written to a temporary file in the directory
+ * * of the Receiver and renamed once its checksum matched, a failed one leaves nothing behind.
+ */
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"websocket"
+)
+
+// Subprotocol is the name clients offer in Sec-WebSocket-Protocol.
+const Subprotocol = "files"
+
+// defaultMaxSize is the largest file a Receiver accepts when MaxSize is zero.
+const defaultMaxSize = 100 << 20
+
+// ErrRefused is wrapped by the errors of Upload and Download the server
+// answered with.
+var ErrRefused = errors.New("files: refused by the server")
+
+// control is a control message, of either side.
+type control struct {
+	Type     string `json:"type"`
+	Name     string `json:"name,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	Received int64  `json:"received,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Progress is called with the bytes of a file transferred so far and its
+// size.
+type Progress func(done, total int64)
+
+// Receiver stores the files uploaded to it in Dir and serves the ones there
+// to downloads. Its Handle is the websocket.Handler of the connections that
+// negotiated Subprotocol.
+type Receiver struct {
+	// Dir is the directory files are stored in and served from.
+	Dir string
+
+	// MaxSize is the largest file accepted, larger uploads are refused
+	// before they start. Zero means 100MB.
+	MaxSize int64
+}
+
+// Handle serves the transfers of conn, one after the other, until it is
+// closed.
+func (r *Receiver) Handle(conn *websocket.Conn) {
+	if conn.Subprotocol() != Subprotocol {
+		conn.Close(1002, "files subprotocol required")
+		return
+	}
+	for {
+		var request control
+		if err := conn.ReadJSON(&request); err != nil {
+			conn.Logger().Info("File transfers ended", "err", err)
+			return
+		}
+		var err error
+		switch request.Type {
+		case "upload":
+			err = r.receive(conn, request)
+		case "download":
+			err = r.send(conn, request.Name)
+		default:
+			err = refuse(conn, fmt.Sprintf("unknown request %q", request.Type))
+		}
+		if err != nil {
+			conn.Logger().Warn("File transfer failed", "name", request.Name, "err", err)
+			return
+		}
+	}
+}
+
+// receive receives the upload request announces. It returns an error only
+// when the connection cannot go on, the refusals are answered.
+func (r *Receiver) receive(conn *websocket.Conn, request control) error {
+	maxSize := r.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	switch {
+	case !validName(request.Name):
+		return refuse(conn, fmt.Sprintf("invalid file name %q", request.Name))
+	case request.Size < 0 || request.Size > maxSize:
+		return refuse(conn, fmt.Sprintf("%d bytes is over the limit of %d", request.Size, maxSize))
+	case len(request.SHA256) != 2*sha256.Size:
+		return refuse(conn, "sha256 missing")
+	}
+	part, err := os.CreateTemp(r.Dir, "."+request.Name+".*.part")
+	if err != nil {
+		return refuse(conn, "cannot store the file")
+	}
+	defer os.Remove(part.Name())
+	defer part.Close()
+	if err := conn.WriteJSON(control{Type: "ready"}); err != nil {
+		return err
+	}
+
+	opcode, data, err := conn.NextReader()
+	if err != nil {
+		return err
+	}
+	if opcode != 0x2 {
+		conn.Close(1003, "file content expected in a binary message")
+		return errors.New("file content not in a binary message")
+	}
+	hash := sha256.New()
+	progress := &progressWriter{total: request.Size, report: func(done, total int64) {
+		conn.WriteJSON(control{Type: "progress", Received: done, Size: total})
+	}}
+	// One byte more than announced is enough to know the file is too large
+	n, err := io.Copy(io.MultiWriter(part, hash, progress), io.LimitReader(data, request.Size+1))
+	if err != nil {
+		return err
+	}
+	if n > request.Size {
+		conn.Close(1009, "file larger than announced")
+		return fmt.Errorf("file larger than the %d bytes announced", request.Size)
+	}
+	if n < request.Size {
+		return refuse(conn, fmt.Sprintf("%d of %d bytes received", n, request.Size))
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if sum != request.SHA256 {
+		return refuse(conn, fmt.Sprintf("sha256 mismatch, received %s", sum))
+	}
+	// CreateTemp makes the file private to the server
+	if err := part.Chmod(0o644); err != nil {
+		return refuse(conn, "cannot store the file")
+	}
+	if err := part.Close(); err != nil {
+		return refuse(conn, "cannot store the file")
+	}
+	if err := os.Rename(part.Name(), filepath.Join(r.Dir, request.Name)); err != nil {
+		return refuse(conn, "cannot store the file")
+	}
+	conn.Logger().Info("File received", "name", request.Name, "size", n)
+	return conn.WriteJSON(control{Type: "done", Name: request.Name, Size: n, SHA256: sum})
+}
+
+// send sends the file called name in the directory.
+func (r *Receiver) send(conn *websocket.Conn, name string) error {
+	if !validName(name) {
+		return refuse(conn, fmt.Sprintf("invalid file name %q", name))
+	}
+	file, err := os.Open(filepath.Join(r.Dir, name))
+	if err != nil {
+		return refuse(conn, fmt.Sprintf("no file %q", name))
+	}
+	defer file.Close()
+	size, sum, err := checksum(file)
+	if err != nil {
+		return refuse(conn, "cannot read the file")
+	}
+	if err := conn.WriteJSON(control{Type: "file", Name: name, Size: size, SHA256: sum}); err != nil {
+		return err
+	}
+	w, err := conn.NextWriter(0x2)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, io.LimitReader(file, size)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	conn.Logger().Info("File sent", "name", name, "size", size)
+	return nil
+}
+
+// Upload sends the file at path to the server over client, which negotiated
+// Subprotocol, and calls progress with the bytes the server reports received.
+func Upload(client *websocket.Client, path string, progress Progress) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	size, sum, err := checksum(file)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	if err := client.WriteJSON(control{Type: "upload", Name: name, Size: size, SHA256: sum}); err != nil {
+		return err
+	}
+	if _, err := answer(client, "ready"); err != nil {
+		return err
+	}
+
+	// The progress of the server comes in while the content goes out
+	result := make(chan error, 1)
+	go func() {
+		for {
+			var event control
+			if err := client.ReadJSON(&event); err != nil {
+				result <- err
+				return
+			}
+			switch event.Type {
+			case "progress":
+				if progress != nil {
+					progress(event.Received, event.Size)
+				}
+			case "done":
+				if event.SHA256 != sum {
+					result <- fmt.Errorf("files: the server stored sha256 %s, sent %s", event.SHA256, sum)
+					return
+				}
+				result <- nil
+				return
+			case "error":
+				result <- fmt.Errorf("%w: %s", ErrRefused, event.Error)
+				return
+			}
+		}
+	}()
+	w, err := client.NextWriter(0x2)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, io.LimitReader(file, size)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return <-result
+}
+
+// Download receives the file called name from the server over client, which
+// negotiated Subprotocol, into dir and returns its path. progress is called
+// as the bytes arrive.
+func Download(client *websocket.Client, name, dir string, progress Progress) (string, error) {
+	if err := client.WriteJSON(control{Type: "download", Name: name}); err != nil {
+		return "", err
+	}
+	info, err := answer(client, "file")
+	if err != nil {
+		return "", err
+	}
+	if !validName(info.Name) {
+		return "", fmt.Errorf("files: invalid file name %q from the server", info.Name)
+	}
+	part, err := os.CreateTemp(dir, "."+info.Name+".*.part")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(part.Name())
+	defer part.Close()
+
+	opcode, data, err := client.NextReader()
+	if err != nil {
+		return "", err
+	}
+	if opcode != 0x2 {
+		return "", errors.New("files: file content not in a binary message")
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(part, hash, &progressWriter{total: info.Size, report: progress}), data)
+	if err != nil {
+		return "", err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); n != info.Size || sum != info.SHA256 {
+		return "", fmt.Errorf("files: received %d bytes with sha256 %s, announced %d with %s", n, sum, info.Size, info.SHA256)
+	}
+	if err := part.Close(); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, info.Name)
+	return path, os.Rename(part.Name(), path)
+}
+
+// answer reads the answer of the server to a request, which is want unless
+// the server refused it.
+func answer(client *websocket.Client, want string) (control, error) {
+	var reply control
+	if err := client.ReadJSON(&reply); err != nil {
+		return control{}, err
+	}
+	switch reply.Type {
+	case want:
+		return reply, nil
+	case "error":
+		return control{}, fmt.Errorf("%w: %s", ErrRefused, reply.Error)
+	}
+	return control{}, fmt.Errorf("files: %q answered, %q expected", reply.Type, want)
+}
+
+// refuse answers a request with an error message.
+func refuse(conn *websocket.Conn, reason string) error {
+	conn.Logger().Info("File transfer refused", "reason", reason)
+	return conn.WriteJSON(control{Type: "error", Error: reason})
+}
+
+// validName reports whether name is a file name of the directory, without a
+// path that would get out of it.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name && filepath.IsLocal(name)
+}
+
+// checksum returns the size and the hex SHA-256 of file, and rewinds it.
+func checksum(file *os.File) (int64, string, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// progressWriter counts the bytes written to it and reports them to report
+// every tenth of total, and at the end.
+type progressWriter struct {
+	total  int64
+	done   int64
+	next   int64
+	report Progress
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if p.report != nil && (p.done >= p.next || p.done == p.total) {
+		p.report(p.done, p.total)
+		p.next = p.done + max(p.total/10, 1)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,319 @@
+/**
+ * * Package graphqlws serves GraphQL over WebSocket with the graphql-transport-ws subprotocol, the
+ * * one of the graphql-ws client used by Apollo, urql and Relay. Add Subprotocol to
+ * * websocket.Server.Subprotocols and run Server.Handle as the handler:
+ *
+ *	client: {"type":"connection_init","payload":{"token":"..."}}
+ *	server: {"type":"connection_ack"}
+ *	client: {"id":"1","type":"subscribe","payload":{"query":"subscription { ticks }"}}
+ *	server: {"id":"1","type":"next","payload":{"data":{"ticks":1}}}
+ *	server: {"id":"1","type":"next","payload":{"data":{"ticks":2}}}
+ *	client: {"id":"1","type":"complete"}
+ *
+ * * Either side may ping at any time and gets a pong. Protocol violations close the connection with
+ * * the 44xx codes of the specification. Executing the operations is left to Server.Subscribe, which
+ * * would typically hand them to a GraphQL library.
+ */
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket"
+)
+
+// Subprotocol is the name negotiated in Sec-WebSocket-Protocol.
+const Subprotocol = "graphql-transport-ws"
+
+// defaultInitTimeout is how long clients have to send connection_init when
+// Server.InitTimeout is zero, the default of the graphql-ws server.
+const defaultInitTimeout = 3 * time.Second
+
+// The message types of the protocol.
+const (
+	typeConnectionInit = "connection_init"
+	typeConnectionAck  = "connection_ack"
+	typePing           = "ping"
+	typePong           = "pong"
+	typeSubscribe      = "subscribe"
+	typeNext           = "next"
+	typeError          = "error"
+	typeComplete       = "complete"
+)
+
+// The close codes of the protocol.
+const (
+	closeInvalidMessage  = 4400
+	closeUnauthorized    = 4401
+	closeForbidden       = 4403
+	closeBadSubprotocol  = 4406
+	closeInitTimeout     = 4408
+	closeSubscriberTaken = 4409
+	closeTooManyInits    = 4429
+)
+
+// Message is a message of the protocol in either direction.
+type Message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SubscribePayload is the operation a subscribe message asks to execute.
+type SubscribePayload struct {
+	OperationName string         `json:"operationName,omitempty"`
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	Extensions    map[string]any `json:"extensions,omitempty"`
+}
+
+// Result is one execution result, the payload of a next message.
+type Result struct {
+	Data       any            `json:"data,omitempty"`
+	Errors     Errors         `json:"errors,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// Error is a GraphQL error as the specification formats them.
+type Error struct {
+	Message    string         `json:"message"`
+	Locations  []Location     `json:"locations,omitempty"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// Location points at the part of the query an Error is about.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Errors are the errors of an operation that could not be executed, sent
+// to the client in an error message when Subscribe returns them.
+type Errors []Error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+	return "graphql: " + strings.Join(messages, "; ")
+}
+
+// Server runs the operations of graphql-transport-ws connections.
+type Server struct {
+	/**
+	 * * Subscribe executes an operation and returns its results: one for a query or a mutation, one
+	 * * per event for a subscription. It closes the channel once the operation completed, and must
+	 * * stop sending when ctx is done, which happens when the client completes it or leaves. An
+	 * * error, Errors for validation failures, is sent to the client in an error message.
+	 */
+	Subscribe func(ctx context.Context, payload SubscribePayload) (<-chan Result, error)
+
+	// OnConnect, when set, checks the payload of connection_init, the
+	// place clients put their credentials, and returns the payload of
+	// connection_ack. An error closes the connection with 4403.
+	OnConnect func(ctx context.Context, payload json.RawMessage) (any, error)
+
+	// InitTimeout is how long clients have to send connection_init before
+	// the connection is closed with 4408. Zero means 3 seconds.
+	InitTimeout time.Duration
+}
+
+// Handle serves a connection, it is a websocket.Handler.
+func (s *Server) Handle(conn *websocket.Conn) {
+	if conn.Subprotocol() != Subprotocol {
+		conn.Close(closeBadSubprotocol, "Subprotocol not acceptable")
+		return
+	}
+	session := &session{
+		server:     s,
+		conn:       conn,
+		operations: make(map[string]*operation),
+	}
+	// After closeWith the read loop ends on the closed connection.
+	if err := session.serve(); errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		conn.Logger().Info("GraphQL client disconnected")
+	} else {
+		conn.Logger().Warn("Error reading WebSocket message", "err", err)
+	}
+}
+
+// session is the state of one connection.
+type session struct {
+	server *Server
+	conn   *websocket.Conn
+
+	initialised atomic.Bool
+	acked       atomic.Bool
+
+	mu         sync.Mutex
+	operations map[string]*operation
+	running    sync.WaitGroup
+}
+
+// operation is a subscribe message being executed.
+type operation struct {
+	cancel context.CancelFunc
+}
+
+func (s *session) serve() error {
+	timeout := s.server.InitTimeout
+	if timeout <= 0 {
+		timeout = defaultInitTimeout
+	}
+	timer := time.AfterFunc(timeout, func() {
+		if !s.acked.Load() {
+			s.conn.Close(closeInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer timer.Stop()
+
+	// The operations end with the connection, before Handle returns.
+	ctx, cancel := context.WithCancel(s.conn.Context())
+	defer s.running.Wait()
+	defer cancel()
+
+	return s.conn.ReadLoop(nil, func(opcode byte, data []byte) error {
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return s.invalid("Invalid message received")
+		}
+		return s.handle(ctx, msg)
+	})
+}
+
+func (s *session) handle(ctx context.Context, msg Message) error {
+	switch msg.Type {
+	case typeConnectionInit:
+		if s.initialised.Swap(true) {
+			return s.closeWith(closeTooManyInits, "Too many initialisation requests")
+		}
+		var ack any
+		if s.server.OnConnect != nil {
+			var err error
+			if ack, err = s.server.OnConnect(ctx, msg.Payload); err != nil {
+				s.conn.Logger().Warn("GraphQL connection refused", "err", err)
+				return s.closeWith(closeForbidden, "Forbidden")
+			}
+		}
+		s.acked.Store(true)
+		return s.send("", typeConnectionAck, ack)
+	case typePing:
+		return s.send("", typePong, msg.Payload)
+	case typePong:
+		return nil
+	case typeSubscribe:
+		if !s.acked.Load() {
+			return s.closeWith(closeUnauthorized, "Unauthorized")
+		}
+		var payload SubscribePayload
+		if msg.ID == "" || json.Unmarshal(msg.Payload, &payload) != nil || payload.Query == "" {
+			return s.invalid("Invalid subscribe message")
+		}
+		return s.subscribe(ctx, msg.ID, payload)
+	case typeComplete:
+		s.mu.Lock()
+		op := s.operations[msg.ID]
+		delete(s.operations, msg.ID)
+		s.mu.Unlock()
+		if op != nil {
+			op.cancel()
+		}
+		return nil
+	default:
+		return s.invalid(fmt.Sprintf("Invalid message type %q", msg.Type))
+	}
+}
+
+// subscribe starts executing an operation on a goroutine of its own, so that
+// subscriptions run side by side and the connection keeps being read.
+func (s *session) subscribe(ctx context.Context, id string, payload SubscribePayload) error {
+	ctx, cancel := context.WithCancel(ctx)
+	op := &operation{cancel: cancel}
+	s.mu.Lock()
+	if _, ok := s.operations[id]; ok {
+		s.mu.Unlock()
+		cancel()
+		return s.closeWith(closeSubscriberTaken, fmt.Sprintf("Subscriber for %s already exists", id))
+	}
+	s.operations[id] = op
+	s.mu.Unlock()
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer cancel()
+		if s.run(ctx, id, payload) {
+			s.send(id, typeComplete, nil)
+		}
+		s.mu.Lock()
+		if s.operations[id] == op {
+			delete(s.operations, id)
+		}
+		s.mu.Unlock()
+	}()
+	return nil
+}
+
+// run sends the results of an operation and reports whether it ran to its
+// end, which the client is told with complete. Operations the client
+// completed itself, or that failed to start, are not completed again.
+func (s *session) run(ctx context.Context, id string, payload SubscribePayload) bool {
+	results, err := s.server.Subscribe(ctx, payload)
+	if err != nil {
+		var graphqlErrs Errors
+		if !errors.As(err, &graphqlErrs) {
+			graphqlErrs = Errors{{Message: err.Error()}}
+		}
+		s.send(id, typeError, graphqlErrs)
+		return false
+	}
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				return ctx.Err() == nil
+			}
+			if err := s.send(id, typeNext, result); err != nil {
+				return false
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (s *session) send(id, typ string, payload any) error {
+	msg := Message{ID: id, Type: typ}
+	if raw, ok := payload.(json.RawMessage); ok {
+		msg.Payload = raw
+	} else if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		msg.Payload = data
+	}
+	return s.conn.WriteJSON(msg)
+}
+
+func (s *session) invalid(reason string) error {
+	return s.closeWith(closeInvalidMessage, reason)
+}
+
+// closeWith closes the connection with one of the protocol's codes.
+func (s *session) closeWith(code uint16, reason string) error {
+	s.conn.Logger().Warn("Closing GraphQL connection", "code", code, "reason", reason)
+	s.conn.Close(code, reason)
+	return nil
+}
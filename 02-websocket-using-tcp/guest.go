@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// defaultGuestTTL is how long a guest may stay connected without
+// authenticating, unless Server.GuestTTL says otherwise.
+const defaultGuestTTL = 15 * time.Minute
+
+var errNoAuthenticator = errors.New("server has no Authenticate hook")
+
+/**
+ * * Authenticate upgrades a guest connection to an authenticated identity without reconnecting,
+ * * for clients that log in after connecting. token is validated by the server's Authenticate
+ * * hook as if it had been sent as "Authorization: Bearer <token>" during the handshake.
+ *
+ * * On success the connection takes the new principal, leaves the guest rate limit for the
+ * * server's RateLimit and is no longer disconnected after GuestTTL. It also works for
+ * * connections that are authenticated already, to switch identities. Use Rooms.Authenticate
+ * * instead when the connection is in rooms, so their presence moves along.
+ */
+func (c *Conn) Authenticate(token string) (Principal, error) {
+	principal, err := c.verify(token)
+	if err != nil {
+		return Principal{}, err
+	}
+	c.become(principal)
+	return principal, nil
+}
+
+// verify runs the server's Authenticate hook on token, without changing the
+// identity of the connection.
+func (c *Conn) verify(token string) (Principal, error) {
+	if c.authenticate == nil {
+		return Principal{}, errNoAuthenticator
+	}
+	request, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		return Principal{}, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return c.authenticate(request)
+}
+
+// become makes principal, verified, the identity of the connection.
+func (c *Conn) become(principal Principal) {
+	c.identityMu.Lock()
+	wasGuest := c.principal != nil && c.principal.Guest
+	c.principal = &principal
+	c.identityMu.Unlock()
+
+	if wasGuest {
+		c.limiter.Store(newLimiter(c.rateLimit))
+	}
+	c.Logger().Info("Connection authenticated in-band", "principal", principal.ID)
+}
+
+// IsGuest reports whether the connection is a guest session.
+func (c *Conn) IsGuest() bool {
+	principal, ok := c.Principal()
+	return ok && principal.Guest
+}
+
+// expireGuest closes the connection with 1008 after ttl (defaultGuestTTL when
+// zero) unless it authenticated by then. The returned timer must be stopped
+// once the connection is done.
+func (c *Conn) expireGuest(ttl time.Duration) *time.Timer {
+	if ttl <= 0 {
+		ttl = defaultGuestTTL
+	}
+	return time.AfterFunc(ttl, func() {
+		if !c.IsGuest() {
+			return
+		}
+		c.Logger().Info("Guest session expired")
+		c.writeClose(closePolicyViolation)
+		c.conn.Close()
+	})
+}
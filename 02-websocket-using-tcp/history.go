@@ -0,0 +1,210 @@
+package websocket
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// history is the ring buffer of recent messages of one room. With an outbox
+// it only numbers the messages, the outbox keeps them.
+type history struct {
+	size   int
+	ttl    time.Duration
+	outbox Outbox
+	keep   sync.Once // Subscribes for good to a room with an outbox.
+
+	mu      sync.Mutex
+	seq     uint64 // Sequence number of the last message added.
+	entries []historyEntry
+	next    int // Index the next entry is written to once entries is full.
+}
+
+type historyEntry struct {
+	msg RoomMessage
+	at  time.Time
+}
+
+// defaultMaxRooms is how many rooms have a history unless Rooms.MaxRooms
+// says otherwise.
+const defaultMaxRooms = 10_000
+
+// history returns the history of room, creating it on first use, or nil
+// when the Rooms keep none or MaxRooms have one already.
+func (r *Rooms) history(room string) *history {
+	if r.HistorySize <= 0 && r.Outbox == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.histories[room]
+	if !ok {
+		limit := r.MaxRooms
+		if limit <= 0 {
+			limit = defaultMaxRooms
+		}
+		if len(r.histories) >= limit {
+			slog.Warn("Room limit reached, room kept without history", "room", room, "max_rooms", limit)
+			return nil
+		}
+		if r.Outbox == nil {
+			h = &history{size: r.HistorySize, ttl: r.HistoryTTL}
+		} else {
+			// The outbox replaces the buffer, and the numbering goes on
+			// from its journal, of a previous run maybe
+			h = &history{outbox: r.Outbox}
+			var err error
+			if h.seq, err = r.Outbox.Last(room); err != nil {
+				slog.Error("Error reading the outbox", "room", room, "err", err)
+			}
+		}
+		r.histories[room] = h
+	}
+	return h
+}
+
+// add numbers msg and stores it, evicting the oldest message when the buffer
+// is full. The caller must hold h.mu.
+func (h *history) add(msg RoomMessage) uint64 {
+	h.seq++
+	msg.Seq = h.seq
+	if h.size <= 0 {
+		return h.seq
+	}
+	entry := historyEntry{msg: msg, at: time.Now()}
+	if len(h.entries) < h.size {
+		h.entries = append(h.entries, entry)
+	} else {
+		h.entries[h.next] = entry
+		h.next = (h.next + 1) % h.size
+	}
+	return h.seq
+}
+
+// since returns the buffered messages after seq that have not expired, in
+// order. The caller must hold h.mu.
+func (h *history) since(seq uint64) []RoomMessage {
+	var messages []RoomMessage
+	for i := range h.entries {
+		entry := h.entries[(h.next+i)%len(h.entries)]
+		if entry.msg.Seq <= seq || (h.ttl > 0 && time.Since(entry.at) > h.ttl) {
+			continue
+		}
+		messages = append(messages, entry.msg)
+	}
+	return messages
+}
+
+// JoinFrom adds conn to room like Join and first sends it the messages
+// published to room after seq that are still in the history. Messages
+// published meanwhile are held back until the replay is done.
+func (r *Rooms) JoinFrom(room string, conn *Conn, seq uint64) {
+	// Subscribe before taking the history lock: a broker may deliver messages
+	// of the room, which need the lock, before it confirms the subscription.
+	// The subscription also keeps the history from being dropped.
+	r.subscribe(room)
+	h := r.history(room)
+	if h == nil {
+		r.join(room, conn)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r.join(room, conn)
+	h.replayTo(conn, room, seq)
+}
+
+// JoinClient adds conn to room on behalf of client, the stable ID of whoever
+// is behind conn, so that the Outbox keeps the messages of room until client
+// acks them. A client coming back first gets the messages after its last
+// ack, like JoinFrom, one joining for the first time those published from
+// now on. Without an Outbox it is Join.
+func (r *Rooms) JoinClient(room string, conn *Conn, client string) {
+	r.subscribe(room)
+	h := r.history(room)
+	if h == nil || h.outbox == nil {
+		r.join(room, conn)
+		return
+	}
+	// Once a client has a cursor the messages of the room must be journaled
+	// while no member is connected, which takes a subscription of its own
+	h.keep.Do(func() { r.subscribe(room) })
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seq, ok, err := h.outbox.Acked(client, room)
+	if err != nil {
+		conn.Logger().Error("Error reading the outbox", "room", room, "err", err)
+	}
+	if !ok {
+		// The cursor starts here, holding what is published from now on
+		seq = h.seq
+		if err := h.outbox.Ack(client, room, seq); err != nil {
+			conn.Logger().Error("Error writing the outbox", "room", room, "err", err)
+		}
+	}
+	r.join(room, conn)
+	h.replayTo(conn, room, seq)
+}
+
+// replayTo sends conn what it missed of room after seq, see replay. The
+// caller must hold h.mu.
+func (h *history) replayTo(conn *Conn, room string, seq uint64) {
+	for _, msg := range h.replay(room, seq) {
+		if err := conn.WriteJSON(msg); err != nil {
+			conn.Logger().Warn("Error replaying history", "room", room, "err", err)
+			return
+		}
+		advance(conn, room, msg.Seq)
+	}
+}
+
+// SubscribeFrom is Subscribe first calling fn with the messages published to
+// room after seq that are still in the history, like JoinFrom.
+func (r *Rooms) SubscribeFrom(room string, seq uint64, fn func(RoomMessage)) (unsubscribe func()) {
+	r.subscribe(room)
+	h := r.history(room)
+	if h == nil {
+		return r.listen(room, fn)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	unsubscribe = r.listen(room, fn)
+	for _, msg := range h.replay(room, seq) {
+		fn(msg)
+	}
+	return unsubscribe
+}
+
+// replay returns what a client that received the messages of room up to seq
+// missed: the buffered messages after seq, or the journaled ones with an
+// outbox, preceded by a "gap" message when some of them are no longer
+// buffered. A seq past the last message comes from a history dropped since,
+// the numbering started over and the gap tells the client from where. The
+// caller must hold h.mu.
+func (h *history) replay(room string, seq uint64) []RoomMessage {
+	restarted := seq > h.seq
+	if restarted {
+		seq = 0
+	}
+	missed := h.since(seq)
+	if h.outbox != nil {
+		var err error
+		if missed, err = h.outbox.Since(room, seq); err != nil {
+			slog.Error("Error reading the outbox", "room", room, "err", err)
+		}
+	}
+	if restarted || (seq < h.seq && (len(missed) == 0 || missed[0].Seq != seq+1)) {
+		oldest := h.seq + 1
+		if len(missed) > 0 {
+			oldest = missed[0].Seq
+		}
+		missed = append([]RoomMessage{{Type: "gap", Room: room, Seq: oldest}}, missed...)
+	}
+	return missed
+}
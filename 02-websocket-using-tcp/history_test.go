@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"strconv"
+	"testing"
+)
+
+// histories returns the number of rooms of r with a history.
+func histories(r *Rooms) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.histories)
+}
+
+func TestHistoryDroppedWithLastSubscription(t *testing.T) {
+	rooms := NewRooms(NewHub())
+	rooms.HistorySize = 10
+	first := rooms.SubscribeFrom("lobby", 0, func(RoomMessage) {})
+	second := rooms.SubscribeFrom("lobby", 0, func(RoomMessage) {})
+	rooms.Publish("lobby", "hello")
+
+	first()
+	if n := histories(rooms); n != 1 {
+		t.Fatalf("%d histories with a subscriber left, want 1", n)
+	}
+	second()
+	if n := histories(rooms); n != 0 {
+		t.Errorf("%d histories once nothing follows the room, want 0", n)
+	}
+}
+
+func TestHistoryMaxRooms(t *testing.T) {
+	rooms := NewRooms(NewHub())
+	rooms.HistorySize, rooms.MaxRooms = 10, 3
+	for i := range 5 {
+		defer rooms.SubscribeFrom("room-"+strconv.Itoa(i), 0, func(RoomMessage) {})()
+	}
+	if n := histories(rooms); n != 3 {
+		t.Errorf("%d histories, want MaxRooms 3", n)
+	}
+}
+
+func TestReplayAfterNumberingStartedOver(t *testing.T) {
+	rooms := NewRooms(NewHub())
+	rooms.HistorySize = 10
+	var got []RoomMessage
+	unsubscribe := rooms.SubscribeFrom("lobby", 0, func(RoomMessage) {})
+	rooms.Publish("lobby", "hello")
+	defer unsubscribe()
+
+	// A client that saw seq 40 of a history dropped since
+	rooms.SubscribeFrom("lobby", 40, func(msg RoomMessage) { got = append(got, msg) })()
+	if len(got) != 2 || got[0].Type != "gap" || got[0].Seq != 1 || got[1].Seq != 1 {
+		t.Errorf("replay %+v, want a gap at 1 then message 1", got)
+	}
+}
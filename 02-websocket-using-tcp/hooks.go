@@ -0,0 +1,43 @@
+package websocket
+
+/**
+ * * Hooks are called by a Conn or a Client on the frames going through it, so that tracing, metrics
+ * * or recording can be plugged in without changing ReadFrame or WriteFrame. Every hook is optional.
+ *
+ * * Hooks run synchronously on the goroutine reading or writing, and must not block or keep the
+ * * frame: its payload may be reused once the hook returned. Set them before the connection is
+ * * used, Server.Hooks are copied to every Conn before its handler runs.
+ */
+type Hooks struct {
+	// OnFrameRead is called with every frame read from the peer, control
+	// frames included, unmasked and before it is checked against RFC 6455.
+	OnFrameRead func(frame *Frame)
+
+	// OnFrameWrite is called with every frame written to the peer, as passed
+	// to WriteFrame: the payload is the unmasked one.
+	OnFrameWrite func(frame *Frame)
+
+	// OnError is called with every error failing the connection: read and
+	// write errors, protocol violations such as frames over MaxFrameSize. The
+	// peer closing the connection with a close frame is not an error.
+	OnError func(err error)
+}
+
+func (h *Hooks) frameRead(frame *Frame) {
+	if h.OnFrameRead != nil {
+		h.OnFrameRead(frame)
+	}
+}
+
+// frameWritten reports the frame just written, built only when a hook wants it.
+func (h *Hooks) frameWritten(fin bool, opcode byte, payload []byte, masked bool) {
+	if h.OnFrameWrite != nil {
+		h.OnFrameWrite(&Frame{Fin: fin, Opcode: opcode, Masked: masked, PayloadLen: uint64(len(payload)), Payload: payload})
+	}
+}
+
+func (h *Hooks) failed(err error) {
+	if h.OnError != nil {
+		h.OnError(err)
+	}
+}
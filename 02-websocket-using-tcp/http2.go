@@ -0,0 +1,901 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"websocket/events"
+)
+
+/**
+ * * WebSockets over HTTP/2, RFC 8441. The server announces SETTINGS_ENABLE_CONNECT_PROTOCOL and
+ * * the client opens a stream with an extended CONNECT request instead of an Upgrade:
+ *
+ *	:method = CONNECT
+ *	:protocol = websocket
+ *	:scheme = https
+ *	:path = /chat
+ *	:authority = example.com
+ *	sec-websocket-version = 13
+ *
+ * * A 200 response accepts it, and the WebSocket frames then travel in the DATA frames of the
+ * * stream, both ways, with the stream's flow control. Several WebSocket sessions share one TCP
+ * * and TLS connection that way, which is how browsers and CDNs speaking HTTP/2 to the origin
+ * * carry them. There is no Sec-WebSocket-Key: the stream already belongs to the client.
+ *
+ * * Only what these streams need is implemented on top of the framer of golang.org/x/net/http2:
+ * * settings, flow control, pings, headers and data. Other requests are answered with 501.
+ */
+
+const (
+	// h2StreamWindow and h2ConnWindow are the receive windows advertised for
+	// every stream and for the connection, larger than the 64KB default so
+	// that a stream does not stall on a round trip per window.
+	h2StreamWindow = 1 << 20
+	h2ConnWindow   = 4 << 20
+
+	// h2DefaultWindow and h2DefaultFrameSize are the initial values of the
+	// specification, in force until the peer's SETTINGS say otherwise.
+	h2DefaultWindow    = 65535
+	h2DefaultFrameSize = 16384
+
+	// h2MaxStreams is how many streams a client may have open on one
+	// connection, the ones beyond are refused.
+	h2MaxStreams = 100
+)
+
+// tlsConfig returns TLSConfig, with h2 offered through ALPN when HTTP2 is set.
+func (s *Server) tlsConfig() *tls.Config {
+	if !s.HTTP2 || slices.Contains(s.TLSConfig.NextProtos, "h2") {
+		return s.TLSConfig
+	}
+	config := s.TLSConfig.Clone()
+	config.NextProtos = append([]string{"h2"}, config.NextProtos...)
+	if !slices.Contains(config.NextProtos, "http/1.1") {
+		config.NextProtos = append(config.NextProtos, "http/1.1")
+	}
+	return config
+}
+
+/**
+ * * serveHTTP2 serves an HTTP/2 connection whose preface was read as a PRI request by serveConn,
+ * * until the client or Shutdown closes it. Every stream opened with an extended CONNECT is a
+ * * WebSocket connection of its own, served like the ones of serveConn.
+ */
+func (s *Server) serveHTTP2(conn net.Conn, reader *bufio.Reader, log *slog.Logger) {
+	preface := make([]byte, len(http2.ClientPreface)-len("PRI * HTTP/2.0\r\n\r\n"))
+	if _, err := io.ReadFull(reader, preface); err != nil || string(preface) != "SM\r\n\r\n" {
+		log.Warn("Invalid HTTP/2 connection preface")
+		return
+	}
+	limit := s.MaxHeaderBytes
+	if limit <= 0 {
+		limit = defaultMaxHeaderBytes
+	}
+	c := newH2Conn(conn, reader, limit)
+	c.server = true
+	if err := c.start(
+		http2.Setting{ID: http2.SettingEnableConnectProtocol, Val: 1},
+		http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: h2MaxStreams},
+		http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: uint32(limit)},
+	); err != nil {
+		log.Warn("Error starting HTTP/2 connection", "err", err)
+		return
+	}
+	log.Info("HTTP/2 connection accepted")
+
+	s.mu.Lock()
+	s.h2[c] = true
+	closing := s.closing
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.h2, c)
+		s.mu.Unlock()
+	}()
+	if closing {
+		c.drain()
+	}
+
+	err := c.readLoop(func(f *http2.MetaHeadersFrame) error {
+		c.mu.Lock()
+		if st := c.streams[f.StreamID]; st != nil {
+			// Trailers, which WebSocket streams do not have.
+			c.mu.Unlock()
+			return http2.StreamError{StreamID: f.StreamID, Code: http2.ErrCodeProtocol}
+		}
+		if f.StreamID%2 == 0 || f.StreamID <= c.lastID {
+			c.mu.Unlock()
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+		if c.draining || len(c.streams) >= h2MaxStreams {
+			c.lastID = f.StreamID
+			c.mu.Unlock()
+			return http2.StreamError{StreamID: f.StreamID, Code: http2.ErrCodeRefusedStream}
+		}
+		st := c.newStream(f.StreamID)
+		st.recvDone = f.StreamEnded()
+		c.mu.Unlock()
+
+		s.serving.Add(1)
+		go s.serveHTTP2Stream(st, f)
+		return nil
+	})
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+		log.Info("HTTP/2 connection closed")
+	} else {
+		log.Warn("HTTP/2 connection failed", "err", err)
+	}
+}
+
+// serveHTTP2Stream performs the opening handshake of a stream and runs the
+// handler on it. It closes the stream when done.
+func (s *Server) serveHTTP2Stream(st *h2Stream, f *http2.MetaHeadersFrame) {
+	var done cleanup
+	defer func() { done.run() }()
+	done.add(s.serving.Done)
+	done.add(func() { st.Close() })
+	connID, remoteAddr := s.ids().New(), st.RemoteAddr().String()
+	log := s.logger().With("conn_id", connID, "remote_addr", remoteAddr, "stream", f.StreamID)
+
+	s.publish(events.Event{Kind: events.Accepted, ConnID: connID, RemoteAddr: remoteAddr})
+	fail := func(err error, status int) {
+		s.publish(events.Event{Kind: events.Errored, ConnID: connID, RemoteAddr: remoteAddr, Err: err, Status: status})
+		if status != 0 {
+			st.respond(status, s.rejectHeader(err, status), true)
+		}
+	}
+
+	if f.Truncated {
+		log.Warn("Error reading HTTP/2 request", "err", errHeaderTooLarge)
+		fail(errHeaderTooLarge, http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+	request, err := h2Request(f, remoteAddr)
+	if err == nil {
+		var status int
+		if status, err = checkExtendedConnect(f, s.Mode); err != nil {
+			log.Warn("Invalid WebSocket handshake", "err", err)
+			fail(err, status)
+			return
+		}
+	}
+	if err != nil {
+		log.Warn("Error reading HTTP/2 request", "err", err)
+		fail(err, http.StatusBadRequest)
+		return
+	}
+
+	s.upgrade(st, bufio.NewReader(st), request, connID, log, &done, fail, func(header http.Header) error {
+		return st.respond(http.StatusOK, header, false)
+	})
+}
+
+// h2Request converts the header block of a request to an http.Request.
+func h2Request(f *http2.MetaHeadersFrame, remoteAddr string) (*http.Request, error) {
+	path := f.PseudoValue("path")
+	u, err := url.ParseRequestURI(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid :path %q", ErrBadHandshake, path)
+	}
+	return &http.Request{
+		Method:     f.PseudoValue("method"),
+		URL:        u,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     h2Header(f),
+		Body:       http.NoBody,
+		Host:       f.PseudoValue("authority"),
+		RemoteAddr: remoteAddr,
+		RequestURI: path,
+	}, nil
+}
+
+/**
+ * * checkExtendedConnect validates the extended CONNECT opening a WebSocket over an HTTP/2 stream,
+ * * returning the status to reject it with, like checkHandshake does for HTTP/1.1. Lenient mode
+ * * only requires the :protocol.
+ */
+func checkExtendedConnect(f *http2.MetaHeadersFrame, mode Mode) (int, error) {
+	if f.PseudoValue("method") != http.MethodConnect || f.PseudoValue("protocol") == "" {
+		return http.StatusNotImplemented, fmt.Errorf("%w: only extended CONNECT requests are served over HTTP/2", ErrBadHandshake)
+	}
+	if !strings.EqualFold(f.PseudoValue("protocol"), "websocket") {
+		return http.StatusNotImplemented, fmt.Errorf("%w: unsupported :protocol %q", ErrBadHandshake, f.PseudoValue("protocol"))
+	}
+	if mode == Lenient {
+		return 0, nil
+	}
+	if f.PseudoValue("scheme") == "" || f.PseudoValue("path") == "" || f.PseudoValue("authority") == "" {
+		return http.StatusBadRequest, fmt.Errorf("%w: missing :scheme, :path or :authority", ErrBadHandshake)
+	}
+	if version := h2Header(f).Get("Sec-WebSocket-Version"); version != "13" {
+		return http.StatusUpgradeRequired, fmt.Errorf("%w: unsupported Sec-WebSocket-Version %q", ErrBadHandshake, version)
+	}
+	return 0, nil
+}
+
+// h2Conn is an HTTP/2 connection carrying WebSocket streams, on the server or
+// on the client side.
+type h2Conn struct {
+	conn   net.Conn
+	framer *http2.Framer
+
+	// writeMu serializes the frames written, encoder writes header blocks
+	// to headerBuf.
+	writeMu   sync.Mutex
+	encoder   *hpack.Encoder
+	headerBuf bytes.Buffer
+
+	// mu guards the state below, cond is signaled on every change waiting
+	// readers and writers of the streams may care about.
+	mu               sync.Mutex
+	cond             sync.Cond
+	streams          map[uint32]*h2Stream
+	lastID           uint32
+	sendWindow       int64
+	peerStreamWindow int64
+	peerMaxFrame     int
+	extendedConnect  bool
+	gotSettings      bool  // The peer's first SETTINGS arrived.
+	unacked          int64 // Received bytes not credited to the connection window yet.
+	err              error
+	draining         bool
+	server           bool
+}
+
+func newH2Conn(conn net.Conn, r io.Reader, maxHeaderBytes int) *h2Conn {
+	c := &h2Conn{
+		conn:             conn,
+		framer:           http2.NewFramer(conn, r),
+		streams:          make(map[uint32]*h2Stream),
+		sendWindow:       h2DefaultWindow,
+		peerStreamWindow: h2DefaultWindow,
+		peerMaxFrame:     h2DefaultFrameSize,
+	}
+	c.cond.L = &c.mu
+	c.encoder = hpack.NewEncoder(&c.headerBuf)
+	c.framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	c.framer.MaxHeaderListSize = uint32(maxHeaderBytes)
+	return c
+}
+
+// start sends the connection's SETTINGS and opens its receive window.
+func (c *h2Conn) start(settings ...http2.Setting) error {
+	settings = append(settings, http2.Setting{ID: http2.SettingInitialWindowSize, Val: h2StreamWindow})
+	return c.write(func(f *http2.Framer) error {
+		if err := f.WriteSettings(settings...); err != nil {
+			return err
+		}
+		return f.WriteWindowUpdate(0, h2ConnWindow-h2DefaultWindow)
+	})
+}
+
+// write writes frames with the connection to itself, failing it on error.
+func (c *h2Conn) write(frames func(*http2.Framer) error) error {
+	c.writeMu.Lock()
+	err := frames(c.framer)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.fail(err)
+	}
+	return err
+}
+
+// writeHeaders writes a header block, split into CONTINUATION frames when it
+// does not fit in one.
+func (c *h2Conn) writeHeaders(streamID uint32, endStream bool, fields []hpack.HeaderField) error {
+	c.mu.Lock()
+	maxFrame := c.peerMaxFrame
+	c.mu.Unlock()
+	return c.write(func(f *http2.Framer) error {
+		c.headerBuf.Reset()
+		for _, field := range fields {
+			c.encoder.WriteField(field)
+		}
+		block := c.headerBuf.Bytes()
+		first := block[:min(len(block), maxFrame)]
+		block = block[len(first):]
+		err := f.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      streamID,
+			BlockFragment: first,
+			EndStream:     endStream,
+			EndHeaders:    len(block) == 0,
+		})
+		for err == nil && len(block) > 0 {
+			next := block[:min(len(block), maxFrame)]
+			block = block[len(next):]
+			err = f.WriteContinuation(streamID, len(block) == 0, next)
+		}
+		return err
+	})
+}
+
+// fail ends the connection and every stream on it with err.
+func (c *h2Conn) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.conn.Close()
+}
+
+// goAway tells the peer no more streams are accepted and closes the
+// connection.
+func (c *h2Conn) goAway(code http2.ErrCode) {
+	// The last stream the peer opened, clients serve none.
+	var lastID uint32
+	c.mu.Lock()
+	if c.server {
+		lastID = c.lastID
+	}
+	c.mu.Unlock()
+	c.write(func(f *http2.Framer) error { return f.WriteGoAway(lastID, code, nil) })
+	c.fail(net.ErrClosed)
+}
+
+// drain closes the connection once its last stream has ended, right away
+// when it has none.
+func (c *h2Conn) drain() {
+	c.mu.Lock()
+	c.draining = true
+	idle := len(c.streams) == 0
+	c.mu.Unlock()
+	if idle {
+		c.goAway(http2.ErrCodeNo)
+	}
+}
+
+// newStream registers the stream id. The caller must hold c.mu.
+func (c *h2Conn) newStream(id uint32) *h2Stream {
+	st := &h2Stream{c: c, id: id, sendWindow: c.peerStreamWindow}
+	c.streams[id] = st
+	c.lastID = id
+	return st
+}
+
+// removeStream forgets st, crediting the connection with what it did not
+// read. It reports whether the connection is draining and now idle. The
+// caller must hold c.mu.
+func (c *h2Conn) removeStream(st *h2Stream) bool {
+	if c.streams[st.id] != st {
+		return false
+	}
+	delete(c.streams, st.id)
+	c.unacked += int64(len(st.recv))
+	st.recv = nil
+	c.cond.Broadcast()
+	return c.draining && len(c.streams) == 0
+}
+
+/**
+ * * readLoop reads the frames of the connection until it fails, and returns why. Connection
+ * * level frames are handled here, HEADERS are passed to onHeaders: requests on the server,
+ * * responses on the client.
+ */
+func (c *h2Conn) readLoop(onHeaders func(*http2.MetaHeadersFrame) error) error {
+	for {
+		frame, err := c.framer.ReadFrame()
+		if err == nil {
+			err = c.handle(frame, onHeaders)
+		}
+		var streamErr http2.StreamError
+		var connErr http2.ConnectionError
+		switch {
+		case err == nil:
+		case errors.As(err, &streamErr):
+			// Only the stream fails, the connection goes on.
+			c.resetStream(streamErr.StreamID, streamErr.Code)
+		case errors.As(err, &connErr):
+			c.write(func(f *http2.Framer) error { return f.WriteGoAway(0, http2.ErrCode(connErr), nil) })
+			c.fail(err)
+			return err
+		default:
+			c.fail(err)
+			return err
+		}
+	}
+}
+
+// resetStream ends the stream id with RST_STREAM.
+func (c *h2Conn) resetStream(id uint32, code http2.ErrCode) {
+	c.mu.Lock()
+	idle := false
+	if st := c.streams[id]; st != nil {
+		st.reset = fmt.Errorf("websocket: HTTP/2 stream reset: %v", code)
+		idle = c.removeStream(st)
+	}
+	c.mu.Unlock()
+	c.write(func(f *http2.Framer) error { return f.WriteRSTStream(id, code) })
+	if idle {
+		c.goAway(http2.ErrCodeNo)
+	}
+}
+
+func (c *h2Conn) handle(frame http2.Frame, onHeaders func(*http2.MetaHeadersFrame) error) error {
+	switch f := frame.(type) {
+	case *http2.SettingsFrame:
+		if f.IsAck() {
+			return nil
+		}
+		c.mu.Lock()
+		err := f.ForeachSetting(func(s http2.Setting) error {
+			if err := s.Valid(); err != nil {
+				return err
+			}
+			switch s.ID {
+			case http2.SettingInitialWindowSize:
+				delta := int64(s.Val) - c.peerStreamWindow
+				c.peerStreamWindow = int64(s.Val)
+				for _, st := range c.streams {
+					st.sendWindow += delta
+				}
+			case http2.SettingMaxFrameSize:
+				c.peerMaxFrame = int(s.Val)
+			case http2.SettingEnableConnectProtocol:
+				c.extendedConnect = s.Val == 1
+			}
+			return nil
+		})
+		c.gotSettings = true
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return c.write(func(f *http2.Framer) error { return f.WriteSettingsAck() })
+	case *http2.PingFrame:
+		if f.IsAck() {
+			return nil
+		}
+		return c.write(func(w *http2.Framer) error { return w.WritePing(true, f.Data) })
+	case *http2.WindowUpdateFrame:
+		c.mu.Lock()
+		if f.StreamID == 0 {
+			c.sendWindow += int64(f.Increment)
+		} else if st := c.streams[f.StreamID]; st != nil {
+			st.sendWindow += int64(f.Increment)
+		}
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	case *http2.MetaHeadersFrame:
+		return onHeaders(f)
+	case *http2.DataFrame:
+		return c.receive(f)
+	case *http2.RSTStreamFrame:
+		c.mu.Lock()
+		st := c.streams[f.StreamID]
+		idle := false
+		if st != nil {
+			st.reset = fmt.Errorf("websocket: HTTP/2 stream reset by peer: %v", f.ErrCode)
+			idle = c.removeStream(st)
+		}
+		c.mu.Unlock()
+		if idle {
+			c.goAway(http2.ErrCodeNo)
+		}
+	case *http2.GoAwayFrame:
+		// The peer closes the connection once its streams are done.
+	case *http2.PushPromiseFrame:
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	}
+	return nil
+}
+
+// receive buffers the payload of a DATA frame for its stream. Payloads of
+// streams that are gone are only credited to the connection window.
+func (c *h2Conn) receive(f *http2.DataFrame) error {
+	data := f.Data()
+	padding := int64(f.Length) - int64(len(data))
+
+	c.mu.Lock()
+	st := c.streams[f.StreamID]
+	c.unacked += padding
+	switch {
+	case st == nil || st.localClosed:
+		c.unacked += int64(len(data))
+	case st.recvDone:
+		c.mu.Unlock()
+		return http2.StreamError{StreamID: f.StreamID, Code: http2.ErrCodeStreamClosed}
+	case len(st.recv)+len(data) > h2StreamWindow:
+		c.mu.Unlock()
+		return http2.ConnectionError(http2.ErrCodeFlowControl)
+	default:
+		st.recv = append(st.recv, data...)
+	}
+	idle := false
+	if st != nil && f.StreamEnded() {
+		st.recvDone = true
+		if st.localDone {
+			idle = c.removeStream(st)
+		}
+	}
+	c.cond.Broadcast()
+	connIncrement := c.connCredit()
+	c.mu.Unlock()
+
+	if connIncrement > 0 {
+		c.write(func(f *http2.Framer) error { return f.WriteWindowUpdate(0, connIncrement) })
+	}
+	if idle {
+		c.goAway(http2.ErrCodeNo)
+	}
+	return nil
+}
+
+// connCredit returns the increment to send for the connection window once
+// half of it was consumed, zero before. The caller must hold c.mu.
+func (c *h2Conn) connCredit() uint32 {
+	if c.unacked < h2ConnWindow/2 {
+		return 0
+	}
+	increment := uint32(c.unacked)
+	c.unacked = 0
+	return increment
+}
+
+/**
+ * * h2Stream is a stream of an h2Conn carrying one WebSocket connection, used as its net.Conn. Its
+ * * deadlines only bound the waits for data and for flow control window, the frames themselves
+ * * are written to the shared connection.
+ */
+type h2Stream struct {
+	c  *h2Conn
+	id uint32
+
+	// Guarded by c.mu. response is the one of a client stream, once the
+	// server sent it.
+	response      *http.Response
+	recv          []byte
+	unacked       int
+	recvDone      bool // END_STREAM received.
+	localDone     bool // END_STREAM sent.
+	localClosed   bool
+	reset         error
+	sendWindow    int64
+	readDeadline  time.Time
+	writeDeadline time.Time
+	timers        [2]*time.Timer
+
+	// ownsConn is set on the client, where the connection is the stream's
+	// alone and closed with it.
+	ownsConn bool
+}
+
+// ready returns the error a read or a write waiting on the stream returns
+// instead, nil while it has to keep waiting. The caller must hold c.mu.
+func (st *h2Stream) ready(deadline time.Time) error {
+	switch {
+	case st.localClosed:
+		return net.ErrClosed
+	case st.reset != nil:
+		return st.reset
+	case st.c.err != nil:
+		if errors.Is(st.c.err, net.ErrClosed) {
+			return io.EOF
+		}
+		return st.c.err
+	case !deadline.IsZero() && !time.Now().Before(deadline):
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+func (st *h2Stream) Read(p []byte) (int, error) {
+	c := st.c
+	c.mu.Lock()
+	for len(st.recv) == 0 {
+		if st.recvDone {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		if err := st.ready(st.readDeadline); err != nil {
+			c.mu.Unlock()
+			return 0, err
+		}
+		c.cond.Wait()
+	}
+	n := copy(p, st.recv)
+	st.recv = st.recv[n:]
+
+	// Credit the reads back once half a window was consumed.
+	var streamIncrement uint32
+	st.unacked += n
+	c.unacked += int64(n)
+	if st.unacked >= h2StreamWindow/2 && !st.recvDone {
+		streamIncrement = uint32(st.unacked)
+		st.unacked = 0
+	}
+	connIncrement := c.connCredit()
+	c.mu.Unlock()
+
+	if streamIncrement > 0 || connIncrement > 0 {
+		c.write(func(f *http2.Framer) error {
+			if streamIncrement > 0 {
+				if err := f.WriteWindowUpdate(st.id, streamIncrement); err != nil {
+					return err
+				}
+			}
+			if connIncrement > 0 {
+				return f.WriteWindowUpdate(0, connIncrement)
+			}
+			return nil
+		})
+	}
+	return n, nil
+}
+
+// Write sends p in DATA frames as the flow control windows of the stream
+// and of the connection allow.
+func (st *h2Stream) Write(p []byte) (int, error) {
+	c := st.c
+	written := 0
+	for written < len(p) {
+		c.mu.Lock()
+		for st.sendWindow <= 0 || c.sendWindow <= 0 || st.localDone {
+			err := st.ready(st.writeDeadline)
+			if err == nil && st.localDone {
+				err = net.ErrClosed
+			}
+			if err != nil {
+				c.mu.Unlock()
+				return written, err
+			}
+			c.cond.Wait()
+		}
+		n := int(min(int64(len(p)-written), st.sendWindow, c.sendWindow, int64(c.peerMaxFrame)))
+		st.sendWindow -= int64(n)
+		c.sendWindow -= int64(n)
+		c.mu.Unlock()
+
+		chunk := p[written : written+n]
+		if err := c.write(func(f *http2.Framer) error { return f.WriteData(st.id, false, chunk) }); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+/**
+ * * Close ends the stream with an empty DATA frame flagged END_STREAM, the close of a TCP
+ * * connection, unless a response did already. A server stream the client has not ended yet is
+ * * reset with NO_ERROR after it, as RFC 9113 allows once the response is complete, so that it
+ * * does not linger. A client stream closes its connection.
+ */
+func (st *h2Stream) Close() error {
+	c := st.c
+	c.mu.Lock()
+	if st.localClosed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	st.localClosed = true
+	alive := st.reset == nil && c.err == nil
+	sendEnd := alive && !st.localDone
+	reset := alive && !st.recvDone && !st.ownsConn
+	st.localDone = true
+	idle := false
+	if st.recvDone || reset || st.reset != nil || c.err != nil {
+		idle = c.removeStream(st)
+	}
+	for _, timer := range st.timers {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	// The frames are written on another goroutine, so that closing a stream
+	// never waits on a connection whose peer stopped reading.
+	go func() {
+		if sendEnd || reset {
+			c.write(func(f *http2.Framer) error {
+				if sendEnd {
+					if err := f.WriteData(st.id, true, nil); err != nil {
+						return err
+					}
+				}
+				if reset {
+					return f.WriteRSTStream(st.id, http2.ErrCodeNo)
+				}
+				return nil
+			})
+		}
+		if st.ownsConn || idle {
+			c.goAway(http2.ErrCodeNo)
+		}
+	}()
+	return nil
+}
+
+// respond sends the response headers of a server stream.
+func (st *h2Stream) respond(status int, header http.Header, endStream bool) error {
+	fields := []hpack.HeaderField{{Name: ":status", Value: fmt.Sprint(status)}}
+	for name, values := range header {
+		for _, value := range values {
+			fields = append(fields, hpack.HeaderField{Name: strings.ToLower(name), Value: value})
+		}
+	}
+	if endStream {
+		c := st.c
+		c.mu.Lock()
+		st.localDone = true
+		c.mu.Unlock()
+	}
+	return st.c.writeHeaders(st.id, endStream, fields)
+}
+
+func (st *h2Stream) LocalAddr() net.Addr {
+	return st.c.conn.LocalAddr()
+}
+
+func (st *h2Stream) RemoteAddr() net.Addr {
+	return st.c.conn.RemoteAddr()
+}
+
+func (st *h2Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+func (st *h2Stream) SetReadDeadline(t time.Time) error {
+	st.setDeadline(0, &st.readDeadline, t)
+	return nil
+}
+
+func (st *h2Stream) SetWriteDeadline(t time.Time) error {
+	st.setDeadline(1, &st.writeDeadline, t)
+	return nil
+}
+
+// setDeadline sets the deadline and arms a timer waking the goroutines
+// waiting on the stream when it passes.
+func (st *h2Stream) setDeadline(i int, deadline *time.Time, t time.Time) {
+	c := st.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*deadline = t
+	if st.timers[i] != nil {
+		st.timers[i].Stop()
+		st.timers[i] = nil
+	}
+	if !t.IsZero() {
+		st.timers[i] = time.AfterFunc(time.Until(t), func() {
+			c.mu.Lock()
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		})
+	}
+	c.cond.Broadcast()
+}
+
+// h2Header converts the regular fields of a header block to an http.Header.
+func h2Header(f *http2.MetaHeadersFrame) http.Header {
+	header := make(http.Header)
+	for _, field := range f.RegularFields() {
+		header.Add(field.Name, field.Value)
+	}
+	return header
+}
+
+/**
+ * * handshakeHTTP2 opens a WebSocket over conn with the HTTP/2 extended CONNECT of RFC 8441: it
+ * * sends the client preface, waits for the server's SETTINGS to allow it and opens the first
+ * * stream with the request. The connection serves that one stream and closes with it.
+ */
+func (d *Dialer) handshakeHTTP2(conn net.Conn, u *url.URL) (*Client, error) {
+	if _, err := io.WriteString(conn, http2.ClientPreface); err != nil {
+		return nil, err
+	}
+	c := newH2Conn(conn, conn, defaultMaxHeaderBytes)
+	if err := c.start(http2.Setting{ID: http2.SettingEnablePush, Val: 0}); err != nil {
+		return nil, err
+	}
+	go c.readLoop(func(f *http2.MetaHeadersFrame) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		st := c.streams[f.StreamID]
+		if st == nil {
+			return nil
+		}
+		status, err := strconv.Atoi(f.PseudoValue("status"))
+		if st.response != nil || err != nil {
+			return http2.StreamError{StreamID: f.StreamID, Code: http2.ErrCodeProtocol}
+		}
+		if status < 200 {
+			// Informational, the final response follows.
+			return nil
+		}
+		st.response = &http.Response{
+			Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode: status,
+			Proto:      "HTTP/2.0",
+			ProtoMajor: 2,
+			Header:     h2Header(f),
+		}
+		st.recvDone = f.StreamEnded()
+		c.cond.Broadcast()
+		return nil
+	})
+
+	c.mu.Lock()
+	for !c.gotSettings && c.err == nil {
+		c.cond.Wait()
+	}
+	err, extendedConnect := c.err, c.extendedConnect
+	var st *h2Stream
+	if err == nil && extendedConnect {
+		st = c.newStream(1)
+		st.ownsConn = true
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if !extendedConnect {
+		return nil, fmt.Errorf("%w: server does not accept WebSockets over HTTP/2", ErrBadHandshake)
+	}
+
+	scheme := "http"
+	if u.Scheme == "wss" {
+		scheme = "https"
+	}
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: http.MethodConnect},
+		{Name: ":protocol", Value: "websocket"},
+		{Name: ":scheme", Value: scheme},
+		{Name: ":path", Value: u.RequestURI()},
+		{Name: ":authority", Value: u.Host},
+		{Name: "sec-websocket-version", Value: "13"},
+	}
+	if len(d.Subprotocols) > 0 {
+		fields = append(fields, hpack.HeaderField{Name: "sec-websocket-protocol", Value: strings.Join(d.Subprotocols, ", ")})
+	}
+	if d.SessionToken != "" {
+		fields = append(fields, hpack.HeaderField{Name: strings.ToLower(SessionHeader), Value: d.SessionToken})
+	}
+	if err := c.writeHeaders(st.id, false, fields); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	for st.response == nil && err == nil {
+		if err = st.ready(time.Time{}); err == nil {
+			c.cond.Wait()
+		}
+	}
+	response := st.response
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status %s", ErrBadHandshake, response.Status)
+	}
+	subprotocol := response.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !slices.Contains(d.Subprotocols, subprotocol) {
+		return nil, fmt.Errorf("%w: server selected subprotocol %q, which was not offered", ErrBadHandshake, subprotocol)
+	}
+	return d.newClient(st, bufio.NewReader(st), response), nil
+}
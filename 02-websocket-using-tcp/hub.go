@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"websocket/broker"
+)
+
+// hubTopic is the broker topic Broadcast publishes to.
+const hubTopic = "hub"
+
+// Hub keeps the set of connected clients and fans messages out to them.
+// Broadcasts go through a broker.Broker, so that hubs of several instances
+// sharing one reach each other's clients.
+type Hub struct {
+	conns  *registry
+	broker broker.Broker
+}
+
+// hubBroadcast is what Broadcast publishes to the broker.
+type hubBroadcast struct {
+	TraceID string `json:"trace_id,omitempty"`
+	Opcode  byte   `json:"opcode"`
+	Payload []byte `json:"payload"`
+}
+
+// NewHub returns a hub for a single instance, using an in-memory broker.
+func NewHub() *Hub {
+	hub, _ := NewHubWithBroker(broker.NewMemory())
+	return hub
+}
+
+// NewHubWithBroker returns a hub broadcasting through b, which it subscribes
+// to right away.
+func NewHubWithBroker(b broker.Broker) (*Hub, error) {
+	h := &Hub{conns: newRegistry(), broker: b}
+	_, err := b.Subscribe(hubTopic, func(data []byte) {
+		var msg hubBroadcast
+		if err := json.Unmarshal(data, &msg); err != nil {
+			slog.Warn("Error decoding broadcast from broker", "err", err)
+			return
+		}
+		h.BroadcastFunc(msg.TraceID, msg.Opcode, msg.Payload, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Subscribe calls fn with every broadcast reaching the hub, from any
+// instance sharing its broker, until unsubscribe is called. It lets
+// consumers that are not WebSocket connections, such as the sse package,
+// follow the hub. fn must not block for long, see broker.Broker.
+func (h *Hub) Subscribe(fn func(traceID string, opcode byte, payload []byte)) (unsubscribe func(), err error) {
+	return h.broker.Subscribe(hubTopic, func(data []byte) {
+		var msg hubBroadcast
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return
+		}
+		fn(msg.TraceID, msg.Opcode, msg.Payload)
+	})
+}
+
+// Register adds conn to the hub.
+func (h *Hub) Register(conn *Conn) {
+	h.conns.add(conn)
+}
+
+// Unregister removes conn from the hub.
+func (h *Hub) Unregister(conn *Conn) {
+	h.conns.remove(conn)
+}
+
+// Len returns the number of connections registered to the hub.
+func (h *Hub) Len() int {
+	return h.conns.len()
+}
+
+// Broadcast sends payload to every registered connection, on every instance
+// sharing the hub's broker. traceID is the trace of the message that caused
+// the broadcast and tags every delivery in the logs.
+func (h *Hub) Broadcast(traceID string, opcode byte, payload []byte) {
+	data, err := json.Marshal(hubBroadcast{TraceID: traceID, Opcode: opcode, Payload: payload})
+	if err != nil {
+		return
+	}
+	if err := h.broker.Publish(hubTopic, data); err != nil {
+		slog.Error("Error publishing broadcast", "trace_id", traceID, "err", err)
+	}
+}
+
+// BroadcastFunc sends payload to every registered connection for which match
+// returns true, for example those whose metadata places them in a given room
+// or tenant. A nil match selects every connection. The predicate cannot
+// travel through the broker, BroadcastFunc only reaches local connections.
+//
+// match is evaluated in a single pass with the shard of the connection
+// locked, so it must be cheap and must not call back into the hub. The
+// messages are queued during the pass, without a list of the recipients:
+// Conn.Enqueue never blocks, so a slow client neither holds up Register nor
+// the delivery to the other connections.
+func (h *Hub) BroadcastFunc(traceID string, opcode byte, payload []byte, match func(*Conn) bool) {
+	h.conns.each(match, func(conn *Conn) {
+		// Enqueue only fails on a full queue, and logs how it handled it.
+		if err := conn.Enqueue(opcode, payload); err != nil {
+			conn.Logger().Debug("Dropped message", "trace_id", traceID, "err", err)
+			return
+		}
+		conn.Logger().Debug("Queued message", "trace_id", traceID)
+	})
+}
+
+// broadcastWritten is BroadcastFunc calling written with every connection
+// the message was written to, from the goroutine writing it.
+func (h *Hub) broadcastWritten(traceID string, opcode byte, payload []byte, match func(*Conn) bool, written func(*Conn)) {
+	h.conns.each(match, func(conn *Conn) {
+		err := conn.enqueue(opcode, payload, func(err error) {
+			if err == nil {
+				written(conn)
+			}
+		})
+		if err != nil {
+			conn.Logger().Debug("Dropped message", "trace_id", traceID, "err", err)
+			return
+		}
+		conn.Logger().Debug("Queued message", "trace_id", traceID)
+	})
+}
+
+// Delivery counts what became of a message sent by Deliver: written to the
+// connection of a recipient, dropped for it, or still queued when Deliver
+// stopped waiting.
+type Delivery struct {
+	Delivered int
+	Failed    int
+	Pending   int
+}
+
+/**
+ * * Deliver is BroadcastFunc waiting for the message to be written to every connection it was queued
+ * * for, or until ctx is done, and counting the outcomes. Written means handed to the TCP connection,
+ * * not read by the client: a receipt of the application is needed for that.
+ *
+ * * Like BroadcastFunc, Deliver only reaches local connections, the outcomes could not come back
+ * * through the broker.
+ */
+func (h *Hub) Deliver(ctx context.Context, traceID string, opcode byte, payload []byte, match func(*Conn) bool) Delivery {
+	conns := h.conns.collect(match)
+	// Buffered so that a write finishing after Deliver returned does not block
+	outcomes := make(chan error, len(conns))
+	var d Delivery
+	for _, conn := range conns {
+		if err := conn.enqueue(opcode, payload, func(err error) { outcomes <- err }); err != nil {
+			conn.Logger().Debug("Dropped message", "trace_id", traceID, "err", err)
+			d.Failed++
+			continue
+		}
+		d.Pending++
+	}
+	for d.Pending > 0 {
+		select {
+		case err := <-outcomes:
+			d.Pending--
+			if err != nil {
+				d.Failed++
+			} else {
+				d.Delivered++
+			}
+		case <-ctx.Done():
+			return d
+		}
+	}
+	return d
+}
@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// discardConn is a net.Conn swallowing writes and counting them down on
+// frames. A message fitting in one frame is written with a single Write.
+type discardConn struct {
+	frames *sync.WaitGroup
+}
+
+func (c discardConn) Write(p []byte) (int, error) {
+	c.frames.Done()
+	return len(p), nil
+}
+
+func (discardConn) Read([]byte) (int, error)         { select {} }
+func (discardConn) Close() error                     { return nil }
+func (discardConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (discardConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (discardConn) SetDeadline(time.Time) error      { return nil }
+func (discardConn) SetReadDeadline(time.Time) error  { return nil }
+func (discardConn) SetWriteDeadline(time.Time) error { return nil }
+
+// benchHub returns a hub with n connections writing to discardConns.
+func benchHub(n int, frames *sync.WaitGroup) *Hub {
+	hub := NewHub()
+	for i := range n {
+		hub.Register(&Conn{id: strconv.Itoa(i), conn: discardConn{frames}})
+	}
+	return hub
+}
+
+// BenchmarkBroadcastFunc measures a broadcast of a short message to every
+// connection of the hub, until it was written to all of them.
+func BenchmarkBroadcastFunc(b *testing.B) {
+	payload := []byte(`{"type":"message","text":"hello"}`)
+	for _, n := range []int{10_000, 100_000} {
+		b.Run(fmt.Sprintf("conns=%d", n), func(b *testing.B) {
+			var frames sync.WaitGroup
+			hub := benchHub(n, &frames)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				frames.Add(n)
+				hub.BroadcastFunc("", 0x1, payload, nil)
+				frames.Wait()
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/conn")
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Package id generates identifiers for connections, sessions and messages.
+//
+// Every generator here produces IDs that sort by creation time and are
+// unique across processes without coordination (Snowflake needs a distinct
+// Node per process), which is what deduplication, acknowledgements and
+// message history rely on.
+package id
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Generator returns a new unique identifier on every call. Implementations
+// must be safe for concurrent use.
+type Generator interface {
+	New() string
+}
+
+// Default is the generator used when none is configured.
+var Default Generator = &ULID{}
+
+// crockford is the alphabet ULIDs are encoded with, it leaves out I, L, O
+// and U to avoid confusion.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+/**
+ * * ULID generates Universally Unique Lexicographically Sortable Identifiers: a 48 bit
+ * * millisecond timestamp followed by 80 random bits, encoded as 26 Crockford base32 characters.
+ *
+ * * IDs created within the same millisecond increment the random part instead of drawing a new
+ * * one, so IDs of one generator are strictly increasing.
+ */
+type ULID struct {
+	mu      sync.Mutex
+	lastMs  uint64
+	entropy [10]byte
+}
+
+// New returns a new ULID.
+func (g *ULID) New() string {
+	g.mu.Lock()
+	ms := uint64(time.Now().UnixMilli())
+	if ms > g.lastMs {
+		g.lastMs = ms
+		rand.Read(g.entropy[:])
+	} else {
+		increment(g.entropy[:])
+	}
+	var raw [16]byte
+	binary.BigEndian.PutUint64(raw[:8], g.lastMs<<16)
+	copy(raw[6:], g.entropy[:])
+	g.mu.Unlock()
+
+	// 128 bits as 26 characters of 5 bits, the first character taking the
+	// topmost 3 bits only.
+	var out [26]byte
+	hi, lo := binary.BigEndian.Uint64(raw[:8]), binary.BigEndian.Uint64(raw[8:])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1F]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// increment adds one to the big endian number b, wrapping around on overflow.
+func increment(b []byte) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
+
+// UUIDv7 generates version 7 UUIDs (RFC 9562): a 48 bit millisecond
+// timestamp, 12 bits of sub-millisecond precision and 62 random bits.
+type UUIDv7 struct{}
+
+// New returns a new UUIDv7 in its canonical 36 character form.
+func (UUIDv7) New() string {
+	now := time.Now()
+	ms := uint64(now.UnixMilli())
+	fraction := uint64(now.Nanosecond()%int(time.Millisecond)) * 4096 / uint64(time.Millisecond)
+
+	var raw [16]byte
+	rand.Read(raw[8:])
+	binary.BigEndian.PutUint64(raw[:8], ms<<16|0x7000|fraction)
+	raw[8] = raw[8]&0x3F | 0x80 // Variant 10.
+
+	var out [36]byte
+	hex.Encode(out[0:8], raw[0:4])
+	out[8] = '-'
+	hex.Encode(out[9:13], raw[4:6])
+	out[13] = '-'
+	hex.Encode(out[14:18], raw[6:8])
+	out[18] = '-'
+	hex.Encode(out[19:23], raw[8:10])
+	out[23] = '-'
+	hex.Encode(out[24:], raw[10:])
+	return string(out[:])
+}
+
+// snowflakeEpoch is the start of Snowflake timestamps, 2020-01-01 UTC. 41
+// bits of milliseconds last until 2089.
+var snowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+/**
+ * * Snowflake generates Twitter style 64 bit IDs, formatted in decimal: 41 bits of milliseconds
+ * * since snowflakeEpoch, 10 bits of Node and a 12 bit sequence within the millisecond.
+ *
+ * * Node (0-1023) must be unique for every process generating IDs at the same time. When the
+ * * 4096 IDs of a millisecond are used up, New waits for the next one.
+ */
+type Snowflake struct {
+	Node int64
+
+	mu       sync.Mutex
+	lastMs   int64
+	sequence int64
+}
+
+// New returns a new Snowflake ID.
+func (g *Snowflake) New() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := time.Since(snowflakeEpoch).Milliseconds()
+	if ms <= g.lastMs {
+		ms = g.lastMs
+		g.sequence = (g.sequence + 1) & 0xFFF
+		if g.sequence == 0 {
+			for ms <= g.lastMs {
+				time.Sleep(100 * time.Microsecond)
+				ms = time.Since(snowflakeEpoch).Milliseconds()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastMs = ms
+
+	return strconv.FormatInt(ms<<22|(g.Node&0x3FF)<<12|g.sequence, 10)
+}
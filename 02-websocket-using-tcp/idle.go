@@ -0,0 +1,61 @@
+package websocket
+
+import "time"
+
+// idleCloseGrace is how long a reaped connection has to answer its close
+// frame before reads on it fail.
+const idleCloseGrace = 5 * time.Second
+
+// markActive records that an application frame (text, binary or
+// continuation) went through the connection.
+func (c *Conn) markActive(opcode byte) {
+	if opcode <= 0x2 {
+		c.lastActive.Store(time.Now().UnixNano())
+	}
+}
+
+// LastActivity returns when the connection last read or wrote a data frame,
+// or completed its handshake if it never did. Pings and pongs do not count.
+func (c *Conn) LastActivity() time.Time {
+	return time.Unix(0, c.lastActive.Load())
+}
+
+/**
+ * * ReapIdle closes the connections of the hub that have neither sent nor received an application
+ * * frame for timeout, reclaiming those of abandoned browser tabs: a tab left open answers pings,
+ * * so that liveness checks never catch it. Idle connections get a 1001 (going away) close frame,
+ * * and idleCloseGrace to answer it before their reads fail.
+ *
+ * * The hub is swept every timeout/4. ReapIdle returns a function stopping the sweeper.
+ */
+func (h *Hub) ReapIdle(timeout time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(max(timeout/4, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				h.reapIdle(now.Add(-timeout))
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// reapIdle closes the connections idle since before cutoff.
+func (h *Hub) reapIdle(cutoff time.Time) {
+	idle := h.conns.collect(func(conn *Conn) bool {
+		return conn.LastActivity().Before(cutoff)
+	})
+	for _, conn := range idle {
+		if conn.closeSent.Load() {
+			continue
+		}
+		conn.Logger().Info("Closing idle connection", "last_activity", conn.LastActivity())
+		conn.writeClose(closeGoingAway)
+		conn.conn.SetReadDeadline(time.Now().Add(idleCloseGrace))
+	}
+}
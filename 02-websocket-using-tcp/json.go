@@ -0,0 +1,37 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// defaultMaxMessageSize is the largest message ReadJSON and Receive accept by default.
+const defaultMaxMessageSize = 1 << 20
+
+// readLimited reads the whole message from r. Messages longer than limit
+// bytes are rejected and fail the connection with 1009 (message too big):
+// the rest of the message is not read, nothing else can be read after it.
+func readLimited(r io.Reader, limit int64, fail func(error) error) ([]byte, error) {
+	if limit == 0 {
+		limit = defaultMaxMessageSize
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fail(&ErrProtocolError{Code: closeMessageTooBig, Reason: fmt.Sprintf("message exceeds %d bytes", limit), err: ErrMessageTooBig})
+	}
+	return data, nil
+}
+
+// readJSON decodes the message read from r into v.
+func readJSON(r io.Reader, limit int64, fail func(error) error, v any) error {
+	data, err := readLimited(r, limit, fail)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
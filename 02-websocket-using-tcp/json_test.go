@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReceiveMessageTooBig(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := make(chan error, 1)
+	server := NewServer("", WithMaxMessageSize(16), WithHandler(func(conn *Conn) {
+		var v any
+		read <- conn.ReadJSON(&v)
+	}))
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	client, err := Dial("ws://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.WriteMessage(0x1, []byte(`"`+strings.Repeat("x", 32)+`"`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-read; !errors.Is(err, ErrMessageTooBig) {
+		t.Errorf("ReadJSON: %v, want ErrMessageTooBig", err)
+	}
+	expectClose(t, client, closeMessageTooBig)
+}
@@ -0,0 +1,153 @@
+// Package jwt is a reference JSON Web Token validator for authenticating
+// WebSocket upgrades. It supports HS256 and RS256 compact tokens and checks
+// the exp and nbf claims, which covers most setups without a dependency.
+//
+//	validator := &jwt.Validator{HMACKey: secret}
+//	server := &websocket.Server{Handler: handler, Authenticate: validator.Authenticator()}
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"websocket"
+)
+
+// Errors returned by Validate. Every one of them rejects the handshake with 401.
+var (
+	ErrMalformed        = errors.New("jwt: malformed token")
+	ErrUnsupportedAlg   = errors.New("jwt: unsupported or unexpected signing algorithm")
+	ErrInvalidSignature = errors.New("jwt: invalid signature")
+	ErrExpired          = errors.New("jwt: token expired")
+	ErrNotYetValid      = errors.New("jwt: token not valid yet")
+)
+
+// Validator checks tokens signed with HS256 using HMACKey or with RS256 using
+// RSAKey. A token is only accepted with the algorithm whose key is set, so a
+// token cannot pick an algorithm the server did not configure ("alg": "none"
+// or an HS256 token signed with the RSA public key).
+type Validator struct {
+	HMACKey []byte
+	RSAKey  *rsa.PublicKey
+
+	// Leeway tolerates clock skew when checking exp and nbf.
+	Leeway time.Duration
+
+	// Now returns the current time, time.Now when nil.
+	Now func() time.Time
+}
+
+// Claims are the decoded claims of a valid token.
+type Claims map[string]any
+
+// Validate checks the signature and validity period of token and returns its claims.
+func (v *Validator) Validate(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if err := v.verify(header.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.checkTime(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Validator) verify(alg, signed string, signature []byte) error {
+	switch {
+	case alg == "HS256" && v.HMACKey != nil:
+		mac := hmac.New(sha256.New, v.HMACKey)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case alg == "RS256" && v.RSAKey != nil:
+		digest := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(v.RSAKey, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return ErrUnsupportedAlg
+	}
+}
+
+// checkTime enforces the exp and nbf claims when the token has them.
+func (v *Validator) checkTime(claims Claims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(v.Leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-v.Leeway)) {
+		return ErrNotYetValid
+	}
+	return nil
+}
+
+// Authenticator returns a websocket.Authenticator reading the token from the
+// Authorization header or the access_token query parameter (see
+// websocket.BearerToken). The principal's ID is the sub claim. Every claim is
+// copied into its Attributes, strings as they are and other values JSON
+// encoded.
+func (v *Validator) Authenticator() websocket.Authenticator {
+	return websocket.BearerToken(func(token string) (websocket.Principal, error) {
+		claims, err := v.Validate(token)
+		if err != nil {
+			return websocket.Principal{}, err
+		}
+
+		principal := websocket.Principal{Attributes: make(map[string]string, len(claims))}
+		for name, value := range claims {
+			if s, ok := value.(string); ok {
+				principal.Attributes[name] = s
+				continue
+			}
+			encoded, _ := json.Marshal(value)
+			principal.Attributes[name] = string(encoded)
+		}
+		principal.ID = principal.Attributes["sub"]
+		return principal, nil
+	})
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"time"
+
+	"websocket/metrics"
+)
+
+// pingRTT observes the round trip times measured by server pings, from 1ms
+// to about 4s.
+var pingRTT = metrics.Default.RegisterHistogram(metrics.NewHistogram(
+	"websocket_ping_rtt_seconds", "Round trip times of the pings sent to clients, from ping to pong.",
+	metrics.ExponentialBuckets(0.001, 2, 13)...))
+
+/**
+ * * pingLoop pings the client every interval until the connection is closing, see
+ * * Server.PingInterval. Each ping carries the time it was sent as 8 bytes of Unix nanoseconds,
+ * * which the client echoes in its pong: pongReceived turns it into the round trip time without
+ * * having to remember the pings in flight. Only the pong of the latest ping is measured, a late
+ * * pong of an earlier one is ignored.
+ */
+func (c *Conn) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Context().Done():
+			return
+		case now := <-ticker.C:
+			sent := now.UnixNano()
+			c.lastPing.Store(sent)
+			if err := c.writeControl(0x9, binary.BigEndian.AppendUint64(nil, uint64(sent))); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// pongReceived records the round trip time of the pong answering the latest
+// ping of pingLoop. Other pongs, unsolicited ones included, are ignored.
+func (c *Conn) pongReceived(payload []byte) {
+	if len(payload) != 8 {
+		return
+	}
+	sent := int64(binary.BigEndian.Uint64(payload))
+	if sent == 0 || !c.lastPing.CompareAndSwap(sent, 0) {
+		return
+	}
+	rtt := time.Since(time.Unix(0, sent))
+	c.latency.Store(int64(rtt))
+	pingRTT.Observe(rtt.Seconds())
+}
+
+// Latency returns the round trip time measured by the latest ping answered,
+// zero until the client answered one. The server pings only with
+// Server.PingInterval set, and pongs are read along with the messages: the
+// handler must keep reading for the latency to be updated.
+func (c *Conn) Latency() time.Duration {
+	return time.Duration(c.latency.Load())
+}
@@ -0,0 +1,39 @@
+package websocket
+
+import (
+	"log/slog"
+
+	"websocket/events"
+	"websocket/id"
+)
+
+func (s *Server) ids() id.Generator {
+	if s.IDs != nil {
+		return s.IDs
+	}
+	return id.Default
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+func (s *Server) publish(event events.Event) {
+	bus := s.Events
+	if bus == nil {
+		bus = events.Default
+	}
+	bus.Publish(event)
+}
+
+// Logger returns the logger of the connection. Every record it writes carries
+// the connection's conn_id and remote_addr, handlers should log through it.
+func (c *Conn) Logger() *slog.Logger {
+	if c.log != nil {
+		return c.log
+	}
+	return slog.Default()
+}
@@ -0,0 +1,56 @@
+package websocket
+
+import "sync"
+
+// metadata is the key/value store attached to every Conn.
+type metadata struct {
+	mu     sync.RWMutex
+	values map[string]any
+}
+
+// SetMeta attaches value to the connection under key, replacing any previous
+// value. Middleware and handlers use it to carry things such as the
+// authenticated identity or the client's locale along with the connection.
+func (c *Conn) SetMeta(key string, value any) {
+	c.meta.mu.Lock()
+	defer c.meta.mu.Unlock()
+	if c.meta.values == nil {
+		c.meta.values = make(map[string]any)
+	}
+	c.meta.values[key] = value
+}
+
+// Meta returns the value stored under key and whether it was set.
+func (c *Conn) Meta(key string) (any, bool) {
+	c.meta.mu.RLock()
+	defer c.meta.mu.RUnlock()
+	value, ok := c.meta.values[key]
+	return value, ok
+}
+
+// DeleteMeta removes key from the connection's metadata.
+func (c *Conn) DeleteMeta(key string) {
+	c.meta.mu.Lock()
+	defer c.meta.mu.Unlock()
+	delete(c.meta.values, key)
+}
+
+// Get returns the metadata stored under key as a T. The second result is
+// false when the key is not set or holds a value of another type.
+//
+//	websocket.Set(conn, "user", User{Name: "alice"})
+//	user, ok := websocket.Get[User](conn, "user")
+func Get[T any](c *Conn, key string) (T, bool) {
+	value, ok := c.Meta(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	typed, ok := value.(T)
+	return typed, ok
+}
+
+// Set stores value under key in the connection's metadata.
+func Set[T any](c *Conn, key string, value T) {
+	c.SetMeta(key, value)
+}
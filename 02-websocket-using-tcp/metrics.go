@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strconv"
+
+	"websocket/events"
+	"websocket/metrics"
+)
+
+var (
+	activeConnections = metrics.Default.RegisterGauge(metrics.NewGauge(
+		"websocket_active_connections", "WebSocket connections currently open."))
+
+	handshakes = metrics.Default.Register(metrics.NewCounterVec(
+		"websocket_handshakes_total", "Opening handshakes, by result (succeeded, failed or rejected).", 3, "result"))
+
+	framesRead = metrics.Default.Register(metrics.NewCounterVec(
+		"websocket_frames_read_total", "Frames read from clients, by opcode and tenant.", 256, "opcode", "tenant"))
+
+	framesWritten = metrics.Default.Register(metrics.NewCounterVec(
+		"websocket_frames_written_total", "Frames written to clients, by opcode and tenant.", 256, "opcode", "tenant"))
+
+	bytesIn = metrics.Default.Register(metrics.NewCounterVec(
+		"websocket_bytes_read_total", "Bytes read from client connections, handshake included.", 1))
+
+	bytesOut = metrics.Default.Register(metrics.NewCounterVec(
+		"websocket_bytes_written_total", "Bytes written to client connections, handshake included.", 1))
+
+	closeCodes = metrics.Default.Register(metrics.NewCounterVec(
+		"websocket_close_codes_total", "Close frames received from clients, by close code.", 32, "code"))
+)
+
+// Connection and handshake metrics are derived from the lifecycle events
+// published on events.Default.
+func init() {
+	events.Default.Subscribe(func(event events.Event) {
+		switch event.Kind {
+		case events.Upgraded:
+			handshakes.Inc("succeeded")
+			activeConnections.Inc()
+		case events.Closed:
+			activeConnections.Dec()
+		case events.Errored:
+			if event.Status == http.StatusServiceUnavailable {
+				handshakes.Inc("rejected")
+			} else {
+				handshakes.Inc("failed")
+			}
+		}
+	})
+}
+
+// countingConn counts the bytes read from and written to a client connection.
+type countingConn struct {
+	net.Conn
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	bytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	bytesOut.Add(uint64(n))
+	return n, err
+}
+
+// tenantLabel returns the "tenant" attribute of the connection's principal,
+// or "none" when it has none.
+func (c *Conn) tenantLabel() string {
+	if principal, ok := c.Principal(); ok && principal.Attributes["tenant"] != "" {
+		return principal.Attributes["tenant"]
+	}
+	return "none"
+}
+
+// closeCodeLabel returns the status code carried by a close frame payload,
+// or "none" when the peer did not send one.
+func closeCodeLabel(payload []byte) string {
+	if len(payload) < 2 {
+		return "none"
+	}
+	return strconv.Itoa(int(binary.BigEndian.Uint16(payload)))
+}
@@ -0,0 +1,345 @@
+// Package metrics keeps in-process counters for the WebSocket server.
+//
+// Counters can be partitioned by labels such as opcode, room, tenant or close
+// code. Because some label values come from clients, every CounterVec has a
+// cardinality limit: once it holds that many distinct label combinations,
+// further new combinations are aggregated into a single series whose labels
+// are all set to Overflow. This keeps a busy deployment from creating an
+// unbounded number of series in the metrics backend.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Overflow is the label value used for series aggregated by the cardinality limit.
+const Overflow = "__overflow__"
+
+// CounterVec is a monotonically increasing counter partitioned by labels.
+type CounterVec struct {
+	name      string
+	help      string
+	labels    []string
+	maxSeries int
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	count  uint64
+}
+
+// Sample is the value of one series of a CounterVec.
+type Sample struct {
+	Labels map[string]string
+	Value  uint64
+}
+
+// NewCounterVec returns a counter with the given label names holding at most
+// maxSeries distinct label combinations (plus the overflow series).
+func NewCounterVec(name, help string, maxSeries int, labels ...string) *CounterVec {
+	return &CounterVec{
+		name:      name,
+		help:      help,
+		labels:    labels,
+		maxSeries: maxSeries,
+		series:    make(map[string]*series),
+	}
+}
+
+// Inc increments the series identified by values, one per label.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds n to the series identified by values, one per label.
+func (c *CounterVec) Add(n uint64, values ...string) {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", c.name, len(c.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		if len(c.series) >= c.maxSeries {
+			s = c.overflow()
+		} else {
+			s = &series{values: append([]string(nil), values...)}
+			c.series[key] = s
+		}
+	}
+	s.count += n
+}
+
+// overflow returns the aggregated series, creating it on first use. It is
+// not counted against maxSeries. The caller must hold c.mu.
+func (c *CounterVec) overflow() *series {
+	values := make([]string, len(c.labels))
+	for i := range values {
+		values[i] = Overflow
+	}
+	key := strings.Join(values, "\xff")
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{values: values}
+		c.series[key] = s
+		c.maxSeries++
+	}
+	return s
+}
+
+// Snapshot returns the current value of every series.
+func (c *CounterVec) Snapshot() []Sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	samples := make([]Sample, 0, len(c.series))
+	for _, s := range c.series {
+		labels := make(map[string]string, len(c.labels))
+		for i, name := range c.labels {
+			labels[name] = s.values[i]
+		}
+		samples = append(samples, Sample{Labels: labels, Value: s.count})
+	}
+	return samples
+}
+
+// writeTo writes the counter in the Prometheus text exposition format.
+func (c *CounterVec) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := c.series[key]
+		if len(c.labels) == 0 {
+			fmt.Fprintf(w, "%s %d\n", c.name, s.count)
+			continue
+		}
+		pairs := make([]string, len(c.labels))
+		for i, name := range c.labels {
+			pairs[i] = name + `="` + escapeLabel(s.values[i]) + `"`
+		}
+		fmt.Fprintf(w, "%s{%s} %d\n", c.name, strings.Join(pairs, ","), s.count)
+	}
+}
+
+// labelEscaper escapes label values the way the text exposition format
+// reads them, which only knows \\, \" and \n.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel returns value escaped for the text exposition format, invalid
+// UTF-8 replaced.
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(strings.ToValidUTF8(value, "\uFFFD"))
+}
+
+// Gauge is a single value that can go up and down, such as the number of
+// open connections.
+type Gauge struct {
+	name string
+	help string
+
+	mu    sync.Mutex
+	value int64
+}
+
+// NewGauge returns a gauge starting at zero.
+func NewGauge(name, help string) *Gauge {
+	return &Gauge{name: name, help: help}
+}
+
+// Inc increments the gauge by one.
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Add adds n, which may be negative, to the gauge.
+func (g *Gauge) Add(n int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value += n
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// writeTo writes the gauge in the Prometheus text exposition format.
+func (g *Gauge) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.name, g.help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+	fmt.Fprintf(w, "%s %d\n", g.name, g.Value())
+}
+
+// Histogram counts observations, such as frame sizes, in cumulative buckets.
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64 // counts[i] is the number of observations <= buckets[i], the last one counting all.
+	sum    float64
+}
+
+// HistogramSnapshot is the state of a Histogram at one point in time.
+type HistogramSnapshot struct {
+	// Buckets are the upper bounds, Counts[i] the number of observations
+	// less than or equal to Buckets[i]. Counts has one more element, the
+	// total number of observations.
+	Buckets []float64
+	Counts  []uint64
+	Sum     float64
+}
+
+// NewHistogram returns a histogram with the given bucket upper bounds, which
+// must be sorted in increasing order.
+func NewHistogram(name, help string, buckets ...float64) *Histogram {
+	return &Histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// ExponentialBuckets returns n bucket bounds starting at start, each factor
+// times the previous one.
+func ExponentialBuckets(start, factor float64, n int) []float64 {
+	buckets := make([]float64, n)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Observe records one observation of v.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := sort.SearchFloat64s(h.buckets, v); i < len(h.counts); i++ {
+		h.counts[i]++
+	}
+	h.sum += v
+}
+
+// Snapshot returns the current state of the histogram.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return HistogramSnapshot{
+		Buckets: append([]float64(nil), h.buckets...),
+		Counts:  append([]uint64(nil), h.counts...),
+		Sum:     h.sum,
+	}
+}
+
+// writeTo writes the histogram in the Prometheus text exposition format.
+func (h *Histogram) writeTo(w io.Writer) {
+	s := h.Snapshot()
+	fmt.Fprintf(w, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", h.name)
+	for i, bound := range s.Buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, strconv.FormatFloat(bound, 'f', -1, 64), s.Counts[i])
+	}
+	total := s.Counts[len(s.Counts)-1]
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, total)
+	fmt.Fprintf(w, "%s_sum %g\n", h.name, s.Sum)
+	fmt.Fprintf(w, "%s_count %d\n", h.name, total)
+}
+
+// collector is implemented by every metric a Registry can hold.
+type collector interface {
+	writeTo(w io.Writer)
+}
+
+// Registry is a set of metrics written out together.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// Default is the registry the server's built-in counters are registered with.
+var Default = &Registry{}
+
+// Register adds c to the registry and returns it.
+func (r *Registry) Register(c *CounterVec) *CounterVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+	return c
+}
+
+// RegisterGauge adds g to the registry and returns it.
+func (r *Registry) RegisterGauge(g *Gauge) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, g)
+	return g
+}
+
+// RegisterHistogram adds h to the registry and returns it.
+func (r *Registry) RegisterHistogram(h *Histogram) *Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, h)
+	return h
+}
+
+// WriteText writes every registered metric in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.collectors {
+		c.writeTo(w)
+	}
+}
+
+// Handler returns an HTTP handler serving the registry to Prometheus.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// ListenAndServe serves the Default registry on /metrics at addr. It blocks
+// like http.ListenAndServe.
+func ListenAndServe(addr string) error {
+	return NewServer(addr).ListenAndServe()
+}
+
+// NewServer returns an HTTP server serving the Default registry on /metrics
+// at addr, for callers that need to shut it down.
+func NewServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Default.Handler())
+	return &http.Server{Addr: addr, Handler: mux}
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelEscaping(t *testing.T) {
+	c := NewCounterVec("rooms_total", "Rooms.", 10, "room")
+	c.Inc("a\\b\"c\nd")
+	c.Inc("café")
+	c.Inc("bad\xffutf8")
+
+	var out strings.Builder
+	c.writeTo(&out)
+	for _, want := range []string{
+		`rooms_total{room="a\\b\"c\nd"} 1`,
+		`rooms_total{room="café"} 1`,
+		"rooms_total{room=\"bad\uFFFDutf8\"} 1",
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("exposition misses %s:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestCardinalityLimit(t *testing.T) {
+	c := NewCounterVec("frames_total", "Frames.", 2, "tenant")
+	for _, tenant := range []string{"a", "b", "c", "d"} {
+		c.Inc(tenant)
+	}
+	counts := make(map[string]uint64)
+	for _, s := range c.Snapshot() {
+		counts[s.Labels["tenant"]] = s.Value
+	}
+	if len(counts) != 3 || counts["a"] != 1 || counts["b"] != 1 || counts[Overflow] != 2 {
+		t.Errorf("series %v, want a, b and 2 in the overflow", counts)
+	}
+}
@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// MessageHandler handles one complete message.
+type MessageHandler func(opcode byte, data []byte) error
+
+// Middleware wraps a MessageHandler to inspect, rewrite or drop messages
+// before they reach next. Not calling next drops the message.
+type Middleware func(next MessageHandler) MessageHandler
+
+// chain wraps final with middleware, the first middleware being the outermost.
+func chain(middleware []Middleware, final MessageHandler) MessageHandler {
+	handler := final
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// LogMessages is a middleware logging the opcode and size of every message
+// that passes through it, tagged with prefix.
+func LogMessages(prefix string) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(opcode byte, data []byte) error {
+			slog.Info(prefix, "opcode", fmt.Sprintf("0x%X", opcode), "bytes", len(data))
+			return next(opcode, data)
+		}
+	}
+}
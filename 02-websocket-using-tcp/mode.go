@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+/**
+ * * Mode selects how strictly a Server or a Client holds its peer to RFC 6455.
+ *
+ * * Strict (the default) enforces every rule, which is what the Autobahn TestSuite and interop
+ * * testing expect. Lenient tolerates deviations common in the wild:
+ * 		- handshakes without Sec-WebSocket-Version 13, with a Sec-WebSocket-Key that is not 16
+ * 		  base64 encoded bytes, or without the "upgrade" token in the Connection header (the
+ * 		  client side skips the Upgrade and Connection checks on the 101 response),
+ * 		- frames that are not masked the way their direction requires,
+ * 		- close frames with a truncated or invalid close code, or a reason that is not UTF-8,
+ * 		  which are treated as a close without status code,
+ * 		- text messages that are not valid UTF-8, which are delivered as they are.
+ *
+ * * Header names and the values of Upgrade and Connection are compared case-insensitively in both
+ * * modes, as the RFC requires.
+ */
+type Mode int
+
+const (
+	Strict Mode = iota
+	Lenient
+)
+
+// checkHandshake validates the opening handshake of a client and returns the
+// HTTP status to reject it with.
+func checkHandshake(request *http.Request, mode Mode) (int, error) {
+	if !headerHasToken(request.Header, "Upgrade", "websocket") {
+		return http.StatusBadRequest, fmt.Errorf("%w: missing Upgrade: websocket header", ErrBadHandshake)
+	}
+	key := request.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return http.StatusBadRequest, fmt.Errorf("%w: missing Sec-WebSocket-Key header", ErrBadHandshake)
+	}
+	if mode == Lenient {
+		return 0, nil
+	}
+
+	if request.Method != http.MethodGet || !request.ProtoAtLeast(1, 1) {
+		return http.StatusBadRequest, fmt.Errorf("%w: handshake must be a GET request over HTTP/1.1", ErrBadHandshake)
+	}
+	if !headerHasToken(request.Header, "Connection", "upgrade") {
+		return http.StatusBadRequest, fmt.Errorf("%w: missing Connection: Upgrade header", ErrBadHandshake)
+	}
+	if request.Header.Get("Sec-WebSocket-Version") != "13" {
+		return http.StatusUpgradeRequired, fmt.Errorf("%w: unsupported Sec-WebSocket-Version %q", ErrBadHandshake, request.Header.Get("Sec-WebSocket-Version"))
+	}
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		return http.StatusBadRequest, fmt.Errorf("%w: invalid Sec-WebSocket-Key header", ErrBadHandshake)
+	}
+	return 0, nil
+}
+
+// checkHandshakeResponse validates the 101 response of a server beyond its
+// Sec-WebSocket-Accept header, which is checked in both modes.
+func checkHandshakeResponse(response *http.Response, mode Mode) error {
+	if mode == Lenient {
+		return nil
+	}
+	if !headerHasToken(response.Header, "Upgrade", "websocket") {
+		return fmt.Errorf("%w: missing Upgrade: websocket header", ErrBadHandshake)
+	}
+	if !headerHasToken(response.Header, "Connection", "upgrade") {
+		return fmt.Errorf("%w: missing Connection: Upgrade header", ErrBadHandshake)
+	}
+	return nil
+}
+
+// headerHasToken reports whether the comma separated header contains token,
+// ignoring case.
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectSubprotocol returns the first of supported the client offered in the
+// Sec-WebSocket-Protocol headers of request, "" when there is none.
+func selectSubprotocol(request *http.Request, supported []string) string {
+	var offered []string
+	for _, value := range request.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(value, ",") {
+			offered = append(offered, strings.TrimSpace(protocol))
+		}
+	}
+	for _, protocol := range supported {
+		if slices.Contains(offered, protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
@@ -0,0 +1,238 @@
+/**
+ * * Package mqtt bridges MQTT over WebSocket to an MQTT broker over TCP, for browser clients such as
+ * * MQTT.js or Paho talking to a Mosquitto, EMQX or HiveMQ that only listens on 1883. Every
+ * * WebSocket connection negotiating the mqtt subprotocol gets a TCP connection to the broker of
+ * * its own, and the MQTT 3.1.1 control packets travel unchanged between the two: CONNECT,
+ * * SUBSCRIBE, PUBLISH and the rest, whatever their QoS.
+ *
+ * * Packets may be split across WebSocket messages or share one, the bridge finds their boundaries
+ * * from the remaining length and sends each packet upstream as it completes, and each packet from
+ * * the broker in a binary message of its own.
+ *
+ * * Keep-alive is translated rather than passed through. The bridge answers the PINGREQs of the
+ * * client itself and closes the connection when the client is silent for one and a half times its
+ * * keep-alive, as a broker would. Towards the broker, CONNECT declares Bridge.KeepAlive and the
+ * * bridge sends the PINGREQs, so a browser tab throttled in the background cannot get the
+ * * session dropped upstream, while WebSocket pings keep the browser side alive.
+ */
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"websocket"
+)
+
+// Subprotocols are the names MQTT clients offer in Sec-WebSocket-Protocol,
+// "mqttv3.1" by clients of MQTT 3.1.
+var Subprotocols = []string{"mqtt", "mqttv3.1"}
+
+const (
+	// defaultKeepAlive is the keep-alive declared to the broker when
+	// Bridge.KeepAlive is zero.
+	defaultKeepAlive = 60 * time.Second
+
+	// defaultMaxPacketSize is the largest packet forwarded either way when
+	// Bridge.MaxPacketSize is zero.
+	defaultMaxPacketSize = 1 << 20
+
+	// connectTimeout is how long clients have to send CONNECT.
+	connectTimeout = 10 * time.Second
+)
+
+// Bridge forwards MQTT over WebSocket to a broker over TCP.
+type Bridge struct {
+	// Upstream is the host:port of the MQTT broker.
+	Upstream string
+
+	// Dial opens the connection to Upstream, a net.Dialer when nil. Set it
+	// to reach the broker over TLS.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// KeepAlive is the keep-alive the bridge declares to the broker and
+	// pings it at, whatever the client asked for. Zero means 60 seconds.
+	KeepAlive time.Duration
+
+	// MaxPacketSize is the largest packet forwarded in either direction,
+	// larger ones close the connection. Zero means 1MB.
+	MaxPacketSize int
+}
+
+// Handle bridges a connection to the broker, it is a websocket.Handler.
+func (b *Bridge) Handle(conn *websocket.Conn) {
+	if !slices.Contains(Subprotocols, conn.Subprotocol()) {
+		conn.Close(1002, "mqtt subprotocol required")
+		return
+	}
+	stream := websocket.NetConn(conn)
+	defer stream.Close()
+
+	if err := b.bridge(conn, stream); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		conn.Logger().Warn("MQTT bridge closed", "err", err)
+		return
+	}
+	conn.Logger().Info("MQTT client disconnected")
+}
+
+func (b *Bridge) bridge(conn *websocket.Conn, stream net.Conn) error {
+	client := bufio.NewReader(stream)
+	stream.SetReadDeadline(time.Now().Add(connectTimeout))
+	connect, err := readPacket(client, b.maxPacketSize())
+	if err != nil {
+		return err
+	}
+	if packetType(connect) != typeConnect {
+		return fmt.Errorf("%w: first packet is %s, not CONNECT", ErrPacket, packetNames[packetType(connect)])
+	}
+	offset, clientKeepAlive, err := connectKeepAlive(connect)
+	if err != nil {
+		return err
+	}
+	keepAlive := b.keepAlive()
+	binary.BigEndian.PutUint16(connect[offset:], uint16(min(max(keepAlive/time.Second, 1), 65535)))
+
+	dial := b.Dial
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+	upstream, err := dial(conn.Context(), "tcp", b.Upstream)
+	if err != nil {
+		return fmt.Errorf("connecting to the MQTT broker: %w", err)
+	}
+	defer upstream.Close()
+	conn.Logger().Info("MQTT client bridged", "upstream", b.Upstream, "client_keepalive", clientKeepAlive, "upstream_keepalive", keepAlive)
+
+	// writeMu serialises the packets the client sends and the pings of
+	// the bridge.
+	var writeMu sync.Mutex
+	writeUpstream := func(packet []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err := upstream.Write(packet)
+		return err
+	}
+	if err := writeUpstream(connect); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- b.fromBroker(conn, stream, upstream)
+	}()
+	stopPings := pingEvery(keepAlive, func() error { return writeUpstream(pingreq) })
+	defer stopPings()
+
+	err = b.fromClient(conn, client, stream, time.Duration(clientKeepAlive)*time.Second, writeUpstream)
+	// Whichever side ended, closing both ends the other copy.
+	upstream.Close()
+	stream.Close()
+	if brokerErr := <-done; err == nil || errors.Is(err, net.ErrClosed) {
+		err = brokerErr
+	}
+	return err
+}
+
+// fromClient forwards the packets of the client until it disconnects,
+// answering its PINGREQs.
+func (b *Bridge) fromClient(conn *websocket.Conn, client *bufio.Reader, stream net.Conn, keepAlive time.Duration, writeUpstream func([]byte) error) error {
+	for {
+		if keepAlive > 0 {
+			stream.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		} else {
+			stream.SetReadDeadline(time.Time{})
+		}
+		packet, err := readPacket(client, b.maxPacketSize())
+		if errors.Is(err, os.ErrDeadlineExceeded) && keepAlive > 0 {
+			return fmt.Errorf("client silent for 1.5 times its keep-alive of %s: %w", keepAlive, err)
+		}
+		if err != nil {
+			return err
+		}
+		switch packetType(packet) {
+		case typeConnect:
+			return fmt.Errorf("%w: second CONNECT", ErrPacket)
+		case typePingreq:
+			if _, err := stream.Write(pingresp); err != nil {
+				return err
+			}
+			continue
+		}
+		conn.Logger().Debug("MQTT packet to broker", "type", packetNames[packetType(packet)], "size", len(packet))
+		if err := writeUpstream(packet); err != nil {
+			return err
+		}
+		if packetType(packet) == typeDisconnect {
+			return nil
+		}
+	}
+}
+
+// fromBroker sends the packets of the broker to the client, one binary
+// message each, but for the PINGRESPs answering the bridge's own pings.
+func (b *Bridge) fromBroker(conn *websocket.Conn, stream, upstream net.Conn) error {
+	broker := bufio.NewReader(upstream)
+	for {
+		packet, err := readPacket(broker, b.maxPacketSize())
+		if err != nil {
+			return err
+		}
+		switch packetType(packet) {
+		case typePingresp:
+			continue
+		case typeConnack:
+			if code := body(packet); len(code) == 2 && code[1] != 0 {
+				conn.Logger().Warn("MQTT broker refused the connection", "return_code", code[1])
+			}
+		}
+		conn.Logger().Debug("MQTT packet to client", "type", packetNames[packetType(packet)], "size", len(packet))
+		if _, err := stream.Write(packet); err != nil {
+			return err
+		}
+	}
+}
+
+// pingEvery calls ping every interval until the returned function is called
+// or ping fails.
+func pingEvery(interval time.Duration, ping func() error) func() {
+	ticker := time.NewTicker(interval)
+	stop := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if ping() != nil {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+func (b *Bridge) keepAlive() time.Duration {
+	if b.KeepAlive <= 0 {
+		return defaultKeepAlive
+	}
+	return b.KeepAlive
+}
+
+func (b *Bridge) maxPacketSize() int {
+	if b.MaxPacketSize <= 0 {
+		return defaultMaxPacketSize
+	}
+	return b.MaxPacketSize
+}
@@ -0,0 +1,120 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrPacket is returned for bytes that are not an MQTT 3.1.1 control packet.
+var ErrPacket = errors.New("mqtt: malformed packet")
+
+// The control packet types the bridge looks at, the high nibble of the
+// first byte.
+const (
+	typeConnect    = 1
+	typeConnack    = 2
+	typePingreq    = 12
+	typePingresp   = 13
+	typeDisconnect = 14
+)
+
+// packetNames names the control packet types in the logs.
+var packetNames = [16]string{
+	1: "CONNECT", 2: "CONNACK", 3: "PUBLISH", 4: "PUBACK", 5: "PUBREC", 6: "PUBREL", 7: "PUBCOMP",
+	8: "SUBSCRIBE", 9: "SUBACK", 10: "UNSUBSCRIBE", 11: "UNSUBACK", 12: "PINGREQ", 13: "PINGRESP",
+	14: "DISCONNECT",
+}
+
+// pingresp is the whole PINGRESP packet, the answer to a PINGREQ.
+var pingresp = []byte{typePingresp << 4, 0}
+
+// pingreq is the whole PINGREQ packet.
+var pingreq = []byte{typePingreq << 4, 0}
+
+/**
+ * * readPacket reads one control packet from r and returns it whole, fixed header included: the
+ * * type and flags byte, the remaining length in one to four bytes of seven bits, least
+ * * significant first, and that many bytes.
+ *
+ *	30 0d 00 04 6e 65 77 73 68 65 6c 6c 6f 21 21   PUBLISH, 13 bytes: topic "news", "hello!!"
+ */
+func readPacket(r *bufio.Reader, maxSize int) ([]byte, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	header := []byte{first}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		header = append(header, b)
+		length += int(b&0x7F) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return nil, fmt.Errorf("%w: remaining length longer than 4 bytes", ErrPacket)
+		}
+		multiplier *= 128
+	}
+	if len(header)+length > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes, more than the limit of %d", ErrPacket, len(header)+length, maxSize)
+	}
+	packet := make([]byte, len(header)+length)
+	copy(packet, header)
+	if _, err := io.ReadFull(r, packet[len(header):]); err != nil {
+		return nil, noEOF(err)
+	}
+	return packet, nil
+}
+
+// noEOF reports a stream ending within a packet as io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func packetType(packet []byte) byte {
+	return packet[0] >> 4
+}
+
+// body returns the packet after its fixed header.
+func body(packet []byte) []byte {
+	i := 1
+	for packet[i]&0x80 != 0 {
+		i++
+	}
+	return packet[i+1:]
+}
+
+/**
+ * * connectKeepAlive returns the offset of the keep-alive of a CONNECT packet and its value in
+ * * seconds. It follows the protocol name, "MQTT" for 3.1.1 or "MQIsdp" for 3.1, the protocol
+ * * level and the connect flags:
+ *
+ *	10 10 00 04 4d 51 54 54 04 02 00 3c 00 04 6d 79 69 64
+ *	CONNECT   "MQTT"        lvl flags keep-alive 60s, client id "myid"
+ */
+func connectKeepAlive(packet []byte) (int, uint16, error) {
+	b := body(packet)
+	if len(b) < 2 {
+		return 0, 0, fmt.Errorf("%w: CONNECT too short", ErrPacket)
+	}
+	nameLength := int(binary.BigEndian.Uint16(b))
+	offset := 2 + nameLength + 2
+	if len(b) < offset+2 {
+		return 0, 0, fmt.Errorf("%w: CONNECT too short", ErrPacket)
+	}
+	if name := string(b[2 : 2+nameLength]); name != "MQTT" && name != "MQIsdp" {
+		return 0, 0, fmt.Errorf("%w: protocol name %q", ErrPacket, name)
+	}
+	return len(packet) - len(b) + offset, binary.BigEndian.Uint16(b[offset:]), nil
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+/**
+ * * NetConn adapts conn to a net.Conn, so stream-oriented code such as the TCP echo of module 01,
+ * * an HTTP client or a tunnel can run over the WebSocket connection unchanged. Every Write is sent
+ * * as one binary message, and Read returns the payloads of the messages received, text or binary,
+ * * one after the other, ignoring where one message ends and the next begins.
+ *
+ * * Read returns io.EOF once the client closed the connection with 1000, 1001 or no status, and
+ * * the CloseError for any other code. Close is Conn.Close(1000, ""). The deadlines are those of
+ * * the underlying connection. Use it from a handler, not in reactor mode where the poller reads.
+ */
+func NetConn(conn *Conn) net.Conn {
+	return &messageConn{
+		conn:  conn.conn,
+		next:  conn.NextReader,
+		write: conn.WriteMessage,
+		close: func() error { return conn.Close(closeNormal, "") },
+	}
+}
+
+// ClientNetConn is NetConn for the client side of a connection.
+func ClientNetConn(client *Client) net.Conn {
+	return &messageConn{
+		conn:  client.conn,
+		next:  client.NextReader,
+		write: client.WriteMessage,
+		close: func() error { return client.CloseWith(closeNormal, "") },
+	}
+}
+
+// messageConn is a stream over the messages of a Conn or Client.
+type messageConn struct {
+	conn  net.Conn
+	next  func() (byte, io.Reader, error)
+	write func(opcode byte, data []byte) error
+	close func() error
+
+	// readMu serialises Reads, which share the message being read.
+	readMu sync.Mutex
+	reader io.Reader
+}
+
+func (c *messageConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		if c.reader == nil {
+			_, r, err := c.next()
+			if err != nil {
+				return 0, streamError(err)
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue // Empty message, or the end of this one.
+			}
+			err = nil
+		}
+		return n, streamError(err)
+	}
+}
+
+// streamError maps a normal close to the plain io.EOF that io.Copy and
+// bufio expect at the end of a stream.
+func streamError(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		switch closeErr.Code {
+		case closeNormal, closeGoingAway, closeNoStatus:
+			return io.EOF
+		}
+	}
+	return err
+}
+
+func (c *messageConn) Write(p []byte) (int, error) {
+	if err := c.write(0x2, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *messageConn) Close() error {
+	return c.close()
+}
+
+func (c *messageConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *messageConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *messageConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *messageConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *messageConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
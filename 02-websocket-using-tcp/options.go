@@ -0,0 +1,236 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"netsim/throttle"
+)
+
+// ServerOption configures a Server built by NewServer.
+type ServerOption func(*Server)
+
+// ClientOption configures the Dialer of Dial and DialContext.
+type ClientOption func(*Dialer)
+
+/**
+ * * NewServer returns a Server listening on addr, a TCP address or unix:/path, once ListenAndServe
+ * * is called, configured by opts:
+ *
+ *	server := websocket.NewServer(":4443",
+ *		websocket.WithHandler(chat.AckHandler),
+ *		websocket.WithTLS(tlsConfig),
+ *		websocket.WithMaxMessageSize(1<<20),
+ *	)
+ *	err := server.ListenAndServe()
+ *
+ * * Settings without an option keep the defaults of a zero Server, the options only set fields and
+ * * a Server can still be configured through them directly. The handler is EchoHandler unless
+ * * WithHandler is given.
+ */
+func NewServer(addr string, opts ...ServerOption) *Server {
+	s := &Server{Addr: addr, Handler: EchoHandler}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithHandler sets the handler run for every connection.
+func WithHandler(handler Handler) ServerOption {
+	return func(s *Server) { s.Handler = handler }
+}
+
+// WithReactor serves connections with handler in reactor mode, see
+// Server.Reactor.
+func WithReactor(handler ReactorHandler) ServerOption {
+	return func(s *Server) { s.Reactor = &handler }
+}
+
+// WithWorkers sets Server.Workers.
+func WithWorkers(pool *WorkerPool) ServerOption {
+	return func(s *Server) { s.Workers = pool }
+}
+
+// WithTLS serves wss:// with config.
+func WithTLS(config *tls.Config) ServerOption {
+	return func(s *Server) { s.TLSConfig = config }
+}
+
+// WithHTTP2 accepts WebSockets over HTTP/2 too, see Server.HTTP2.
+func WithHTTP2() ServerOption {
+	return func(s *Server) { s.HTTP2 = true }
+}
+
+// WithProxyProtocol expects a PROXY protocol header on every connection,
+// see Server.ProxyProtocol.
+func WithProxyProtocol() ServerOption {
+	return func(s *Server) { s.ProxyProtocol = true }
+}
+
+// WithTCP sets Server.TCP, for example to LowLatency or HighThroughput.
+func WithTCP(options TCPOptions) ServerOption {
+	return func(s *Server) { s.TCP = options }
+}
+
+// WithBandwidth limits how fast every connection is read and written, see
+// Server.Bandwidth.
+func WithBandwidth(limit throttle.Limit) ServerOption {
+	return func(s *Server) { s.Bandwidth = limit }
+}
+
+// WithReusePort sets Server.ReusePort.
+func WithReusePort() ServerOption {
+	return func(s *Server) { s.ReusePort = true }
+}
+
+// WithMode sets how strictly clients are held to RFC 6455.
+func WithMode(mode Mode) ServerOption {
+	return func(s *Server) { s.Mode = mode }
+}
+
+// WithMaxMessageSize sets Server.MaxMessageSize.
+func WithMaxMessageSize(size int64) ServerOption {
+	return func(s *Server) { s.MaxMessageSize = size }
+}
+
+// WithFragmentSize sets Server.FragmentSize.
+func WithFragmentSize(size int) ServerOption {
+	return func(s *Server) { s.FragmentSize = size }
+}
+
+// WithMaxFrameSize sets Server.MaxFrameSize.
+func WithMaxFrameSize(size uint64) ServerOption {
+	return func(s *Server) { s.MaxFrameSize = size }
+}
+
+// WithReassemblyTimeout sets Server.ReassemblyTimeout.
+func WithReassemblyTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) { s.ReassemblyTimeout = timeout }
+}
+
+// WithMaxConnections sets Server.MaxConnections and QueueConnections.
+func WithMaxConnections(max int, queue bool) ServerOption {
+	return func(s *Server) { s.MaxConnections, s.QueueConnections = max, queue }
+}
+
+// WithHandshakeTimeout sets Server.HandshakeTimeout.
+func WithHandshakeTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) { s.HandshakeTimeout = timeout }
+}
+
+// WithPingInterval pings every connection at interval, see Conn.Latency.
+func WithPingInterval(interval time.Duration) ServerOption {
+	return func(s *Server) { s.PingInterval = interval }
+}
+
+// WithSendQueue sets Server.SendQueueSize and SendQueuePolicy.
+func WithSendQueue(size int, policy QueuePolicy) ServerOption {
+	return func(s *Server) { s.SendQueueSize, s.SendQueuePolicy = size, policy }
+}
+
+// WithSessionKey makes sessions sticky, see Server.SessionKey and
+// SessionTTL.
+func WithSessionKey(key []byte, ttl time.Duration) ServerOption {
+	return func(s *Server) { s.SessionKey, s.SessionTTL = key, ttl }
+}
+
+// WithLogger sets the logger of the server and its connections.
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(s *Server) { s.Logger = logger }
+}
+
+// WithSubprotocols sets the subprotocols the server selects from.
+func WithSubprotocols(protocols ...string) ServerOption {
+	return func(s *Server) { s.Subprotocols = protocols }
+}
+
+// WithAllowedOrigins sets the origins browsers may connect from.
+func WithAllowedOrigins(origins ...string) ServerOption {
+	return func(s *Server) { s.AllowedOrigins = origins }
+}
+
+// DialTLS sets the TLS configuration of wss:// connections.
+func DialTLS(config *tls.Config) ClientOption {
+	return func(d *Dialer) { d.TLSConfig = config }
+}
+
+// DialHTTP2 opens the WebSocket over HTTP/2, see Dialer.HTTP2.
+func DialHTTP2() ClientOption {
+	return func(d *Dialer) { d.HTTP2 = true }
+}
+
+// DialTCP sets Dialer.TCP, for example to LowLatency or HighThroughput.
+func DialTCP(options TCPOptions) ClientOption {
+	return func(d *Dialer) { d.TCP = options }
+}
+
+// DialBandwidth limits how fast the connection is read and written, see
+// Dialer.Bandwidth.
+func DialBandwidth(limit throttle.Limit) ClientOption {
+	return func(d *Dialer) { d.Bandwidth = limit }
+}
+
+// DialUnix dials the unix domain socket at path, see Dialer.UnixSocket.
+func DialUnix(path string) ClientOption {
+	return func(d *Dialer) { d.UnixSocket = path }
+}
+
+// DialProxy connects through proxy, an http://, https://, socks5:// or
+// socks5h:// URL, instead of the proxy of the environment. A nil proxy
+// connects directly.
+func DialProxy(proxy *url.URL) ClientOption {
+	return func(d *Dialer) {
+		d.Proxy = nil
+		if proxy != nil {
+			d.Proxy = ProxyURL(proxy)
+		}
+	}
+}
+
+// DialMode sets how strictly the server is held to RFC 6455.
+func DialMode(mode Mode) ClientOption {
+	return func(d *Dialer) { d.Mode = mode }
+}
+
+// DialTimeout bounds the dial and the opening handshake.
+func DialTimeout(timeout time.Duration) ClientOption {
+	return func(d *Dialer) { d.HandshakeTimeout = timeout }
+}
+
+// DialSubprotocols offers protocols to the server, in order of preference.
+func DialSubprotocols(protocols ...string) ClientOption {
+	return func(d *Dialer) { d.Subprotocols = protocols }
+}
+
+// DialSessionToken resumes the session of token, see Dialer.SessionToken.
+func DialSessionToken(token string) ClientOption {
+	return func(d *Dialer) { d.SessionToken = token }
+}
+
+// DialMaxMessageSize sets Client.MaxMessageSize.
+func DialMaxMessageSize(size int64) ClientOption {
+	return func(d *Dialer) { d.MaxMessageSize = size }
+}
+
+// DialFragmentSize sets Client.FragmentSize.
+func DialFragmentSize(size int) ClientOption {
+	return func(d *Dialer) { d.FragmentSize = size }
+}
+
+// DialMaxFrameSize sets Client.MaxFrameSize.
+func DialMaxFrameSize(size uint64) ClientOption {
+	return func(d *Dialer) { d.MaxFrameSize = size }
+}
+
+// DialReassemblyTimeout sets Client.ReassemblyTimeout.
+func DialReassemblyTimeout(timeout time.Duration) ClientOption {
+	return func(d *Dialer) { d.ReassemblyTimeout = timeout }
+}
+
+// DialLogger sets the logger of the client's wire trace.
+func DialLogger(logger *slog.Logger) ClientOption {
+	return func(d *Dialer) { d.Logger = logger }
+}
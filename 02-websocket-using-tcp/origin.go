@@ -0,0 +1,23 @@
+package websocket
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// checkOrigin reports an error when the handshake request comes from a page
+// whose origin is not in allowed. Origins are compared case-insensitively,
+// browsers always send them in lower case but configurations may not be.
+func checkOrigin(request *http.Request, allowed []string) error {
+	origin := request.Header.Get("Origin")
+	if origin == "" || len(allowed) == 0 {
+		return nil
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q is not allowed", origin)
+}
@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"sort"
+	"sync"
+)
+
+/**
+ * * Outbox journals the messages delivered to rooms until their recipients acknowledged them, so
+ * * that a client missing some, because its connection dropped before they were written or before
+ * * it processed them, gets them again when it comes back: at-least-once delivery. Unlike the
+ * * history, which keeps the last HistorySize messages of a room in memory, an outbox may keep
+ * * them on disk, across restarts of the server, and keeps every message some client did not ack.
+ *
+ * * Clients are told apart by a stable ID rather than their connection, see Rooms.Outbox. A client
+ * * acks a room up to a sequence number, and the messages every client of the room acked are
+ * * dropped from the journal: a client that never comes back holds the messages after its last
+ * * ack until Forget drops its cursor.
+ *
+ * * Implementations must be safe for concurrent use. MemoryOutbox keeps the journal in memory, the
+ * * outbox/bolt package in a bbolt file.
+ */
+type Outbox interface {
+	// Append journals msg, numbered already, as a message of room. It only
+	// records the sequence number when no client has a cursor there, nobody
+	// would ever ack the message.
+	Append(room string, msg RoomMessage) error
+
+	// Since returns the journaled messages of room after seq, in order.
+	Since(room string, seq uint64) ([]RoomMessage, error)
+
+	// Last returns the sequence number of the last message journaled in
+	// room, zero when there is none, for the numbering to go on from.
+	Last(room string) (uint64, error)
+
+	// Ack records that client received the messages of room up to seq, and
+	// drops the messages every client of room acked. The first Ack of a
+	// client, with the sequence number it joins at, starts its cursor.
+	Ack(client, room string, seq uint64) error
+
+	// Acked returns the sequence number client last acked in room, ok false
+	// when it never did.
+	Acked(client, room string) (seq uint64, ok bool, err error)
+
+	// Forget drops the cursor of client in room, which no longer holds
+	// messages there.
+	Forget(client, room string) error
+
+	// Close releases the storage of the outbox.
+	Close() error
+}
+
+// MemoryOutbox is an Outbox in memory: it survives client reconnects, not a
+// restart of the server.
+type MemoryOutbox struct {
+	mu    sync.Mutex
+	rooms map[string]*outboxRoom
+}
+
+// outboxRoom is the journal of a room of a MemoryOutbox.
+type outboxRoom struct {
+	messages []RoomMessage // In sequence order.
+	last     uint64
+	acked    map[string]uint64
+}
+
+// NewMemoryOutbox returns an empty MemoryOutbox.
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{rooms: make(map[string]*outboxRoom)}
+}
+
+// room returns the journal of name, creating it on first use. The caller
+// must hold o.mu.
+func (o *MemoryOutbox) room(name string) *outboxRoom {
+	r, ok := o.rooms[name]
+	if !ok {
+		r = &outboxRoom{acked: make(map[string]uint64)}
+		o.rooms[name] = r
+	}
+	return r
+}
+
+func (o *MemoryOutbox) Append(room string, msg RoomMessage) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	r := o.room(room)
+	r.last = max(r.last, msg.Seq)
+	if len(r.acked) > 0 {
+		r.messages = append(r.messages, msg)
+	}
+	return nil
+}
+
+func (o *MemoryOutbox) Since(room string, seq uint64) ([]RoomMessage, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	r := o.room(room)
+	i := sort.Search(len(r.messages), func(i int) bool { return r.messages[i].Seq > seq })
+	return append([]RoomMessage(nil), r.messages[i:]...), nil
+}
+
+func (o *MemoryOutbox) Last(room string) (uint64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.room(room).last, nil
+}
+
+func (o *MemoryOutbox) Ack(client, room string, seq uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	r := o.room(room)
+	if acked, ok := r.acked[client]; ok && seq <= acked {
+		return nil
+	}
+	r.acked[client] = seq
+	r.trim()
+	return nil
+}
+
+// trim drops the messages every client of the room acked, all of them once
+// no client is left.
+func (r *outboxRoom) trim() {
+	oldest := ^uint64(0)
+	for _, seq := range r.acked {
+		oldest = min(oldest, seq)
+	}
+	i := sort.Search(len(r.messages), func(i int) bool { return r.messages[i].Seq > oldest })
+	r.messages = append(r.messages[:0:0], r.messages[i:]...)
+}
+
+func (o *MemoryOutbox) Acked(client, room string) (uint64, bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	seq, ok := o.room(room).acked[client]
+	return seq, ok, nil
+}
+
+func (o *MemoryOutbox) Forget(client, room string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	r := o.room(room)
+	if _, ok := r.acked[client]; ok {
+		delete(r.acked, client)
+		r.trim()
+	}
+	return nil
+}
+
+func (o *MemoryOutbox) Close() error {
+	return nil
+}
@@ -0,0 +1,197 @@
+// Package bolt is a websocket.Outbox in a bbolt file, so that the messages
+// clients did not ack survive a restart of the server.
+//
+// Every room is a bucket holding the journaled messages, keyed by their
+// sequence number in big endian so that they sort in order, the cursor of
+// every client and the sequence number of the last message. Every Append
+// and Ack is a transaction synced to disk before it returns.
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"websocket"
+)
+
+var (
+	messagesBucket = []byte("messages")
+	ackedBucket    = []byte("acked")
+	lastKey        = []byte("last")
+)
+
+// Outbox journals the messages of the rooms in one bbolt file.
+type Outbox struct {
+	db *bbolt.DB
+}
+
+var _ websocket.Outbox = (*Outbox)(nil)
+
+// Open opens the outbox at path, creating the file when it does not exist.
+// A file is opened by one process at a time, Open waits a second for
+// another one to close it.
+func Open(path string) (*Outbox, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &Outbox{db: db}, nil
+}
+
+// Close closes the file.
+func (o *Outbox) Close() error {
+	return o.db.Close()
+}
+
+func (o *Outbox) Append(room string, msg websocket.RoomMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return o.db.Update(func(tx *bbolt.Tx) error {
+		b, err := roomBucket(tx, room)
+		if err != nil {
+			return err
+		}
+		if msg.Seq > seqOf(b.Get(lastKey)) {
+			if err := b.Put(lastKey, key(msg.Seq)); err != nil {
+				return err
+			}
+		}
+		// Nobody would ever ack the message
+		if k, _ := b.Bucket(ackedBucket).Cursor().First(); k == nil {
+			return nil
+		}
+		return b.Bucket(messagesBucket).Put(key(msg.Seq), data)
+	})
+}
+
+func (o *Outbox) Since(room string, seq uint64) ([]websocket.RoomMessage, error) {
+	var messages []websocket.RoomMessage
+	err := o.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(room))
+		if b == nil {
+			return nil
+		}
+		c := b.Bucket(messagesBucket).Cursor()
+		for k, v := c.Seek(key(seq + 1)); k != nil; k, v = c.Next() {
+			var msg websocket.RoomMessage
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return err
+			}
+			messages = append(messages, msg)
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (o *Outbox) Last(room string) (uint64, error) {
+	var last uint64
+	err := o.db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte(room)); b != nil {
+			last = seqOf(b.Get(lastKey))
+		}
+		return nil
+	})
+	return last, err
+}
+
+func (o *Outbox) Ack(client, room string, seq uint64) error {
+	return o.db.Update(func(tx *bbolt.Tx) error {
+		b, err := roomBucket(tx, room)
+		if err != nil {
+			return err
+		}
+		acked := b.Bucket(ackedBucket)
+		if v := acked.Get([]byte(client)); v != nil && seq <= seqOf(v) {
+			return nil
+		}
+		if err := acked.Put([]byte(client), key(seq)); err != nil {
+			return err
+		}
+		return trim(b)
+	})
+}
+
+func (o *Outbox) Acked(client, room string) (uint64, bool, error) {
+	var (
+		seq uint64
+		ok  bool
+	)
+	err := o.db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte(room)); b != nil {
+			v := b.Bucket(ackedBucket).Get([]byte(client))
+			seq, ok = seqOf(v), v != nil
+		}
+		return nil
+	})
+	return seq, ok, err
+}
+
+func (o *Outbox) Forget(client, room string) error {
+	return o.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(room))
+		if b == nil || b.Bucket(ackedBucket).Get([]byte(client)) == nil {
+			return nil
+		}
+		if err := b.Bucket(ackedBucket).Delete([]byte(client)); err != nil {
+			return err
+		}
+		return trim(b)
+	})
+}
+
+// roomBucket returns the bucket of room, creating it on first use.
+func roomBucket(tx *bbolt.Tx, room string) (*bbolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(room))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.CreateBucketIfNotExists(messagesBucket); err != nil {
+		return nil, err
+	}
+	if _, err := b.CreateBucketIfNotExists(ackedBucket); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// trim deletes the messages of the room bucket b every client acked, all of
+// them once no client is left.
+func trim(b *bbolt.Bucket) error {
+	oldest := ^uint64(0)
+	b.Bucket(ackedBucket).ForEach(func(_, v []byte) error {
+		oldest = min(oldest, seqOf(v))
+		return nil
+	})
+	// Deleting under a cursor moves it, the keys are collected first
+	messages := b.Bucket(messagesBucket)
+	var acked [][]byte
+	c := messages.Cursor()
+	for k, _ := c.First(); k != nil && seqOf(k) <= oldest; k, _ = c.Next() {
+		acked = append(acked, append([]byte(nil), k...))
+	}
+	for _, k := range acked {
+		if err := messages.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// key encodes seq as a key sorting in sequence order.
+func key(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, seq)
+}
+
+// seqOf decodes a key, zero for none.
+func seqOf(k []byte) uint64 {
+	if len(k) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(k)
+}
@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultPresenceDebounce is how long Presence collects changes of a room
+// before broadcasting them, unless NewPresence is given another duration.
+const defaultPresenceDebounce = 500 * time.Millisecond
+
+// PresenceDiff is the data of a "presence" RoomMessage: the users that came
+// online in the room and those that went offline since the last diff.
+type PresenceDiff struct {
+	Joined []string `json:"joined,omitempty"`
+	Left   []string `json:"left,omitempty"`
+}
+
+/**
+ * * Presence tracks which authenticated users are online in every room of a Rooms manager.
+ *
+ * * A user is online in a room while at least one of their connections is a member, so several
+ * * tabs of the same user count once. Guests and connections without a Principal are not
+ * * tracked, a guest authenticating in-band through Rooms.Authenticate comes online then.
+ *
+ * * Changes are debounced: they are collected per room and broadcast to the room's members as a
+ * * single {"type":"presence","room":"r","data":{"joined":[...],"left":[...]}} message once the
+ * * debounce period has passed. A user leaving and coming back within the period, e.g. while
+ * * reconnecting, causes no diff at all.
+ */
+type Presence struct {
+	rooms    *Rooms
+	debounce time.Duration
+
+	mu      sync.Mutex
+	online  map[string]map[string]int // room -> user -> connections in the room
+	pending map[string]map[string]int // room -> user -> +1 came online, -1 went offline
+	timers  map[string]*time.Timer
+}
+
+// NewPresence starts tracking the members of rooms. A debounce of zero means
+// defaultPresenceDebounce.
+func NewPresence(rooms *Rooms, debounce time.Duration) *Presence {
+	if debounce <= 0 {
+		debounce = defaultPresenceDebounce
+	}
+	p := &Presence{
+		rooms:    rooms,
+		debounce: debounce,
+		online:   make(map[string]map[string]int),
+		pending:  make(map[string]map[string]int),
+		timers:   make(map[string]*time.Timer),
+	}
+	rooms.Observe(p.observe)
+	return p
+}
+
+// WhoIsOnline returns the IDs of the users online in room, sorted.
+func (p *Presence) WhoIsOnline(room string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	users := make([]string, 0, len(p.online[room]))
+	for user := range p.online[room] {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
+}
+
+func (p *Presence) observe(room string, conn *Conn, joined bool) {
+	principal, ok := conn.Principal()
+	if !ok || principal.Guest {
+		return
+	}
+	user := principal.ID
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.online[room] == nil {
+		p.online[room] = make(map[string]int)
+	}
+	if joined {
+		p.online[room][user]++
+		if p.online[room][user] == 1 {
+			p.change(room, user, 1)
+		}
+		return
+	}
+
+	if p.online[room][user] == 0 {
+		return
+	}
+	p.online[room][user]--
+	if p.online[room][user] == 0 {
+		delete(p.online[room], user)
+		if len(p.online[room]) == 0 {
+			delete(p.online, room)
+		}
+		p.change(room, user, -1)
+	}
+}
+
+// change records that user came online (+1) or went offline (-1) in room
+// and schedules a flush. The caller must hold p.mu.
+func (p *Presence) change(room, user string, delta int) {
+	if p.pending[room] == nil {
+		p.pending[room] = make(map[string]int)
+	}
+	p.pending[room][user] += delta
+	if p.timers[room] == nil {
+		p.timers[room] = time.AfterFunc(p.debounce, func() { p.flush(room) })
+	}
+}
+
+// flush broadcasts the pending changes of room.
+func (p *Presence) flush(room string) {
+	p.mu.Lock()
+	var diff PresenceDiff
+	for user, delta := range p.pending[room] {
+		switch {
+		case delta > 0:
+			diff.Joined = append(diff.Joined, user)
+		case delta < 0:
+			diff.Left = append(diff.Left, user)
+		}
+	}
+	delete(p.pending, room)
+	delete(p.timers, room)
+	p.mu.Unlock()
+
+	if len(diff.Joined) == 0 && len(diff.Left) == 0 {
+		return
+	}
+	sort.Strings(diff.Joined)
+	sort.Strings(diff.Left)
+	data, err := json.Marshal(diff)
+	if err != nil {
+		return
+	}
+	p.rooms.publish(room, RoomMessage{Type: "presence", Room: room, Data: data}, "")
+}
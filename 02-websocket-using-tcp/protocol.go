@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Close codes used by this package, see RFC 6455 section 7.4.1.
+const (
+	closeNormal          = 1000
+	closeGoingAway       = 1001
+	closeProtocolError   = 1002
+	closeNoStatus        = 1005
+	closeInvalidPayload  = 1007
+	closePolicyViolation = 1008
+	closeMessageTooBig   = 1009
+	closeInternalError   = 1011
+)
+
+// defaultMaxFrameSize is the largest frame payload read unless MaxFrameSize is set.
+const defaultMaxFrameSize = 16 << 20
+
+/**
+ * * checkFrame validates the header of a frame received from the peer.
+ *
+ * * RSV bits must be 0 since no extension is negotiated, opcodes 0x3-0x7 and 0xB-0xF are reserved,
+ * * control frames (close, ping, pong) can neither be fragmented nor carry more than 125 bytes,
+ * * and, in Strict mode, frames from a client must be masked while frames from a server must not be.
+ */
+func checkFrame(frame *Frame, fromClient bool, mode Mode) error {
+	if frame.Rsv != 0 {
+		return &ErrProtocolError{Code: closeProtocolError, Reason: "reserved bits set"}
+	}
+	if frame.OpcodeName() == "unknown" {
+		return &ErrProtocolError{Code: closeProtocolError, Reason: fmt.Sprintf("reserved opcode 0x%X", frame.Opcode)}
+	}
+	if frame.Opcode >= 0x8 && (!frame.Fin || frame.PayloadLen > 125) {
+		return &ErrProtocolError{Code: closeProtocolError, Reason: "invalid control frame"}
+	}
+	if mode == Strict && frame.Masked != fromClient {
+		return &ErrProtocolError{Code: closeProtocolError, Reason: "invalid masking"}
+	}
+	return nil
+}
+
+// checkClose validates the payload of a close frame: either empty, or a
+// valid close code followed by a UTF-8 reason.
+func checkClose(payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	if len(payload) == 1 {
+		return &ErrProtocolError{Code: closeProtocolError, Reason: "truncated close code"}
+	}
+	if code := binary.BigEndian.Uint16(payload); !validCloseCode(code) {
+		return &ErrProtocolError{Code: closeProtocolError, Reason: fmt.Sprintf("invalid close code %d", code)}
+	}
+	if !utf8.Valid(payload[2:]) {
+		return &ErrProtocolError{Code: closeInvalidPayload, Reason: "close reason is not valid UTF-8"}
+	}
+	return nil
+}
+
+// frameLimit returns the frame payload limit for a MaxFrameSize setting.
+func frameLimit(max uint64) uint64 {
+	if max == 0 {
+		return defaultMaxFrameSize
+	}
+	return max
+}
+
+// closeReply returns the payload of the close frame answering a close frame
+// from the peer: its status code, if it sent one.
+func closeReply(payload []byte) []byte {
+	if len(payload) < 2 {
+		return nil
+	}
+	return payload[:2]
+}
+
+// validCloseCode reports whether code may be sent in a close frame. 1005,
+// 1006 and 1015 are reserved for reporting and never go on the wire.
+func validCloseCode(code uint16) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// utf8Validator checks a text message fragment by fragment, so invalid text
+// fails the connection as soon as it arrives. A rune split across two
+// fragments is held back until the rest of it is seen.
+type utf8Validator struct {
+	pending []byte
+}
+
+func (v *utf8Validator) write(p []byte, fin bool) error {
+	data := append(v.pending, p...)
+
+	// Find where a trailing, still incomplete rune starts.
+	complete := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				complete = i
+			}
+			break
+		}
+	}
+
+	if !utf8.Valid(data[:complete]) || (fin && complete < len(data)) {
+		return &ErrProtocolError{Code: closeInvalidPayload, Reason: "text message is not valid UTF-8"}
+	}
+	v.pending = append(v.pending[:0], data[complete:]...)
+	return nil
+}
@@ -0,0 +1,239 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout is how long a connection accepted by a ProxyListener has
+// to send its PROXY protocol header.
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV1MaxLength is the longest v1 header allowed by the specification,
+// "PROXY TCP6" with two full IPv6 addresses and ports.
+const proxyV1MaxLength = 107
+
+// proxyV2Signature starts every v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrProxyHeader is returned by the reads of a connection accepted by a
+// ProxyListener that did not start with a valid PROXY protocol header.
+var ErrProxyHeader = errors.New("websocket: invalid PROXY protocol header")
+
+/**
+ * * ProxyListener wraps a listener behind HAProxy, an AWS NLB or another load balancer speaking the
+ * * PROXY protocol, version 1 (text) or 2 (binary). Every connection it accepts must start with the
+ * * header the balancer adds, which names the client it accepted the connection from: RemoteAddr
+ * * returns that client instead of the balancer, and so do the logs, events and Conn.RemoteAddr.
+ * * Headers without an address, LOCAL health checks or UNKNOWN sources, keep the balancer's.
+ *
+ * * The header is read on the first Read or RemoteAddr call, within proxyHeaderTimeout, so Accept
+ * * is never held up by a slow client. A connection without valid header fails with
+ * * ErrProxyHeader. Anyone who can reach the listener directly can claim any address, only use it
+ * * on ports the balancer alone can connect to. Wrap the listener before tls.NewListener, the
+ * * header precedes the TLS handshake.
+ */
+func ProxyListener(listener net.Listener) net.Listener {
+	return proxyListener{listener}
+}
+
+type proxyListener struct {
+	net.Listener
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn}, nil
+}
+
+// proxyConn is a connection whose first bytes are a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+
+	once    sync.Once
+	err     error
+	remote  net.Addr // The client, nil when the header carried none.
+	local   net.Addr
+	pending []byte // Read past the header.
+}
+
+// NetConn returns the connection from the balancer, like tls.Conn.NetConn.
+func (c *proxyConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.readHeader() == nil && c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.readHeader() == nil && c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader reads the header once. Its deadline replaces any read deadline
+// set before and is lifted again afterwards.
+func (c *proxyConn) readHeader() error {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+
+		// The buffer holds the longest header parsed in place, a v1 line or
+		// v2 IPv6 addresses. The bytes it read past the header are replayed
+		// by Read.
+		reader := bufio.NewReaderSize(c.Conn, 256)
+		c.remote, c.local, c.err = readProxyHeader(reader)
+		if c.err == nil {
+			c.pending, _ = reader.Peek(reader.Buffered())
+		}
+	})
+	return c.err
+}
+
+// readProxyHeader reads a v1 or v2 header from r and returns the source and
+// destination addresses it carries, both nil when it carries none.
+func readProxyHeader(r *bufio.Reader) (source, destination net.Addr, err error) {
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrProxyHeader, err)
+	}
+	switch {
+	case bytes.Equal(start, proxyV2Signature):
+		return readProxyV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readProxyV1(r)
+	default:
+		return nil, nil, fmt.Errorf("%w: missing", ErrProxyHeader)
+	}
+}
+
+// readProxyV1 reads a header such as "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil || len(line) > proxyV1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("%w: v1 line not terminated by CRLF within %d bytes", ErrProxyHeader, proxyV1MaxLength)
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: v1 line %q", ErrProxyHeader, line)
+	}
+	source, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	destination, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	if source.Addr().Is4() != (fields[1] == "TCP4") || destination.Addr().Is4() != (fields[1] == "TCP4") {
+		return nil, nil, fmt.Errorf("%w: v1 addresses do not match %s", ErrProxyHeader, fields[1])
+	}
+	return net.TCPAddrFromAddrPort(source), net.TCPAddrFromAddrPort(destination), nil
+}
+
+func parseProxyAddr(ip, port string) (netip.AddrPort, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("%w: v1 address %q", ErrProxyHeader, ip)
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return netip.AddrPort{}, fmt.Errorf("%w: v1 port %q", ErrProxyHeader, port)
+	}
+	return netip.AddrPortFrom(addr, uint16(n)), nil
+}
+
+/**
+ * * readProxyV2 reads a binary header: the 12 byte signature, the version and command, the address
+ * * family and protocol, the length of the rest and the addresses, followed by TLVs this server
+ * * skips.
+ *
+ *	0d 0a 0d 0a 00 0d 0a 51 55 49 54 0a  21  11  00 0c  cb 00 71 07  0a 00 00 01  c8 22  01 bb
+ *	signature                            v2  TCP len=12 203.0.113.7  10.0.0.1     51234  443
+ *	                                     PROXY  over IPv4
+ */
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header, err := r.Peek(16)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrProxyHeader, err)
+	}
+	version, command := header[12]>>4, header[12]&0x0F
+	family := header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	if version != 2 || command > 1 {
+		return nil, nil, fmt.Errorf("%w: v2 version %d, command %d", ErrProxyHeader, version, command)
+	}
+
+	var source, destination net.Addr
+	if command == 1 {
+		switch family {
+		case 0x11, 0x12: // TCP or UDP over IPv4
+			if length < 12 {
+				return nil, nil, fmt.Errorf("%w: v2 length %d too short for IPv4", ErrProxyHeader, length)
+			}
+			addrs, err := r.Peek(16 + 12)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %w", ErrProxyHeader, err)
+			}
+			addrs = addrs[16:]
+			source = proxyV2Addr(family, netip.AddrFrom4([4]byte(addrs[0:4])), addrs[8:10])
+			destination = proxyV2Addr(family, netip.AddrFrom4([4]byte(addrs[4:8])), addrs[10:12])
+		case 0x21, 0x22: // TCP or UDP over IPv6
+			if length < 36 {
+				return nil, nil, fmt.Errorf("%w: v2 length %d too short for IPv6", ErrProxyHeader, length)
+			}
+			addrs, err := r.Peek(16 + 36)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %w", ErrProxyHeader, err)
+			}
+			addrs = addrs[16:]
+			source = proxyV2Addr(family, netip.AddrFrom16([16]byte(addrs[0:16])), addrs[32:34])
+			destination = proxyV2Addr(family, netip.AddrFrom16([16]byte(addrs[16:32])), addrs[34:36])
+		}
+		// Unix sockets and unspecified families keep the balancer's address.
+	}
+	if _, err := r.Discard(16 + length); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrProxyHeader, err)
+	}
+	return source, destination, nil
+}
+
+func proxyV2Addr(family byte, ip netip.Addr, port []byte) net.Addr {
+	addrPort := netip.AddrPortFrom(ip.Unmap(), binary.BigEndian.Uint16(port))
+	if family&0x0F == 0x02 {
+		return net.UDPAddrFromAddrPort(addrPort)
+	}
+	return net.TCPAddrFromAddrPort(addrPort)
+}
@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateAction is what a Server does with a connection exceeding its rate limit.
+type RateAction int
+
+const (
+	// Throttle delays reading from the connection until the budget allows the
+	// frame, pushing back on the client through TCP flow control.
+	Throttle RateAction = iota
+
+	// ClosePolicyViolation fails the connection with 1008 Policy Violation.
+	ClosePolicyViolation
+)
+
+/**
+ * * RateLimit bounds how fast clients may send, using token buckets.
+ *
+ * * Every frame counts as one message against MessagesPerSecond, pings and continuation frames
+ * * included, so that floods of tiny frames are caught too, and its payload length counts
+ * * against BytesPerSecond. A zero rate leaves that dimension unlimited.
+ *
+ * * The bursts are how much may arrive at once after a quiet period and default to one second
+ * * worth of the rate. With ClosePolicyViolation a frame larger than ByteBurst always violates
+ * * the limit, so ByteBurst should be at least MaxFrameSize when closing on violations.
+ */
+type RateLimit struct {
+	MessagesPerSecond float64
+	MessageBurst      int
+
+	BytesPerSecond float64
+	ByteBurst      int
+
+	OnExceed RateAction
+}
+
+// limiter enforces a RateLimit. A nil limiter allows everything.
+type limiter struct {
+	messages *bucket
+	bytes    *bucket
+	action   RateAction
+}
+
+func newLimiter(limit RateLimit) *limiter {
+	l := &limiter{
+		messages: newBucket(limit.MessagesPerSecond, limit.MessageBurst),
+		bytes:    newBucket(limit.BytesPerSecond, limit.ByteBurst),
+		action:   limit.OnExceed,
+	}
+	if l.messages == nil && l.bytes == nil {
+		return nil
+	}
+	return l
+}
+
+// admit charges one frame of size bytes to the limiter. In Throttle mode it
+// sleeps until the frame fits the budget, otherwise it reports whether the
+// frame fits.
+func (l *limiter) admit(size int) bool {
+	if l == nil {
+		return true
+	}
+	if l.action == Throttle {
+		time.Sleep(max(l.messages.reserve(1), l.bytes.reserve(float64(size))))
+		return true
+	}
+	return l.messages.allow(1) && l.bytes.allow(float64(size))
+}
+
+// bucket is a token bucket refilled continuously at rate tokens per second.
+// A nil bucket is unlimited.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, burst int) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if burst <= 0 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return &bucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// refill adds the tokens accumulated since the last call. The caller must hold b.mu.
+func (b *bucket) refill() {
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// allow takes n tokens if they are available.
+func (b *bucket) allow(n float64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// reserve takes n tokens, going into debt if needed, and returns how long
+// the caller has to wait until the debt is paid back.
+func (b *bucket) reserve(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,191 @@
+package websocket
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// frameReadTimeout is how long the reactor waits for the rest of a frame
+// whose first bytes arrived.
+const frameReadTimeout = 10 * time.Second
+
+/**
+ * * ReactorHandler serves the connections of a server in reactor mode, see Server.Reactor. Instead
+ * * of a Handler blocking in a read for as long as the connection lives, the server calls OnMessage
+ * * whenever a complete message arrived. Between messages a connection holds no goroutine, which
+ * * for hundreds of thousands of mostly idle clients saves the several KB of stack each would pin.
+ *
+ * * The callbacks of one connection never run concurrently, those of different connections do.
+ * * Pings, pongs and close frames are handled by the server as in Handler mode.
+ */
+type ReactorHandler struct {
+	// OnOpen, when set, is called once the handshake is done, before any
+	// message of the connection. It must not read from the connection.
+	OnOpen func(conn *Conn)
+
+	// OnMessage is called with every message, read completely. It should
+	// return quickly: the next frames of the connection wait for it, unless
+	// the server hands messages to Server.Workers.
+	OnMessage func(conn *Conn, opcode byte, data []byte)
+
+	// OnClose, when set, is called once with the error that ended the
+	// connection, a CloseError when the client closed it.
+	OnClose func(conn *Conn, err error)
+}
+
+/**
+ * * serveReactor hands c over to the server's poller, which calls back when frames arrive, and
+ * * returns right away: done is emptied and run by the poller once the connection ends.
+ *
+ * * Connections the poller cannot watch, TLS ones whose decrypted bytes it cannot see and all of
+ * * them on systems without a poller, are served by the calling goroutine with the same callbacks.
+ */
+func (s *Server) serveReactor(c *Conn, done *cleanup) {
+	if s.Reactor.OnOpen != nil {
+		s.Reactor.OnOpen(c)
+	}
+	if s.poller != nil {
+		if raw, ok := rawConn(c.conn); ok {
+			release := *done
+			c.polled.Store(true)
+			err := s.poller.add(c, raw, release.run)
+			if err == nil {
+				*done = nil
+				return
+			}
+			c.polled.Store(false)
+			c.Logger().Warn("Error watching connection, serving it on its own goroutine", "err", err)
+		}
+	}
+	err := c.ReadLoop(s.Workers, func(opcode byte, data []byte) error {
+		s.onMessage(c, opcode, data)
+		return nil
+	})
+	s.reactorClosed(c, err)
+}
+
+func (s *Server) onMessage(c *Conn, opcode byte, data []byte) {
+	if s.Reactor.OnMessage != nil {
+		s.Reactor.OnMessage(c, opcode, data)
+	}
+}
+
+/**
+ * * readReady handles the frames of a connection the poller reported readable. The first frame is
+ * * read in any case, the following ones only while bytes are buffered already: the poller calls
+ * * again once more arrive. A message whose continuation frames are still on their way is kept in
+ * * pending meanwhile, as are pings arriving between its fragments.
+ *
+ * * Reading a frame waits for all of it, a client stalling in the middle of one holds a goroutine
+ * * until it sends the rest, for frameReadTimeout at most. A fragmented message has the
+ * * connection's ReassemblyTimeout to be completed, see poller.sweep for the clients going quiet
+ * * in between.
+ */
+func (s *Server) readReady(c *Conn, pending *pendingMessage) error {
+	defer c.conn.SetReadDeadline(time.Time{})
+	for first := true; first || c.reader.Buffered() > 0; first = false {
+		c.conn.SetReadDeadline(time.Now().Add(frameReadTimeout))
+		frame, err := c.ReadFrame()
+		if err != nil {
+			return err
+		}
+		if frame.Opcode >= 0x8 {
+			if err := c.control(frame); err != nil {
+				return err
+			}
+			continue
+		}
+		complete, err := pending.add(c, frame)
+		if err != nil {
+			return err
+		}
+		if !complete {
+			if err := pending.expire(c, time.Now()); err != nil {
+				return err
+			}
+			continue
+		}
+		opcode, data := pending.opcode, pending.data
+		if s.Workers != nil {
+			s.Workers.submit(c.id, func() { s.onMessage(c, opcode, data) })
+		} else {
+			s.onMessage(c, opcode, data)
+		}
+		*pending = pendingMessage{}
+	}
+	return nil
+}
+
+// pendingMessage is a message being received by the reactor, frame by frame.
+type pendingMessage struct {
+	reader  *messageReader
+	opcode  byte
+	data    []byte
+	started time.Time // When the first frame arrived.
+}
+
+// add appends the payload of frame and reports whether it finished the
+// message. The frames are checked like messageReader does, and the message
+// against the connection's MaxMessageSize.
+func (m *pendingMessage) add(c *Conn, frame *Frame) (bool, error) {
+	var err error
+	if m.reader == nil {
+		m.reader, err = newMessageReader(frame, nil, c.fail, c.mode)
+		m.opcode, m.started = frame.Opcode, time.Now()
+	} else {
+		err = m.reader.next(frame)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	m.data = append(m.data, m.reader.payload...)
+	m.reader.payload = nil
+	limit := c.MaxMessageSize
+	if limit == 0 {
+		limit = defaultMaxMessageSize
+	}
+	if int64(len(m.data)) > limit {
+		return false, c.fail(&ErrProtocolError{Code: closeMessageTooBig, Reason: fmt.Sprintf("message exceeds %d bytes", limit), err: ErrMessageTooBig})
+	}
+	return m.reader.fin, nil
+}
+
+// expire fails c with 1002 when the message is still incomplete after the
+// connection's ReassemblyTimeout at now.
+func (m *pendingMessage) expire(c *Conn, now time.Time) error {
+	if m.reader == nil {
+		return nil
+	}
+	if timeout := c.reassemblyTimeout(); now.Sub(m.started) >= timeout {
+		return c.fail(errReassemblyTimeout(timeout))
+	}
+	return nil
+}
+
+func (s *Server) reactorClosed(c *Conn, err error) {
+	logDisconnect(c.Logger(), err)
+	if s.Reactor.OnClose != nil {
+		s.Reactor.OnClose(c, err)
+	}
+}
+
+// rawConn returns the file descriptor access of a plain TCP or unix socket
+// connection, unwrapping the byte counting of the server.
+func rawConn(conn net.Conn) (syscall.RawConn, bool) {
+	if counting, ok := conn.(countingConn); ok {
+		conn = counting.Conn
+	}
+	// The handshake read the bytes that came with the PROXY header.
+	if proxied, ok := conn.(*proxyConn); ok {
+		conn = proxied.Conn
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, false
+	}
+	raw, err := sc.SyscallConn()
+	return raw, err == nil
+}
@@ -0,0 +1,254 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// pollEvents is how many readiness events one epoll_wait returns at most.
+const pollEvents = 256
+
+/**
+ * * poller watches the connections of a server in reactor mode with a single epoll instance. Each
+ * * connection is armed with EPOLLONESHOT: once readable it is disarmed, a goroutine reads and
+ * * handles its frames until none is left buffered, see readReady, then arms it again. The descriptors stay in
+ * * non-blocking mode under the runtime's own netpoller, reads go through the net.Conn as usual.
+ *
+ * * Connections are keyed by a sequence number carried in the event data rather than by their
+ * * descriptor, which the kernel reuses as soon as a connection is closed.
+ */
+type poller struct {
+	epfd   int
+	server *Server
+
+	mu    sync.Mutex
+	conns map[uint64]*polledConn
+	next  uint64
+}
+
+type polledConn struct {
+	id      uint64
+	conn    *Conn
+	raw     syscall.RawConn
+	release func()
+	once    sync.Once
+
+	// mu orders the goroutines processing the connection one after the
+	// other, armed tells whether it was added to the epoll set already.
+	mu      sync.Mutex
+	armed   bool
+	pending pendingMessage
+
+	// closeSeen is when the sweep first saw that a close frame was sent to
+	// the connection, guarded by mu.
+	closeSeen time.Time
+
+	// reassembling is set while pending holds the first fragments of a
+	// message, for the sweep to look at without taking mu.
+	reassembling atomic.Bool
+}
+
+func newPoller(s *Server) (*poller, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	p := &poller{epfd: epfd, server: s, conns: make(map[uint64]*polledConn)}
+	go p.loop()
+	return p, nil
+}
+
+// add watches c. Frames already buffered, for example sent along with the
+// handshake, are handled right away.
+func (p *poller) add(c *Conn, raw syscall.RawConn, release func()) error {
+	p.mu.Lock()
+	p.next++
+	pc := &polledConn{id: p.next, conn: c, raw: raw, release: release}
+	p.conns[pc.id] = pc
+	p.mu.Unlock()
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if c.reader.Buffered() > 0 {
+		go p.process(pc)
+		return nil
+	}
+	if err := p.arm(pc); err != nil {
+		p.mu.Lock()
+		delete(p.conns, pc.id)
+		p.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// arm adds pc to the epoll set or, once it is in, arms it again.
+func (p *poller) arm(pc *polledConn) error {
+	if pc.armed {
+		return p.ctl(pc, unix.EPOLL_CTL_MOD)
+	}
+	pc.armed = true
+	return p.ctl(pc, unix.EPOLL_CTL_ADD)
+}
+
+// ctl arms or disarms pc. The descriptor is only touched while the runtime
+// guarantees it is still open, so a reused descriptor is never affected.
+func (p *poller) ctl(pc *polledConn, op int) error {
+	var ctlErr error
+	err := pc.raw.Control(func(fd uintptr) {
+		event := unix.EpollEvent{
+			Events: unix.EPOLLIN | unix.EPOLLRDHUP | unix.EPOLLONESHOT,
+			Fd:     int32(pc.id),
+			Pad:    int32(pc.id >> 32),
+		}
+		ctlErr = unix.EpollCtl(p.epfd, op, int(fd), &event)
+	})
+	if err != nil {
+		return err
+	}
+	return ctlErr
+}
+
+func (p *poller) loop() {
+	events := make([]unix.EpollEvent, pollEvents)
+	lastSweep := time.Now()
+	for {
+		n, err := unix.EpollWait(p.epfd, events, int(time.Second/time.Millisecond))
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, unix.EBADF) {
+				slog.Error("Error waiting for connections to become readable", "err", err)
+			}
+			return
+		}
+		for _, event := range events[:n] {
+			id := uint64(uint32(event.Fd)) | uint64(uint32(event.Pad))<<32
+			p.mu.Lock()
+			pc := p.conns[id]
+			p.mu.Unlock()
+			if pc != nil {
+				go p.process(pc)
+			}
+		}
+		if time.Since(lastSweep) >= time.Second {
+			lastSweep = time.Now()
+			p.sweep()
+		}
+	}
+}
+
+// process handles the frames of a readable connection, then arms it again.
+func (p *poller) process(pc *polledConn) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	err := p.server.readReady(pc.conn, &pc.pending)
+	pc.reassembling.Store(pc.pending.reader != nil)
+	if err != nil {
+		p.finish(pc, err)
+		return
+	}
+	if err := p.arm(pc); err != nil {
+		p.finish(pc, err)
+	}
+}
+
+// finish stops watching pc and releases it, once. The caller must hold
+// pc.mu, so that OnClose never runs along with another callback of the
+// connection.
+func (p *poller) finish(pc *polledConn, err error) {
+	pc.once.Do(func() {
+		p.ctl(pc, unix.EPOLL_CTL_DEL)
+		p.mu.Lock()
+		delete(p.conns, pc.id)
+		p.mu.Unlock()
+		closed := func() {
+			p.server.reactorClosed(pc.conn, err)
+			pc.release()
+		}
+		// Behind the messages of the connection still waiting for a worker.
+		if p.server.Workers != nil {
+			p.server.Workers.submit(pc.conn.id, closed)
+		} else {
+			closed()
+		}
+	})
+}
+
+/**
+ * * sweep releases the connections the server sent a close frame to more than idleCloseGrace ago,
+ * * on shutdown, when reaped as idle or kicked for being slow. In Handler mode a read deadline ends
+ * * those, a watched connection is not being read and would wait for the client forever. It also
+ * * fails with 1002 the connections that left a fragmented message incomplete for longer than
+ * * their ReassemblyTimeout, as the read of the next fragment does in Handler mode.
+ *
+ * * A connection being processed is skipped until the next sweep rather than waited for, which
+ * * would hold up the events of every other connection.
+ */
+func (p *poller) sweep() {
+	now := time.Now()
+	var closing []*polledConn
+	p.mu.Lock()
+	for _, pc := range p.conns {
+		if pc.conn.closeSent.Load() || pc.reassembling.Load() {
+			closing = append(closing, pc)
+		}
+	}
+	p.mu.Unlock()
+	for _, pc := range closing {
+		if !pc.mu.TryLock() {
+			continue
+		}
+		if pc.pending.reader != nil && now.Sub(pc.pending.started) >= pc.conn.reassemblyTimeout() {
+			// Writing the close frame may block on the client
+			go p.expire(pc)
+			pc.mu.Unlock()
+			continue
+		}
+		if !pc.conn.closeSent.Load() {
+			pc.mu.Unlock()
+			continue
+		}
+		if pc.closeSeen.IsZero() {
+			pc.closeSeen = now
+		}
+		if now.Sub(pc.closeSeen) >= idleCloseGrace {
+			p.finish(pc, context.DeadlineExceeded)
+		}
+		pc.mu.Unlock()
+	}
+}
+
+// expire fails pc once its pending message outlived the ReassemblyTimeout,
+// and releases it.
+func (p *poller) expire(pc *polledConn) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if err := pc.pending.expire(pc.conn, time.Now()); err != nil {
+		p.finish(pc, err)
+	}
+}
+
+// close releases every connection still watched and stops the poller.
+func (p *poller) close() {
+	p.mu.Lock()
+	conns := make([]*polledConn, 0, len(p.conns))
+	for _, pc := range p.conns {
+		conns = append(conns, pc)
+	}
+	p.mu.Unlock()
+	for _, pc := range conns {
+		pc.mu.Lock()
+		p.finish(pc, ErrServerClosed)
+		pc.mu.Unlock()
+	}
+	unix.Close(p.epfd)
+}
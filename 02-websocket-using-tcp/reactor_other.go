@@ -0,0 +1,22 @@
+//go:build !linux
+
+package websocket
+
+import (
+	"errors"
+	"syscall"
+)
+
+// poller is only implemented on Linux, elsewhere newPoller fails and every
+// connection of a server in reactor mode gets its own goroutine.
+type poller struct{}
+
+func newPoller(s *Server) (*poller, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (p *poller) add(c *Conn, raw syscall.RawConn, release func()) error {
+	return errors.ErrUnsupported
+}
+
+func (p *poller) close() {}
@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reactorServer serves a reactor with no callbacks on a local port and
+// returns its ws:// URL.
+func reactorServer(t *testing.T, opts ...ServerOption) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer("", append(opts, WithReactor(ReactorHandler{}))...)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	return "ws://" + listener.Addr().String()
+}
+
+// expectClose reads client until the server closes it and checks the code.
+func expectClose(t *testing.T, client *Client, code uint16) {
+	t.Helper()
+	client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := client.ReadFullMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != code {
+		t.Fatalf("read %v, want close %d", err, code)
+	}
+}
+
+func TestReactorReassemblyTimeout(t *testing.T) {
+	url := reactorServer(t, WithReassemblyTimeout(500*time.Millisecond))
+	client, err := Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The first fragment, then nothing
+	if err := client.writeFrame(false, 0x1, []byte("never finished")); err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, client, closeProtocolError)
+}
+
+func TestReactorMessageTooBig(t *testing.T) {
+	url := reactorServer(t, WithMaxMessageSize(16))
+	client, err := Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, opcode := range []byte{0x1, 0x0} {
+		if err := client.writeFrame(false, opcode, []byte(strings.Repeat("x", 10))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectClose(t, client, closeMessageTooBig)
+}
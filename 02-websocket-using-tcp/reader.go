@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"fmt"
+	"io"
+)
+
+/**
+ * * messageReader exposes a single, possibly fragmented, message as an io.Reader.
+ *
+ * * It starts with the first frame of the message and only reads the next continuation frame from
+ * * the connection once the payload of the current one has been consumed, so a message never has to
+ * * be held in memory as a whole. io.EOF is returned after the payload of the frame with FIN set.
+ *
+ * * In Strict mode text messages are checked for valid UTF-8 as every frame arrives. Protocol violations are handed
+ * * to fail, which lets the connection send the matching close frame before the error is returned.
+ */
+type messageReader struct {
+	opcode    byte
+	nextFrame func() (*Frame, error)
+	fail      func(error) error
+	payload   []byte
+	fin       bool
+	utf8      *utf8Validator
+}
+
+func newMessageReader(first *Frame, nextFrame func() (*Frame, error), fail func(error) error, mode Mode) (*messageReader, error) {
+	if first.Opcode == 0x0 {
+		return nil, fail(&ErrProtocolError{Code: closeProtocolError, Reason: "unexpected continuation frame", err: ErrUnexpectedContinuation})
+	}
+
+	r := &messageReader{
+		opcode:    first.Opcode,
+		nextFrame: nextFrame,
+		fail:      fail,
+	}
+	if first.Opcode == 0x1 && mode == Strict {
+		r.utf8 = &utf8Validator{}
+	}
+	if err := r.load(first); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	for len(r.payload) == 0 {
+		if r.fin {
+			return 0, io.EOF
+		}
+
+		frame, err := r.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+		if err := r.next(frame); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.payload)
+	r.payload = r.payload[n:]
+	return n, nil
+}
+
+// next loads the continuation frame following the current one.
+func (r *messageReader) next(frame *Frame) error {
+	if frame.Opcode != 0x0 {
+		return r.fail(&ErrProtocolError{Code: closeProtocolError, Reason: fmt.Sprintf("expected continuation frame, got %s", frame.OpcodeName()), err: ErrUnexpectedContinuation})
+	}
+	return r.load(frame)
+}
+
+func (r *messageReader) load(frame *Frame) error {
+	if r.utf8 != nil {
+		if err := r.utf8.write(frame.Payload, frame.Fin); err != nil {
+			return r.fail(err)
+		}
+	}
+	r.payload = frame.Payload
+	r.fin = frame.Fin
+	return nil
+}
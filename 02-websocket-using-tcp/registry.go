@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// registryShards is the number of shards of a registry, a power of two.
+const registryShards = 64
+
+/**
+ * * registry is the set of connections of a Hub, split into registryShards maps each guarded by its
+ * * own lock and picked by a hash of the connection ID. Connections registering and unregistering
+ * * while a broadcast walks the set only wait for the one shard they hash to, instead of for the
+ * * whole walk, and concurrent registrations rarely meet on the same lock.
+ *
+ * * A walk locks one shard at a time. It sees every connection registered before it started and
+ * * none unregistered before it started, connections coming and going meanwhile may be seen or not.
+ */
+type registry struct {
+	seed   maphash.Seed
+	shards [registryShards]registryShard
+}
+
+type registryShard struct {
+	mu    sync.RWMutex
+	conns map[*Conn]struct{}
+}
+
+func newRegistry() *registry {
+	r := &registry{seed: maphash.MakeSeed()}
+	for i := range r.shards {
+		r.shards[i].conns = make(map[*Conn]struct{})
+	}
+	return r
+}
+
+func (r *registry) shard(conn *Conn) *registryShard {
+	return &r.shards[maphash.String(r.seed, conn.id)&(registryShards-1)]
+}
+
+func (r *registry) add(conn *Conn) {
+	s := r.shard(conn)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = struct{}{}
+}
+
+func (r *registry) remove(conn *Conn) {
+	s := r.shard(conn)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// len returns the number of connections registered.
+func (r *registry) len() int {
+	n := 0
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.RLock()
+		n += len(s.conns)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// each calls fn with the connections for which match returns true, all of
+// them when match is nil. Both run with the connection's shard locked, fn
+// must not block.
+func (r *registry) each(match func(*Conn) bool, fn func(*Conn)) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.RLock()
+		for conn := range s.conns {
+			if match == nil || match(conn) {
+				fn(conn)
+			}
+		}
+		s.mu.RUnlock()
+	}
+}
+
+// collect returns the connections for which match returns true, all of them
+// when match is nil. match runs with the connection's shard locked.
+func (r *registry) collect(match func(*Conn) bool) []*Conn {
+	var conns []*Conn
+	if match == nil {
+		conns = make([]*Conn, 0, r.len())
+	}
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.RLock()
+		for conn := range s.conns {
+			if match == nil || match(conn) {
+				conns = append(conns, conn)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return conns
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// registryConns is the number of connections the registry benchmarks hold.
+const registryConns = 100_000
+
+// connSet is what the benchmarks need of a connection set.
+type connSet interface {
+	add(*Conn)
+	remove(*Conn)
+	collect(match func(*Conn) bool) []*Conn
+}
+
+// lockedSet is the single map behind a single lock the registry replaced,
+// the baseline of the benchmarks.
+type lockedSet struct {
+	mu    sync.RWMutex
+	conns map[*Conn]struct{}
+}
+
+func (s *lockedSet) add(conn *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = struct{}{}
+}
+
+func (s *lockedSet) remove(conn *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+func (s *lockedSet) collect(match func(*Conn) bool) []*Conn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var conns []*Conn
+	if match == nil {
+		conns = make([]*Conn, 0, len(s.conns))
+	}
+	for conn := range s.conns {
+		if match == nil || match(conn) {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+// connSets are the sets compared, each filled with registryConns
+// connections.
+func connSets() map[string]func() connSet {
+	fill := func(set connSet) connSet {
+		for i := range registryConns {
+			set.add(&Conn{id: strconv.Itoa(i)})
+		}
+		return set
+	}
+	return map[string]func() connSet{
+		"sharded": func() connSet { return fill(newRegistry()) },
+		"single":  func() connSet { return fill(&lockedSet{conns: make(map[*Conn]struct{})}) },
+	}
+}
+
+// BenchmarkRegistryCollect measures a walk of every connection, as a
+// broadcast does.
+func BenchmarkRegistryCollect(b *testing.B) {
+	for name, newSet := range connSets() {
+		b.Run(name, func(b *testing.B) {
+			set := newSet()
+			b.ResetTimer()
+			for range b.N {
+				set.collect(nil)
+			}
+		})
+	}
+}
+
+// BenchmarkRegisterDuringBroadcast measures registering and unregistering a
+// connection while walks of the set run back to back.
+func BenchmarkRegisterDuringBroadcast(b *testing.B) {
+	for name, newSet := range connSets() {
+		b.Run(name, func(b *testing.B) {
+			set := newSet()
+			stop, walking := make(chan struct{}), make(chan struct{})
+			var walks sync.WaitGroup
+			walks.Add(1)
+			go func() {
+				defer walks.Done()
+				close(walking)
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						set.collect(nil)
+					}
+				}
+			}()
+
+			<-walking
+			conn := &Conn{id: "registering"}
+			b.ResetTimer()
+			for range b.N {
+				set.add(conn)
+				set.remove(conn)
+			}
+			b.StopTimer()
+			close(stop)
+			walks.Wait()
+		})
+	}
+}
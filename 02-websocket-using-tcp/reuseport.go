@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"runtime"
+)
+
+// ErrReusePortUnsupported is returned by ListenReusePort on systems where
+// SO_REUSEPORT does not spread connections over the listening sockets.
+var ErrReusePortUnsupported = errors.New("websocket: SO_REUSEPORT is not supported on this platform")
+
+/**
+ * * ListenReusePort opens n TCP listeners on the same address with SO_REUSEPORT set, runtime.NumCPU()
+ * * of them when n is zero or negative. The kernel hashes every incoming connection to one of the
+ * * sockets, so that as many accept loops as listeners run in parallel instead of queuing behind a
+ * * single one. With port 0 in addr all listeners share the port picked for the first.
+ *
+ * * Only Linux balances connections across such sockets, elsewhere ListenReusePort returns
+ * * ErrReusePortUnsupported.
+ */
+func ListenReusePort(network, addr string, n int) ([]net.Listener, error) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	config := &net.ListenConfig{Control: reusePortControl}
+	listeners := make([]net.Listener, 0, n)
+	for range n {
+		listener, err := config.Listen(context.Background(), network, addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		addr = listener.Addr().String()
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listenAndServeReusePort is ListenAndServe with one listener per CPU, see
+// Server.ReusePort. It returns once every accept loop returned, with the
+// first error: a failing loop closes the other listeners.
+func (s *Server) listenAndServeReusePort() error {
+	listeners, err := ListenReusePort("tcp", s.Addr, 0)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		if s.ProxyProtocol {
+			listener = ProxyListener(listener)
+		}
+		if s.TLSConfig != nil {
+			listener = tls.NewListener(listener, s.tlsConfig())
+		}
+		go func() { errs <- s.Serve(listener) }()
+	}
+
+	err = <-errs
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	for range len(listeners) - 1 {
+		<-errs
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package websocket
+
+import "syscall"
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return ErrReusePortUnsupported
+}
@@ -0,0 +1,689 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"websocket/metrics"
+)
+
+var roomMessages = metrics.Default.Register(metrics.NewCounterVec(
+	"websocket_room_messages_total", "Messages published to rooms, by room.", 100, "room"))
+
+/**
+ * * RoomMessage is the JSON control protocol spoken by Rooms.Handler.
+ *
+ * * Clients send {"type":"join","room":"r"}, {"type":"leave","room":"r"} and
+ * * {"type":"publish","room":"r","data":...}, the latter only to rooms they joined. Members of a
+ * * room receive {"type":"message","room":"r","conn":"<sender>","data":...} for every publish
+ * * and {"type":"joined"|"left","room":"r","conn":"<id>"} when membership changes. Messages
+ * * published by server code carry no conn.
+ *
+ * * When the Rooms keep a history, every "message" also carries the room's sequence number in
+ * * "seq". A client reconnecting after a drop sends {"type":"join","room":"r","resume_from":N}
+ * * and receives the buffered messages after N before any live traffic. When some of them are
+ * * no longer buffered it first gets {"type":"gap","room":"r","seq":M}, M being the oldest
+ * * message it will receive, which may be lower than N when the history was dropped meanwhile.
+ *
+ * * With an Outbox, clients identify themselves to get every message at least once: users with the
+ * * ID of their principal, guests with {"type":"join","room":"r","client":"<stable id>"}, kept as
+ * * "guest:<stable id>" so that it never names a user. The outbox keeps the messages of the room
+ * * until the client, a member of the room, sends {"type":"ack","room":"r","seq":N} for them, and a client joining again, on any connection, first receives those after its last
+ * * ack. A "leave" drops its cursor, a disconnect does not.
+ *
+ * * A connection resuming a sticky session (see Server.SessionKey) joins the rooms of the session
+ * * again by itself, from the last message written to the previous connection, with the history or
+ * * the outbox. The rooms of a session stay subscribed until it ends, so their history goes on.
+ *
+ * * Guests authenticate in-band with {"type":"auth","data":"<token>"}. Requests that are refused,
+ * * such as a guest joining a private room or a failed auth, are answered with
+ * * {"type":"error","room":"r","data":"<reason>"}.
+ */
+type RoomMessage struct {
+	Type string          `json:"type"`
+	Room string          `json:"room"`
+	Conn string          `json:"conn,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+
+	Seq        uint64  `json:"seq,omitempty"`
+	ResumeFrom *uint64 `json:"resume_from,omitempty"`
+	Client     string  `json:"client,omitempty"`
+}
+
+// Rooms groups the connections of a hub into named rooms, so that messages
+// can be fanned out to the members of one room only.
+type Rooms struct {
+	hub *Hub
+
+	// Private reports whether room is private. Guests cannot join private
+	// rooms. When nil, no room is private.
+	Private func(room string) bool
+
+	// HistorySize is the number of recent messages kept per room for clients
+	// resuming after a reconnect, zero disables the history. Messages older
+	// than HistoryTTL are not replayed, zero keeps them until they are
+	// pushed out. Both must be set before the first message is published.
+	// A history is dropped once nothing on this instance follows the room,
+	// no member, subscriber or session left.
+	HistorySize int
+	HistoryTTL  time.Duration
+
+	// MaxRooms bounds the number of rooms with a history or a journal,
+	// defaultMaxRooms when zero. Rooms beyond it are joined without one.
+	MaxRooms int
+
+	// Outbox, when set, journals the messages of every room until the
+	// clients that joined it acked them, see JoinClient. Replays come from
+	// it rather than the history, HistorySize and HistoryTTL are ignored.
+	// It must be set before the first message is published.
+	Outbox Outbox
+
+	mu        sync.RWMutex
+	members   map[string]map[*Conn]bool
+	observers []func(room string, conn *Conn, joined bool)
+	histories map[string]*history
+	listeners map[string]map[*roomListener]bool
+
+	// subscriptions holds the broker subscription of every room with local
+	// members. subMu serializes subscribing, which may wait on the network.
+	// mu is only taken with subMu held to drop a history, never the other
+	// way around.
+	subMu         sync.Mutex
+	subscriptions map[string]*roomSubscription
+}
+
+// roomListener is a function following a room, see Subscribe.
+type roomListener struct {
+	fn func(RoomMessage)
+}
+
+type roomSubscription struct {
+	refs        int
+	unsubscribe func()
+}
+
+// roomTopic is the broker topic of room.
+func roomTopic(room string) string {
+	return "room." + room
+}
+
+// roomBroadcast is what publish sends through the hub's broker.
+type roomBroadcast struct {
+	TraceID string      `json:"trace_id,omitempty"`
+	Message RoomMessage `json:"message"`
+}
+
+// NewRooms returns a room manager on top of hub.
+func NewRooms(hub *Hub) *Rooms {
+	return &Rooms{
+		hub:           hub,
+		members:       make(map[string]map[*Conn]bool),
+		histories:     make(map[string]*history),
+		listeners:     make(map[string]map[*roomListener]bool),
+		subscriptions: make(map[string]*roomSubscription),
+	}
+}
+
+// Observe registers fn to be called after every membership change, with
+// joined telling whether conn joined or left room. It lets layers such as
+// Presence follow the rooms without wrapping them.
+func (r *Rooms) Observe(fn func(room string, conn *Conn, joined bool)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observers = append(r.observers, fn)
+}
+
+func (r *Rooms) notify(room string, conn *Conn, joined bool) {
+	r.mu.RLock()
+	observers := r.observers
+	r.mu.RUnlock()
+	for _, fn := range observers {
+		fn(room, conn, joined)
+	}
+}
+
+// Join adds conn to room and tells the room's members, conn included.
+func (r *Rooms) Join(room string, conn *Conn) {
+	r.subscribe(room)
+	r.join(room, conn)
+}
+
+// join adds conn to room once subscribe has been called for it, releasing
+// that reference again when conn was a member already.
+func (r *Rooms) join(room string, conn *Conn) {
+	r.mu.Lock()
+	if r.members[room] == nil {
+		r.members[room] = make(map[*Conn]bool)
+	}
+	joined := !r.members[room][conn]
+	r.members[room][conn] = true
+	r.mu.Unlock()
+
+	if !joined {
+		r.release(room)
+		return
+	}
+	r.publish(room, RoomMessage{Type: "joined", Room: room, Conn: conn.ID()}, "")
+	r.notify(room, conn, true)
+}
+
+// subscribe takes a reference on the broker subscription of room, so that
+// this instance receives what is published to it. Every local member holds
+// one.
+func (r *Rooms) subscribe(room string) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	sub := r.subscriptions[room]
+	if sub == nil {
+		sub = &roomSubscription{}
+		r.subscriptions[room] = sub
+	}
+	sub.refs++
+	if sub.unsubscribe != nil {
+		return
+	}
+
+	// A failed subscription is retried by the next member joining.
+	unsubscribe, err := r.hub.broker.Subscribe(roomTopic(room), func(data []byte) {
+		var msg roomBroadcast
+		if err := json.Unmarshal(data, &msg); err != nil {
+			slog.Warn("Error decoding room message from broker", "room", room, "err", err)
+			return
+		}
+		r.deliver(room, msg.Message, msg.TraceID)
+	})
+	if err != nil {
+		slog.Error("Error subscribing to room", "room", room, "err", err)
+		return
+	}
+	sub.unsubscribe = unsubscribe
+}
+
+// release drops a reference taken by subscribe, unsubscribing from room
+// with the last one.
+func (r *Rooms) release(room string) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	sub := r.subscriptions[room]
+	if sub == nil {
+		return
+	}
+	if sub.refs--; sub.refs > 0 {
+		return
+	}
+	if sub.unsubscribe != nil {
+		sub.unsubscribe()
+	}
+	delete(r.subscriptions, room)
+	// The instance no longer receives the messages of room, the history
+	// would only get stale
+	r.mu.Lock()
+	delete(r.histories, room)
+	r.mu.Unlock()
+}
+
+// Leave removes conn from room and tells the remaining members.
+func (r *Rooms) Leave(room string, conn *Conn) {
+	r.mu.Lock()
+	left := r.members[room][conn]
+	delete(r.members[room], conn)
+	if len(r.members[room]) == 0 {
+		delete(r.members, room)
+	}
+	r.mu.Unlock()
+
+	if left {
+		r.publish(room, RoomMessage{Type: "left", Room: room, Conn: conn.ID()}, "")
+		r.notify(room, conn, false)
+		r.release(room)
+	}
+}
+
+// Subscribe calls fn with every RoomMessage the members of room receive,
+// without making a member of anyone, until unsubscribe is called. It lets
+// consumers that are not WebSocket connections, such as the sse package,
+// follow a room. fn is called with the room's history locked and must not
+// block or call back into Rooms.
+func (r *Rooms) Subscribe(room string, fn func(RoomMessage)) (unsubscribe func()) {
+	r.subscribe(room)
+	return r.listen(room, fn)
+}
+
+// listen adds fn to the listeners of room once subscribe has been called for
+// it. The returned function removes it and releases that reference.
+func (r *Rooms) listen(room string, fn func(RoomMessage)) func() {
+	l := &roomListener{fn: fn}
+	r.mu.Lock()
+	if r.listeners[room] == nil {
+		r.listeners[room] = make(map[*roomListener]bool)
+	}
+	r.listeners[room][l] = true
+	r.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.listeners[room], l)
+			if len(r.listeners[room]) == 0 {
+				delete(r.listeners, room)
+			}
+			r.mu.Unlock()
+			r.release(room)
+		})
+	}
+}
+
+// LeaveAll removes conn from every room it joined.
+func (r *Rooms) LeaveAll(conn *Conn) {
+	for _, room := range r.RoomsOf(conn) {
+		r.Leave(room, conn)
+	}
+}
+
+// RoomsOf returns the rooms conn is a member of, sorted.
+func (r *Rooms) RoomsOf(conn *Conn) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var rooms []string
+	for room, members := range r.members {
+		if members[conn] {
+			rooms = append(rooms, room)
+		}
+	}
+	slices.Sort(rooms)
+	return rooms
+}
+
+// MemberCounts returns the number of members of every room.
+func (r *Rooms) MemberCounts() map[string]int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	counts := make(map[string]int, len(r.members))
+	for room, members := range r.members {
+		counts[room] = len(members)
+	}
+	return counts
+}
+
+// Authenticate upgrades conn to the identity behind token (see
+// Conn.Authenticate) and moves its memberships along atomically: observers
+// such as Presence see it leave every room under its old identity and join
+// them again under the new one, with no other membership change in between.
+// The token is verified before the rooms are locked, the Authenticate hook
+// may take its time.
+func (r *Rooms) Authenticate(conn *Conn, token string) (Principal, error) {
+	principal, err := conn.verify(token)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rooms []string
+	for room, members := range r.members {
+		if members[conn] {
+			rooms = append(rooms, room)
+		}
+	}
+
+	// Observers are called with r.mu held here, which is why they must not
+	// call back into Rooms.
+	for _, room := range rooms {
+		for _, fn := range r.observers {
+			fn(room, conn, false)
+		}
+	}
+	conn.become(principal)
+	for _, room := range rooms {
+		for _, fn := range r.observers {
+			fn(room, conn, true)
+		}
+	}
+	return principal, nil
+}
+
+// Members returns the connections currently in room.
+func (r *Rooms) Members(room string) []*Conn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	conns := make([]*Conn, 0, len(r.members[room]))
+	for conn := range r.members[room] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// isMember reports whether conn joined room.
+func (r *Rooms) isMember(room string, conn *Conn) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.members[room][conn]
+}
+
+// Publish sends v, encoded as JSON, to every member of room as the data of a
+// "message" RoomMessage.
+func (r *Rooms) Publish(room string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.publish(room, RoomMessage{Type: "message", Room: room, Data: data}, "")
+	return nil
+}
+
+// publish sends msg to the members of room on every instance sharing the
+// hub's broker.
+func (r *Rooms) publish(room string, msg RoomMessage, traceID string) {
+	if msg.Type == "message" {
+		roomMessages.Inc(room)
+	}
+	data, err := json.Marshal(roomBroadcast{TraceID: traceID, Message: msg})
+	if err != nil {
+		return
+	}
+	if err := r.hub.broker.Publish(roomTopic(room), data); err != nil {
+		slog.Error("Error publishing room message", "room", room, "err", err)
+	}
+}
+
+// deliver fans msg, received from the broker, out to the local members of room.
+func (r *Rooms) deliver(room string, msg RoomMessage, traceID string) {
+	// With a history, numbering and delivery happen under the room's history
+	// lock, so that live messages go out in sequence order and never
+	// overtake a replay (see JoinFrom). Every instance numbers the messages
+	// it delivers itself, sequence numbers are only meaningful on one.
+	if h := r.history(room); h != nil && msg.Type == "message" {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		msg.Seq = h.add(msg)
+		// Journaled before it goes out, a client dropping meanwhile gets it
+		// when it comes back
+		if h.outbox != nil {
+			if err := h.outbox.Append(room, msg); err != nil {
+				slog.Error("Error writing the outbox", "room", room, "err", err)
+			}
+		}
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	// Copy the member set first, the predicate runs with the hub locked.
+	r.mu.RLock()
+	members := make(map[*Conn]bool, len(r.members[room]))
+	for conn := range r.members[room] {
+		members[conn] = true
+	}
+	listeners := make([]*roomListener, 0, len(r.listeners[room]))
+	for l := range r.listeners[room] {
+		listeners = append(listeners, l)
+	}
+	r.mu.RUnlock()
+
+	match := func(conn *Conn) bool { return members[conn] }
+	if msg.Seq == 0 {
+		r.hub.BroadcastFunc(traceID, 0x1, payload, match)
+	} else {
+		r.hub.broadcastWritten(traceID, 0x1, payload, match, func(conn *Conn) { advance(conn, room, msg.Seq) })
+	}
+	for _, l := range listeners {
+		l.fn(msg)
+	}
+}
+
+// Handler registers conn with the hub and serves the RoomMessage protocol
+// until the client disconnects, leaving all its rooms.
+func (r *Rooms) Handler(conn *Conn) {
+	r.hub.Register(conn)
+	defer r.hub.Unregister(conn)
+	defer r.LeaveAll(conn)
+
+	session := conn.Session()
+	cursors, resumed := SessionValue[*roomCursors](session, roomsKey)
+	if resumed {
+		r.rejoin(conn, cursors)
+	} else {
+		cursors = &roomCursors{seqs: make(map[string]uint64)}
+		session.Set(roomsKey, cursors)
+		// The rooms of the session stay subscribed between its connections,
+		// so that their history goes on for it
+		session.OnEnd(func() {
+			for room := range cursors.snapshot() {
+				r.release(room)
+			}
+		})
+	}
+	for {
+		var msg RoomMessage
+		if err := readValidJSON(conn, &msg); err != nil {
+			logDisconnect(conn.Logger(), err)
+			return
+		}
+
+		switch msg.Type {
+		case "join":
+			if conn.IsGuest() && r.Private != nil && r.Private(msg.Room) {
+				r.reject(conn, msg.Room, "guests cannot join private rooms")
+				continue
+			}
+			if id := clientID(conn, msg.Client); id != "" {
+				session.Set(clientKey, id)
+			}
+			client, _ := SessionValue[string](session, clientKey)
+			seq := r.lastSeq(msg.Room)
+			if msg.ResumeFrom != nil {
+				seq = *msg.ResumeFrom
+			}
+			if cursors.set(msg.Room, seq) {
+				r.subscribe(msg.Room)
+			}
+			switch {
+			case msg.ResumeFrom != nil:
+				r.JoinFrom(msg.Room, conn, *msg.ResumeFrom)
+			case client != "" && r.Outbox != nil:
+				r.JoinClient(msg.Room, conn, client)
+			default:
+				r.Join(msg.Room, conn)
+			}
+		case "leave":
+			r.Leave(msg.Room, conn)
+			if cursors.remove(msg.Room) {
+				r.release(msg.Room)
+			}
+			if client, ok := SessionValue[string](session, clientKey); ok && r.Outbox != nil {
+				if err := r.Outbox.Forget(client, msg.Room); err != nil {
+					conn.Logger().Error("Error writing the outbox", "room", msg.Room, "err", err)
+				}
+			}
+		case "ack":
+			client, ok := SessionValue[string](session, clientKey)
+			if !ok || r.Outbox == nil {
+				r.reject(conn, msg.Room, "acks need an outbox and a client id")
+				continue
+			}
+			if !r.isMember(msg.Room, conn) {
+				r.reject(conn, msg.Room, "acks need to join the room")
+				continue
+			}
+			if err := r.Outbox.Ack(client, msg.Room, msg.Seq); err != nil {
+				conn.Logger().Error("Error writing the outbox", "room", msg.Room, "err", err)
+			}
+		case "publish":
+			if !r.isMember(msg.Room, conn) {
+				conn.Logger().Warn("Publish to a room not joined", "room", msg.Room)
+				continue
+			}
+			traceID := conn.NewID()
+			conn.Logger().Info("Received room message", "room", msg.Room, "trace_id", traceID)
+			r.publish(msg.Room, RoomMessage{Type: "message", Room: msg.Room, Conn: conn.ID(), Data: msg.Data}, traceID)
+		case "auth":
+			var token string
+			if err := json.Unmarshal(msg.Data, &token); err != nil {
+				r.reject(conn, "", "auth data must be a token string")
+				continue
+			}
+			principal, err := r.Authenticate(conn, token)
+			if err != nil {
+				conn.Logger().Warn("In-band authentication failed", "err", err)
+				r.reject(conn, "", "authentication failed")
+				continue
+			}
+			r.moveClient(conn, cursors, principal)
+		default:
+			conn.Logger().Warn("Unknown room message type", "type", msg.Type)
+		}
+	}
+}
+
+// rejoin joins conn to the rooms of the session it resumed, from where the
+// previous connection of the session left each of them.
+func (r *Rooms) rejoin(conn *Conn, cursors *roomCursors) {
+	client, _ := SessionValue[string](conn.Session(), clientKey)
+	for room, seq := range cursors.snapshot() {
+		conn.Logger().Info("Rejoining room of the session", "room", room, "seq", seq)
+		switch {
+		case client != "" && r.Outbox != nil:
+			r.JoinClient(room, conn, client)
+		case r.history(room) != nil:
+			r.JoinFrom(room, conn, seq)
+		default:
+			r.Join(room, conn)
+		}
+	}
+}
+
+// lastSeq returns the sequence number of the last message of room, zero
+// without a history.
+func (r *Rooms) lastSeq(room string) uint64 {
+	h := r.history(room)
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.seq
+}
+
+// roomsKey is the session key of the roomCursors of a connection of Handler.
+const roomsKey = "rooms.joined"
+
+// roomCursors are the rooms a session joined through Handler, with the
+// sequence number of the last message of each written to it, for the
+// connection resuming the session to join them again where it left.
+type roomCursors struct {
+	mu   sync.Mutex
+	seqs map[string]uint64
+}
+
+// set records that the session joined room, having received its messages up
+// to seq, and reports whether it was not in room yet.
+func (c *roomCursors) set(room string, seq uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.seqs[room]
+	c.seqs[room] = seq
+	return !ok
+}
+
+// advance records that the message seq of room was written, when the
+// session is still in room.
+func (c *roomCursors) advance(room string, seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.seqs[room]; ok && seq > last {
+		c.seqs[room] = seq
+	}
+}
+
+// advance advances the cursor of room of the session of conn to seq, see
+// roomCursors.advance.
+func advance(conn *Conn, room string, seq uint64) {
+	if cursors, ok := SessionValue[*roomCursors](conn.Session(), roomsKey); ok {
+		cursors.advance(room, seq)
+	}
+}
+
+// remove records that the session left room, and reports whether it was in
+// room.
+func (c *roomCursors) remove(room string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.seqs[room]
+	delete(c.seqs, room)
+	return ok
+}
+
+// snapshot returns a copy of the cursors.
+func (c *roomCursors) snapshot() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.seqs)
+}
+
+// clientKey is the session key of the stable ID a connection of Handler acks
+// messages under, see JoinClient.
+const clientKey = "rooms.client"
+
+// guestClientPrefix sets the IDs claimed by clients apart from the IDs of
+// principals, a guest claiming "alice" does not get the messages of alice.
+const guestClientPrefix = "guest:"
+
+// clientID returns the stable ID conn acks messages under: the ID of its
+// principal, or the one a guest claims with guestClientPrefix, empty for
+// none.
+func clientID(conn *Conn, claimed string) string {
+	if principal, ok := conn.Principal(); ok && !principal.Guest {
+		return principal.ID
+	}
+	if claimed == "" {
+		return ""
+	}
+	return guestClientPrefix + claimed
+}
+
+// moveClient makes the ID of principal, which conn authenticated as, the ID
+// it acks messages under. The cursors of the ID it had, a guest's, move over
+// to it in the rooms the session joined, unless it has cursors there already.
+func (r *Rooms) moveClient(conn *Conn, cursors *roomCursors, principal Principal) {
+	if principal.Guest {
+		return
+	}
+	session := conn.Session()
+	previous, ok := SessionValue[string](session, clientKey)
+	session.Set(clientKey, principal.ID)
+	if !ok || previous == principal.ID || r.Outbox == nil {
+		return
+	}
+	for room := range cursors.snapshot() {
+		seq, acked, err := r.Outbox.Acked(previous, room)
+		if err == nil && acked {
+			if _, ok, _ := r.Outbox.Acked(principal.ID, room); !ok {
+				err = r.Outbox.Ack(principal.ID, room, seq)
+			}
+		}
+		if err == nil {
+			err = r.Outbox.Forget(previous, room)
+		}
+		if err != nil {
+			conn.Logger().Error("Error writing the outbox", "room", room, "err", err)
+		}
+	}
+}
+
+// reject answers a refused request of conn with an "error" RoomMessage.
+func (r *Rooms) reject(conn *Conn, room, reason string) {
+	data, _ := json.Marshal(reason)
+	if err := conn.WriteJSON(RoomMessage{Type: "error", Room: room, Data: data}); err != nil {
+		conn.Logger().Warn("Error sending message", "err", err)
+	}
+}
@@ -0,0 +1,24 @@
+package websocket
+
+import "testing"
+
+func TestMoveClientOnAuthentication(t *testing.T) {
+	rooms := NewRooms(NewHub())
+	outbox := NewMemoryOutbox()
+	rooms.Outbox = outbox
+	conn := &Conn{id: "guest", principal: &Principal{ID: "guest-1", Guest: true}}
+	conn.Session().Set(clientKey, clientID(conn, "device-42"))
+	cursors := &roomCursors{seqs: map[string]uint64{"news": 5}}
+	outbox.Ack("guest:device-42", "news", 5)
+
+	rooms.moveClient(conn, cursors, Principal{ID: "alice"})
+	if client, _ := SessionValue[string](conn.Session(), clientKey); client != "alice" {
+		t.Errorf("acks under %q, want alice", client)
+	}
+	if seq, ok, _ := outbox.Acked("alice", "news"); !ok || seq != 5 {
+		t.Errorf("cursor of alice %d %v, want 5", seq, ok)
+	}
+	if _, ok, _ := outbox.Acked("guest:device-42", "news"); ok {
+		t.Error("the cursor of the guest is still there")
+	}
+}
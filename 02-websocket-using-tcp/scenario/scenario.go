@@ -0,0 +1,213 @@
+// Package scenario runs scripted conversations between several simulated
+// clients and the WebSocket server, so that multi-client behavior (replies,
+// broadcasts, timing) can be checked end to end against a real server.
+//
+// A scenario is described with a small builder:
+//
+//	err := scenario.New("echo").
+//		Client("alice").
+//		Client("bob").
+//		Send("alice", `{"role":"user","content":"hi","trace_id":"echo-1"}`).
+//		Expect("alice", `{"role":"agent","content":"Message Recieved","trace_id":"echo-1"}`, time.Second).
+//		ExpectSilence("bob", 100*time.Millisecond).
+//		RunInProcess(chat.AckHandler)
+package scenario
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"websocket"
+)
+
+// Scenario is an ordered script of steps executed by named clients.
+type Scenario struct {
+	Name    string
+	clients []string
+	steps   []step
+}
+
+type step struct {
+	description string
+	clients     []string // The clients the step names.
+	run         func(clients map[string]*client) error
+}
+
+// client is a simulated peer that collects every message it receives.
+type client struct {
+	name  string
+	conn  *websocket.Client
+	inbox chan []byte
+}
+
+// New returns an empty scenario.
+func New(name string) *Scenario {
+	return &Scenario{Name: name}
+}
+
+// Client declares a simulated client. Clients connect in declaration order
+// before the first step runs.
+func (s *Scenario) Client(name string) *Scenario {
+	s.clients = append(s.clients, name)
+	return s
+}
+
+// Send makes the named client send message as a text message.
+func (s *Scenario) Send(name, message string) *Scenario {
+	return s.add(fmt.Sprintf("%s sends %s", name, message), []string{name}, func(clients map[string]*client) error {
+		return clients[name].conn.SendTextMessage(message)
+	})
+}
+
+// Expect asserts that the named client receives message within the given
+// duration. Messages that are valid JSON are compared structurally.
+func (s *Scenario) Expect(name, message string, within time.Duration) *Scenario {
+	return s.add(fmt.Sprintf("%s expects %s", name, message), []string{name}, func(clients map[string]*client) error {
+		return clients[name].expect(message, within)
+	})
+}
+
+// ExpectBroadcast asserts that every named client receives message within
+// the given duration, measured from the start of the step.
+func (s *Scenario) ExpectBroadcast(message string, within time.Duration, names ...string) *Scenario {
+	return s.add(fmt.Sprintf("%v expect broadcast %s", names, message), names, func(clients map[string]*client) error {
+		deadline := time.Now().Add(within)
+		for _, name := range names {
+			if err := clients[name].expect(message, time.Until(deadline)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExpectSilence asserts that the named client receives nothing for d.
+func (s *Scenario) ExpectSilence(name string, d time.Duration) *Scenario {
+	return s.add(fmt.Sprintf("%s expects silence for %s", name, d), []string{name}, func(clients map[string]*client) error {
+		select {
+		case message, ok := <-clients[name].inbox:
+			if !ok {
+				return fmt.Errorf("%s: connection closed", name)
+			}
+			return fmt.Errorf("%s: unexpected message %s", name, message)
+		case <-time.After(d):
+			return nil
+		}
+	})
+}
+
+// Wait pauses the script for d.
+func (s *Scenario) Wait(d time.Duration) *Scenario {
+	return s.add(fmt.Sprintf("wait %s", d), nil, func(map[string]*client) error {
+		time.Sleep(d)
+		return nil
+	})
+}
+
+func (s *Scenario) add(description string, clients []string, run func(map[string]*client) error) *Scenario {
+	s.steps = append(s.steps, step{description: description, clients: clients, run: run})
+	return s
+}
+
+// check returns an error for the first step naming a client that was not
+// declared.
+func (s *Scenario) check() error {
+	declared := make(map[string]bool, len(s.clients))
+	for _, name := range s.clients {
+		declared[name] = true
+	}
+	for i, step := range s.steps {
+		for _, name := range step.clients {
+			if !declared[name] {
+				return fmt.Errorf("scenario %q: step %d (%s): client %s was not declared", s.Name, i+1, step.description, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Run connects the clients to the server at addr and executes every step,
+// stopping at the first failing one. A step naming a client that was not
+// declared fails the scenario before any client connects.
+func (s *Scenario) Run(addr string) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	clients := make(map[string]*client, len(s.clients))
+	defer func() {
+		for _, c := range clients {
+			c.conn.Close()
+		}
+	}()
+
+	for _, name := range s.clients {
+		conn, err := websocket.Dial("ws://" + addr)
+		if err != nil {
+			return fmt.Errorf("scenario %q: connecting %s: %w", s.Name, name, err)
+		}
+		c := &client{name: name, conn: conn, inbox: make(chan []byte, 64)}
+		go c.readLoop()
+		clients[name] = c
+	}
+
+	for i, step := range s.steps {
+		if err := step.run(clients); err != nil {
+			return fmt.Errorf("scenario %q: step %d (%s): %w", s.Name, i+1, step.description, err)
+		}
+	}
+	return nil
+}
+
+// RunInProcess starts a server running handler on a random local port, runs
+// the scenario against it and shuts the server down again.
+func (s *Scenario) RunInProcess(handler websocket.Handler) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	go websocket.Serve(listener, handler)
+	return s.Run(listener.Addr().String())
+}
+
+func (c *client) readLoop() {
+	defer close(c.inbox)
+	for {
+		_, payload, err := c.conn.ReadFullMessage()
+		if err != nil {
+			return
+		}
+		c.inbox <- payload
+	}
+}
+
+func (c *client) expect(message string, within time.Duration) error {
+	select {
+	case got, ok := <-c.inbox:
+		if !ok {
+			return fmt.Errorf("%s: connection closed", c.name)
+		}
+		if !equal(got, []byte(message)) {
+			return fmt.Errorf("%s: got %s, want %s", c.name, got, message)
+		}
+		return nil
+	case <-time.After(within):
+		return fmt.Errorf("%s: no message within %s", c.name, within)
+	}
+}
+
+// equal compares two messages, ignoring formatting differences when both
+// are valid JSON.
+func equal(got, want []byte) bool {
+	var a, b any
+	if json.Unmarshal(got, &a) == nil && json.Unmarshal(want, &b) == nil {
+		x, _ := json.Marshal(a)
+		y, _ := json.Marshal(b)
+		return bytes.Equal(x, y)
+	}
+	return bytes.Equal(got, want)
+}
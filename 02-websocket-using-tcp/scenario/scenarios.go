@@ -0,0 +1,20 @@
+package scenario
+
+import "time"
+
+// Echo checks that the server acknowledges a chat message to its sender only.
+var Echo = New("echo").
+	Client("alice").
+	Client("bob").
+	Send("alice", `{"role":"user","content":"hi","trace_id":"echo-1"}`).
+	Expect("alice", `{"role":"agent","content":"Message Recieved","trace_id":"echo-1"}`, time.Second).
+	ExpectSilence("bob", 100*time.Millisecond)
+
+// Broadcast checks that a hub relays a chat message to every client,
+// including its sender. Run it against chat.RelayHandler(websocket.NewHub()).
+var Broadcast = New("broadcast").
+	Client("alice").
+	Client("bob").
+	Wait(50*time.Millisecond). // Let the hub register both connections.
+	Send("alice", `{"role":"user","content":"hi","trace_id":"broadcast-1"}`).
+	ExpectBroadcast(`{"role":"user","content":"hi","trace_id":"broadcast-1"}`, time.Second, "alice", "bob")
@@ -0,0 +1,34 @@
+package scenario_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"websocket"
+	"websocket/chat"
+	"websocket/scenario"
+)
+
+func TestEcho(t *testing.T) {
+	if err := scenario.Echo.RunInProcess(chat.AckHandler); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	if err := scenario.Broadcast.RunInProcess(chat.RelayHandler(websocket.NewHub())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUndeclaredClient(t *testing.T) {
+	err := scenario.New("typo").
+		Client("alice").
+		Send("alice", `{"role":"user","content":"hi"}`).
+		Expect("alcie", `{"role":"agent","content":"Message Recieved"}`, time.Second).
+		RunInProcess(chat.AckHandler)
+	if err == nil || !strings.Contains(err.Error(), "client alcie was not declared") {
+		t.Errorf("Run: %v, want the undeclared client", err)
+	}
+}
@@ -0,0 +1,203 @@
+package websocket
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"websocket/metrics"
+)
+
+// defaultSendQueueSize is how many messages a connection queues unless
+// Server.SendQueueSize says otherwise.
+const defaultSendQueueSize = 256
+
+// QueuePolicy decides what Enqueue does with a message for a connection
+// whose send queue is full, that is a client reading slower than it is
+// written to.
+type QueuePolicy int
+
+const (
+	// DisconnectSlow closes the connection with 1008 (policy violation).
+	DisconnectSlow QueuePolicy = iota
+	// DropOldest discards the oldest queued message to make room.
+	DropOldest
+	// DropNewest discards the message being enqueued.
+	DropNewest
+)
+
+func (p QueuePolicy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop_oldest"
+	case DropNewest:
+		return "drop_newest"
+	default:
+		return "disconnect"
+	}
+}
+
+// ErrQueueFull is returned by Enqueue when the message was not queued, or the
+// connection was closed, because the send queue was full.
+var ErrQueueFull = errors.New("websocket: send queue full")
+
+var queueOverflows = metrics.Default.Register(metrics.NewCounterVec(
+	"websocket_send_queue_overflows_total", "Messages enqueued for a connection whose send queue was full, by policy applied.", 3, "policy"))
+
+type queuedMessage struct {
+	opcode  byte
+	payload []byte
+	written func(error) // Called once written, or dropped, when not nil.
+}
+
+// done reports what became of the message to its written callback.
+func (m queuedMessage) done(err error) {
+	if m.written != nil {
+		m.written(err)
+	}
+}
+
+/**
+ * * sendQueue holds the messages Enqueue accepted until a writer goroutine, started with the first
+ * * of them and ending once the queue is empty, sends them in order. Broadcasting to a slow client
+ * * thus costs a slice append instead of blocking until the client read the previous messages.
+ */
+type sendQueue struct {
+	mu       sync.Mutex
+	messages []queuedMessage
+	size     int
+	policy   QueuePolicy
+	writing  bool  // Set while a goroutine writes the queued messages.
+	inFlight bool  // Set while the writer holds a message taken out of messages.
+	slow     bool  // Set once DisconnectSlow applied, later messages are refused.
+	err      error // The error a write failed with, later messages are refused.
+}
+
+/**
+ * * Enqueue queues a message for the connection and returns without waiting for it to be written.
+ * * Messages are written in order by a goroutine of the connection, through WriteMessage. When
+ * * the queue already holds Server.SendQueueSize messages, the one being written included,
+ * * Server.SendQueuePolicy applies: DropOldest makes room, DropNewest and DisconnectSlow return
+ * * ErrQueueFull, the latter closing the connection with 1008. Once a write failed, Enqueue
+ * * returns its error.
+ *
+ * * Hub and Rooms broadcasts use Enqueue, handlers answering on their own goroutine can keep
+ * * calling WriteMessage.
+ */
+func (c *Conn) Enqueue(opcode byte, payload []byte) error {
+	return c.enqueue(opcode, payload, nil)
+}
+
+// enqueue is Enqueue calling written, when not nil, once the message was
+// written or with the error that had it dropped. It is not called when
+// enqueue returns an error, and must not block: the queue may be locked.
+func (c *Conn) enqueue(opcode byte, payload []byte, written func(error)) error {
+	q := &c.queue
+	q.mu.Lock()
+	if q.size == 0 {
+		q.size = defaultSendQueueSize
+	}
+	if q.err != nil {
+		err := q.err
+		q.mu.Unlock()
+		return err
+	}
+	if q.slow {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	if q.length() >= q.size {
+		queueOverflows.Inc(q.policy.String())
+		// The message being written cannot be dropped anymore
+		policy := q.policy
+		if policy == DropOldest && len(q.messages) == 0 {
+			policy = DropNewest
+		}
+		switch policy {
+		case DropOldest:
+			q.messages[0].done(ErrQueueFull)
+			q.messages = q.messages[1:]
+			c.Logger().Debug("Send queue full, dropped oldest message")
+		case DropNewest:
+			q.mu.Unlock()
+			c.Logger().Debug("Send queue full, dropped message")
+			return ErrQueueFull
+		default:
+			dropped := q.messages
+			q.slow, q.messages = true, nil
+			q.mu.Unlock()
+			for _, msg := range dropped {
+				msg.done(ErrQueueFull)
+			}
+			c.disconnectSlow()
+			return ErrQueueFull
+		}
+	}
+	q.messages = append(q.messages, queuedMessage{opcode, payload, written})
+	if !q.writing {
+		q.writing = true
+		go c.drainQueue()
+	}
+	q.mu.Unlock()
+	return nil
+}
+
+// length returns the number of messages of the queue, the one being written
+// included. The caller must hold q.mu.
+func (q *sendQueue) length() int {
+	if q.inFlight {
+		return len(q.messages) + 1
+	}
+	return len(q.messages)
+}
+
+// drainQueue writes the queued messages, oldest first, until the queue is
+// empty. A failed write drops the rest and has Enqueue refuse the messages to
+// come, the connection is broken.
+func (c *Conn) drainQueue() {
+	q := &c.queue
+	for {
+		q.mu.Lock()
+		if len(q.messages) == 0 {
+			q.writing, q.inFlight = false, false
+			q.mu.Unlock()
+			return
+		}
+		msg := q.messages[0]
+		q.messages[0] = queuedMessage{}
+		q.messages = q.messages[1:]
+		q.inFlight = true
+		q.mu.Unlock()
+
+		err := c.WriteMessage(msg.opcode, msg.payload)
+		msg.done(err)
+		if err == nil {
+			continue
+		}
+		q.mu.Lock()
+		dropped := q.messages
+		q.messages, q.err = nil, err
+		q.writing, q.inFlight = false, false
+		q.mu.Unlock()
+		if c.Context().Err() == nil && !errors.Is(err, ErrCloseSent) {
+			c.Logger().Warn("Error writing queued message", "err", err, "dropped", len(dropped))
+		}
+		for _, rest := range dropped {
+			rest.done(err)
+		}
+		return
+	}
+}
+
+// disconnectSlow closes a connection that cannot keep up. The writer may be
+// blocked on the client, so the close frame is given a second and written
+// from another goroutine.
+func (c *Conn) disconnectSlow() {
+	c.Logger().Warn("Send queue full, disconnecting slow client")
+	c.closing()
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	go func() {
+		c.writeClose(closePolicyViolation)
+		c.conn.Close()
+	}()
+}
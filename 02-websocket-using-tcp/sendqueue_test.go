@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errBroken = errors.New("broken pipe")
+
+// brokenConn is a net.Conn whose writes fail.
+type brokenConn struct{ discardConn }
+
+func (brokenConn) Write([]byte) (int, error) { return 0, errBroken }
+
+// blockedConn is a net.Conn whose writes wait for unblock.
+type blockedConn struct {
+	discardConn
+	unblock chan struct{}
+}
+
+func (c blockedConn) Write(p []byte) (int, error) {
+	<-c.unblock
+	return len(p), nil
+}
+
+func TestEnqueueAfterFailedWrite(t *testing.T) {
+	c := &Conn{id: "broken", conn: brokenConn{}}
+	written := make(chan error, 1)
+	if err := c.enqueue(0x1, []byte("lost"), func(err error) { written <- err }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-written:
+		if !errors.Is(err, errBroken) {
+			t.Fatalf("written with %v, want %v", err, errBroken)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the failed write was not reported")
+	}
+	c.queue.mu.Lock()
+	writing := c.queue.writing
+	c.queue.mu.Unlock()
+	if writing {
+		t.Error("queue still writing after the failed write")
+	}
+	if err := c.Enqueue(0x1, []byte("next")); !errors.Is(err, errBroken) {
+		t.Errorf("Enqueue after the failed write: %v, want %v", err, errBroken)
+	}
+}
+
+func TestSendQueueCountsMessageInFlight(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	c := &Conn{id: "blocked", conn: blockedConn{unblock: unblock}}
+	c.queue.size, c.queue.policy = 2, DropNewest
+
+	c.Enqueue(0x1, []byte("1"))
+	// Wait for the writer to take the first message out of the queue
+	for {
+		c.queue.mu.Lock()
+		inFlight := c.queue.inFlight
+		c.queue.mu.Unlock()
+		if inFlight {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Enqueue(0x1, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Enqueue(0x1, []byte("3")); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("third message with a queue of 2: %v, want ErrQueueFull", err)
+	}
+}
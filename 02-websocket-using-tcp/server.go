@@ -0,0 +1,1026 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"netsim/throttle"
+	"websocket/events"
+	"websocket/id"
+)
+
+/**
+ * WebSocket Frame.
+ */
+type Frame struct {
+	Fin        bool   // Fin indicates if this is the final fragment in a message.
+	Rsv        byte   // Rsv holds the RSV1, RSV2 and RSV3 bits, which must be 0 without extensions.
+	Opcode     byte   // Opcode defines the interpretation of the payload data.
+	Masked     bool   // Masked indicates if the payload data is masked.
+	PayloadLen uint64 // PayloadLen specifies the length of the payload data.
+	MaskKey    []byte // MaskKey is the masking key used to unmask the payload data.
+	Payload    []byte // Payload contains the actual data being transmitted.
+}
+
+/**
+ * * ReadFrame reads a single WebSocket frame from r, usually a TCP connection.
+ *
+ * * In the WebSocket protocol, the first byte of a frame contains several important pieces of information. Let's break down the first byte:
+ * FIN bit (1 bit): The Most Significant Bit (MSB) of the first byte (bit 7) indicates whether this is the final fragment in a message. If set to 1, it means this is the final fragment.
+ * RSV1, RSV2, RSV3 bits (3 bits): The next three bits (bits 6, 5, and 4) are reserved for future use. They should be set to 0 unless an extension defines otherwise. These bits are typically not used in standard WebSocket communication.
+ * Opcode (4 bits): The last four bits (bits 3 to 0) of the first byte define the frame's type. For example:
+ * 	 	0x0 (0000): Continuation frame
+ * 		0x1 (0001): Text frame
+ * 		0x2 (0010): Binary frame
+ * 		0x8 (1000): Connection close frame
+ * 		0x9 (1001): Ping frame
+ * 		0xA (1010): Pong frame
+ */
+func ReadFrame(r io.Reader) (*Frame, error) {
+	return readFrame(r, defaultMaxFrameSize)
+}
+
+// readFrame is ReadFrame rejecting frames whose payload is longer than maxPayload.
+func readFrame(r io.Reader, maxPayload uint64) (*Frame, error) {
+	frame := &Frame{}
+
+	firstByte := make([]byte, 1)
+	if _, err := io.ReadFull(r, firstByte); err != nil {
+		return nil, err
+	}
+
+	/**
+	 * Note: 1 Byte is 8 bits.
+	 * Anything bitwise ( & ) with above will be either 0x80 or 0
+	 * 0x80 -> 1000 0000
+	 * 0x70 -> 0111 0000
+	 * 0x0F -> 0000 1111
+	 *
+	 * 	frame.Fin extracts the FIN bit to determine if this is the final fragment.
+	 * 	frame.Rsv extracts the reserved bits (RSV1, RSV2, RSV3). No extension is implemented here, so a
+	 * 	connection receiving a frame with any of them set must fail (see checkFrame).
+	 * 	frame.Opcode extracts the last four bits to determine the frame type.
+	 */
+	frame.Fin = (firstByte[0] & 0x80) != 0 // Determines whether the MSB is 1.
+	frame.Rsv = firstByte[0] & 0x70        // Determines bits 6, 5 and 4 from first byte.
+	frame.Opcode = firstByte[0] & 0x0F     // Determines the right 4 bits from first byte.
+
+	secondByte := make([]byte, 1)
+	if _, err := io.ReadFull(r, secondByte); err != nil {
+		return nil, err
+	}
+
+	/**
+	 * Note: 1 Byte is 8 bits.
+	 * Anything bitwise ( & ) with above will be either 0x80 or 0
+	 * 0x80 -> 1000 0000
+	 * 0x7F -> 0111 1111
+	 *
+	 * 	frame.Masked extracts the MASK bit to determine if the payload data is masked.
+	 * 	payloadLen extracts the last seven bits to determine the payload length.
+	 */
+	frame.Masked = (secondByte[0] & 0x80) != 0 // Determine the whether the MSB is 1
+	payloadLen := secondByte[0] & 0x7F
+
+	// Read extended payload length if necessary
+	switch payloadLen {
+	case 126: // 0111 1110 -> 0x7E
+		extendedLen := make([]byte, 2)
+		if _, err := io.ReadFull(r, extendedLen); err != nil {
+			return nil, err
+		}
+		frame.PayloadLen = uint64(binary.BigEndian.Uint16(extendedLen))
+	case 127: // 0111 1111 -> 0x7F
+		extendedLen := make([]byte, 8)
+		if _, err := io.ReadFull(r, extendedLen); err != nil {
+			return nil, err
+		}
+		frame.PayloadLen = binary.BigEndian.Uint64(extendedLen)
+	default:
+		frame.PayloadLen = uint64(payloadLen)
+	}
+
+	/**
+	 * The most significant bit of the 64 bit length must be 0, and the payload is only allocated
+	 * once its length is known to be within maxPayload. Otherwise a forged header could make the
+	 * reader allocate up to 2^63 bytes before a single payload byte arrived.
+	 */
+	if frame.PayloadLen > math.MaxInt64 {
+		return nil, &ErrProtocolError{Code: closeProtocolError, Reason: "payload length has its most significant bit set"}
+	}
+	if frame.PayloadLen > maxPayload {
+		return nil, &ErrProtocolError{Code: closeMessageTooBig, Reason: fmt.Sprintf("frame payload of %d bytes exceeds %d bytes", frame.PayloadLen, maxPayload), err: ErrMessageTooBig}
+	}
+
+	/**
+	 * Note: 1 Byte is 8 bits.
+	 * Anything bitwise ( & ) with above will be either 0x80 or 0
+	 *
+	 * 	frame.MaskKey extracts the MASK KEY bit.
+	 *  MASK KEY is of 4 byte.
+	 *
+	 */
+	if frame.Masked {
+		frame.MaskKey = make([]byte, 4)
+		if _, err := io.ReadFull(r, frame.MaskKey); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read payload
+	if frame.PayloadLen > 0 {
+		frame.Payload = make([]byte, frame.PayloadLen)
+		if _, err := io.ReadFull(r, frame.Payload); err != nil {
+			return nil, err
+		}
+
+		/**
+		 * Certainly! Let's break down the operation in the provided Go code:
+		 *
+		 * 	Explanation
+		 * 		frame.Payload[i]:
+		 *
+		 * 	This accesses the i-th element of the Payload slice (or array)
+		 * 	within the frame struct. The Payload is likely a byte slice ([]byte).
+		 * 		frame.MaskKey[i%4]:
+		 *
+		 * 	This accesses an element of the MaskKey slice (or array) within the frame struct.
+		 * 	The index used here is i%4, which means the index is the remainder of i divided by 4.
+		 * 	This ensures that the index cycles through 0, 1, 2, and 3, regardless of how large i gets.
+		 * 		^= (XOR assignment operator):
+		 *
+		 * 	The ^= operator performs a bitwise XOR operation between the left-hand side and
+		 * 	the right-hand side, and then assigns the result back to the left-hand side.
+		 * 	In this case, it XORs frame.Payload[i] with frame.MaskKey[i%4] and stores the
+		 * 	result back in frame.Payload[i].
+		 *
+		 * 	Context
+		 * 		This operation is commonly used in WebSocket implementations for masking and
+		 * 		unmasking data frames. The WebSocket protocol specifies that payload data must
+		 * 		be XORed with a masking key to obscure the data being transmitted.
+		 *
+		 * 	Example
+		 * 		Let's say frame.Payload is [0x01, 0x02, 0x03, 0x04] and
+		 * 		frame.MaskKey is [0xAA, 0xBB, 0xCC, 0xDD]. For i = 0, the operation would be:
+		 *
+		 * 	After the operation, frame.Payload would be [0xAB, 0x02, 0x03, 0x04].
+		 *
+		 * 	This process would repeat for each element in frame.Payload, cycling through the MaskKey.
+		 *
+		 * 	Summary
+		 * 		The line of code is performing a bitwise XOR operation between each byte of the Payload and
+		 * 		a corresponding byte from the MaskKey, cycling through the MaskKey every 4 bytes.
+		 * 		This is typically used for encoding or decoding data in WebSocket frames.
+		 */
+		if frame.Masked {
+			for i := range frame.Payload {
+				frame.Payload[i] ^= frame.MaskKey[i%4]
+			}
+		}
+	}
+
+	return frame, nil
+}
+
+// OpcodeName returns the string representation of the opcode
+func (f *Frame) OpcodeName() string {
+	switch f.Opcode {
+	case 0x0:
+		return "continuation"
+	case 0x1:
+		return "text"
+	case 0x2:
+		return "binary"
+	case 0x8:
+		return "close"
+	case 0x9:
+		return "ping"
+	case 0xA:
+		return "pong"
+	default:
+		return "unknown"
+	}
+}
+
+// Handler serves a WebSocket connection once the opening handshake is done.
+// The connection is closed when the handler returns.
+type Handler func(conn *Conn)
+
+/**
+ * * WriteFrame writes a single WebSocket frame to w.
+ *
+ * * The header mirrors what ReadFrame parses: FIN and the opcode in the first byte, the MASK bit and
+ * * the 7 bit payload length in the second one, followed by a 16 bit (126) or 64 bit (127) extended
+ * * length when the payload does not fit in 125 bytes.
+ *
+ * * Frames sent by a client must be masked: a fresh random 4 byte MASK KEY follows the length, and
+ * * every payload byte is XORed with MaskKey[i%4], exactly the operation ReadFrame undoes.
+ */
+func WriteFrame(w io.Writer, fin bool, opcode byte, payload []byte, masked bool) error {
+	firstByte := opcode
+	if fin {
+		firstByte |= 0x80
+	}
+
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+
+	header := []byte{firstByte}
+	length := uint64(len(payload))
+	if length <= 125 {
+		header = append(header, maskBit|byte(length))
+	} else if length <= 65535 {
+		header = append(header, maskBit|126)
+		extendedLen := make([]byte, 2)
+		binary.BigEndian.PutUint16(extendedLen, uint16(length))
+		header = append(header, extendedLen...)
+	} else {
+		header = append(header, maskBit|127)
+		extendedLen := make([]byte, 8)
+		binary.BigEndian.PutUint64(extendedLen, length)
+		header = append(header, extendedLen...)
+	}
+
+	if masked {
+		maskKey := make([]byte, 4)
+		if _, err := rand.Read(maskKey); err != nil {
+			return err
+		}
+		header = append(header, maskKey...)
+
+		maskedPayload := make([]byte, len(payload))
+		for i := range payload {
+			maskedPayload[i] = payload[i] ^ maskKey[i%4]
+		}
+		payload = maskedPayload
+	}
+
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+// Server accepts WebSocket connections and runs Handler for each of them.
+type Server struct {
+	Handler Handler
+
+	// Reactor, when set, replaces Handler: the connections are watched by
+	// one epoll instance and hold no goroutine between messages, see
+	// ReactorHandler. Linux only, and only for plain TCP, TLS connections and
+	// other systems get a goroutine each running the same callbacks.
+	Reactor *ReactorHandler
+
+	// Workers, when set, handles the messages of ReactorHandler.OnMessage,
+	// so a slow callback does not hold up reading, see WorkerPool.
+	Workers *WorkerPool
+
+	// Addr and TLSConfig are used by ListenAndServe: the TCP address to
+	// listen on, or unix:/path for a unix domain socket (see Listen), and,
+	// when set, the TLS configuration serving wss://.
+	Addr      string
+	TLSConfig *tls.Config
+
+	// HTTP2 accepts WebSockets over HTTP/2 too, opened with the extended
+	// CONNECT of RFC 8441, several of them sharing one TCP connection. Over
+	// TLS the client has to negotiate h2 with ALPN: ListenAndServe adds it
+	// to the NextProtos of TLSConfig, listeners wrapped by the caller need
+	// it there themselves. Plain connections are served HTTP/2 when the
+	// client speaks it with prior knowledge. Not with Reactor.
+	HTTP2 bool
+
+	// TCP tunes the socket of every accepted connection, see TCPOptions.
+	TCP TCPOptions
+
+	// Bandwidth limits how fast every connection is read from and written
+	// to, each direction on its own, handshake included, see throttle.Conn.
+	// Unlimited by default. With Reactor, throttled connections are served
+	// on a goroutine each rather than by the poller.
+	Bandwidth throttle.Limit
+
+	// ProxyProtocol makes ListenAndServe read the PROXY protocol header a
+	// load balancer sends ahead of every connection, see ProxyListener.
+	ProxyProtocol bool
+
+	// ReusePort makes ListenAndServe accept on one SO_REUSEPORT listener
+	// per CPU instead of a single one, see ListenReusePort.
+	ReusePort bool
+
+	// Mode selects how strictly clients are held to RFC 6455, see Mode.
+	Mode Mode
+
+	// Logger receives the server's log records, slog.Default() when nil.
+	// Frame level records (frames, pings, pongs) are logged at Debug, so a
+	// logger at Info or above silences them.
+	Logger *slog.Logger
+
+	// ConnContext, when set, derives the Context of every connection from
+	// ctx, which carries the Conn, and its handshake request. It can add
+	// request-scoped values such as a tenant or a request ID.
+	ConnContext func(ctx context.Context, r *http.Request) context.Context
+
+	// TraceWire, when set, is called with every valid handshake request and
+	// turns on the wire trace of the connection when it returns true, for
+	// example for requests carrying ?trace=wire. See Conn.SetWireTrace.
+	TraceWire func(r *http.Request) bool
+
+	// RateLimit applies to every connection on its own, GlobalRateLimit to
+	// all connections of the server together. Both are unlimited by default.
+	RateLimit       RateLimit
+	GlobalRateLimit RateLimit
+
+	// MaxConnections caps the number of connections served at once, zero
+	// means no cap. Connections beyond it are rejected with 503 during the
+	// handshake, or, with QueueConnections, left in the listen backlog until
+	// a connection closes.
+	MaxConnections   int
+	QueueConnections bool
+
+	// HandshakeTimeout is how long a client has to send its complete opening
+	// handshake, defaultHandshakeTimeout when zero. MaxHeaderBytes bounds the
+	// size of that request, defaultMaxHeaderBytes when zero. Clients exceeding
+	// either are dropped, so slow or endless requests cannot pin goroutines.
+	HandshakeTimeout time.Duration
+	MaxHeaderBytes   int
+
+	// PingInterval, when positive, pings every connection at that interval
+	// to measure its round trip time, see Conn.Latency.
+	PingInterval time.Duration
+
+	// SendQueueSize bounds how many messages Conn.Enqueue holds for a client
+	// that reads slower than hub broadcasts arrive, the one being written
+	// included, defaultSendQueueSize when zero. SendQueuePolicy decides what
+	// happens to the next one.
+	SendQueueSize   int
+	SendQueuePolicy QueuePolicy
+
+	// MaxMessageSize, MaxFrameSize, FragmentSize, ReassemblyTimeout and
+	// Hooks are copied to every Conn, see there.
+	MaxMessageSize    int64
+	MaxFrameSize      uint64
+	FragmentSize      int
+	ReassemblyTimeout time.Duration
+	Hooks             Hooks
+
+	// Subprotocols lists the subprotocols the server speaks, in order of
+	// preference. The first one the client offers in Sec-WebSocket-Protocol
+	// is selected, see Conn.Subprotocol. Clients offering none of them, or
+	// none at all, are served without subprotocol.
+	Subprotocols []string
+
+	// AllowedOrigins lists the origins (scheme://host[:port]) whose pages may
+	// connect, "*" allows any. Handshakes from other origins are rejected
+	// with 403. Requests without an Origin header do not come from a browser
+	// and are always allowed. Empty allows any origin.
+	AllowedOrigins []string
+
+	// Authenticate, when set, is called with every valid handshake request
+	// before the 101 response is sent. An error rejects the handshake with
+	// 401, otherwise the principal is available through Conn.Principal.
+	Authenticate Authenticator
+
+	// AllowGuests admits clients that send no credentials at all (the
+	// authenticator returned ErrNoCredentials) as guests instead of rejecting
+	// them, see Principal.Guest. Guests are held to GuestRateLimit and are
+	// disconnected after GuestTTL, defaultGuestTTL when zero, unless they
+	// authenticate in-band with Conn.Authenticate first.
+	AllowGuests    bool
+	GuestRateLimit RateLimit
+	GuestTTL       time.Duration
+
+	// Events receives the lifecycle events of every connection, events.Default
+	// when nil. The built-in metrics only watch events.Default.
+	Events *events.Bus
+
+	// IDs generates the IDs of connections and of the trace IDs assigned to
+	// messages, id.Default (ULIDs) when nil.
+	IDs id.Generator
+
+	// SessionKey, when set, makes sessions sticky: clients get a session
+	// token signed with it and resume their Session when they reconnect
+	// with it within SessionTTL, defaultSessionTTL (2 minutes) when zero.
+	// The token is a bearer credential: whoever presents it gets the
+	// session, its principal included. See sessionStore.
+	SessionKey []byte
+	SessionTTL time.Duration
+
+	initOnce sync.Once
+	global   *limiter
+	slots    chan struct{}
+	poller   *poller
+	sessions sessionStore
+
+	// Shutdown state: the listeners Serve accepts on, the raw connections
+	// being served and, once upgraded, their Conns.
+	mu        sync.Mutex
+	closing   bool
+	listeners map[net.Listener]bool
+	raw       map[net.Conn]bool
+	conns     map[*Conn]bool
+	h2        map[*h2Conn]bool
+	serving   sync.WaitGroup
+
+	// Drain state: drained is closed once no Conn is left, retryAfter is
+	// sent to the handshakes refused meanwhile.
+	drained    chan struct{}
+	retryAfter time.Duration
+}
+
+// ErrServerClosed is returned by Serve once Shutdown has been called.
+var ErrServerClosed = errors.New("websocket: server closed")
+
+// init sets up the state shared by every connection of the server.
+func (s *Server) init() {
+	s.initOnce.Do(func() {
+		s.global = newLimiter(s.GlobalRateLimit)
+		if s.MaxConnections > 0 {
+			s.slots = make(chan struct{}, s.MaxConnections)
+		}
+		s.listeners = make(map[net.Listener]bool)
+		s.raw = make(map[net.Conn]bool)
+		s.conns = make(map[*Conn]bool)
+		s.h2 = make(map[*h2Conn]bool)
+		if s.Reactor != nil {
+			var err error
+			if s.poller, err = newPoller(s); err != nil {
+				s.logger().Warn("Reactor mode unavailable, serving every connection on its own goroutine", "err", err)
+			}
+		}
+	})
+}
+
+// Serve accepts WebSocket connections on listener until it is closed and
+// runs handler for each of them, in Strict mode.
+func Serve(listener net.Listener, handler Handler) error {
+	server := &Server{Handler: handler}
+	return server.Serve(listener)
+}
+
+// ListenAndServe listens on s.Addr, over TLS when s.TLSConfig is set, and
+// serves connections on it, see Serve. With s.ReusePort it opens one listener
+// per CPU, see ListenReusePort.
+func (s *Server) ListenAndServe() error {
+	if s.ReusePort {
+		if _, ok := UnixPath(s.Addr); ok {
+			return errors.New("websocket: ReusePort needs a TCP address")
+		}
+		return s.listenAndServeReusePort()
+	}
+	listener, err := Listen(s.Addr)
+	if err != nil {
+		return err
+	}
+	if s.ProxyProtocol {
+		listener = ProxyListener(listener)
+	}
+	if s.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig())
+	}
+	return s.Serve(listener)
+}
+
+/**
+ * * Serve accepts WebSocket connections on listener until it is closed, or the server is shut
+ * * down, in which case it returns ErrServerClosed.
+ *
+ * * Failing Accept calls (e.g. when the process runs out of file descriptors) are retried after
+ * * a delay doubling from 5ms up to 1s, instead of spinning on the error.
+ */
+func (s *Server) Serve(listener net.Listener) error {
+	s.init()
+	queue := s.QueueConnections && s.slots != nil
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[listener] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, listener)
+		s.mu.Unlock()
+	}()
+
+	var delay time.Duration
+	for {
+		if queue {
+			s.slots <- struct{}{}
+		}
+		conn, err := listener.Accept()
+		if err != nil {
+			if queue {
+				<-s.slots
+			}
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			s.logger().Error("Error accepting WebSocket connection", "err", err, "retry_in", delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		if !s.track(conn) {
+			conn.Close()
+			if queue {
+				<-s.slots
+			}
+			return ErrServerClosed
+		}
+		go s.serveConn(conn, queue)
+	}
+}
+
+/**
+ * * Shutdown stops the server gracefully: it closes the listeners of Serve, sends a 1001 (going
+ * * away) close frame to every open connection, connections still in their handshake included
+ * * once they complete it, and waits for the handlers to return.
+ *
+ * * When ctx is done first, the remaining connections are closed without further ado and
+ * * Shutdown returns ctx.Err().
+ */
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.init()
+
+	s.mu.Lock()
+	s.closing = true
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	h2 := make([]*h2Conn, 0, len(s.h2))
+	for c := range s.h2 {
+		h2 = append(h2, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.goAway()
+	}
+	for _, c := range h2 {
+		c.drain()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.serving.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		if s.poller != nil {
+			s.poller.close()
+		}
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.raw {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		if s.poller != nil {
+			s.poller.close()
+		}
+		<-drained
+		return ctx.Err()
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// track counts conn as being served until serveConn returns, or reports
+// false when the server is shutting down.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.raw[conn] = true
+	s.serving.Add(1)
+	return true
+}
+
+// upgraded registers c for Shutdown, telling it to go away right away when
+// the server is shutting down already. The returned function unregisters it.
+func (s *Server) upgraded(c *Conn) func() {
+	s.mu.Lock()
+	s.conns[c] = true
+	closing := s.closing
+	s.mu.Unlock()
+	if closing {
+		c.goAway()
+	}
+	return func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		if s.drained != nil && len(s.conns) == 0 {
+			select {
+			case <-s.drained:
+			default:
+				close(s.drained)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// ServeConn performs the opening handshake on an accepted connection and
+// runs the handler. It closes conn when done. Serve calls it for every
+// connection, tests can call it on one end of a net.Pipe.
+func (s *Server) ServeConn(conn net.Conn) {
+	s.init()
+	if !s.track(conn) {
+		conn.Close()
+		return
+	}
+	s.serveConn(conn, false)
+}
+
+// serveConn is ServeConn for a connection that, when holdsSlot is set, was
+// already counted against MaxConnections by the accept loop. conn must be
+// tracked.
+func (s *Server) serveConn(conn net.Conn, holdsSlot bool) {
+	// done collects what has to happen once the connection ends. It runs
+	// when serveConn returns, unless the reactor took the connection over.
+	var done cleanup
+	defer func() { done.run() }()
+	done.add(s.serving.Done)
+	done.add(func() {
+		s.mu.Lock()
+		delete(s.raw, conn)
+		s.mu.Unlock()
+	})
+	done.add(func() { conn.Close() })
+	tlsConn, _ := conn.(*tls.Conn)
+	if err := s.TCP.apply(conn); err != nil {
+		s.logger().Warn("Error tuning TCP connection", "remote_addr", conn.RemoteAddr().String(), "err", err)
+	}
+	conn = throttle.Conn(conn, throttle.NewLimiter(s.Bandwidth), throttle.NewLimiter(s.Bandwidth))
+	conn = countingConn{conn}
+	connID, remoteAddr := s.ids().New(), conn.RemoteAddr().String()
+	log := s.logger().With("conn_id", connID, "remote_addr", remoteAddr)
+
+	s.publish(events.Event{Kind: events.Accepted, ConnID: connID, RemoteAddr: remoteAddr})
+	fail := func(err error, status int) {
+		s.publish(events.Event{Kind: events.Errored, ConnID: connID, RemoteAddr: remoteAddr, Err: err, Status: status})
+		if status != 0 {
+			rejectHandshake(conn, status, s.rejectHeader(err, status))
+		}
+	}
+
+	// Step 1: Perform WebSocket handshake
+	timeout := s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	limit := s.MaxHeaderBytes
+	if limit <= 0 {
+		limit = defaultMaxHeaderBytes
+	}
+	headerReader := &handshakeReader{r: conn, remaining: limit}
+	reader := bufio.NewReader(headerReader)
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		log.Warn("Error reading HTTP request", "err", err)
+		if errors.Is(err, errHeaderTooLarge) {
+			fail(err, http.StatusRequestHeaderFieldsTooLarge)
+		} else {
+			fail(err, 0)
+		}
+		if holdsSlot {
+			<-s.slots
+		}
+		return
+	}
+	// http.ReadRequest leaves it to the caller, the TLS handshake is done
+	// once the request was read
+	if tlsConn != nil {
+		state := tlsConn.ConnectionState()
+		request.TLS = &state
+	}
+
+	if s.slots != nil {
+		if !holdsSlot {
+			select {
+			case s.slots <- struct{}{}:
+			default:
+				log.Warn("Connection limit reached, rejecting handshake", "max_connections", s.MaxConnections)
+				fail(errConnectionLimit, http.StatusServiceUnavailable)
+				return
+			}
+		}
+		done.add(func() { <-s.slots })
+	}
+
+	// An HTTP/2 connection, over TLS with ALPN or with prior knowledge,
+	// starts with a preface that reads as a PRI request.
+	if request.Method == "PRI" && request.Proto == "HTTP/2.0" && s.HTTP2 && s.Reactor == nil {
+		conn.SetDeadline(time.Time{})
+		headerReader.remaining = -1
+		s.serveHTTP2(conn, reader, log)
+		return
+	}
+
+	// Validate WebSocket handshake
+	if status, err := checkHandshake(request, s.Mode); err != nil {
+		log.Warn("Invalid WebSocket handshake", "err", err)
+		fail(err, status)
+		return
+	}
+
+	s.upgrade(conn, reader, request, connID, log, &done, fail, func(header http.Header) error {
+		// WebSocket handshake response
+		key := request.Header.Get("Sec-WebSocket-Key")
+		acceptKey := generateWebSocketAcceptKey(key)
+		var response strings.Builder
+		fmt.Fprintf(&response,
+			"HTTP/1.1 101 Switching Protocols\r\n"+
+				"Upgrade: websocket\r\n"+
+				"Connection: Upgrade\r\n"+
+				"Sec-WebSocket-Accept: %s\r\n",
+			acceptKey,
+		)
+		header.Write(&response)
+		response.WriteString("\r\n")
+		if _, err := io.WriteString(conn, response.String()); err != nil {
+			return err
+		}
+
+		// The handshake is done, lift its deadline and size limit.
+		conn.SetDeadline(time.Time{})
+		headerReader.remaining = -1
+		return nil
+	})
+}
+
+/**
+ * * upgrade completes the opening handshake of a valid request and serves the connection: it checks
+ * * the origin and the credentials, sends the response with respond, given its headers beyond the
+ * * upgrade itself, and runs the handler on the Conn. Requests over HTTP/1.1 and HTTP/2 streams
+ * * both end up here, with the connection to speak the WebSocket frames on.
+ */
+func (s *Server) upgrade(conn net.Conn, reader *bufio.Reader, request *http.Request, connID string, log *slog.Logger, done *cleanup, fail func(error, int), respond func(header http.Header) error) {
+	remoteAddr := conn.RemoteAddr().String()
+	if s.Draining() {
+		log.Info("Server draining, refusing handshake")
+		fail(ErrDraining, http.StatusServiceUnavailable)
+		return
+	}
+	if err := checkOrigin(request, s.AllowedOrigins); err != nil {
+		log.Warn("Origin not allowed", "err", err)
+		fail(err, http.StatusForbidden)
+		return
+	}
+
+	var principal *Principal
+	if s.Authenticate != nil {
+		p, err := s.Authenticate(request)
+		switch {
+		case err == nil:
+		case s.AllowGuests && errors.Is(err, ErrNoCredentials):
+			p = Principal{ID: "guest-" + connID, Guest: true}
+		default:
+			log.Warn("Authentication failed", "err", err)
+			fail(err, http.StatusUnauthorized)
+			return
+		}
+		principal = &p
+	}
+
+	header := make(http.Header)
+	var session *Session
+	if s.SessionKey != nil {
+		if session = s.resumeSession(request, principal, log); session != nil {
+			// The client comes back as who it was: a guest keeps its ID, an
+			// in-band authentication carries over
+			if previous, ok := session.Principal(); ok && (principal == nil || principal.Guest) {
+				principal = &previous
+			}
+			log = log.With("session_id", session.id)
+		} else {
+			session = &Session{id: s.ids().New(), created: time.Now()}
+		}
+		token := s.sessionToken(session.id)
+		header.Set(SessionHeader, token)
+		header.Add("Set-Cookie", sessionCookie(token, request.TLS != nil).String())
+	}
+	if principal != nil {
+		log = log.With("principal", principal.ID)
+	}
+
+	if subprotocol := selectSubprotocol(request, s.Subprotocols); subprotocol != "" {
+		header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if err := respond(header); err != nil {
+		log.Warn("Error sending handshake response", "err", err)
+		fail(err, 0)
+		return
+	}
+	log.Info("WebSocket handshake completed")
+
+	s.publish(events.Event{Kind: events.Upgraded, ConnID: connID, RemoteAddr: remoteAddr})
+	done.add(func() { s.publish(events.Event{Kind: events.Closed, ConnID: connID, RemoteAddr: remoteAddr}) })
+
+	// Step 2: Hand the connection over to the application
+	c := &Conn{
+		id:           connID,
+		ids:          s.ids(),
+		conn:         conn,
+		reader:       reader,
+		mode:         s.Mode,
+		log:          log,
+		global:       s.global,
+		principal:    principal,
+		authenticate: s.Authenticate,
+		rateLimit:    s.RateLimit,
+		subprotocol:  header.Get("Sec-WebSocket-Protocol"),
+		session:      session,
+
+		MaxMessageSize:    s.MaxMessageSize,
+		MaxFrameSize:      s.MaxFrameSize,
+		FragmentSize:      s.FragmentSize,
+		Hooks:             s.Hooks,
+		ReassemblyTimeout: s.ReassemblyTimeout,
+	}
+	c.queue.size, c.queue.policy = s.SendQueueSize, s.SendQueuePolicy
+	c.connected = time.Now()
+	c.lastActive.Store(c.connected.UnixNano())
+	if session != nil {
+		s.attachSession(c, session)
+		done.add(func() { s.detachSession(c) })
+	} else {
+		done.add(c.Session().end)
+	}
+	ctx := context.WithValue(context.Background(), connKey, c)
+	if s.ConnContext != nil {
+		ctx = s.ConnContext(ctx, request)
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	done.add(c.cancel)
+
+	if s.PingInterval > 0 {
+		go c.pingLoop(s.PingInterval)
+	}
+	if s.TraceWire != nil && s.TraceWire(request) {
+		c.SetWireTrace(true)
+	}
+	if principal != nil && principal.Guest {
+		c.limiter.Store(newLimiter(s.GuestRateLimit))
+		expiry := c.expireGuest(s.GuestTTL)
+		done.add(func() { expiry.Stop() })
+	} else {
+		c.limiter.Store(newLimiter(s.RateLimit))
+	}
+	done.add(s.upgraded(c))
+
+	if s.Reactor != nil {
+		s.serveReactor(c, done)
+		return
+	}
+	s.Handler(c)
+}
+
+// cleanup is a list of functions run in reverse order, like deferred calls.
+type cleanup []func()
+
+func (c *cleanup) add(f func()) {
+	*c = append(*c, f)
+}
+
+// run runs the functions added and empties the list.
+func (c *cleanup) run() {
+	for i := len(*c) - 1; i >= 0; i-- {
+		(*c)[i]()
+	}
+	*c = nil
+}
+
+// EchoHandler sends every message back with its original opcode. It is the
+// handler cmd/autobahn runs the Autobahn TestSuite against.
+func EchoHandler(conn *Conn) {
+	for {
+		opcode, r, err := conn.NextReader()
+		if err != nil {
+			logDisconnect(conn.Logger(), err)
+			return
+		}
+
+		// Read the message completely before answering, so that pings arriving between
+		// its fragments can be answered while no message is being written.
+		message, err := readLimited(r, conn.MaxMessageSize, conn.fail)
+		if err != nil {
+			logDisconnect(conn.Logger(), err)
+			return
+		}
+		if err := conn.WriteMessage(opcode, message); err != nil {
+			conn.Logger().Error("Error sending message", "err", err)
+			return
+		}
+	}
+}
+
+// readValidJSON decodes the next message of conn that is valid JSON for v
+// into it, logging and skipping the others.
+func readValidJSON(conn *Conn, v any) error {
+	for {
+		err := conn.ReadJSON(v)
+		if err == nil {
+			return nil
+		}
+
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr) {
+			return err
+		}
+		conn.Logger().Warn("Error parsing JSON", "err", err)
+	}
+}
+
+func logDisconnect(log *slog.Logger, err error) {
+	if errors.Is(err, io.EOF) {
+		log.Info("Client disconnected")
+	} else {
+		log.Warn("Error reading WebSocket message", "err", err)
+	}
+}
+
+// Limits applied to the opening handshake unless the Server overrides them.
+const (
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultMaxHeaderBytes   = 1 << 20
+)
+
+var (
+	errHeaderTooLarge  = errors.New("handshake request too large")
+	errConnectionLimit = errors.New("connection limit reached")
+)
+
+// handshakeReader fails once more than remaining bytes were read, bounding
+// the size of the handshake request. A negative remaining disables the limit.
+type handshakeReader struct {
+	r         io.Reader
+	remaining int
+}
+
+func (h *handshakeReader) Read(p []byte) (int, error) {
+	if h.remaining < 0 {
+		return h.r.Read(p)
+	}
+	if h.remaining == 0 {
+		return 0, errHeaderTooLarge
+	}
+	if len(p) > h.remaining {
+		p = p[:h.remaining]
+	}
+	n, err := h.r.Read(p)
+	h.remaining -= n
+	return n, err
+}
+
+// rejectHandshake answers a failed handshake with an empty HTTP error response
+// carrying header.
+func rejectHandshake(conn net.Conn, status int, header http.Header) {
+	var response strings.Builder
+	fmt.Fprintf(&response, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	header.Write(&response)
+	response.WriteString("Content-Length: 0\r\n\r\n")
+	io.WriteString(conn, response.String())
+}
+
+func generateWebSocketAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
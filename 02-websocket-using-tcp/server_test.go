@@ -0,0 +1,48 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// fuzzMaxPayload is the payload limit readFrame is fuzzed with, small enough
+// for an allocation above it to stand out.
+const fuzzMaxPayload = 1 << 16
+
+// FuzzReadFrame feeds arbitrary bytes to readFrame: it must never panic,
+// never allocate more than the payload limit whatever length the header
+// claims, and fail only with a protocol error or because the input ended.
+func FuzzReadFrame(f *testing.F) {
+	f.Add([]byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}) // Masked "Hello".
+	f.Add([]byte{0x82, 0x7e, 0x01, 0x00})                                           // 256 bytes announced, none sent.
+	f.Add([]byte{0x82, 0x7f, 0x80, 0, 0, 0, 0, 0, 0, 0})                            // Top bit of the 64-bit length set.
+	f.Add([]byte{0x82, 0x7f, 0, 0, 0, 0x10, 0, 0, 0, 0})                            // 64 GiB announced.
+	f.Add([]byte{0x89, 0x00})                                                       // Empty ping.
+	f.Add([]byte{0x01})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		frame, err := readFrame(bytes.NewReader(data), fuzzMaxPayload)
+		runtime.ReadMemStats(&after)
+
+		// The frame, its mask key and a little bookkeeping on top of the payload
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > fuzzMaxPayload+4096 {
+			t.Fatalf("readFrame allocated %d bytes for %d bytes of input", allocated, len(data))
+		}
+
+		if err != nil {
+			var protocolErr *ErrProtocolError
+			if !errors.As(err, &protocolErr) && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("readFrame failed with %T %v, want a protocol error or the end of the input", err, err)
+			}
+			return
+		}
+		if frame.PayloadLen > fuzzMaxPayload || uint64(len(frame.Payload)) != frame.PayloadLen {
+			t.Fatalf("frame of %d bytes announced %d, limit %d", len(frame.Payload), frame.PayloadLen, fuzzMaxPayload)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/**
+ * * Session is the server side state of a client, created with its connection once the handshake
+ * * completed. Handlers and the callbacks of ReactorHandler reach it from the Conn they are given,
+ * * or from its Context, and keep in it what they learn about the client as the messages come in,
+ * * a nickname or the rooms it asked for, instead of in locals of the handler that the other
+ * * callbacks cannot see.
+ *
+ * * Unlike the metadata of the Conn, which middleware uses to carry things along with the
+ * * connection, a Session belongs to the client: its ID is not the connection ID. It lives as long
+ * * as the connection, unless the server makes sessions sticky (see Server.SessionKey) and the
+ * * client reconnects to it.
+ */
+type Session struct {
+	id      string
+	created time.Time
+
+	mu     sync.RWMutex
+	conn   *Conn // The latest connection of the session.
+	values map[string]any
+
+	// onEnd is called once the session ended, then ended is set.
+	onEnd []func()
+	ended bool
+
+	// expiry drops a sticky session its connection left, guarded by the
+	// sessions of the server.
+	expiry *time.Timer
+}
+
+// Session returns the session of the connection, created on first use for
+// connections that were not accepted by a Server.
+func (c *Conn) Session() *Session {
+	c.sessionOnce.Do(func() {
+		if c.session == nil {
+			c.session = &Session{id: c.NewID(), created: time.Now(), conn: c}
+		}
+	})
+	return c.session
+}
+
+// attach makes c the connection of the session and returns the previous one.
+func (s *Session) attach(c *Conn) *Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.conn
+	s.conn = c
+	return previous
+}
+
+// current returns the latest connection of the session.
+func (s *Session) current() *Conn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.conn
+}
+
+// SessionFromContext returns the session of the connection of ctx.
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	conn, ok := ConnFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return conn.Session(), true
+}
+
+// ID returns the identifier of the session.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// CreatedAt returns when the session was created.
+func (s *Session) CreatedAt() time.Time {
+	return s.created
+}
+
+// Principal returns the principal of the client, following in-band
+// authentication, see Conn.Principal.
+func (s *Session) Principal() (Principal, bool) {
+	return s.current().Principal()
+}
+
+// OnEnd registers fn to be called once the session ended: with its
+// connection, or for a sticky session once SessionTTL passed without a
+// connection resuming it. fn is called right away when it ended already.
+func (s *Session) OnEnd(fn func()) {
+	s.mu.Lock()
+	if !s.ended {
+		s.onEnd = append(s.onEnd, fn)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	fn()
+}
+
+// end ends the session.
+func (s *Session) end() {
+	s.mu.Lock()
+	onEnd := s.onEnd
+	s.onEnd, s.ended = nil, true
+	s.mu.Unlock()
+	for _, fn := range onEnd {
+		fn()
+	}
+}
+
+// Set stores value under key, replacing any previous value.
+func (s *Session) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]any)
+	}
+	s.values[key] = value
+}
+
+// Get returns the value stored under key and whether it was set.
+func (s *Session) Get(key string) (any, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Delete removes key from the session.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+// SessionValue returns the value stored under key in session as a T. The
+// second result is false when the key is not set or holds a value of
+// another type.
+//
+//	session.Set("nick", "alice")
+//	nick, ok := websocket.SessionValue[string](conn.Session(), "nick")
+func SessionValue[T any](s *Session, key string) (T, bool) {
+	value, ok := s.Get(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	typed, ok := value.(T)
+	return typed, ok
+}
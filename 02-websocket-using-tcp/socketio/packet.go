@@ -0,0 +1,164 @@
+package socketio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrPacket is returned for text that is not a Socket.IO packet.
+var ErrPacket = errors.New("socketio: malformed packet")
+
+// The Socket.IO packet types, the first character of a packet.
+const (
+	packetConnect      = 0
+	packetDisconnect   = 1
+	packetEvent        = 2
+	packetAck          = 3
+	packetConnectError = 4
+	packetBinaryEvent  = 5
+	packetBinaryAck    = 6
+)
+
+/**
+ * * packet is a Socket.IO packet, carried in an engine.io message packet: the type, for binary ones
+ * * the number of attachments sent after it in binary WebSocket messages, the namespace unless it
+ * * is the main one, the acknowledgement id and the JSON data.
+ *
+ *	2["chat","hello"]                   event chat on /
+ *	2/admin,7["kick","bob"]             event on /admin, acknowledged with id 7
+ *	51-["upload",{"_placeholder":true,"num":0}]   event with one binary attachment
+ */
+type packet struct {
+	kind        int
+	attachments int
+	namespace   string
+	id          int // -1 without acknowledgement.
+	data        json.RawMessage
+}
+
+func parsePacket(text string) (*packet, error) {
+	if text == "" || text[0] < '0' || text[0] > '6' {
+		return nil, fmt.Errorf("%w: %q", ErrPacket, text)
+	}
+	p := &packet{kind: int(text[0] - '0'), namespace: "/", id: -1}
+	rest := text[1:]
+	if p.kind == packetBinaryEvent || p.kind == packetBinaryAck {
+		count, after, ok := strings.Cut(rest, "-")
+		n, err := strconv.Atoi(count)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: attachment count in %q", ErrPacket, text)
+		}
+		p.attachments, rest = n, after
+	}
+	if strings.HasPrefix(rest, "/") {
+		namespace, after, _ := strings.Cut(rest, ",")
+		p.namespace, rest = namespace, after
+	}
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	if digits > 0 {
+		id, err := strconv.Atoi(rest[:digits])
+		if err != nil {
+			return nil, fmt.Errorf("%w: acknowledgement id in %q", ErrPacket, text)
+		}
+		p.id, rest = id, rest[digits:]
+	}
+	if rest != "" {
+		if !json.Valid([]byte(rest)) {
+			return nil, fmt.Errorf("%w: data of %q", ErrPacket, text)
+		}
+		p.data = json.RawMessage(rest)
+	}
+	return p, nil
+}
+
+func (p *packet) encode() string {
+	var b strings.Builder
+	b.WriteByte(byte('0' + p.kind))
+	if p.kind == packetBinaryEvent || p.kind == packetBinaryAck {
+		b.WriteString(strconv.Itoa(p.attachments))
+		b.WriteByte('-')
+	}
+	if p.namespace != "/" {
+		b.WriteString(p.namespace)
+		b.WriteByte(',')
+	}
+	if p.id >= 0 {
+		b.WriteString(strconv.Itoa(p.id))
+	}
+	b.Write(p.data)
+	return b.String()
+}
+
+// placeholder stands for a binary attachment in the data of binary packets.
+type placeholder struct {
+	Placeholder bool `json:"_placeholder"`
+	Num         int  `json:"num"`
+}
+
+// deconstruct replaces the []byte values of args, at any depth of slices
+// and maps, with placeholders and returns the attachments they stand for.
+func deconstruct(args []any) ([]any, [][]byte) {
+	var attachments [][]byte
+	var walk func(v any) any
+	walk = func(v any) any {
+		switch v := v.(type) {
+		case []byte:
+			attachments = append(attachments, v)
+			return placeholder{Placeholder: true, Num: len(attachments) - 1}
+		case []any:
+			out := make([]any, len(v))
+			for i, item := range v {
+				out[i] = walk(item)
+			}
+			return out
+		case map[string]any:
+			out := make(map[string]any, len(v))
+			for key, item := range v {
+				out[key] = walk(item)
+			}
+			return out
+		}
+		return v
+	}
+	out := make([]any, len(args))
+	for i, arg := range args {
+		out[i] = walk(arg)
+	}
+	return out, attachments
+}
+
+// reconstruct puts the attachments back in place of their placeholders.
+func reconstruct(v any, attachments [][]byte) (any, error) {
+	switch v := v.(type) {
+	case []any:
+		for i, item := range v {
+			item, err := reconstruct(item, attachments)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = item
+		}
+	case map[string]any:
+		if v["_placeholder"] == true {
+			num, ok := v["num"].(float64)
+			if !ok || num < 0 || int(num) >= len(attachments) {
+				return nil, fmt.Errorf("%w: placeholder %v", ErrPacket, v["num"])
+			}
+			return attachments[int(num)], nil
+		}
+		for key, item := range v {
+			item, err := reconstruct(item, attachments)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = item
+		}
+	}
+	return v, nil
+}
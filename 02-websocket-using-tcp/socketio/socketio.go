@@ -0,0 +1,511 @@
+/**
+ * * Package socketio lets socket.io v4 browser clients talk to the server: the engine.io v4 protocol,
+ * * with its open packet and heartbeat, and the Socket.IO v5 packets it carries, namespaces,
+ * * events, acknowledgements and binary attachments. Only the websocket transport is served, not
+ * * HTTP long-polling, so clients connect with transports: ["websocket"]:
+ *
+ *	server: 0{"sid":"01J...","upgrades":[],"pingInterval":25000,"pingTimeout":20000,"maxPayload":1000000}
+ *	client: 40                              join the main namespace
+ *	server: 40{"sid":"01J..."}
+ *	client: 421["chat","hello"]             event chat, acknowledgement 1 requested
+ *	server: 431["received"]                 the handler's answer
+ *	server: 2   client: 3                   ping and pong every pingInterval
+ *
+ * * Run Server.Handle as the handler, on any path: /socket.io/ is the one clients use by default.
+ */
+package socketio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket"
+)
+
+const (
+	// defaultPingInterval and defaultPingTimeout are the heartbeat of the
+	// socket.io server, used when Server.PingInterval and PingTimeout are
+	// zero.
+	defaultPingInterval = 25 * time.Second
+	defaultPingTimeout  = 20 * time.Second
+
+	// maxPayload is announced in the open packet. Clients only use it to
+	// size polling requests, the server enforces MaxMessageSize.
+	maxPayload = 1000000
+)
+
+// EventHandler handles an event sent to a namespace. args are the JSON
+// values of the event, with []byte for its binary attachments. When the
+// client asked for an acknowledgement, the values returned are sent back
+// in it.
+type EventHandler func(socket *Socket, args []any) []any
+
+// Server routes the events of socket.io clients to namespaces.
+type Server struct {
+	// PingInterval is how often clients are pinged, PingTimeout how long
+	// the pong may take before the connection is closed. Zero means 25
+	// and 20 seconds, the socket.io defaults.
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+
+	mu         sync.Mutex
+	namespaces map[string]*Namespace
+}
+
+// NewServer returns a server with the main namespace "/".
+func NewServer() *Server {
+	s := &Server{namespaces: make(map[string]*Namespace)}
+	s.Of("/")
+	return s
+}
+
+// Of returns the namespace name, creating it on first use. Clients can only
+// join namespaces created beforehand.
+func (s *Server) Of(name string) *Namespace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ns, ok := s.namespaces[name]
+	if !ok {
+		ns = &Namespace{name: name, handlers: make(map[string]EventHandler), sockets: make(map[*Socket]struct{})}
+		s.namespaces[name] = ns
+	}
+	return ns
+}
+
+func (s *Server) namespace(name string) *Namespace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.namespaces[name]
+}
+
+// Namespace is a channel clients join separately over one connection, such
+// as "/" or "/admin".
+type Namespace struct {
+	name string
+
+	// OnConnect, when set, is called as a client joins with the auth
+	// payload of its CONNECT, null when it sent none. An error refuses it
+	// with a CONNECT_ERROR carrying the message.
+	OnConnect func(socket *Socket, auth json.RawMessage) error
+
+	// OnDisconnect, when set, is called once a socket left, with the reason
+	// socket.io reports: "client namespace disconnect", "server namespace
+	// disconnect", "transport close" or "ping timeout".
+	OnDisconnect func(socket *Socket, reason string)
+
+	mu       sync.RWMutex
+	handlers map[string]EventHandler
+	sockets  map[*Socket]struct{}
+}
+
+// Name returns the name of the namespace.
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// On sets the handler of event.
+func (n *Namespace) On(event string, handler EventHandler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handlers[event] = handler
+}
+
+// Emit sends event to every socket of the namespace.
+func (n *Namespace) Emit(event string, args ...any) {
+	n.mu.RLock()
+	sockets := make([]*Socket, 0, len(n.sockets))
+	for socket := range n.sockets {
+		sockets = append(sockets, socket)
+	}
+	n.mu.RUnlock()
+	for _, socket := range sockets {
+		socket.Emit(event, args...)
+	}
+}
+
+func (n *Namespace) handler(event string) EventHandler {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.handlers[event]
+}
+
+// Socket is a client joined to a namespace.
+type Socket struct {
+	id        string
+	namespace *Namespace
+	session   *session
+}
+
+// ID returns the socket id sent to the client, socket.id on its side.
+func (s *Socket) ID() string {
+	return s.id
+}
+
+// Namespace returns the namespace the socket joined.
+func (s *Socket) Namespace() *Namespace {
+	return s.namespace
+}
+
+// Conn returns the WebSocket connection carrying the socket.
+func (s *Socket) Conn() *websocket.Conn {
+	return s.session.conn
+}
+
+// Emit sends event with args to the client. []byte values are sent as
+// binary attachments.
+func (s *Socket) Emit(event string, args ...any) error {
+	return s.session.send(s.namespace.name, packetEvent, -1, append([]any{event}, args...))
+}
+
+// Disconnect removes the socket from its namespace and tells the client,
+// the connection stays open for its other namespaces.
+func (s *Socket) Disconnect() error {
+	if !s.session.leave(s, "server namespace disconnect") {
+		return nil
+	}
+	return s.session.writePacket(&packet{kind: packetDisconnect, namespace: s.namespace.name, id: -1}, nil)
+}
+
+// Handle serves an engine.io connection, it is a websocket.Handler.
+func (s *Server) Handle(conn *websocket.Conn) {
+	sess := &session{
+		server:  s,
+		conn:    conn,
+		sid:     conn.NewID(),
+		sockets: make(map[string]*Socket),
+		pong:    make(chan struct{}, 1),
+	}
+	err := sess.serve()
+	reason := "transport close"
+	if sess.timedOut.Load() {
+		reason = "ping timeout"
+	}
+	sess.leaveAll(reason)
+
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		conn.Logger().Info("Socket.IO client disconnected", "reason", reason)
+	} else {
+		conn.Logger().Warn("Error reading WebSocket message", "err", err)
+	}
+}
+
+// session is an engine.io connection and the sockets it carries.
+type session struct {
+	server *Server
+	conn   *websocket.Conn
+	sid    string
+
+	// writeMu keeps a binary packet and its attachments together.
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	sockets map[string]*Socket // By namespace.
+
+	pong     chan struct{}
+	timedOut atomic.Bool
+
+	// binary is the binary packet whose attachments are being received.
+	binary      *packet
+	attachments [][]byte
+}
+
+func (s *session) serve() error {
+	open, _ := json.Marshal(map[string]any{
+		"sid":          s.sid,
+		"upgrades":     []string{},
+		"pingInterval": s.pingInterval().Milliseconds(),
+		"pingTimeout":  s.pingTimeout().Milliseconds(),
+		"maxPayload":   maxPayload,
+	})
+	if err := s.write(0x1, append([]byte("0"), open...)); err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.heartbeat(stop)
+
+	return s.conn.ReadLoop(nil, func(opcode byte, data []byte) error {
+		if opcode == 0x2 {
+			return s.attachment(data)
+		}
+		if len(data) == 0 {
+			return s.invalid("empty engine.io packet")
+		}
+		switch data[0] {
+		case '1': // close
+			s.conn.Close(1000, "")
+		case '2': // ping, from clients of older versions
+			return s.write(0x1, append([]byte("3"), data[1:]...))
+		case '3': // pong
+			select {
+			case s.pong <- struct{}{}:
+			default:
+			}
+		case '4': // message
+			p, err := parsePacket(string(data[1:]))
+			if err != nil {
+				return s.invalid(err.Error())
+			}
+			return s.received(p)
+		case '6': // noop
+		default:
+			return s.invalid(fmt.Sprintf("engine.io packet type %q", data[0]))
+		}
+		return nil
+	})
+}
+
+// heartbeat pings the client every pingInterval and closes the connection
+// when a pong does not come back within pingTimeout.
+func (s *session) heartbeat(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.pingInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		if s.write(0x1, []byte("2")) != nil {
+			return
+		}
+		timeout := time.NewTimer(s.pingTimeout())
+		select {
+		case <-s.pong:
+			timeout.Stop()
+		case <-timeout.C:
+			s.timedOut.Store(true)
+			s.conn.Logger().Warn("Socket.IO client did not answer the ping", "timeout", s.pingTimeout())
+			s.conn.Close(1000, "ping timeout")
+			return
+		case <-stop:
+			timeout.Stop()
+			return
+		}
+	}
+}
+
+// received handles a Socket.IO packet, once its attachments arrived for
+// binary ones.
+func (s *session) received(p *packet) error {
+	if p.attachments > 0 {
+		if s.binary != nil {
+			return s.invalid("binary packet before the attachments of the previous one")
+		}
+		s.binary, s.attachments = p, nil
+		return nil
+	}
+	return s.dispatch(p, nil)
+}
+
+func (s *session) attachment(data []byte) error {
+	if s.binary == nil {
+		return s.invalid("binary message without a binary packet")
+	}
+	s.attachments = append(s.attachments, data)
+	if len(s.attachments) < s.binary.attachments {
+		return nil
+	}
+	p, attachments := s.binary, s.attachments
+	s.binary, s.attachments = nil, nil
+	return s.dispatch(p, attachments)
+}
+
+func (s *session) dispatch(p *packet, attachments [][]byte) error {
+	switch p.kind {
+	case packetConnect:
+		return s.join(p)
+	case packetDisconnect:
+		if socket := s.socket(p.namespace); socket != nil {
+			s.leave(socket, "client namespace disconnect")
+		}
+		return nil
+	case packetEvent, packetBinaryEvent:
+		return s.event(p, attachments)
+	case packetAck, packetBinaryAck:
+		// Emit never asks for acknowledgements.
+		return nil
+	default:
+		return s.invalid(fmt.Sprintf("packet type %d from a client", p.kind))
+	}
+}
+
+// join connects the client to a namespace.
+func (s *session) join(p *packet) error {
+	ns := s.server.namespace(p.namespace)
+	if ns == nil {
+		return s.connectError(p.namespace, "Invalid namespace")
+	}
+	if s.socket(p.namespace) != nil {
+		return nil
+	}
+	socket := &Socket{id: s.conn.NewID(), namespace: ns, session: s}
+	if ns.OnConnect != nil {
+		auth := p.data
+		if auth == nil {
+			auth = json.RawMessage("null")
+		}
+		if err := ns.OnConnect(socket, auth); err != nil {
+			return s.connectError(p.namespace, err.Error())
+		}
+	}
+	s.mu.Lock()
+	s.sockets[p.namespace] = socket
+	s.mu.Unlock()
+	ns.mu.Lock()
+	ns.sockets[socket] = struct{}{}
+	ns.mu.Unlock()
+
+	data, _ := json.Marshal(map[string]string{"sid": socket.id})
+	return s.writePacket(&packet{kind: packetConnect, namespace: p.namespace, id: -1, data: data}, nil)
+}
+
+func (s *session) connectError(namespace, message string) error {
+	data, _ := json.Marshal(map[string]string{"message": message})
+	return s.writePacket(&packet{kind: packetConnectError, namespace: namespace, id: -1, data: data}, nil)
+}
+
+// event runs the handler of an event and sends the acknowledgement it asked
+// for. Events of namespaces the client did not join are ignored, as
+// socket.io does.
+func (s *session) event(p *packet, attachments [][]byte) error {
+	socket := s.socket(p.namespace)
+	if socket == nil {
+		return nil
+	}
+	var values []any
+	if err := json.Unmarshal(p.data, &values); err != nil || len(values) == 0 {
+		return s.invalid("event data is not an array")
+	}
+	event, ok := values[0].(string)
+	if !ok {
+		return s.invalid("event name is not a string")
+	}
+	args := values[1:]
+	for i, arg := range args {
+		arg, err := reconstruct(arg, attachments)
+		if err != nil {
+			return s.invalid(err.Error())
+		}
+		args[i] = arg
+	}
+
+	handler := socket.namespace.handler(event)
+	if handler == nil {
+		s.conn.Logger().Debug("No handler for Socket.IO event", "namespace", p.namespace, "event", event)
+		return nil
+	}
+	ack := handler(socket, args)
+	if p.id < 0 {
+		return nil
+	}
+	if ack == nil {
+		ack = []any{}
+	}
+	return s.send(p.namespace, packetAck, p.id, ack)
+}
+
+// send sends an event or acknowledgement, as its binary variant when the
+// values hold []byte.
+func (s *session) send(namespace string, kind, id int, values []any) error {
+	values, attachments := deconstruct(values)
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	p := &packet{kind: kind, namespace: namespace, id: id, data: data}
+	if len(attachments) > 0 {
+		p.kind += packetBinaryEvent - packetEvent
+		p.attachments = len(attachments)
+	}
+	return s.writePacket(p, attachments)
+}
+
+// writePacket sends p in an engine.io message packet, followed by its
+// attachments in binary WebSocket messages.
+func (s *session) writePacket(p *packet, attachments [][]byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.conn.WriteMessage(0x1, []byte("4"+p.encode())); err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		if err := s.conn.WriteMessage(0x2, attachment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *session) write(opcode byte, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(opcode, data)
+}
+
+func (s *session) socket(namespace string) *Socket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sockets[namespace]
+}
+
+// leave removes socket from its namespace and reports whether it was still
+// there.
+func (s *session) leave(socket *Socket, reason string) bool {
+	s.mu.Lock()
+	if s.sockets[socket.namespace.name] != socket {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.sockets, socket.namespace.name)
+	s.mu.Unlock()
+
+	ns := socket.namespace
+	ns.mu.Lock()
+	delete(ns.sockets, socket)
+	ns.mu.Unlock()
+	if ns.OnDisconnect != nil {
+		ns.OnDisconnect(socket, reason)
+	}
+	return true
+}
+
+func (s *session) leaveAll(reason string) {
+	s.mu.Lock()
+	sockets := make([]*Socket, 0, len(s.sockets))
+	for _, socket := range s.sockets {
+		sockets = append(sockets, socket)
+	}
+	s.mu.Unlock()
+	for _, socket := range sockets {
+		s.leave(socket, reason)
+	}
+}
+
+// invalid closes the connection after a packet engine.io or Socket.IO do
+// not allow, as the socket.io server does.
+func (s *session) invalid(reason string) error {
+	s.conn.Logger().Warn("Invalid Socket.IO packet, closing connection", "reason", reason)
+	s.conn.Close(1002, "invalid packet")
+	return nil
+}
+
+func (s *session) pingInterval() time.Duration {
+	if s.server.PingInterval <= 0 {
+		return defaultPingInterval
+	}
+	return s.server.PingInterval
+}
+
+func (s *session) pingTimeout() time.Duration {
+	if s.server.PingTimeout <= 0 {
+		return defaultPingTimeout
+	}
+	return s.server.PingTimeout
+}
@@ -0,0 +1,331 @@
+/**
+ * * Package sse streams the broadcasts of a websocket.Hub, and the messages of its rooms, as
+ * * Server-Sent Events, for clients behind proxies that refuse to upgrade connections. It is a
+ * * plain http.Handler, served next to the WebSocket server:
+ *
+ *	GET /events                 every hub broadcast, text as data, binary base64 in "binary" events
+ *	GET /events?room=news       the RoomMessages the members of news receive, as JSON
+ *
+ * * Every event carries an id. EventSource sends the last one it saw in Last-Event-ID when it
+ * * reconnects, and the stream resumes after it: hub broadcasts are replayed from the server's own
+ * * history, room messages from the history of the Rooms, with a "gap" event first when some of
+ * * them are gone. Like room sequence numbers, ids are only meaningful on one instance.
+ */
+package sse
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket"
+)
+
+const (
+	// defaultHistorySize is the number of hub broadcasts kept for clients
+	// resuming with Last-Event-ID, when Server.HistorySize is zero.
+	defaultHistorySize = 100
+
+	// defaultBufferSize is the number of events queued for a client that
+	// reads slower than they arrive, when Server.BufferSize is zero.
+	defaultBufferSize = 64
+
+	// defaultKeepAlive is how often idle streams get a comment, when
+	// Server.KeepAlive is zero. Proxies close responses that stay silent.
+	defaultKeepAlive = 15 * time.Second
+)
+
+// Server is an http.Handler streaming a hub and its rooms as Server-Sent
+// Events. Set its fields before the first request.
+type Server struct {
+	// Rooms, when set, serves the room streams (?room=). Private rooms are
+	// refused with 403, SSE clients being as anonymous as guests.
+	Rooms *websocket.Rooms
+
+	// HistorySize is the number of hub broadcasts kept for resuming clients,
+	// defaultHistorySize when zero, none when negative. Room streams resume
+	// from Rooms.HistorySize instead.
+	HistorySize int
+
+	// BufferSize bounds the events queued for a client on top of its replay,
+	// defaultBufferSize when zero. A client falling further behind is
+	// disconnected and catches up from the history when it reconnects.
+	BufferSize int
+
+	// KeepAlive is how often a comment is sent on idle streams,
+	// defaultKeepAlive when zero.
+	KeepAlive time.Duration
+
+	unsubscribe func()
+	done        chan struct{}
+	closeOnce   sync.Once
+
+	// mu orders broadcasts with the replays of connecting clients: a client
+	// gets everything up to seq from the history and the rest live.
+	mu      sync.Mutex
+	seq     uint64
+	history []event
+	streams map[*stream]bool
+}
+
+// NewServer returns a server streaming the broadcasts of hub, which it
+// subscribes to right away, and the rooms of rooms unless it is nil.
+func NewServer(hub *websocket.Hub, rooms *websocket.Rooms) (*Server, error) {
+	s := &Server{Rooms: rooms, done: make(chan struct{}), streams: make(map[*stream]bool)}
+	unsubscribe, err := hub.Subscribe(s.broadcast)
+	if err != nil {
+		return nil, err
+	}
+	s.unsubscribe = unsubscribe
+	return s, nil
+}
+
+// Close unsubscribes from the hub and ends every stream, so that
+// http.Server.Shutdown does not wait for them.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		s.unsubscribe()
+		close(s.done)
+	})
+}
+
+// event is one Server-Sent Event.
+type event struct {
+	id   string
+	name string // Empty for the default "message" event.
+	data []byte
+}
+
+// broadcast numbers a hub broadcast, keeps it and queues it for every hub
+// stream.
+func (s *Server) broadcast(traceID string, opcode byte, payload []byte) {
+	e := event{data: payload}
+	if opcode == 0x2 {
+		e.name, e.data = "binary", []byte(base64.StdEncoding.EncodeToString(payload))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	e.id = strconv.FormatUint(s.seq, 10)
+	if size := s.historySize(); size > 0 {
+		if len(s.history) >= size {
+			s.history = append(s.history[:0], s.history[len(s.history)-size+1:]...)
+		}
+		s.history = append(s.history, e)
+	}
+	for st := range s.streams {
+		st.push(e)
+	}
+}
+
+// since returns the kept broadcasts after seq. The caller must hold s.mu.
+func (s *Server) since(seq uint64) []event {
+	var events []event
+	for _, e := range s.history {
+		if id, _ := strconv.ParseUint(e.id, 10, 64); id > seq {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	room := r.URL.Query().Get("room")
+	if room != "" && s.Rooms == nil {
+		http.Error(w, "rooms are not served", http.StatusNotFound)
+		return
+	}
+	if room != "" && s.Rooms.Private != nil && s.Rooms.Private(room) {
+		http.Error(w, "private room", http.StatusForbidden)
+		return
+	}
+	var last *uint64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		seq, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		last = &seq
+	}
+
+	log := slog.With("remote_addr", r.RemoteAddr, "room", room)
+	st := &stream{wake: make(chan struct{}, 1)}
+	if room == "" {
+		defer s.follow(st, last)()
+	} else {
+		defer s.followRoom(st, room, last)()
+	}
+
+	// X-Accel-Buffering keeps nginx from holding the events back.
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	log.Info("SSE client connected", "last_event_id", r.Header.Get("Last-Event-ID"))
+
+	keepAlive := s.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = defaultKeepAlive
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-st.wake:
+			events, dropped := st.take()
+			if dropped {
+				log.Warn("SSE client too slow, disconnecting")
+				return
+			}
+			for _, e := range events {
+				if err := e.write(w); err != nil {
+					log.Info("SSE client disconnected", "err", err)
+					return
+				}
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				log.Info("SSE client disconnected", "err", err)
+				return
+			}
+		case <-r.Context().Done():
+			log.Info("SSE client disconnected")
+			return
+		case <-s.done:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// follow adds st to the hub streams, queueing the kept broadcasts after last
+// first, and returns the function removing it.
+func (s *Server) follow(st *stream, last *uint64) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.limit = s.bufferSize() + max(s.historySize(), 0)
+	if last != nil {
+		for _, e := range s.since(*last) {
+			st.push(e)
+		}
+	}
+	s.streams[st] = true
+	return func() {
+		s.mu.Lock()
+		delete(s.streams, st)
+		s.mu.Unlock()
+	}
+}
+
+// followRoom subscribes st to room, from the room's history after last when
+// it is set, and returns the function unsubscribing it.
+func (s *Server) followRoom(st *stream, room string, last *uint64) func() {
+	st.limit = s.bufferSize() + max(s.Rooms.HistorySize, 0)
+	push := func(msg websocket.RoomMessage) {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return
+		}
+		e := event{name: msg.Type, data: data}
+		if msg.Type == "message" {
+			e.name = ""
+			if msg.Seq > 0 {
+				e.id = strconv.FormatUint(msg.Seq, 10)
+			}
+		}
+		st.push(e)
+	}
+	if last != nil {
+		return s.Rooms.SubscribeFrom(room, *last, push)
+	}
+	return s.Rooms.Subscribe(room, push)
+}
+
+func (s *Server) historySize() int {
+	if s.HistorySize == 0 {
+		return defaultHistorySize
+	}
+	return s.HistorySize
+}
+
+func (s *Server) bufferSize() int {
+	if s.BufferSize <= 0 {
+		return defaultBufferSize
+	}
+	return s.BufferSize
+}
+
+// stream is the queue of events of one client. Events are pushed by the
+// hub or the rooms and written to the response by ServeHTTP.
+type stream struct {
+	wake chan struct{}
+
+	mu      sync.Mutex
+	limit   int
+	pending []event
+	dropped bool
+}
+
+// push queues e, or drops the client once limit events are pending.
+func (st *stream) push(e event) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.dropped {
+		return
+	}
+	if len(st.pending) >= st.limit {
+		st.dropped, st.pending = true, nil
+	} else {
+		st.pending = append(st.pending, e)
+	}
+	select {
+	case st.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the pending events and whether the client was dropped.
+func (st *stream) take() ([]event, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	events := st.pending
+	st.pending = nil
+	return events, st.dropped
+}
+
+// write writes e in the text/event-stream format, one data line per line of
+// its data.
+func (e event) write(w http.ResponseWriter) error {
+	var b strings.Builder
+	if e.id != "" {
+		b.WriteString("id: " + e.id + "\n")
+	}
+	if e.name != "" {
+		b.WriteString("event: " + e.name + "\n")
+	}
+	for _, line := range strings.Split(string(e.data), "\n") {
+		b.WriteString("data: " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	b.WriteString("\n")
+	_, err := fmt.Fprint(w, b.String())
+	return err
+}
@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"sync"
+
+	"websocket/metrics"
+)
+
+// Bucket bounds of the frame size and fragment count histograms: frames from
+// 16 bytes to 16MB (defaultMaxFrameSize) in steps of 4, messages of 1 to 64 frames.
+var (
+	frameSizeBuckets = metrics.ExponentialBuckets(16, 4, 11)
+	fragmentBuckets  = metrics.ExponentialBuckets(1, 2, 7)
+)
+
+var (
+	frameSizes = metrics.Default.RegisterHistogram(metrics.NewHistogram(
+		"websocket_frame_size_bytes", "Payload sizes of frames read from clients.", frameSizeBuckets...))
+
+	messageFragments = metrics.Default.RegisterHistogram(metrics.NewHistogram(
+		"websocket_message_fragments", "Frames per message read from clients.", fragmentBuckets...))
+
+	messagesRead = metrics.Default.Register(metrics.NewCounterVec(
+		"websocket_messages_read_total", "Messages read from clients, by type (text or binary).", 2, "type"))
+)
+
+// ConnStats describes the traffic of one connection, to help tune buffer
+// sizes, fragment thresholds and compression. The histograms and message
+// counts are of the frames read.
+type ConnStats struct {
+	FramesRead         uint64
+	BytesRead          uint64 // Frame payload bytes, headers excluded.
+	FramesWritten      uint64
+	BytesWritten       uint64
+	TextMessages       uint64
+	BinaryMessages     uint64
+	FragmentedMessages uint64 // Messages made of more than one frame.
+
+	FrameSizes metrics.HistogramSnapshot
+	Fragments  metrics.HistogramSnapshot
+}
+
+// connStats collects ConnStats for a Conn. Its zero value is ready to use.
+type connStats struct {
+	mu         sync.Mutex
+	stats      ConnStats
+	frameSizes *metrics.Histogram
+	fragments  *metrics.Histogram
+	pending    uint64 // Frames of the message being read so far.
+}
+
+// observe records a valid frame read from the connection, both for the
+// connection and in the server wide metrics.
+func (s *connStats) observe(frame *Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.frameSizes == nil {
+		s.frameSizes = metrics.NewHistogram("", "", frameSizeBuckets...)
+		s.fragments = metrics.NewHistogram("", "", fragmentBuckets...)
+	}
+
+	size := float64(len(frame.Payload))
+	s.stats.FramesRead++
+	s.stats.BytesRead += uint64(len(frame.Payload))
+	s.frameSizes.Observe(size)
+	frameSizes.Observe(size)
+
+	switch frame.Opcode {
+	case 0x1:
+		s.stats.TextMessages++
+		messagesRead.Inc("text")
+	case 0x2:
+		s.stats.BinaryMessages++
+		messagesRead.Inc("binary")
+	case 0x0:
+	default:
+		return // Control frames are not part of a message.
+	}
+
+	s.pending++
+	if !frame.Fin {
+		return
+	}
+	if s.pending > 1 {
+		s.stats.FragmentedMessages++
+	}
+	s.fragments.Observe(float64(s.pending))
+	messageFragments.Observe(float64(s.pending))
+	s.pending = 0
+}
+
+// observeWrite records a frame written to the connection.
+func (s *connStats) observeWrite(payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.FramesWritten++
+	s.stats.BytesWritten += uint64(len(payload))
+}
+
+// Stats returns the traffic statistics of the connection so far.
+func (c *Conn) Stats() ConnStats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	stats := c.stats.stats
+	if c.stats.frameSizes != nil {
+		stats.FrameSizes = c.stats.frameSizes.Snapshot()
+		stats.Fragments = c.stats.fragments.Snapshot()
+	}
+	return stats
+}
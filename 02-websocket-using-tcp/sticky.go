@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSessionTTL is how long a sticky session outlives its
+	// connection when Server.SessionTTL is zero.
+	defaultSessionTTL = 2 * time.Minute
+
+	// SessionHeader carries the session token in both directions, for
+	// clients that can set headers. Browsers get it as the SessionCookie.
+	SessionHeader = "X-Session-Token"
+	SessionCookie = "ws_session"
+)
+
+// sessionCookie returns the cookie carrying token, only sent back over TLS
+// when it was set over TLS.
+func sessionCookie(token string, secure bool) *http.Cookie {
+	return &http.Cookie{Name: SessionCookie, Value: token, Path: "/", HttpOnly: true, Secure: secure, SameSite: http.SameSiteStrictMode}
+}
+
+/**
+ * * With Server.SessionKey, sessions are sticky: the handshake response carries a session token,
+ * * the session ID signed with the key, in SessionHeader and in a SessionCookie browsers send back
+ * * on their own. A client reconnecting with the token within SessionTTL gets its Session again,
+ * * values and principal included, instead of a new one: a guest keeps its ID and an in-band
+ * * authentication carries over. A client that authenticated during the handshake only resumes
+ * * sessions of the same principal. When the previous connection is still open, a reconnecting
+ * * client noticed its drop before the server did, and it is closed.
+ *
+ * * The token is a bearer credential. Without credentials, or with guest ones, presenting it is
+ * * enough to come back as the principal of the session, until SessionTTL after its last
+ * * connection ended: it has to be kept like a password, and only sent over TLS. Over TLS the
+ * * cookie is Secure.
+ *
+ * * Sessions are kept in the memory of the server, a token is only good on the instance that
+ * * issued it, and not after a restart.
+ */
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// sessionToken returns the token of the session called id.
+func (s *Server) sessionToken(id string) string {
+	mac := hmac.New(sha256.New, s.SessionKey)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// requestSession returns the ID of the session whose token the client sent,
+// false when it sent none or one that was not signed with the key.
+func (s *Server) requestSession(request *http.Request) (string, bool) {
+	token := request.Header.Get(SessionHeader)
+	if cookie, err := request.Cookie(SessionCookie); token == "" && err == nil {
+		token = cookie.Value
+	}
+	id, _, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(token), []byte(s.sessionToken(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+// resumeSession returns the session the client of request comes back to,
+// nil when there is none to resume. principal is the one the handshake
+// authenticated, nil without an authenticator.
+func (s *Server) resumeSession(request *http.Request, principal *Principal, log *slog.Logger) *Session {
+	id, ok := s.requestSession(request)
+	if !ok {
+		return nil
+	}
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	session := s.sessions.sessions[id]
+	if session == nil {
+		log.Info("Session expired, starting a new one", "session_id", id)
+		return nil
+	}
+	if previous, ok := session.Principal(); principal != nil && !principal.Guest && (!ok || previous.ID != principal.ID) {
+		log.Warn("Session of another principal, starting a new one", "session_id", id)
+		return nil
+	}
+	if session.expiry != nil {
+		session.expiry.Stop()
+		session.expiry = nil
+	}
+	return session
+}
+
+// attachSession makes session the session of c, closing the connection it
+// had when it is still open, and keeps it for the connections to come.
+func (s *Server) attachSession(c *Conn, session *Session) {
+	s.sessions.mu.Lock()
+	if s.sessions.sessions == nil {
+		s.sessions.sessions = make(map[string]*Session)
+	}
+	s.sessions.sessions[session.id] = session
+	s.sessions.mu.Unlock()
+
+	previous := session.attach(c)
+	if previous == nil || previous == c {
+		return
+	}
+	c.Logger().Info("Session resumed", "previous_conn_id", previous.ID())
+	if previous.Context().Err() == nil {
+		go previous.Close(closeNormal, "session resumed by another connection")
+	}
+}
+
+// detachSession keeps the session of c, once c ended, for SessionTTL, unless
+// another connection resumed it meanwhile, and ends it then.
+func (s *Server) detachSession(c *Conn) {
+	session := c.Session()
+	ttl := s.SessionTTL
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	if session.current() != c {
+		return
+	}
+	var expiry *time.Timer
+	expiry = time.AfterFunc(ttl, func() {
+		s.sessions.mu.Lock()
+		if session.expiry != expiry {
+			s.sessions.mu.Unlock()
+			return
+		}
+		delete(s.sessions.sessions, session.id)
+		s.sessions.mu.Unlock()
+		session.end()
+	})
+	session.expiry = expiry
+}
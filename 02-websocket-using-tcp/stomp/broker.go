@@ -0,0 +1,455 @@
+/**
+ * * Package stomp runs a small STOMP 1.2 message broker over WebSocket, enough for stomp.js clients
+ * * and the Spring-style front-ends built on it: CONNECT, SUBSCRIBE and UNSUBSCRIBE, SEND, ACK and
+ * * NACK, BEGIN, COMMIT and ABORT, receipts and DISCONNECT. Versions 1.0 and 1.1 are negotiated
+ * * for older clients. Add Subprotocols to websocket.Server.Subprotocols and run Broker.Handle:
+ *
+ *	client: CONNECT accept-version:1.2 host:example.com
+ *	broker: CONNECTED version:1.2 heart-beat:0,0
+ *	client: SUBSCRIBE id:sub-0 destination:/topic/news ack:client-individual
+ *	client: SEND destination:/topic/news receipt:r-1  "hello"
+ *	broker: RECEIPT receipt-id:r-1
+ *	broker: MESSAGE subscription:sub-0 message-id:01J... ack:01J... destination:/topic/news  "hello"
+ *	client: ACK id:01J...
+ *
+ * * Destinations are topics: every subscription gets a copy of every message sent to it, across
+ * * the instances sharing a broker.Broker. Messages are not stored, a NACK or a message left
+ * * unacknowledged is dropped rather than redelivered.
+ */
+package stomp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"websocket"
+	"websocket/broker"
+)
+
+// Subprotocols are the names stomp.js offers in Sec-WebSocket-Protocol, one
+// per STOMP version.
+var Subprotocols = []string{"v12.stomp", "v11.stomp", "v10.stomp"}
+
+// defaultMaxFrameSize is the largest frame accepted when
+// Broker.MaxFrameSize is zero.
+const defaultMaxFrameSize = 1 << 20
+
+// topicPrefix namespaces the broker topics of destinations.
+const topicPrefix = "stomp:"
+
+// versions are the STOMP versions spoken, preferred first.
+var versions = []string{"1.2", "1.1", "1.0"}
+
+// Broker routes the frames of STOMP connections, create it with NewBroker.
+type Broker struct {
+	// Authenticate, when set, checks the login and passcode headers of
+	// CONNECT. An error is sent to the client in an ERROR frame.
+	Authenticate func(login, passcode string) error
+
+	// MaxFrameSize is the largest frame accepted, headers and body. Zero
+	// means 1MB.
+	MaxFrameSize int
+
+	broker broker.Broker
+}
+
+// NewBroker returns a broker delivering the messages of destinations
+// through b, an in-memory broker when nil.
+func NewBroker(b broker.Broker) *Broker {
+	if b == nil {
+		b = broker.NewMemory()
+	}
+	return &Broker{broker: b}
+}
+
+// Handle serves a connection, it is a websocket.Handler.
+func (b *Broker) Handle(conn *websocket.Conn) {
+	s := &session{
+		broker:        b,
+		conn:          conn,
+		subscriptions: make(map[string]*subscription),
+		transactions:  make(map[string][]*Frame),
+	}
+	defer s.unsubscribeAll()
+
+	if err := s.serve(); errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		conn.Logger().Info("STOMP client disconnected")
+	} else {
+		conn.Logger().Warn("Error reading WebSocket message", "err", err)
+	}
+}
+
+func (b *Broker) maxFrameSize() int {
+	if b.MaxFrameSize <= 0 {
+		return defaultMaxFrameSize
+	}
+	return b.MaxFrameSize
+}
+
+// session is the state of one connection. Only the goroutine reading the
+// connection uses it, but for pending, which deliveries add to.
+type session struct {
+	broker *Broker
+	conn   *websocket.Conn
+
+	version       string // Negotiated by CONNECT, "" before.
+	subscriptions map[string]*subscription
+	transactions  map[string][]*Frame
+
+	mu      sync.Mutex
+	pending []pendingAck // Messages delivered and not acknowledged yet, in order.
+}
+
+// subscription is a SUBSCRIBE of the session.
+type subscription struct {
+	id          string
+	destination string
+	ack         string // auto, client or client-individual.
+	unsubscribe func()
+}
+
+type pendingAck struct {
+	id           string
+	subscription *subscription
+}
+
+// errorFrame is a failure reported to the client in an ERROR frame, after
+// which the connection is closed.
+type errorFrame struct {
+	message string
+	detail  string
+	headers map[string]string
+}
+
+func (e *errorFrame) Error() string {
+	return "stomp: " + e.message
+}
+
+func protocolError(message, detail string) error {
+	return &errorFrame{message: message, detail: detail}
+}
+
+// errDisconnected ends the session after DISCONNECT.
+var errDisconnected = errors.New("stomp: disconnected")
+
+func (s *session) serve() error {
+	var buffered []byte
+	return s.conn.ReadLoop(nil, func(opcode byte, data []byte) error {
+		buffered = append(buffered, data...)
+		for {
+			frame, rest, err := parseFrame(buffered, s.version != "1.0")
+			if err == nil && frame == nil && len(rest) > s.broker.maxFrameSize() {
+				err = protocolError("frame too large", fmt.Sprintf("frames are limited to %d bytes", s.broker.maxFrameSize()))
+			}
+			if err == nil && frame != nil {
+				err = s.handle(frame)
+			}
+			if errors.Is(err, errDisconnected) {
+				return nil
+			}
+			if err != nil {
+				s.fail(frame, err)
+				return nil
+			}
+			buffered = rest
+			if frame == nil {
+				return nil
+			}
+		}
+	})
+}
+
+// fail sends the ERROR frame for err and closes the connection, which the
+// STOMP specification requires after an ERROR.
+func (s *session) fail(frame *Frame, err error) {
+	var stompErr *errorFrame
+	if !errors.As(err, &stompErr) {
+		stompErr = &errorFrame{message: "malformed frame", detail: err.Error()}
+	}
+	s.conn.Logger().Warn("STOMP error", "message", stompErr.message, "detail", stompErr.detail)
+	reply := &Frame{Command: "ERROR", Headers: map[string]string{"message": stompErr.message}}
+	for name, value := range stompErr.headers {
+		reply.Headers[name] = value
+	}
+	if frame != nil && frame.Header("receipt") != "" {
+		reply.Headers["receipt-id"] = frame.Header("receipt")
+	}
+	if stompErr.detail != "" {
+		reply.Headers["content-type"] = "text/plain"
+		reply.Body = []byte(stompErr.detail)
+	}
+	s.write(reply)
+	s.conn.Close(1000, "")
+}
+
+func (s *session) handle(frame *Frame) error {
+	if s.version == "" && frame.Command != "CONNECT" && frame.Command != "STOMP" {
+		return protocolError("not connected", "the first frame must be CONNECT, got "+frame.Command)
+	}
+	var err error
+	switch frame.Command {
+	case "CONNECT", "STOMP":
+		return s.connect(frame)
+	case "SEND":
+		err = s.inTransaction(frame, s.send)
+	case "SUBSCRIBE":
+		err = s.subscribe(frame)
+	case "UNSUBSCRIBE":
+		err = s.unsubscribe(frame)
+	case "ACK", "NACK":
+		err = s.inTransaction(frame, s.ack)
+	case "BEGIN", "COMMIT", "ABORT":
+		err = s.transaction(frame)
+	case "DISCONNECT":
+		s.receipt(frame)
+		s.conn.Close(1000, "")
+		return errDisconnected
+	default:
+		return protocolError("unknown command", "unknown command "+frame.Command)
+	}
+	if err != nil {
+		return err
+	}
+	s.receipt(frame)
+	return nil
+}
+
+// connect negotiates the version and authenticates the client.
+func (s *session) connect(frame *Frame) error {
+	if s.version != "" {
+		return protocolError("already connected", "CONNECT was sent twice")
+	}
+	accepted := []string{"1.0"}
+	if header := frame.Header("accept-version"); header != "" {
+		accepted = strings.Split(header, ",")
+	}
+	for _, version := range versions {
+		if slices.Contains(accepted, version) {
+			s.version = version
+			break
+		}
+	}
+	if s.version == "" {
+		return &errorFrame{
+			message: "unsupported protocol version",
+			detail:  "supported versions are " + strings.Join(versions, ", "),
+			headers: map[string]string{"version": strings.Join(versions, ",")},
+		}
+	}
+	if s.broker.Authenticate != nil {
+		if err := s.broker.Authenticate(frame.Header("login"), frame.Header("passcode")); err != nil {
+			s.version = ""
+			return protocolError("authentication failed", err.Error())
+		}
+	}
+	// The broker sends no heart-beats and expects none, WebSocket pings
+	// keep the connection alive.
+	return s.write(&Frame{Command: "CONNECTED", Headers: map[string]string{
+		"version":    s.version,
+		"heart-beat": "0,0",
+		"server":     "socket-101",
+		"session":    s.conn.ID(),
+	}})
+}
+
+// inTransaction runs handle on frame, or holds it back until COMMIT when it
+// names a transaction.
+func (s *session) inTransaction(frame *Frame, handle func(*Frame) error) error {
+	name := frame.Header("transaction")
+	if name == "" {
+		return handle(frame)
+	}
+	held, ok := s.transactions[name]
+	if !ok {
+		return protocolError("unknown transaction", "transaction "+name+" was not begun")
+	}
+	s.transactions[name] = append(held, frame)
+	return nil
+}
+
+func (s *session) transaction(frame *Frame) error {
+	name := frame.Header("transaction")
+	if name == "" {
+		return protocolError("missing transaction header", frame.Command+" needs a transaction header")
+	}
+	held, ok := s.transactions[name]
+	switch {
+	case frame.Command == "BEGIN" && ok:
+		return protocolError("transaction already begun", "transaction "+name+" was begun twice")
+	case frame.Command == "BEGIN":
+		s.transactions[name] = nil
+		return nil
+	case !ok:
+		return protocolError("unknown transaction", "transaction "+name+" was not begun")
+	}
+	delete(s.transactions, name)
+	if frame.Command == "ABORT" {
+		return nil
+	}
+	for _, held := range held {
+		var err error
+		if held.Command == "SEND" {
+			err = s.send(held)
+		} else {
+			err = s.ack(held)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send publishes the frame to the subscribers of its destination, as it
+// arrived: the subscribers turn it into a MESSAGE.
+func (s *session) send(frame *Frame) error {
+	destination := frame.Header("destination")
+	if destination == "" {
+		return protocolError("missing destination header", "SEND needs a destination header")
+	}
+	message := &Frame{Command: "MESSAGE", Headers: make(map[string]string, len(frame.Headers)+2), Body: frame.Body}
+	for name, value := range frame.Headers {
+		switch name {
+		case "receipt", "transaction", "content-length":
+		default:
+			message.Headers[name] = value
+		}
+	}
+	message.Headers["message-id"] = s.conn.NewID()
+	if err := s.broker.broker.Publish(topicPrefix+destination, message.encode(true)); err != nil {
+		return protocolError("message not delivered", err.Error())
+	}
+	return nil
+}
+
+func (s *session) subscribe(frame *Frame) error {
+	destination := frame.Header("destination")
+	if destination == "" {
+		return protocolError("missing destination header", "SUBSCRIBE needs a destination header")
+	}
+	id := frame.Header("id")
+	if id == "" {
+		if s.version != "1.0" {
+			return protocolError("missing id header", "SUBSCRIBE needs an id header")
+		}
+		id = destination
+	}
+	if _, ok := s.subscriptions[id]; ok {
+		return protocolError("duplicate subscription", "subscription "+id+" exists already")
+	}
+	sub := &subscription{id: id, destination: destination, ack: frame.Header("ack")}
+	switch sub.ack {
+	case "":
+		sub.ack = "auto"
+	case "auto", "client", "client-individual":
+	default:
+		return protocolError("invalid ack header", "ack must be auto, client or client-individual, got "+sub.ack)
+	}
+
+	unsubscribe, err := s.broker.broker.Subscribe(topicPrefix+destination, func(payload []byte) {
+		s.deliver(sub, payload)
+	})
+	if err != nil {
+		return protocolError("subscription failed", err.Error())
+	}
+	sub.unsubscribe = unsubscribe
+	s.subscriptions[id] = sub
+	return nil
+}
+
+// deliver queues a message published to the destination of sub. It runs on
+// the publisher's goroutine, so the message is queued rather than written.
+func (s *session) deliver(sub *subscription, payload []byte) {
+	message, _, err := parseFrame(payload, true)
+	if err != nil || message == nil {
+		s.conn.Logger().Warn("Error decoding STOMP message from broker", "err", err)
+		return
+	}
+	message.Headers["subscription"] = sub.id
+	if sub.ack != "auto" {
+		// Before 1.2 clients acknowledge with the message-id.
+		ackID := message.Header("message-id")
+		if s.version == "1.2" {
+			ackID = s.conn.NewID()
+			message.Headers["ack"] = ackID
+		}
+		s.mu.Lock()
+		s.pending = append(s.pending, pendingAck{id: ackID, subscription: sub})
+		s.mu.Unlock()
+	}
+	data := message.encode(s.version != "1.0")
+	opcode := byte(0x1)
+	if !utf8.Valid(data) {
+		opcode = 0x2
+	}
+	s.conn.Enqueue(opcode, data)
+}
+
+func (s *session) unsubscribe(frame *Frame) error {
+	id := frame.Header("id")
+	if id == "" && s.version == "1.0" {
+		id = frame.Header("destination")
+	}
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return protocolError("unknown subscription", "subscription "+id+" does not exist")
+	}
+	delete(s.subscriptions, id)
+	sub.unsubscribe()
+	return nil
+}
+
+func (s *session) unsubscribeAll() {
+	for _, sub := range s.subscriptions {
+		sub.unsubscribe()
+	}
+}
+
+/**
+ * * ack acknowledges a message with the id of its ack header, the message-id in versions before
+ * * 1.2. For subscriptions with ack:client it acknowledges the messages of the subscription
+ * * received before as well. NACK is handled the same, the messages are not redelivered.
+ */
+func (s *session) ack(frame *Frame) error {
+	id := frame.Header("id")
+	if s.version != "1.2" {
+		id = frame.Header("message-id")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.pending, func(p pendingAck) bool { return p.id == id })
+	if i < 0 {
+		return protocolError("unknown message", frame.Command+" for a message that awaits no acknowledgement: "+id)
+	}
+	sub := s.pending[i].subscription
+	if frame.Command == "NACK" {
+		s.conn.Logger().Info("STOMP message rejected, dropped", "subscription", sub.id)
+	}
+	if sub.ack == "client-individual" {
+		s.pending = slices.Delete(s.pending, i, i+1)
+		return nil
+	}
+	acked := s.pending[:i+1]
+	s.pending = append(slices.DeleteFunc(slices.Clone(acked), func(p pendingAck) bool {
+		return p.subscription == sub
+	}), s.pending[i+1:]...)
+	return nil
+}
+
+// receipt answers the receipt header of a frame that was handled.
+func (s *session) receipt(frame *Frame) {
+	if id := frame.Header("receipt"); id != "" {
+		s.write(&Frame{Command: "RECEIPT", Headers: map[string]string{"receipt-id": id}})
+	}
+}
+
+// write sends a frame answering the client, from the reading goroutine.
+func (s *session) write(frame *Frame) error {
+	return s.conn.WriteMessage(0x1, frame.encode(s.version != "1.0"))
+}
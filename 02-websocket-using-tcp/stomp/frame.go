@@ -0,0 +1,156 @@
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrFrame is returned for bytes that are not a STOMP frame.
+var ErrFrame = errors.New("stomp: malformed frame")
+
+/**
+ * * Frame is a STOMP frame: a command line, header lines and, after an empty line, the body ended by
+ * * a NUL byte. A content-length header lets the body hold NUL bytes itself.
+ *
+ *	SEND
+ *	destination:/topic/news
+ *	content-type:text/plain
+ *
+ *	hello^@
+ */
+type Frame struct {
+	Command string
+	Headers map[string]string
+	Body    []byte
+}
+
+// Header returns the value of the header name, "" when it is missing.
+func (f *Frame) Header(name string) string {
+	return f.Headers[name]
+}
+
+// headerEscaper and headerUnescaper apply the escaping of STOMP 1.2 to
+// header names and values of every frame but CONNECT and CONNECTED.
+var (
+	headerEscaper   = strings.NewReplacer(`\`, `\\`, "\r", `\r`, "\n", `\n`, ":", `\c`)
+	headerUnescaper = strings.NewReplacer(`\\`, `\`, `\r`, "\r", `\n`, "\n", `\c`, ":")
+)
+
+// escapes reports whether the headers of frames with command are escaped.
+func escapes(command string) bool {
+	return command != "CONNECT" && command != "CONNECTED" && command != "STOMP"
+}
+
+/**
+ * * parseFrame parses the frame at the start of data and returns it with the bytes after it. It
+ * * returns a nil frame when data holds no complete frame yet, the rest of it arriving with the
+ * * next WebSocket message. The end-of-line bytes clients send as heart-beats, alone or between
+ * * frames, are skipped. escape is false for STOMP 1.0, whose headers are taken verbatim.
+ */
+func parseFrame(data []byte, escape bool) (*Frame, []byte, error) {
+	data = bytes.TrimLeft(data, "\r\n")
+	headEnd, bodyStart := headerEnd(data)
+	if headEnd < 0 {
+		return nil, data, nil
+	}
+	head, body := data[:headEnd], data[bodyStart:]
+
+	lines := strings.Split(string(head), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	frame := &Frame{Command: lines[0], Headers: make(map[string]string, len(lines)-1)}
+	if frame.Command == "" {
+		return nil, nil, fmt.Errorf("%w: missing command", ErrFrame)
+	}
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: header line %q", ErrFrame, line)
+		}
+		if escape && escapes(frame.Command) {
+			name, value = headerUnescaper.Replace(name), headerUnescaper.Replace(value)
+		}
+		// A repeated header keeps its first value.
+		if _, ok := frame.Headers[name]; !ok {
+			frame.Headers[name] = value
+		}
+	}
+
+	if length, ok := frame.Headers["content-length"]; ok {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("%w: content-length %q", ErrFrame, length)
+		}
+		if len(body) < n+1 {
+			return nil, data, nil
+		}
+		if body[n] != 0 {
+			return nil, nil, fmt.Errorf("%w: body longer than content-length %d", ErrFrame, n)
+		}
+		frame.Body = body[:n:n]
+		return frame, body[n+1:], nil
+	}
+	end := bytes.IndexByte(body, 0)
+	if end < 0 {
+		return nil, data, nil
+	}
+	frame.Body = body[:end:end]
+	return frame, body[end+1:], nil
+}
+
+// headerEnd returns where the headers of data end and the body starts, at
+// the first empty line, or -1 when the empty line has not arrived yet.
+func headerEnd(data []byte) (int, int) {
+	for i := 0; i < len(data); i++ {
+		if data[i] != '\n' {
+			continue
+		}
+		rest := data[i+1:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("\n")):
+			return i, i + 2
+		case bytes.HasPrefix(rest, []byte("\r\n")):
+			return i, i + 3
+		}
+	}
+	return -1, -1
+}
+
+// encode returns the frame on the wire, with a content-length header for
+// the body. Headers are written in name order.
+func (f *Frame) encode(escape bool) []byte {
+	var b bytes.Buffer
+	b.WriteString(f.Command)
+	b.WriteByte('\n')
+	names := make([]string, 0, len(f.Headers))
+	for name := range f.Headers {
+		if name != "content-length" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		value := f.Headers[name]
+		if escape && escapes(f.Command) {
+			name, value = headerEscaper.Replace(name), headerEscaper.Replace(value)
+		}
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+	if len(f.Body) > 0 {
+		b.WriteString("content-length:")
+		b.WriteString(strconv.Itoa(len(f.Body)))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.Write(f.Body)
+	b.WriteByte(0)
+	return b.Bytes()
+}
@@ -0,0 +1,9 @@
+package websocket
+
+import "websocket/id"
+
+// NewTraceID returns an identifier used to follow one inbound message
+// through the logs of every delivery it causes.
+func NewTraceID() string {
+	return id.Default.New()
+}
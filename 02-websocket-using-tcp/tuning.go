@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"net"
+	"time"
+)
+
+/**
+ * * TCPOptions tunes the TCP socket of a connection, see Server.TCP and Dialer.TCP. The zero value
+ * * keeps Go's defaults: TCP_NODELAY set, keep-alive probes every 15 seconds and the buffer sizes
+ * * the kernel picks, which suit chat-like traffic of small messages.
+ *
+ * * LowLatency and HighThroughput are starting points for the two ends of the trade-off.
+ */
+type TCPOptions struct {
+	// Nagle turns Nagle's algorithm back on (TCP_NODELAY off): small writes
+	// are held back until the previous ones are acknowledged and sent
+	// together, fewer packets at the cost of up to a round trip of delay.
+	Nagle bool
+
+	// KeepAlive is the interval of TCP keep-alive probes, which detect
+	// peers that vanished without closing. Zero keeps the default, negative
+	// turns them off.
+	KeepAlive time.Duration
+
+	// ReadBuffer and WriteBuffer set SO_RCVBUF and SO_SNDBUF in bytes, zero
+	// leaves them to the kernel. Larger buffers keep more data in flight on
+	// links with a high bandwidth-delay product, and cost memory per
+	// connection.
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+var (
+	// LowLatency sends every frame right away and probes idle peers often,
+	// for interactive traffic such as chats, games or trading.
+	LowLatency = TCPOptions{KeepAlive: 10 * time.Second}
+
+	// HighThroughput coalesces small writes and uses 4MB buffers, for bulk
+	// transfers such as file uploads or replication streams.
+	HighThroughput = TCPOptions{Nagle: true, KeepAlive: time.Minute, ReadBuffer: 4 << 20, WriteBuffer: 4 << 20}
+)
+
+// apply sets the options on conn, unwrapping TLS and the PROXY protocol.
+// Connections that are not TCP, such as one end of a net.Pipe, are left
+// alone.
+func (o TCPOptions) apply(conn net.Conn) error {
+	for {
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapper.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if o.Nagle {
+		if err := tcpConn.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	switch {
+	case o.KeepAlive > 0:
+		if err := tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{Enable: true, Idle: o.KeepAlive, Interval: o.KeepAlive}); err != nil {
+			return err
+		}
+	case o.KeepAlive < 0:
+		if err := tcpConn.SetKeepAlive(false); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
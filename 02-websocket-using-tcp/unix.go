@@ -0,0 +1,61 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// unixPrefix marks a listen address as the path of a unix domain socket.
+const unixPrefix = "unix:"
+
+// UnixPath returns the socket path of a unix:/path address and whether addr
+// is one.
+func UnixPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, unixPrefix)
+}
+
+/**
+ * * Listen listens on addr, a TCP address such as ":4443" or the path of a unix domain socket
+ * * written unix:/run/ws.sock. A reverse proxy on the same host, nginx terminating TLS for example,
+ * * can then forward to the socket without going through the TCP stack, and file permissions
+ * * decide who may connect.
+ *
+ * * A socket file left behind by a server that did not shut down is removed first. A socket
+ * * another server is still accepting on is not, Listen fails with EADDRINUSE as it would for a
+ * * TCP port in use. The file is removed again when the listener is closed.
+ */
+func Listen(addr string) (net.Listener, error) {
+	path, ok := UnixPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("websocket: %q has no socket path", addr)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// removeStaleSocket removes the socket at path when nothing accepts on it.
+// Files that are not sockets are left for net.Listen to fail on.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return &net.OpError{Op: "listen", Net: "unix", Addr: &net.UnixAddr{Name: path, Net: "unix"}, Err: syscall.EADDRINUSE}
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// wireDumpBytes is how many payload bytes a wire trace prints per frame.
+const wireDumpBytes = 64
+
+/**
+ * * wireTrace logs every frame of a connection the way it travels on the wire, once enabled with
+ * * SetWireTrace: the raw header bytes, the bits they hold and a hex dump of at most wireDumpBytes
+ * * of the payload. The dumped payload is the unmasked one, the mask key is part of the header.
+ *
+ * * A text frame "Hello" sent by a client shows up as
+ *
+ *	header="81 85 37 fa 21 3d" fin=1 rsv=000 opcode=0001 mask=1 len=5 payload="48 65 6c 6c 6f |Hello|"
+ *
+ * * 0x81 is FIN set with opcode 0x1, 0x85 the MASK bit with a 7 bit length of 5 and 37 fa 21 3d the
+ * * mask key.
+ */
+type wireTrace struct {
+	enabled atomic.Bool
+}
+
+// readFrame is readFrame logging the frame read when tracing is enabled. A
+// frame whose header was read but that failed, for example because it is too
+// large, is logged without its payload.
+func (t *wireTrace) readFrame(r io.Reader, maxPayload uint64, log *slog.Logger) (*Frame, error) {
+	if !t.enabled.Load() {
+		return readFrame(r, maxPayload)
+	}
+	recorder := &headerRecorder{r: r}
+	frame, err := readFrame(recorder, maxPayload)
+	if header := recorder.header(); header != nil {
+		var payload []byte
+		if err == nil {
+			payload = frame.Payload
+		}
+		logWireFrame(log, "in", header, payload)
+	}
+	return frame, err
+}
+
+// writeFrame is WriteFrame logging the frame written when tracing is enabled.
+func (t *wireTrace) writeFrame(w io.Writer, fin bool, opcode byte, payload []byte, masked bool, log *slog.Logger) error {
+	if !t.enabled.Load() {
+		return WriteFrame(w, fin, opcode, payload, masked)
+	}
+	recorder := &writeRecorder{w: w}
+	err := WriteFrame(recorder, fin, opcode, payload, masked)
+	if len(recorder.last) >= len(payload) {
+		logWireFrame(log, "out", recorder.last[:len(recorder.last)-len(payload)], payload)
+	}
+	return err
+}
+
+// SetWireTrace turns the wire trace of the connection on or off, see
+// Server.TraceWire. Records are logged at Info through Logger.
+func (c *Conn) SetWireTrace(on bool) {
+	c.wire.enabled.Store(on)
+}
+
+// SetWireTrace turns the wire trace of the client on or off: the header and
+// the beginning of the payload of every frame read or written are logged at
+// Info through Dialer.Logger.
+func (c *Client) SetWireTrace(on bool) {
+	c.wire.enabled.Store(on)
+}
+
+// headerRecorder keeps the first bytes read through it, enough for the
+// longest frame header (2 bytes, 8 bytes of extended length, 4 of mask key).
+type headerRecorder struct {
+	r   io.Reader
+	buf [14]byte
+	n   int
+}
+
+func (h *headerRecorder) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.n += copy(h.buf[h.n:], p[:n])
+	return n, err
+}
+
+// header returns the header bytes recorded, nil unless at least the first
+// two were read.
+func (h *headerRecorder) header() []byte {
+	if h.n < 2 {
+		return nil
+	}
+	size := 2
+	switch h.buf[1] & 0x7F {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if h.buf[1]&0x80 != 0 {
+		size += 4
+	}
+	return h.buf[:min(size, h.n)]
+}
+
+// writeRecorder remembers the last buffer written through it. WriteFrame
+// writes the header and the payload of a frame in a single call.
+type writeRecorder struct {
+	w    io.Writer
+	last []byte
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.last = p
+	return w.w.Write(p)
+}
+
+// logWireFrame logs one frame in the format shown on wireTrace. The fields
+// are decoded from the raw header rather than taken from a Frame, so a
+// malformed header is shown as it was sent.
+func logWireFrame(log *slog.Logger, direction string, header, payload []byte) {
+	length := uint64(header[1] & 0x7F)
+	switch {
+	case length == 126 && len(header) >= 4:
+		length = uint64(binary.BigEndian.Uint16(header[2:4]))
+	case length == 127 && len(header) >= 10:
+		length = binary.BigEndian.Uint64(header[2:10])
+	}
+
+	log.Info("Wire frame",
+		"direction", direction,
+		"header", fmt.Sprintf("% x", header),
+		"fin", header[0]>>7,
+		"rsv", fmt.Sprintf("%03b", header[0]>>4&0x7),
+		"opcode", fmt.Sprintf("%04b", header[0]&0x0F),
+		"mask", header[1]>>7,
+		"len", length,
+		"payload", dumpPayload(payload),
+	)
+}
+
+// dumpPayload formats up to wireDumpBytes of payload as hex followed by the
+// printable ASCII characters, like hexdump -C does.
+func dumpPayload(payload []byte) string {
+	if len(payload) == 0 {
+		return ""
+	}
+	shown := payload[:min(len(payload), wireDumpBytes)]
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "% x |", shown)
+	for _, b := range shown {
+		if b < ' ' || b > '~' {
+			b = '.'
+		}
+		sb.WriteByte(b)
+	}
+	sb.WriteByte('|')
+	if rest := len(payload) - len(shown); rest > 0 {
+		fmt.Fprintf(&sb, " +%d bytes", rest)
+	}
+	return sb.String()
+}
@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"hash/maphash"
+	"sync"
+
+	"websocket/metrics"
+)
+
+var workerQueued = metrics.Default.RegisterGauge(metrics.NewGauge(
+	"websocket_worker_jobs_queued", "Messages waiting for a worker of a WorkerPool."))
+
+/**
+ * * WorkerPool handles messages on a fixed number of goroutines, apart from the goroutines reading
+ * * the connections, see Conn.ReadLoop and Server.Workers. A slow handler then delays the messages
+ * * behind it, not the pings and close frames of its connection, which the reader keeps answering.
+ *
+ * * The messages of one connection always go to the same worker, picked by a hash of the connection
+ * * ID, and are handled in the order they arrived. Each worker queues up to queueSize messages,
+ * * beyond that the reader of the connection waits, which pushes back on the client.
+ */
+type WorkerPool struct {
+	seed   maphash.Seed
+	queues []chan func()
+	done   sync.WaitGroup
+	once   sync.Once
+}
+
+// NewWorkerPool starts workers goroutines, each queuing up to queueSize
+// messages. Zero or negative values count as 1.
+func NewWorkerPool(workers, queueSize int) *WorkerPool {
+	p := &WorkerPool{seed: maphash.MakeSeed(), queues: make([]chan func(), max(workers, 1))}
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), max(queueSize, 1))
+		p.done.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+func (p *WorkerPool) work(queue chan func()) {
+	defer p.done.Done()
+	for job := range queue {
+		workerQueued.Dec()
+		job()
+	}
+}
+
+// submit queues job on the worker of the connection key, waiting while that
+// worker's queue is full.
+func (p *WorkerPool) submit(key string, job func()) {
+	workerQueued.Inc()
+	p.queues[maphash.String(p.seed, key)%uint64(len(p.queues))] <- job
+}
+
+// Close stops the workers once they handled the messages queued. No message
+// may be submitted afterwards, close the pool after the servers using it
+// shut down.
+func (p *WorkerPool) Close() {
+	p.once.Do(func() {
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	})
+	p.done.Wait()
+}
+
+/**
+ * * ReadLoop reads messages until the connection ends and passes each to handle, on pool when it is
+ * * not nil and on the calling goroutine otherwise. It returns the error that ended the connection,
+ * * a CloseError when the client closed it, once the messages handed to pool were handled.
+ *
+ * * An error returned by handle closes the connection with 1011 (internal error).
+ */
+func (c *Conn) ReadLoop(pool *WorkerPool, handle MessageHandler) error {
+	var pending sync.WaitGroup
+	defer pending.Wait()
+	for {
+		opcode, r, err := c.NextReader()
+		if err != nil {
+			return err
+		}
+		data, err := readLimited(r, c.MaxMessageSize, c.fail)
+		if err != nil {
+			return err
+		}
+		if pool == nil {
+			if err := handle(opcode, data); err != nil {
+				c.handlerFailed(err)
+				return err
+			}
+			continue
+		}
+		pending.Add(1)
+		pool.submit(c.id, func() {
+			defer pending.Done()
+			if err := handle(opcode, data); err != nil {
+				c.handlerFailed(err)
+			}
+		})
+	}
+}
+
+// handlerFailed closes the connection after its message handler failed.
+func (c *Conn) handlerFailed(err error) {
+	c.Logger().Error("Error handling message", "err", err)
+	c.closing()
+	c.writeClose(closeInternalError)
+	c.conn.Close()
+}
@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+)
+
+// defaultFragmentSize is the largest payload put in a single frame unless
+// FragmentSize says otherwise. Longer messages are split into a frame with the
+// message opcode followed by continuation frames.
+const defaultFragmentSize = 65535
+
+var errWriterClosed = errors.New("message writer already closed")
+
+// checkDataOpcode returns an error unless opcode is that of a text (0x1) or
+// binary (0x2) message, the only ones that may be fragmented: control frames
+// cannot be (RFC 6455 section 5.5), the other opcodes are reserved.
+func checkDataOpcode(opcode byte) error {
+	if opcode != 0x1 && opcode != 0x2 {
+		return fmt.Errorf("websocket: cannot write a message of opcode %#x, only text (0x1) or binary (0x2)", opcode)
+	}
+	return nil
+}
+
+/**
+ * * messageWriter streams a single message into frames as it is written.
+ *
+ * * At most size bytes, the FragmentSize of the connection, are held in memory. Every time the buffer fills up it is flushed
+ * * as a non-final frame, the first one carrying the message opcode and the following ones the
+ * * continuation opcode (0x0). Close flushes whatever is left as the final frame (FIN bit set).
+ */
+type messageWriter struct {
+	writeFrame func(fin bool, opcode byte, payload []byte) error
+	release    func() // Called once the message is finished.
+	opcode     byte
+	buf        []byte
+	closed     bool
+}
+
+func newMessageWriter(opcode byte, size int, writeFrame func(fin bool, opcode byte, payload []byte) error, release func()) *messageWriter {
+	return &messageWriter{
+		writeFrame: writeFrame,
+		release:    release,
+		opcode:     opcode,
+		buf:        make([]byte, 0, fragmentSize(size)),
+	}
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errWriterClosed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+
+		// Only flush a full buffer once more data is known to follow, so that the
+		// last fragment is always written by Close with the FIN bit set.
+		if len(w.buf) == cap(w.buf) && len(p) > 0 {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return errWriterClosed
+	}
+	w.closed = true
+	defer w.release()
+	return w.flush(true)
+}
+
+func (w *messageWriter) flush(fin bool) error {
+	err := w.writeFrame(fin, w.opcode, w.buf)
+	w.opcode = 0x0 // Continuation frame
+	w.buf = w.buf[:0]
+	return err
+}
+
+// fragmentSize returns the fragment size for a FragmentSize setting.
+func fragmentSize(size int) int {
+	if size <= 0 {
+		return defaultFragmentSize
+	}
+	return size
+}
+
+// writeFragmented writes data as one message, in frames of at most size bytes
+// sliced from data, so unlike messageWriter it needs no buffer. A message
+// fitting one frame, the common case, is written as is.
+func writeFragmented(writeFrame func(fin bool, opcode byte, payload []byte) error, opcode byte, data []byte, size int) error {
+	size = fragmentSize(size)
+	for len(data) > size {
+		if err := writeFrame(false, opcode, data[:size]); err != nil {
+			return err
+		}
+		opcode, data = 0x0, data[size:]
+	}
+	return writeFrame(true, opcode, data)
+}
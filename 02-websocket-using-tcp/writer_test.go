@@ -0,0 +1,15 @@
+package websocket
+
+import "testing"
+
+func TestNextWriterRefusesOtherOpcodes(t *testing.T) {
+	conn, client := &Conn{}, &Client{}
+	for _, opcode := range []byte{0x0, 0x3, 0x8, 0x9, 0xa, 0xf} {
+		if _, err := conn.NextWriter(opcode); err == nil {
+			t.Errorf("Conn.NextWriter(%#x) accepted", opcode)
+		}
+		if _, err := client.NextWriter(opcode); err == nil {
+			t.Errorf("Client.NextWriter(%#x) accepted", opcode)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Package wstest provides helpers for exercising WebSocket handlers without a
+// real network, in the spirit of net/http/httptest.
+package wstest
+
+import (
+	"net"
+
+	"netsim/lossy"
+	"websocket"
+)
+
+/**
+ * * Pipe connects a client to a server running handler over net.Pipe.
+ *
+ * * No socket is opened, the handshake and every frame travel through memory, which makes
+ * * handshake, fragmentation, masking and close behavior deterministic to exercise.
+ *
+ * * net.Pipe is synchronous: a write blocks until the other side reads it. The handler and
+ * * the caller must therefore not both write at the same time without someone reading, read
+ * * replies from a separate goroutine when in doubt.
+ */
+func Pipe(handler websocket.Handler) (*websocket.Client, error) {
+	return PipeMode(handler, websocket.Strict)
+}
+
+// PipeMode is like Pipe but runs both ends in the given protocol mode.
+func PipeMode(handler websocket.Handler, mode websocket.Mode) (*websocket.Client, error) {
+	return pipe(handler, mode, lossy.Faults{})
+}
+
+/**
+ * * PipeFaults is like Pipe but both directions suffer faults, see lossy.Conn, to exercise how
+ * * client and handler react to a connection that loses, repeats or mangles bytes.
+ *
+ * * The faults are injected in every write of both ends, the handshake's included, so a Seed
+ * * makes the same run fail the same way. A corrupted frame is a protocol error for the side
+ * * reading it, and even a dropped write can leave both sides waiting on each other: set
+ * * deadlines.
+ */
+func PipeFaults(handler websocket.Handler, faults lossy.Faults) (*websocket.Client, error) {
+	return pipe(handler, websocket.Strict, faults)
+}
+
+func pipe(handler websocket.Handler, mode websocket.Mode, faults lossy.Faults) (*websocket.Client, error) {
+	serverSide, clientSide := net.Pipe()
+	if faults.Enabled() {
+		// The two ends draw apart, rather than dropping the same writes
+		serverFaults := faults
+		if faults.Seed != 0 {
+			serverFaults.Seed++
+		}
+		serverSide, clientSide = lossy.Conn(serverSide, serverFaults), lossy.Conn(clientSide, faults)
+	}
+
+	server := &websocket.Server{Handler: handler, Mode: mode}
+	go server.ServeConn(serverSide)
+
+	dialer := &websocket.Dialer{Mode: mode}
+	client, err := dialer.Handshake(clientSide, "ws://pipe/")
+	if err != nil {
+		clientSide.Close()
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,207 @@
+package wstest_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"websocket"
+	"websocket/wstest"
+)
+
+// pipeTimeout bounds every wait of the tests, a lost frame would otherwise
+// hang them.
+const pipeTimeout = 5 * time.Second
+
+// result is what a handler saw, handed back to the test.
+type result struct {
+	stats websocket.ConnStats
+	value string
+	err   error
+}
+
+// receive returns the next value of ch, failing t after pipeTimeout.
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(pipeTimeout):
+		t.Fatal("timed out waiting for the handler")
+		var zero T
+		return zero
+	}
+}
+
+func TestPipeHandshake(t *testing.T) {
+	seen := make(chan result, 1)
+	client, err := wstest.Pipe(func(conn *websocket.Conn) {
+		from, ok := websocket.ConnFromContext(conn.Context())
+		switch {
+		case conn.ID() == "":
+			seen <- result{err: errors.New("connection without ID")}
+		case !ok || from != conn:
+			seen <- result{err: errors.New("connection missing from its context")}
+		default:
+			seen <- result{value: conn.Subprotocol()}
+		}
+		conn.WriteMessage(0x1, []byte("welcome"))
+	})
+	if err != nil {
+		t.Fatal("handshake:", err)
+	}
+	defer client.Close()
+
+	r := receive(t, seen)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.value != "" || client.Subprotocol() != "" {
+		t.Errorf("subprotocol %q on the server, %q on the client, want none", r.value, client.Subprotocol())
+	}
+	opcode, data, err := client.ReadFullMessage()
+	if err != nil || opcode != 0x1 || string(data) != "welcome" {
+		t.Errorf("first message %#x %q %v, want text welcome", opcode, data, err)
+	}
+}
+
+func TestPipeFragmentation(t *testing.T) {
+	message := []byte("a message split over many small frames")
+	seen := make(chan result, 1)
+	client, err := wstest.Pipe(func(conn *websocket.Conn) {
+		_, data, err := readAll(conn)
+		seen <- result{stats: conn.Stats(), value: string(data), err: err}
+		if err == nil {
+			conn.FragmentSize = 5
+			conn.WriteMessage(0x2, data)
+		}
+	})
+	if err != nil {
+		t.Fatal("handshake:", err)
+	}
+	defer client.Close()
+
+	var frames []*websocket.Frame
+	client.Hooks.OnFrameRead = func(frame *websocket.Frame) {
+		frames = append(frames, frame)
+	}
+	client.FragmentSize = 4
+	if err := client.WriteMessage(0x1, message); err != nil {
+		t.Fatal(err)
+	}
+
+	r := receive(t, seen)
+	if r.err != nil || r.value != string(message) {
+		t.Fatalf("server read %q %v, want %q", r.value, r.err, message)
+	}
+	if want := uint64((len(message) + 3) / 4); r.stats.FramesRead != want || r.stats.FragmentedMessages != 1 {
+		t.Errorf("server read %d frames and %d fragmented messages, want %d and 1", r.stats.FramesRead, r.stats.FragmentedMessages, want)
+	}
+
+	opcode, data, err := client.ReadFullMessage()
+	if err != nil || opcode != 0x2 || !bytes.Equal(data, message) {
+		t.Fatalf("echo %#x %q %v, want binary %q", opcode, data, err, message)
+	}
+	if want := (len(message) + 4) / 5; len(frames) != want {
+		t.Fatalf("client read %d frames, want %d", len(frames), want)
+	}
+	for i, frame := range frames {
+		wantOpcode := byte(0x0)
+		if i == 0 {
+			wantOpcode = 0x2
+		}
+		if frame.Opcode != wantOpcode || frame.Fin != (i == len(frames)-1) {
+			t.Errorf("frame %d: opcode %#x fin %v", i, frame.Opcode, frame.Fin)
+		}
+	}
+}
+
+func TestPipeMasking(t *testing.T) {
+	masked := make(chan bool, 1)
+	client, err := wstest.Pipe(func(conn *websocket.Conn) {
+		conn.Hooks.OnFrameRead = func(frame *websocket.Frame) {
+			// The first frame only, the close frame follows
+			select {
+			case masked <- frame.Masked:
+			default:
+			}
+		}
+		if _, data, err := readAll(conn); err == nil {
+			conn.WriteMessage(0x1, data)
+		}
+	})
+	if err != nil {
+		t.Fatal("handshake:", err)
+	}
+	defer client.Close()
+
+	var fromServer []*websocket.Frame
+	client.Hooks.OnFrameRead = func(frame *websocket.Frame) {
+		fromServer = append(fromServer, frame)
+	}
+	if err := client.WriteMessage(0x1, []byte("mask me")); err != nil {
+		t.Fatal(err)
+	}
+	if !receive(t, masked) {
+		t.Error("client frame arrived unmasked")
+	}
+	// The payload was unmasked on the way, the echo comes back verbatim
+	if _, data, err := client.ReadFullMessage(); err != nil || string(data) != "mask me" {
+		t.Fatalf("echo %q %v", data, err)
+	}
+	if len(fromServer) != 1 || fromServer[0].Masked {
+		t.Errorf("server frames %v, want one unmasked", fromServer)
+	}
+}
+
+func TestPipeServerClose(t *testing.T) {
+	closed := make(chan error, 1)
+	client, err := wstest.Pipe(func(conn *websocket.Conn) {
+		closed <- conn.Close(4000, "done")
+	})
+	if err != nil {
+		t.Fatal("handshake:", err)
+	}
+	defer client.Close()
+
+	_, _, err = client.ReadFullMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != 4000 || closeErr.Reason != "done" {
+		t.Fatalf("client read %v, want close 4000 done", err)
+	}
+	// The client answered the close frame while reading it
+	if err := receive(t, closed); err != nil {
+		t.Errorf("server Close: %v, want the client's answer", err)
+	}
+}
+
+func TestPipeClientClose(t *testing.T) {
+	read := make(chan error, 1)
+	client, err := wstest.Pipe(func(conn *websocket.Conn) {
+		_, _, err := readAll(conn)
+		read <- err
+	})
+	if err != nil {
+		t.Fatal("handshake:", err)
+	}
+
+	if err := client.CloseWith(1000, "bye"); err != nil {
+		t.Errorf("CloseWith: %v, want the server's answer", err)
+	}
+	var closeErr *websocket.CloseError
+	if err := receive(t, read); !errors.As(err, &closeErr) || closeErr.Code != 1000 || closeErr.Reason != "bye" {
+		t.Errorf("server read %v, want close 1000 bye", err)
+	}
+}
+
+// readAll reads the next message of conn completely.
+func readAll(conn *websocket.Conn) (byte, []byte, error) {
+	opcode, r, err := conn.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(r)
+	return opcode, buf.Bytes(), err
+}
@@ -0,0 +1,99 @@
+package wstest
+
+import (
+	"net"
+	"sync"
+
+	"websocket"
+)
+
+/**
+ * * NewServer starts a WebSocket server running handler on a random local port, much like
+ * * httptest.NewServer does for HTTP handlers.
+ *
+ * * It returns the ws:// URL to dial and a cleanup function. Cleanup stops accepting, closes
+ * * every connection that is still open and waits for the handlers to return, so a test can
+ * * simply defer it:
+ *
+ *	url, cleanup := wstest.NewServer(websocket.EchoHandler)
+ *	defer cleanup()
+ *
+ *	client, err := websocket.Dial(url)
+ */
+func NewServer(handler websocket.Handler) (string, func()) {
+	return Start(&websocket.Server{Handler: handler})
+}
+
+// Start is like NewServer but runs an already configured server, for example
+// one in Lenient mode.
+func Start(server *websocket.Server) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("wstest: failed to listen on a port: " + err.Error())
+	}
+
+	tracked := &trackingListener{Listener: listener, conns: make(map[net.Conn]bool)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.Serve(tracked)
+	}()
+
+	cleanup := func() {
+		listener.Close()
+		// Closing the connections first frees the slots a server with
+		// QueueConnections may be waiting for before its next Accept.
+		tracked.closeAll()
+		<-done
+		tracked.closeAll()
+		tracked.wg.Wait()
+	}
+	return "ws://" + listener.Addr().String() + "/", cleanup
+}
+
+// trackingListener remembers the connections it accepted so cleanup can close
+// them and wait until their handlers have returned.
+type trackingListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	l.conns[conn] = true
+	l.mu.Unlock()
+	l.wg.Add(1)
+	return &trackedConn{Conn: conn, listener: l}, nil
+}
+
+func (l *trackingListener) closeAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	listener *trackingListener
+	once     sync.Once
+}
+
+// Close is called by the server once the handler has returned.
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.listener.mu.Lock()
+		delete(c.listener.conns, c.Conn)
+		c.listener.mu.Unlock()
+		c.listener.wg.Done()
+	})
+	return err
+}
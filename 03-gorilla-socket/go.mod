@@ -1,4 +1,4 @@
-module websocket
+module gorilla-socket
 
 go 1.24.0
 
@@ -0,0 +1,17 @@
+module tunnel
+
+go 1.23.4
+
+require websocket v0.0.0
+
+require (
+	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	netsim v0.0.0 // indirect
+)
+
+replace (
+	netsim => ../netsim
+	websocket => ../02-websocket-using-tcp
+)
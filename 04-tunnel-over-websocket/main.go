@@ -0,0 +1,131 @@
+/**
+ * * Command tunnel carries TCP connections through WebSocket, the trick that gets SSH, databases or
+ * * plain HTTP across firewalls and proxies only letting web traffic through. The server end
+ * * accepts WebSocket connections and opens a TCP connection to -target for each, the client end
+ * * accepts TCP connections on -listen and opens a WebSocket connection to -server for each:
+ *
+ *	go run . -role server -listen :4443 -target localhost:8080
+ *	go run . -role client -listen :9000 -server ws://localhost:4443
+ *
+ * * Anything connecting to :9000 then talks to localhost:8080, the echo server of module 01 for
+ * * example, with the bytes travelling as binary WebSocket messages in between.
+ */
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"websocket"
+)
+
+func main() {
+	role := flag.String("role", "", "run the server end, accepting WebSocket connections, or the client end, accepting TCP ones")
+	listen := flag.String("listen", "", "address to accept connections on, WebSocket for the server end and TCP for the client end")
+	target := flag.String("target", "", "server: TCP address every WebSocket connection is forwarded to")
+	server := flag.String("server", "", "client: ws:// or wss:// URL of the server end")
+	cert := flag.String("cert", "", "server: certificate file, the server end serves wss:// when given with -key")
+	key := flag.String("key", "", "server: private key file of -cert")
+	insecure := flag.Bool("insecure", false, "client: do not verify the certificate of a wss:// server end")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch {
+	case *role == "server" && *listen != "" && *target != "":
+		err = runServer(ctx, *listen, *target, *cert, *key)
+	case *role == "client" && *listen != "" && *server != "":
+		var opts []websocket.ClientOption
+		if *insecure {
+			opts = append(opts, websocket.DialTLS(&tls.Config{InsecureSkipVerify: true}))
+		}
+		err = runClient(ctx, *listen, *server, opts)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.Error("Tunnel stopped", "err", err)
+		os.Exit(1)
+	}
+}
+
+// runServer accepts WebSocket connections on addr until ctx is done and
+// forwards each to target.
+func runServer(ctx context.Context, addr, target, cert, key string) error {
+	server := websocket.NewServer(addr, websocket.WithHandler(func(conn *websocket.Conn) {
+		upstream, err := net.Dial("tcp", target)
+		if err != nil {
+			slog.Error("Error connecting to target", "target", target, "err", err)
+			conn.Close(1011, "target unreachable")
+			return
+		}
+		slog.Info("Tunnel opened", "client", conn.RemoteAddr(), "target", target)
+		pipe(websocket.NetConn(conn), upstream)
+		slog.Info("Tunnel closed", "client", conn.RemoteAddr())
+	}))
+	if cert != "" {
+		certificate, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	slog.Info("Tunnel server running", "addr", addr, "target", target, "tls", cert != "")
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, websocket.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// runClient accepts TCP connections on addr until ctx is done and forwards
+// each through a WebSocket connection to serverURL.
+func runClient(ctx context.Context, addr, serverURL string, opts []websocket.ClientOption) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	slog.Info("Tunnel client running", "addr", listener.Addr(), "server", serverURL)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			client, err := websocket.DialContext(ctx, serverURL, opts...)
+			if err != nil {
+				slog.Error("Error connecting to tunnel server", "server", serverURL, "err", err)
+				conn.Close()
+				return
+			}
+			slog.Info("Tunnel opened", "client", conn.RemoteAddr(), "server", serverURL)
+			pipe(websocket.ClientNetConn(client), conn)
+			slog.Info("Tunnel closed", "client", conn.RemoteAddr())
+		}()
+	}
+}
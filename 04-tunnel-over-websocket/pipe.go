@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io"
+	"net"
+)
+
+/**
+ * * pipe copies between the WebSocket stream and the TCP connection in both directions until
+ * * either side stops. Closing the WebSocket stream runs the closing handshake, which ends the
+ * * reads of the other end of the tunnel, and that end closes its TCP connection in turn.
+ *
+ * * The TCP protocols carried rarely rely on half-closed connections, so the first end of stream
+ * * closes both directions rather than forwarding a FIN.
+ */
+func pipe(stream, conn net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(stream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, stream)
+		done <- struct{}{}
+	}()
+	<-done
+	stream.Close()
+	conn.Close()
+	<-done
+}
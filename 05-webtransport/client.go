@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+
+	"websocket/chat"
+)
+
+// runClient opens a session to url and sends every line of stdin as a
+// message, on one stream or as datagrams, printing the answers, until stdin
+// ends or ctx is done.
+func runClient(ctx context.Context, url string, insecure bool, certHash string, datagrams bool) error {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure, NextProtos: []string{http3.NextProtoH3}}
+	if certHash != "" {
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			hash := sha256.Sum256(state.PeerCertificates[0].Raw)
+			if got := base64.StdEncoding.EncodeToString(hash[:]); got != certHash {
+				return fmt.Errorf("certificate hash %s does not match", got)
+			}
+			return nil
+		}
+	}
+	transport := &webtransport.Transport{
+		TLSClientConfig: tlsConfig,
+		QUICConfig:      &quic.Config{EnableDatagrams: true, EnableStreamResetPartialDelivery: true},
+	}
+	defer transport.Close()
+
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	response, session, err := transport.Dial(dialCtx, url, nil)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("server answered %s", response.Status)
+	}
+	defer session.CloseWithError(0, "")
+	slog.Info("WebTransport session opened", "url", url, "datagrams", datagrams)
+
+	send := func(msg chat.Msg) error {
+		data, _ := json.Marshal(msg)
+		return session.SendDatagram(data)
+	}
+	// finish waits for the answers once stdin ended. Datagrams may be lost,
+	// their answers are given a moment, a stream is closed and drained.
+	finish := func() { time.Sleep(time.Second) }
+	if datagrams {
+		go func() {
+			for {
+				data, err := session.ReceiveDatagram(ctx)
+				if err != nil {
+					return
+				}
+				fmt.Println(string(data))
+			}
+		}()
+	} else {
+		stream, err := session.OpenStreamSync(ctx)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(stream)
+		send = func(msg chat.Msg) error { return encoder.Encode(msg) }
+		drained := make(chan struct{})
+		go func() {
+			defer close(drained)
+			scanner := bufio.NewScanner(stream)
+			for scanner.Scan() {
+				fmt.Println(scanner.Text())
+			}
+		}()
+		finish = func() {
+			stream.Close()
+			select {
+			case <-drained:
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				finish()
+				return nil
+			}
+			if err := send(chat.Msg{Role: "user", Content: line}); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		case <-session.Context().Done():
+			return errors.New("session closed by the server")
+		}
+	}
+}
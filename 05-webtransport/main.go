@@ -0,0 +1,55 @@
+/**
+ * * Command wtchat speaks the JSON chat protocol of module 02, chat.Msg, over WebTransport: HTTP/3
+ * * on QUIC, the transport browsers offer next to WebSocket. A session carries any number of
+ * * bidirectional streams, reliable and ordered each, and unreliable datagrams, so one slow or lost
+ * * message only holds back its own stream instead of the whole connection:
+ *
+ *	go run . -role server -listen :4433 -http :4480
+ *	go run . -role client -url https://localhost:4433/chat -insecure
+ *	go run . -role client -url https://localhost:4433/chat -insecure -datagrams
+ *
+ * * The server acknowledges every message like chat.AckHandler, on the stream or as a datagram,
+ * * whichever it came in. Without -cert it generates a certificate browsers accept through
+ * * serverCertificateHashes, and -http serves a page connecting to it.
+ */
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	role := flag.String("role", "", "run the chat server or a client reading messages from stdin")
+	listen := flag.String("listen", ":4433", "server: UDP address to serve HTTP/3 on")
+	httpAddr := flag.String("http", "", "server: TCP address to serve the browser client on, e.g. :4480 (disabled when empty)")
+	cert := flag.String("cert", "", "server: certificate file, a certificate valid for 13 days is generated when empty")
+	key := flag.String("key", "", "server: private key file of -cert")
+	url := flag.String("url", "https://localhost:4433/chat", "client: URL of the chat endpoint")
+	insecure := flag.Bool("insecure", false, "client: do not verify the certificate of the server")
+	certHash := flag.String("cert-hash", "", "client: accept the server certificate with this base64 SHA-256 hash, as printed by the server")
+	datagrams := flag.Bool("datagrams", false, "client: send messages as datagrams instead of on a stream")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch *role {
+	case "server":
+		err = runServer(ctx, *listen, *httpAddr, *cert, *key)
+	case "client":
+		err = runClient(ctx, *url, *insecure, *certHash, *datagrams)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.Error("WebTransport chat stopped", "err", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+
+	"websocket/chat"
+)
+
+//go:embed index.html
+var indexHTML string
+
+// ack is the answer of the server to every message, the one of chat.AckHandler.
+func ack(msg chat.Msg) chat.Msg {
+	return chat.Msg{Role: "agent", Content: "Message Recieved", TraceID: msg.TraceID}
+}
+
+// runServer serves the chat on the UDP address addr until ctx is done, and
+// the browser client on the TCP address httpAddr unless it is empty.
+func runServer(ctx context.Context, addr, httpAddr, cert, key string) error {
+	var certificate tls.Certificate
+	var hash string
+	var err error
+	if cert != "" {
+		certificate, err = tls.LoadX509KeyPair(cert, key)
+	} else {
+		certificate, hash, err = generateCertificate()
+	}
+	if err != nil {
+		return err
+	}
+
+	h3 := &http3.Server{
+		Addr:       addr,
+		TLSConfig:  http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{certificate}}),
+		QUICConfig: &quic.Config{EnableDatagrams: true, EnableStreamResetPartialDelivery: true},
+	}
+	webtransport.ConfigureHTTP3Server(h3)
+	server := &webtransport.Server{
+		H3: h3,
+		// The page of -http is served from another origin.
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat", func(w http.ResponseWriter, r *http.Request) {
+		session, err := server.Upgrade(w, r)
+		if err != nil {
+			slog.Warn("Error upgrading to WebTransport", "remote_addr", r.RemoteAddr, "err", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		serveSession(session)
+	})
+	h3.Handler = mux
+
+	if httpAddr != "" {
+		go serveBrowserClient(httpAddr, addr, hash)
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	slog.Info("WebTransport chat running", "addr", addr, "cert_hash", hash)
+	if err := server.ListenAndServe(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// serveSession answers the messages of a session, on every stream the client
+// opens and as datagrams, until the client closes it.
+func serveSession(session *webtransport.Session) {
+	log := slog.With("remote_addr", session.RemoteAddr().String())
+	log.Info("WebTransport session opened")
+	ctx := session.Context()
+
+	go func() {
+		for {
+			data, err := session.ReceiveDatagram(ctx)
+			if err != nil {
+				return
+			}
+			var msg chat.Msg
+			if err := json.Unmarshal(data, &msg); err != nil {
+				log.Warn("Error parsing JSON datagram", "err", err)
+				continue
+			}
+			log.Info("Received datagram", "content", msg.Content)
+			reply, _ := json.Marshal(ack(msg))
+			if err := session.SendDatagram(reply); err != nil {
+				log.Warn("Error sending datagram", "err", err)
+			}
+		}
+	}()
+
+	for {
+		stream, err := session.AcceptStream(ctx)
+		if err != nil {
+			// Streams are only refused once the session is gone, closed by
+			// the client or with its QUIC connection.
+			log.Info("WebTransport session closed", "reason", err)
+			return
+		}
+		go serveStream(log.With("stream", stream.StreamID()), stream)
+	}
+}
+
+// serveStream answers the messages of one stream, a JSON object per line in
+// both directions, until the client closes its side.
+func serveStream(log *slog.Logger, stream *webtransport.Stream) {
+	defer stream.Close()
+	decoder, encoder := json.NewDecoder(stream), json.NewEncoder(stream)
+	for {
+		var msg chat.Msg
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				log.Info("Stream closed")
+			} else {
+				log.Warn("Error reading stream", "err", err)
+			}
+			return
+		}
+		log.Info("Received message", "content", msg.Content)
+		if err := encoder.Encode(ack(msg)); err != nil {
+			log.Warn("Error sending message", "err", err)
+			return
+		}
+	}
+}
+
+// serveBrowserClient serves index.html on httpAddr, pointed at the chat on
+// the UDP address addr and trusting the certificate with hash when it is
+// not empty.
+func serveBrowserClient(httpAddr, addr, hash string) {
+	page := template.Must(template.New("index").Parse(indexHTML))
+	_, port, _ := net.SplitHostPort(addr)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The page connects to the host it was loaded from.
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		page.Execute(w, map[string]string{
+			"URL":  "https://" + net.JoinHostPort(host, port) + "/chat",
+			"Hash": hash,
+		})
+	})
+	slog.Info("Browser client running", "addr", httpAddr)
+	if err := http.ListenAndServe(httpAddr, handler); err != nil {
+		slog.Error("Error serving browser client", "err", err)
+	}
+}
@@ -0,0 +1,3 @@
+module punch
+
+go 1.23.4
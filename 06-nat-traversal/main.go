@@ -0,0 +1,56 @@
+/**
+ * * Command punch connects two peers behind NATs directly, over UDP, by hole punching. Neither can
+ * * reach the other first: a NAT drops datagrams from addresses its client never sent to. So both
+ * * register with a rendezvous server, which sees the public address each NAT mapped them to and
+ * * tells each the address of the other, and then both send to each other at once. The first
+ * * datagrams open a hole in the sender's own NAT, those of the other side come in through it, and
+ * * the peers chat without the server from then on:
+ *
+ *	go run . -role server -listen :7000
+ *	go run . -role peer -server rendezvous.example.com:7000 -room demo -name alice
+ *	go run . -role peer -server rendezvous.example.com:7000 -room demo -name bob
+ *
+ * * This works through NATs with an endpoint-independent mapping, cone NATs, and not through
+ * * symmetric ones, which map the socket anew for the peer. The stun package of module 01 tells
+ * * which kind is in the way.
+ */
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	role := flag.String("role", "", "run the rendezvous server or a peer chatting over stdin")
+	listen := flag.String("listen", ":7000", "server: UDP address to listen on")
+	server := flag.String("server", "localhost:7000", "peer: UDP address of the rendezvous server")
+	room := flag.String("room", "demo", "peer: room to meet the other peer in, two peers per room")
+	name := flag.String("name", "", "peer: name shown to the other peer, the host name when empty")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch *role {
+	case "server":
+		err = runRendezvous(ctx, *listen)
+	case "peer":
+		if *name == "" {
+			*name, _ = os.Hostname()
+		}
+		err = runPeer(ctx, *server, *room, *name)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.Error("Hole punching stopped", "err", err)
+		os.Exit(1)
+	}
+}
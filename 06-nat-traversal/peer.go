@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+// datagram is a datagram read by a peer.
+type datagram struct {
+	from *net.UDPAddr
+	data []byte
+}
+
+// runPeer registers as name in room with the rendezvous server at server,
+// punches a hole to the other peer of the room and sends it the lines of
+// stdin once a direct path is open, printing what it sends back, until stdin
+// ends, the other peer leaves or ctx is done.
+func runPeer(ctx context.Context, server, room, name string) error {
+	serverAddr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return err
+	}
+
+	// One socket for the server and the peer alike: the public address the
+	// server sees is the mapping of this socket, which the peer sends to
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	private, err := privateAddr(conn, serverAddr)
+	if err != nil {
+		return err
+	}
+
+	datagrams := make(chan datagram)
+	go func() {
+		defer close(datagrams)
+		for {
+			buffer := make([]byte, maxDatagram)
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			datagrams <- datagram{from: from, data: buffer[:n]}
+		}
+	}()
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	register := func() error {
+		return send(conn, serverAddr, "register %s %s %s", room, name, private)
+	}
+	if err := register(); err != nil {
+		return err
+	}
+	slog.Info("Registering with the rendezvous server", "server", serverAddr.String(), "room", room, "name", name, "private", private.String())
+
+	ticker := time.NewTicker(registerInterval)
+	defer ticker.Stop()
+	var candidates []*net.UDPAddr // Where the other peer may be reached
+	var peerName string
+	var peer *net.UDPAddr // Where it was reached, once it was
+	var punchDeadline time.Time
+	connected := func(addr *net.UDPAddr) {
+		peer = addr
+		ticker.Reset(keepAliveInterval)
+		slog.Info("Direct connection established, type your messages", "peer", peerName, "addr", addr.String())
+	}
+	defer func() {
+		if peer != nil {
+			send(conn, peer, "bye")
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case now := <-ticker.C:
+			switch {
+			case peer != nil:
+				// Keep the holes in both NATs open
+				send(conn, peer, "ping")
+			case candidates == nil:
+				register()
+			case now.After(punchDeadline):
+				return errors.New("no direct path to the peer, a NAT in the way is likely symmetric")
+			default:
+				for _, addr := range candidates {
+					send(conn, addr, "punch %s", name)
+				}
+			}
+
+		case d, ok := <-datagrams:
+			if !ok {
+				return errors.New("socket closed")
+			}
+			if d.from.String() == serverAddr.String() {
+				cmd := parseCommand(d.data, 3)
+				switch {
+				case cmd.name == "full":
+					return fmt.Errorf("room %s already has two peers", room)
+				case cmd.name == "peer" && len(cmd.fields) == 3 && candidates == nil:
+					peerName = cmd.fields[0]
+					candidates = resolveCandidates(cmd.fields[1], cmd.fields[2])
+					slog.Info("Peer found, punching", "peer", peerName, "public", cmd.fields[1], "private", cmd.fields[2])
+					punchDeadline = time.Now().Add(punchTimeout)
+					ticker.Reset(punchInterval)
+				}
+				continue
+			}
+
+			cmd := parseCommand(d.data, 1)
+			switch cmd.name {
+			case "punch", "punched":
+				if cmd.name == "punch" {
+					// The other side's datagrams come through, answer at
+					// the address they came from, which is the one that works
+					send(conn, d.from, "punched %s", name)
+				}
+				if peer == nil && len(cmd.fields) == 1 {
+					// Its punches may arrive before the server's answer
+					peerName = cmd.fields[0]
+					connected(d.from)
+				}
+			case "msg":
+				if len(cmd.fields) == 1 && peer != nil && d.from.String() == peer.String() {
+					fmt.Printf("%s: %s\n", peerName, cmd.fields[0])
+				}
+			case "bye":
+				if peer != nil && d.from.String() == peer.String() {
+					slog.Info("Peer left", "peer", peerName)
+					peer = nil
+					return nil
+				}
+			}
+
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+			if peer == nil {
+				slog.Warn("Not connected to the peer yet, message dropped")
+				continue
+			}
+			if err := send(conn, peer, "msg %s", line); err != nil {
+				slog.Warn("Error sending message", "err", err)
+			}
+		}
+	}
+}
+
+// resolveCandidates returns the addresses of a peer worth punching to, its
+// public address and, when it differs, its private one.
+func resolveCandidates(public, private string) []*net.UDPAddr {
+	var candidates []*net.UDPAddr
+	for _, addr := range []string{public, private} {
+		udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+		if err != nil {
+			slog.Warn("Ignoring peer address", "addr", addr, "err", err)
+			continue
+		}
+		if len(candidates) == 0 || candidates[0].String() != udpAddr.String() {
+			candidates = append(candidates, udpAddr)
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Every datagram is a line of text, a command and its fields separated by
+// spaces, the text of a message last:
+//
+//	peer -> server  register <room> <name> <private addr>
+//	server -> peer  peer <name> <public addr> <private addr> | full
+//	peer -> peer    punch <name> | punched <name> | msg <text> | ping | bye
+//
+// The private address is the one of the peer's socket on its own network.
+// Two peers behind the same NAT reach each other at it, rather than through
+// the NAT's public address, which many NATs do not loop back.
+
+const (
+	// registerInterval is how often a peer registers until the server
+	// introduced it to the other peer, and how often it punches after.
+	registerInterval = time.Second
+	punchInterval    = 200 * time.Millisecond
+
+	// punchTimeout is how long the peers punch before giving up.
+	punchTimeout = 10 * time.Second
+
+	// keepAliveInterval keeps the NAT mappings open while the peers are
+	// quiet, NATs forgetting idle UDP mappings within a minute or two.
+	keepAliveInterval = 15 * time.Second
+
+	// registrationTimeout is how long the server keeps a peer that stopped
+	// registering.
+	registrationTimeout = 30 * time.Second
+
+	maxDatagram = 1024
+)
+
+// command is a decoded datagram.
+type command struct {
+	name   string
+	fields []string
+}
+
+// parseCommand splits datagram into its command and up to n fields, the last
+// one keeping its spaces.
+func parseCommand(datagram []byte, n int) command {
+	fields := strings.SplitN(string(datagram), " ", n+1)
+	return command{name: fields[0], fields: fields[1:]}
+}
+
+// privateAddr returns the address of conn on the local network, as seen
+// from server: conn listens on every interface, the kernel picks the IP from
+// its routes, which a connected socket to server reveals.
+func privateAddr(conn *net.UDPConn, server *net.UDPAddr) (*net.UDPAddr, error) {
+	probe, err := net.DialUDP("udp4", nil, server)
+	if err != nil {
+		return nil, err
+	}
+	defer probe.Close()
+	return &net.UDPAddr{IP: probe.LocalAddr().(*net.UDPAddr).IP, Port: conn.LocalAddr().(*net.UDPAddr).Port}, nil
+}
+
+func send(conn *net.UDPConn, addr *net.UDPAddr, format string, args ...any) error {
+	_, err := conn.WriteToUDP([]byte(fmt.Sprintf(format, args...)), addr)
+	return err
+}
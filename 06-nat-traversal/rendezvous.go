@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// registration is a peer waiting in a room.
+type registration struct {
+	name    string
+	public  *net.UDPAddr
+	private string
+	seen    time.Time
+}
+
+// runRendezvous introduces the peers registering in the same room to each
+// other until ctx is done. It never relays their messages: once introduced,
+// the peers talk directly.
+func runRendezvous(ctx context.Context, addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp4", udpAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	slog.Info("Rendezvous server running", "addr", conn.LocalAddr().String())
+
+	rooms := make(map[string][]*registration)
+	buffer := make([]byte, maxDatagram)
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		cmd := parseCommand(buffer[:n], 3)
+		if cmd.name != "register" || len(cmd.fields) != 3 {
+			slog.Warn("Ignoring datagram", "remote_addr", from.String(), "command", cmd.name)
+			continue
+		}
+		room, name, private := cmd.fields[0], cmd.fields[1], cmd.fields[2]
+
+		// Forget the peers that stopped registering, and find this one: the
+		// public address is the one its NAT mapped it to, as seen here
+		now := time.Now()
+		var peers []*registration
+		var self *registration
+		for _, r := range rooms[room] {
+			if now.Sub(r.seen) >= registrationTimeout {
+				slog.Info("Peer expired", "room", room, "name", r.name, "public", r.public.String())
+				continue
+			}
+			if r.public.String() == from.String() {
+				self = r
+			}
+			peers = append(peers, r)
+		}
+		if self == nil {
+			if len(peers) == 2 {
+				send(conn, from, "full")
+				rooms[room] = peers
+				continue
+			}
+			self = &registration{public: from}
+			peers = append(peers, self)
+			slog.Info("Peer registered", "room", room, "name", name, "public", from.String(), "private", private)
+		}
+		self.name, self.private, self.seen = name, private, now
+		rooms[room] = peers
+		if len(peers) == 0 {
+			delete(rooms, room)
+		}
+
+		// Every registration of a peer with company is answered, which makes
+		// up for a lost answer
+		for _, other := range peers {
+			if other != self {
+				send(conn, from, "peer %s %s %s", other.name, other.public, other.private)
+			}
+		}
+	}
+}
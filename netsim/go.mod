@@ -0,0 +1,3 @@
+module netsim
+
+go 1.23.4
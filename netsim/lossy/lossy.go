@@ -0,0 +1,240 @@
+// Package lossy injects faults into connections: what is written to them is
+// dropped, delayed, duplicated or corrupted at random, to demonstrate and
+// test how the protocol on top copes with a bad network.
+package lossy
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// Faults are the faults injected, every one drawn anew for every write.
+type Faults struct {
+	// Drop, Duplicate and Corrupt are the probabilities of a write being
+	// lost, sent twice and having one bit flipped, from 0 to 1.
+	Drop      float64
+	Duplicate float64
+	Corrupt   float64
+
+	// Delay holds every write back that long, plus up to Jitter more.
+	Delay  time.Duration
+	Jitter time.Duration
+
+	// Seed seeds the draws, the same seed making the same decisions for the
+	// same writes. Zero means a random one.
+	Seed uint64
+}
+
+// Enabled reports whether f injects any fault.
+func (f Faults) Enabled() bool {
+	return f.Drop > 0 || f.Duplicate > 0 || f.Corrupt > 0 || f.Delay > 0 || f.Jitter > 0
+}
+
+// Validate reports the first fault out of range.
+func (f Faults) Validate() error {
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{{"drop", f.Drop}, {"duplicate", f.Duplicate}, {"corrupt", f.Corrupt}} {
+		if p.value < 0 || p.value > 1 {
+			return fmt.Errorf("lossy: %s probability %v is not between 0 and 1", p.name, p.value)
+		}
+	}
+	if f.Delay < 0 || f.Jitter < 0 {
+		return errors.New("lossy: delay and jitter cannot be negative")
+	}
+	return nil
+}
+
+/**
+ * * Conn wraps conn so that its writes suffer faults, its reads are left alone. Wrapping both ends
+ * * of a connection makes both directions lossy.
+ *
+ * * A connection of datagrams, a net.PacketConn such as a connected *net.UDPConn, suffers them per
+ * * datagram: a write is a datagram, lost, duplicated or delayed as a whole, and a jitter larger
+ * * than the time between two writes reorders them, like on a real network. On a stream, such as
+ * * TCP or net.Pipe, the bytes of a write go missing, repeat or change with it, and delayed writes
+ * * keep their order, which no stream would lose.
+ *
+ * * A dropped or delayed write still reports success. A delayed write failing returns its error
+ * * from the next Write, and the writes still held back when the connection is closed are lost.
+ */
+func Conn(conn net.Conn, f Faults) net.Conn {
+	seed := f.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	_, packet := conn.(net.PacketConn)
+	c := &lossyConn{
+		Conn:   conn,
+		faults: f,
+		packet: packet,
+		rand:   rand.New(rand.NewPCG(seed, seed)),
+		done:   make(chan struct{}),
+	}
+	if !packet && (f.Delay > 0 || f.Jitter > 0) {
+		c.delayed = make(chan delayedWrite, 64)
+		go c.writeDelayed()
+	}
+	return c
+}
+
+// lossyConn is the net.Conn returned by Conn.
+type lossyConn struct {
+	net.Conn
+	faults Faults
+	packet bool
+
+	mu   sync.Mutex
+	rand *rand.Rand
+	last time.Time // When the last delayed write of a stream is due
+	err  error     // Of a delayed write, for the next Write
+
+	delayed   chan delayedWrite
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// delayedWrite is a write of a stream held back until due, or the closing
+// of its writing side, which waits for the writes before it.
+type delayedWrite struct {
+	data       []byte
+	due        time.Time
+	closeWrite bool
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	if err := c.err; err != nil {
+		c.mu.Unlock()
+		return 0, err
+	}
+	if c.draw(c.faults.Drop) {
+		c.mu.Unlock()
+		return len(b), nil
+	}
+	// The caller may reuse b once Write returns, the copy is what is sent
+	data := append([]byte(nil), b...)
+	if len(data) > 0 && c.draw(c.faults.Corrupt) {
+		data[c.rand.IntN(len(data))] ^= 1 << c.rand.IntN(8)
+	}
+	copies := 1
+	if c.draw(c.faults.Duplicate) {
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delays[i] = c.faults.Delay
+		if c.faults.Jitter > 0 {
+			delays[i] += time.Duration(c.rand.Int64N(int64(c.faults.Jitter)))
+		}
+	}
+	c.mu.Unlock()
+
+	for _, delay := range delays {
+		switch {
+		case c.delayed == nil && delay == 0:
+			if _, err := c.Conn.Write(data); err != nil {
+				return 0, err
+			}
+		case c.packet:
+			time.AfterFunc(delay, func() { c.deliver(data) })
+		default:
+			// Through the queue even when this one is not delayed, to stay
+			// behind the earlier ones
+			c.mu.Lock()
+			due := time.Now().Add(delay)
+			if due.Before(c.last) {
+				due = c.last
+			}
+			c.last = due
+			c.mu.Unlock()
+			select {
+			case c.delayed <- delayedWrite{data: data, due: due}:
+			case <-c.done:
+				return 0, net.ErrClosed
+			}
+		}
+	}
+	return len(b), nil
+}
+
+// draw reports whether a fault of probability p happens. c.mu must be held.
+func (c *lossyConn) draw(p float64) bool {
+	return p > 0 && c.rand.Float64() < p
+}
+
+// writeDelayed writes the delayed writes of a stream in order, each once
+// due, until the connection is closed.
+func (c *lossyConn) writeDelayed() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case w := <-c.delayed:
+			select {
+			case <-time.After(time.Until(w.due)):
+			case <-c.done:
+				return
+			}
+			if w.closeWrite {
+				c.keep(c.Conn.(interface{ CloseWrite() error }).CloseWrite())
+				continue
+			}
+			c.deliver(w.data)
+		}
+	}
+}
+
+// deliver writes data once held back, keeping the error for the next Write.
+func (c *lossyConn) deliver(data []byte) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	_, err := c.Conn.Write(data)
+	c.keep(err)
+}
+
+// keep keeps the error of a delayed write, if any, for the next Write.
+func (c *lossyConn) keep(err error) {
+	if err == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+}
+
+// CloseWrite closes the writing side of a stream whose connection can, such
+// as a *net.TCPConn, once the writes held back are written.
+func (c *lossyConn) CloseWrite() error {
+	closer, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok || c.packet {
+		return errors.New("lossy: the connection has no writing side to close")
+	}
+	if c.delayed == nil {
+		return closer.CloseWrite()
+	}
+	c.mu.Lock()
+	due := c.last
+	c.mu.Unlock()
+	select {
+	case c.delayed <- delayedWrite{due: due, closeWrite: true}:
+		return nil
+	case <-c.done:
+		return net.ErrClosed
+	}
+}
+
+func (c *lossyConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,154 @@
+// Package throttle limits the bandwidth of readers, writers and connections
+// with token buckets, to demonstrate and test how transfers behave on a slow
+// link.
+package throttle
+
+import (
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Limit is a bandwidth: BytesPerSecond on average, and up to Burst bytes at
+// once after a quiet period. A zero BytesPerSecond is unlimited, a zero Burst
+// one second worth of the rate.
+type Limit struct {
+	BytesPerSecond float64
+	Burst          int
+}
+
+// Enabled reports whether l limits anything.
+func (l Limit) Enabled() bool {
+	return l.BytesPerSecond > 0
+}
+
+/**
+ * * Limiter is a token bucket of bytes, refilled at the rate of its Limit up to the burst. A limiter
+ * * shared by several readers, writers or connections limits them together.
+ *
+ * * A nil Limiter is unlimited.
+ */
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter for l, nil when l is unlimited.
+func NewLimiter(l Limit) *Limiter {
+	if !l.Enabled() {
+		return nil
+	}
+	burst := l.Burst
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(l.BytesPerSecond)))
+	}
+	return &Limiter{rate: l.BytesPerSecond, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes n tokens, n at most the burst, sleeping until the bucket has
+// them.
+func (l *Limiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	// Going into debt keeps the order of the callers: the next one waits
+	// for this one's tokens too
+	l.tokens -= float64(n)
+	debt := l.tokens
+	l.mu.Unlock()
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / l.rate * float64(time.Second)))
+	}
+}
+
+// Reader returns a reader reading from r no faster than l allows: every read
+// asks r for at most the burst and waits for the bytes it returned.
+func Reader(r io.Reader, l *Limiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{r: r, limiter: l}
+}
+
+type reader struct {
+	r       io.Reader
+	limiter *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:min(len(p), r.limiter.burst)])
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
+
+// Writer returns a writer writing to w no faster than l allows: every write
+// is split into pieces of at most the burst, each waiting for its bytes
+// before it goes to w.
+func Writer(w io.Writer, l *Limiter) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &writer{w: w, limiter: l}
+}
+
+type writer struct {
+	w       io.Writer
+	limiter *Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		piece := p[:min(len(p), w.limiter.burst)]
+		w.limiter.wait(len(piece))
+		n, err := w.w.Write(piece)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+/**
+ * * Conn wraps conn so that reading from it is limited by read and writing to it by write, either
+ * * nil for no limit. Only the reads and writes wait, the deadlines of conn still apply to the bytes
+ * * going through, so a deadline can expire while a write waits for its tokens.
+ *
+ * * A throttled reader does not slow the peer down by itself: what it does not read yet piles up in
+ * * the socket buffers, until TCP flow control stops the peer.
+ */
+func Conn(conn net.Conn, read, write *Limiter) net.Conn {
+	if read == nil && write == nil {
+		return conn
+	}
+	return &throttledConn{Conn: conn, reader: Reader(conn, read), writer: Writer(conn, write)}
+}
+
+type throttledConn struct {
+	net.Conn
+	reader io.Reader
+	writer io.Writer
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+// NetConn returns the connection c throttles.
+func (c *throttledConn) NetConn() net.Conn {
+	return c.Conn
+}